@@ -10,17 +10,20 @@ import (
 	"fmt"
 	"github.com/malivvan/vv/vvm"
 	"github.com/malivvan/vv/vvm/encoding"
+	"github.com/malivvan/vv/vvm/vfs"
 
 	"hash/crc64"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/malivvan/vv/vvm/parser"
 )
 
-// Magic is a magic number every encoded Program starts with.
-// format: [4]MAGIC [4]SIZE [N]DATA [8]CRC64(ECMA)
-const Magic = "VVC\x00"
+// Magic is a magic number every encoded Program starts with. It is followed
+// by the bytecode format version (see vvm.FormatVersion).
+// format: [3]MAGIC [1]VERSION [4]SIZE [N]DATA [8]CRC64(ECMA)
+const Magic = "VVC"
 
 // Script can simplify compilation and execution of embedded scripts.
 type Script struct {
@@ -32,6 +35,9 @@ type Script struct {
 	maxConstObjects  int
 	enableFileImport bool
 	importDir        string
+	fs               vfs.FS
+	deterministic    bool
+	seed             int64
 }
 
 // NewScript creates a Script instance with an input script.
@@ -107,6 +113,19 @@ func (s *Script) EnableFileImport(enable bool) {
 	s.enableFileImport = enable
 }
 
+// SetFS sets the filesystem the os module operates on. The host filesystem
+// is used by default.
+func (s *Script) SetFS(fsys vfs.FS) {
+	s.fs = fsys
+}
+
+// SetDeterministic makes the runs of the compiled Program deterministic,
+// with random numbers generated from seed. See Program.SetDeterministic.
+func (s *Script) SetDeterministic(seed int64) {
+	s.deterministic = true
+	s.seed = seed
+}
+
 // Compile compiles the script with all the defined variables and returns Program object.
 func (s *Script) Compile() (*Program, error) {
 	symbolTable, globals, err := s.prepCompile()
@@ -157,6 +176,9 @@ func (s *Script) Compile() (*Program, error) {
 		bytecode:      bytecode,
 		globals:       globals,
 		maxAllocs:     s.maxAllocs,
+		fs:            s.fs,
+		deterministic: s.deterministic,
+		seed:          s.seed,
 	}, nil
 }
 
@@ -212,6 +234,11 @@ type Program struct {
 	bytecode      *vvm.Bytecode
 	globals       []vvm.Object
 	maxAllocs     int64
+	fs            vfs.FS
+	deterministic bool
+	seed          int64
+	replay        *vvm.Trace
+	trace         *vvm.Trace
 	lock          sync.RWMutex
 }
 
@@ -234,8 +261,12 @@ func (p *Program) Unmarshal(b []byte) (err error) {
 	body := b[8 : len(b)-8]
 	tail := b[len(b)-8:]
 
-	if string(head[:4]) != Magic {
-		return fmt.Errorf("invalid magic number: %s", head[:4])
+	if string(head[:3]) != Magic {
+		return fmt.Errorf("invalid magic number: %s", head[:3])
+	}
+	version := int(head[3])
+	if version > vvm.FormatVersion {
+		return fmt.Errorf("unsupported bytecode version: %d", version)
 	}
 	size := binary.LittleEndian.Uint32(head[4:8])
 	if size != uint32(len(body)) {
@@ -267,7 +298,7 @@ func (p *Program) Unmarshal(b []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	n, p.globals, err = encoding.UnmarshalSlice[vvm.Object](n, body, vvm.UnmarshalObject)
+	n, p.globals, err = encoding.UnmarshalSlice[vvm.Object](n, body, vvm.ObjectUnmarshaler(version))
 	if err != nil {
 		return err
 	}
@@ -277,10 +308,19 @@ func (p *Program) Unmarshal(b []byte) (err error) {
 	}
 
 	p.bytecode = &vvm.Bytecode{}
-	err = p.bytecode.Unmarshal(body[n:], Modules)
+	err = p.bytecode.UnmarshalVersion(body[n:], Modules, version)
 	if err != nil {
 		return err
 	}
+	err = p.bytecode.Upgrade(version)
+	if err != nil {
+		return err
+	}
+	for _, g := range p.globals {
+		if fn, ok := g.(*vvm.CompiledFunction); ok {
+			fn.Upgrade(version)
+		}
+	}
 
 	return nil
 }
@@ -329,7 +369,7 @@ func (p *Program) Marshal() ([]byte, error) {
 	head[0] = Magic[0]
 	head[1] = Magic[1]
 	head[2] = Magic[2]
-	head[3] = Magic[3]
+	head[3] = vvm.FormatVersion
 	binary.LittleEndian.PutUint32(head[4:], uint32(len(body)))
 
 	var tail [8]byte
@@ -342,13 +382,71 @@ func (p *Program) Marshal() ([]byte, error) {
 	return append(append(head[:], body...), tail[:]...), nil
 }
 
+// SetFS sets the filesystem the os module operates on. The host filesystem
+// is used by default.
+func (p *Program) SetFS(fsys vfs.FS) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.fs = fsys
+}
+
+// SetDeterministic makes the runs of the Program deterministic: random
+// numbers are generated from seed, the time is read from a virtual clock
+// starting at the time the run starts, and routines run one at a time in a
+// reproducible order. The trace of a run, returned by Trace, replays it.
+func (p *Program) SetDeterministic(seed int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.deterministic = true
+	p.seed = seed
+}
+
+// SetReplay makes the runs of the Program replay trace, which is the trace
+// of a deterministic run of the same program. A run that does not follow the
+// trace fails with an error wrapping vvm.ErrReplayDiverged.
+func (p *Program) SetReplay(trace *vvm.Trace) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.replay = trace
+}
+
+// Trace returns the trace of the last deterministic run, or nil.
+func (p *Program) Trace() *vvm.Trace {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.trace
+}
+
+func (p *Program) newVM(ctx context.Context) (*vvm.VM, *vvm.Deterministic) {
+	v := vvm.NewVM(ctx, p.bytecode, p.globals, p.maxAllocs)
+	if p.fs != nil {
+		v.FS = p.fs
+	}
+	var d *vvm.Deterministic
+	if p.replay != nil {
+		d = vvm.NewReplay(p.replay)
+	} else if p.deterministic {
+		// without the monotonic clock reading, which a trace cannot hold
+		d = vvm.NewDeterministic(p.seed, time.Now().Round(0))
+	}
+	if d != nil {
+		v.SetDeterministic(d)
+		p.trace = d.Trace()
+	}
+	return v, d
+}
+
 // Run executes the compiled script in the virtual machine.
 func (p *Program) Run() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	v := vvm.NewVM(context.Background(), p.bytecode, p.globals, p.maxAllocs)
-	return v.Run()
+	v, d := p.newVM(context.Background())
+	err := v.Run()
+	if err == nil && d != nil {
+		err = d.Err()
+	}
+	return err
 }
 
 // RunContext is like Run but includes a context.
@@ -356,10 +454,14 @@ func (p *Program) RunContext(ctx context.Context) (err error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	v := vvm.NewVM(ctx, p.bytecode, p.globals, p.maxAllocs)
+	v, d := p.newVM(ctx)
 	ch := make(chan error, 1)
 	go func() {
-		ch <- v.Run()
+		err := v.Run()
+		if err == nil && d != nil {
+			err = d.Err()
+		}
+		ch <- err
 	}()
 
 	select {
@@ -383,6 +485,10 @@ func (p *Program) Clone() *Program {
 		bytecode:      p.bytecode,
 		globals:       make([]vvm.Object, len(p.globals)),
 		maxAllocs:     p.maxAllocs,
+		fs:            p.fs,
+		deterministic: p.deterministic,
+		seed:          p.seed,
+		replay:        p.replay,
 	}
 	// copy global objects
 	for idx, g := range p.globals {
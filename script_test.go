@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/malivvan/vv"
 	"math/rand"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	"github.com/malivvan/vv/vvm/require"
 	"github.com/malivvan/vv/vvm/stdlib"
 	"github.com/malivvan/vv/vvm/token"
+	"github.com/malivvan/vv/vvm/vfs"
 )
 
 func TestExample(t *testing.T) {
@@ -130,6 +132,59 @@ func TestScript_BuiltinModules(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestScript_SetFS(t *testing.T) {
+	mem := vfs.NewMemory()
+	s := vv.NewScript([]byte(`
+os := import("os")
+os.mkdir_all("/data/sub", 0755)
+f := os.create("/data/sub/hello.txt")
+f.write_string("hello")
+f.close()
+a := string(os.read_file("/data/sub/hello.txt"))
+b := os.stat("/data/sub/hello.txt").size
+c := len(os.read_dir("/data"))
+d := is_error(os.symlink("/data", "/link"))
+`))
+	s.SetImports(stdlib.GetModuleMap("os"))
+	s.SetFS(mem)
+	p, err := s.Run()
+	require.NoError(t, err)
+	programGet(t, p, "a", "hello")
+	programGet(t, p, "b", int64(5))
+	programGet(t, p, "c", int64(1))
+	programGet(t, p, "d", true)
+
+	data, err := mem.ReadFile("/data/sub/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// the same program can be pointed to a different filesystem
+	s = vv.NewScript([]byte(`
+os := import("os")
+out := os.read_file("/data/sub/hello.txt")
+out = is_error(out) ? "missing" : string(out)
+`))
+	s.SetImports(stdlib.GetModuleMap("os"))
+	p, err = s.Compile()
+	require.NoError(t, err)
+	p.SetFS(vfs.ReadOnly(mem))
+	programRun(t, p)
+	programGet(t, p, "out", "hello")
+	p.SetFS(vfs.NewMemory())
+	programRun(t, p)
+	programGet(t, p, "out", "missing")
+
+	s = vv.NewScript([]byte(`
+os := import("os")
+out := is_error(os.create("/file"))
+`))
+	s.SetImports(stdlib.GetModuleMap("os"))
+	s.SetFS(vfs.ReadOnly(mem))
+	p, err = s.Run()
+	require.NoError(t, err)
+	programGet(t, p, "out", true)
+}
+
 func TestScript_SourceModules(t *testing.T) {
 	s := vv.NewScript([]byte(`
 enum := import("enum")
@@ -507,6 +562,29 @@ a := func() {
 	programGet(t, p, "a", int64(15))
 }
 
+func TestProgram_Generator(t *testing.T) {
+	p, err := vv.NewScript([]byte(`
+gen := func(n) { for i := 0; i < n; i++ { yield i * 10 } }
+g := gen(3)
+bad := func() { yield 1; yield [] + 1 }()`)).Run()
+	require.NoError(t, err)
+
+	// generators can be resumed from Go after the program has finished
+	g := p.Get("g").Object().(*vvm.Generator)
+	var values []int
+	for g.Next() {
+		values = append(values, int(g.Value().(*vvm.Int).Value))
+	}
+	require.Equal(t, []int{0, 10, 20}, values)
+	require.False(t, g.Next())
+	require.NoError(t, g.Err())
+
+	bad := p.Get("bad").Object().(*vvm.Generator)
+	require.True(t, bad.Next())
+	require.False(t, bad.Next())
+	require.Error(t, bad.Err())
+}
+
 func TestProgram_RunContext(t *testing.T) {
 	// machine completes normally
 	p := compile(t, `a := 5`, nil)
@@ -522,6 +600,111 @@ func TestProgram_RunContext(t *testing.T) {
 	require.Equal(t, context.DeadlineExceeded, err)
 }
 
+func TestProgram_RunError(t *testing.T) {
+	p := compile(t, `f := func(a) {
+	return a + "x"
+}
+out := f(1)`, nil)
+	err := p.Run()
+	var rerr *vvm.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, 2, rerr.Line)
+	require.Equal(t, 9, rerr.Column)
+	require.Equal(t, 2, len(rerr.Stack))
+	require.Equal(t, 4, rerr.Stack[1].Line)
+	require.True(t, errors.Is(rerr.Err, vvm.ErrInvalidOperator))
+	require.Equal(t, "invalid_operator", vvm.ErrorCode(err))
+}
+
+func TestProgram_Deterministic(t *testing.T) {
+	src := `
+rand := import("rand")
+times := import("times")
+os := import("os")
+
+begin := times.now()
+c := chan()
+log := []
+worker := func(name, n) {
+	for i := 0; i < n; i++ {
+		times.sleep(rand.intn(1000))
+		c.send(name + string(i))
+	}
+}
+r1 := start(worker, "a", 3)
+r2 := start(worker, "b", 3)
+for i := 0; i < 6; i++ {
+	log = append(log, c.recv())
+}
+r1.wait()
+r2.wait()
+out := string(log) + os.getenv("VV_DETERMINISTIC_TEST") + string(times.since(begin))
+`
+	run := func(p *vv.Program) string {
+		require.NoError(t, p.Run())
+		return p.Get("out").String()
+	}
+
+	t.Setenv("VV_DETERMINISTIC_TEST", "x")
+	s := vv.NewScript([]byte(src))
+	s.SetImports(stdlib.GetModuleMap("rand", "times", "os"))
+	s.SetDeterministic(42)
+	p, err := s.Compile()
+	require.NoError(t, err)
+	out := run(p)
+	trace := p.Trace()
+	require.NotNil(t, trace)
+	require.Equal(t, int64(42), trace.Seed)
+
+	// same seed, same run
+	require.Equal(t, out, run(p.Clone()))
+
+	// replay sees the recorded environment
+	t.Setenv("VV_DETERMINISTIC_TEST", "y")
+	replay := p.Clone()
+	replay.SetReplay(trace)
+	require.Equal(t, out, run(replay))
+	require.Equal(t, trace.Clock.UnixNano(), replay.Trace().Clock.UnixNano())
+
+	// a different program diverges
+	s = vv.NewScript([]byte(`c := chan(1); c.send(1); c.recv()`))
+	p, err = s.Compile()
+	require.NoError(t, err)
+	p.SetReplay(trace)
+	err = p.Run()
+	require.True(t, errors.Is(err, vvm.ErrReplayDiverged), err)
+
+	// restarted routines run in new tasks
+	s = vv.NewScript([]byte(`
+n := 0
+c := chan()
+r := start_with({on_failure: "restart"}, func() {
+	n++
+	c.send(n)
+	if n < 3 { return 1 + "a" }
+	return n
+})
+sum := 0
+for i := 0; i < 3; i++ { sum += c.recv() }
+out := [r.result(), sum, wait_all([r]), r.info().restarts]`))
+	s.SetDeterministic(1)
+	p, err = s.Compile()
+	require.NoError(t, err)
+	require.Equal(t, "[3, 6, true, 2]", run(p))
+	replay = p.Clone()
+	replay.SetReplay(p.Trace())
+	require.Equal(t, "[3, 6, true, 2]", run(replay))
+
+	// routines blocked forever are reported
+	s = vv.NewScript([]byte(`c := chan(); start(func() { c.recv() })`))
+	s.SetDeterministic(1)
+	p, err = s.Compile()
+	require.NoError(t, err)
+	err = p.Run()
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), vvm.ErrDeadlock.Error()), err)
+}
+
 func TestProgram_EncodeDecode(t *testing.T) {
 	p := compile(t, `for true {}`, nil)
 	p.Bytecode().MainFunction.SourceMap = nil
@@ -593,3 +776,44 @@ func programGetAll(t *testing.T, p *vv.Program, expected M) {
 func programIsDefined(t *testing.T, p *vv.Program, name string, expected bool) {
 	require.Equal(t, expected, p.IsDefined(name))
 }
+
+func TestProgram_DecodeVersion0(t *testing.T) {
+	// encoded by version 0 from:
+	//
+	//	fmt := import("fmt")
+	//	text := import("text")
+	//	m := {a: 1, b: [1, 2, 3]}
+	//	e := error("boom")
+	//	f := func(s) { return s[1:3] }
+	//	out := fmt.sprintf("%s-%d", f("abcd"), m.b[1])
+	b, err := os.ReadFile("testdata/v0.vvc")
+	require.NoError(t, err)
+	p := new(vv.Program)
+	require.NoError(t, p.Unmarshal(b))
+
+	require.Equal(t, "bc-2", p.Get("out").String())
+	e, ok := p.Get("e").Object().(*vvm.Error)
+	require.True(t, ok)
+	require.Equal(t, `"boom"`, e.Value.String())
+	require.Equal(t, "", e.Code)
+	require.Nil(t, e.Cause)
+	require.Equal(t, 0, len(e.Stack))
+
+	require.NoError(t, p.Set("out", ""))
+	require.NoError(t, p.Run())
+	require.Equal(t, "bc-2", p.Get("out").String())
+	require.Equal(t, int64(1), p.Get("m").Map()["a"])
+
+	// encoded by version 0 from:
+	//
+	//	c := chan(1)
+	//	r := start(func(x) { c.send(x * 2); return x + 1 }, 20)
+	//	out := [c.recv(), r.result(), type_name(c)]
+	b, err = os.ReadFile("testdata/v0_routines.vvc")
+	require.NoError(t, err)
+	p = new(vv.Program)
+	require.NoError(t, p.Unmarshal(b))
+	require.NoError(t, p.Set("out", ""))
+	require.NoError(t, p.Run())
+	require.Equal(t, `[40, 21, "map"]`, p.Get("out").Object().String())
+}
@@ -3,6 +3,7 @@ package vv
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/malivvan/vv/pkg/cli"
 	"github.com/malivvan/vv/pkg/sh"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
@@ -94,6 +96,62 @@ func RunCompiled(ctx context.Context, data []byte) (err error) {
 	return
 }
 
+// RunDeterministic runs the program in data, source code or a compiled
+// binary, deterministically with random numbers generated from seed. If
+// traceFile is not empty, the trace of the run is written to it even if the
+// run fails.
+func RunDeterministic(ctx context.Context, data []byte, inputFile string, seed int64, traceFile string) (err error) {
+	p, err := loadProgram(data, inputFile)
+	if err != nil {
+		return
+	}
+	p.SetDeterministic(seed)
+	err = p.RunContext(ctx)
+	if traceFile == "" {
+		return
+	}
+	trace := p.Trace()
+	trace.Name = inputFile
+	b, merr := json.MarshalIndent(trace, "", "  ")
+	if merr == nil {
+		merr = os.WriteFile(traceFile, b, 0644)
+	}
+	if merr != nil && err == nil {
+		err = fmt.Errorf("error writing trace file %s: %w", traceFile, merr)
+	}
+	return
+}
+
+// Replay reads the trace of a deterministic run from traceFile and replays
+// it with the program in data, source code or a compiled binary.
+func Replay(ctx context.Context, data []byte, inputFile string, traceFile string) (err error) {
+	trace, err := ReadTrace(traceFile)
+	if err != nil {
+		return
+	}
+	p, err := loadProgram(data, inputFile)
+	if err != nil {
+		return
+	}
+	p.SetReplay(trace)
+	err = p.RunContext(ctx)
+	return
+}
+
+// ReadTrace reads the trace of a deterministic run written by
+// RunDeterministic.
+func ReadTrace(traceFile string) (*vvm.Trace, error) {
+	b, err := os.ReadFile(traceFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trace file %s: %w", traceFile, err)
+	}
+	trace := &vvm.Trace{}
+	if err := json.Unmarshal(b, trace); err != nil {
+		return nil, fmt.Errorf("error reading trace file %s: %w", traceFile, err)
+	}
+	return trace, nil
+}
+
 // RunREPL starts REPL.
 func RunREPL(ctx context.Context, in io.Reader, out io.Writer, prompt string) {
 	stdin := bufio.NewScanner(in)
@@ -158,6 +216,17 @@ func RunREPL(ctx context.Context, in io.Reader, out io.Writer, prompt string) {
 	}
 }
 
+func loadProgram(data []byte, inputFile string) (*Program, error) {
+	if len(data) >= len(Magic) && string(data[:len(Magic)]) == Magic {
+		p := &Program{}
+		if err := p.Unmarshal(data); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return compileSrc(data, inputFile)
+}
+
 func compileSrc(src []byte, inputFile string) (*Program, error) {
 	s := NewScript(src)
 	s.SetName(inputFile)
@@ -291,6 +360,16 @@ func NewCli(ui cli.ActionFunc) (*cli.App, error) {
 			Name:    "run",
 			Aliases: []string{"r"},
 			Usage:   "run a VV program",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:  "seed",
+					Usage: "run deterministically with random numbers generated from `SEED`",
+				},
+				&cli.StringFlag{
+					Name:  "record",
+					Usage: "run deterministically and write the trace of the run to `FILE`",
+				},
+			},
 			Action: func(ctx *cli.Context) error {
 				if ctx.Args().Len() != 1 {
 					return fmt.Errorf("run command requires exactly one argument")
@@ -300,12 +379,43 @@ func NewCli(ui cli.ActionFunc) (*cli.App, error) {
 				if err != nil {
 					return fmt.Errorf("error reading input file %s: %w", inputFile, err)
 				}
+				if ctx.IsSet("seed") || ctx.IsSet("record") {
+					seed := ctx.Int64("seed")
+					if !ctx.IsSet("seed") {
+						seed = time.Now().UnixNano()
+					}
+					return RunDeterministic(ctx.Context, data, inputFile, seed, ctx.String("record"))
+				}
 				if string(data[:len(Magic)]) == Magic {
 					return RunCompiled(ctx.Context, data)
 				}
 				return CompileAndRun(ctx.Context, data, inputFile)
 			},
 		},
+		{
+			Name:      "replay",
+			Usage:     "replay a run recorded with 'run --record'",
+			ArgsUsage: "TRACE [PROGRAM]",
+			Action: func(ctx *cli.Context) error {
+				if ctx.Args().Len() < 1 || ctx.Args().Len() > 2 {
+					return fmt.Errorf("replay command requires a trace file and an optional program")
+				}
+				traceFile := ctx.Args().Get(0)
+				inputFile := ctx.Args().Get(1)
+				if inputFile == "" {
+					trace, err := ReadTrace(traceFile)
+					if err != nil {
+						return err
+					}
+					inputFile = trace.Name
+				}
+				data, err := os.ReadFile(inputFile)
+				if err != nil {
+					return fmt.Errorf("error reading input file %s: %w", inputFile, err)
+				}
+				return Replay(ctx.Context, data, inputFile, traceFile)
+			},
+		},
 		{
 			Name:    "build",
 			Aliases: []string{"b"},
@@ -10,6 +10,14 @@ func addBuiltinFunction(name string, fn CallableFunc) {
 	builtinFuncs = append(builtinFuncs, &BuiltinFunction{Name: name, Value: fn})
 }
 
+// addRestartableFunction adds a builtin function that only blocks until
+// something happens and can therefore be interrupted by a snapshot.
+func addRestartableFunction(name string, fn CallableFunc) {
+	builtinFuncs = append(builtinFuncs, &BuiltinFunction{Name: name, Value: fn, Restartable: true})
+}
+
+// The position of a builtin function is its index in the OpGetBuiltin
+// instructions of compiled programs, so new functions are only appended.
 func init() {
 	addBuiltinFunction("len", builtinLen)
 	addBuiltinFunction("copy", builtinCopy)
@@ -42,6 +50,22 @@ func init() {
 	addBuiltinFunction("type_name", builtinTypeName)
 	addBuiltinFunction("format", builtinFormat)
 	addBuiltinFunction("range", builtinRange)
+	addBuiltinFunction("start", builtinStart)
+	addBuiltinFunction("abort", builtinAbort)
+	addBuiltinFunction("chan", builtinChan)
+	addBuiltinFunction("set", builtinSet)
+	addBuiltinFunction("is_set", builtinIsSet)
+	addBuiltinFunction("bigint", builtinBigInt)
+	addBuiltinFunction("decimal", builtinDecimal)
+	addBuiltinFunction("is_bigint", builtinIsBigInt)
+	addBuiltinFunction("is_decimal", builtinIsDecimal)
+	addBuiltinFunction("new_error", builtinNewError)
+	addBuiltinFunction("wrap", builtinWrap)
+	addBuiltinFunction("unwrap", builtinUnwrap)
+	addBuiltinFunction("start_with", builtinStartWith)
+	addBuiltinFunction("routines", builtinRoutines)
+	addRestartableFunction("wait_all", builtinWaitAll)
+	addRestartableFunction("wait_any", builtinWaitAny)
 }
 
 // GetAllBuiltinFunctions returns all builtin function objects.
@@ -76,6 +100,26 @@ func builtinIsInt(ctx context.Context, args ...Object) (Object, error) {
 	return FalseValue, nil
 }
 
+func builtinIsBigInt(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*BigInt); ok {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
+func builtinIsDecimal(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*Decimal); ok {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
 func builtinIsFloat(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
@@ -120,7 +164,8 @@ func builtinIsArray(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	if _, ok := args[0].(*Array); ok {
+	switch args[0].(type) {
+	case *Array, *Range:
 		return TrueValue, nil
 	}
 	return FalseValue, nil
@@ -156,6 +201,16 @@ func builtinIsImmutableMap(ctx context.Context, args ...Object) (Object, error)
 	return FalseValue, nil
 }
 
+func builtinIsSet(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*Set); ok {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
 func builtinIsTime(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
@@ -167,13 +222,87 @@ func builtinIsTime(ctx context.Context, args ...Object) (Object, error) {
 }
 
 func builtinIsError(ctx context.Context, args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	e, ok := args[0].(*Error)
+	if !ok {
+		return FalseValue, nil
+	}
+	if argsLen == 2 {
+		switch args[1].(type) {
+		case *String, *Error:
+		default:
+			return nil, ErrInvalidArgumentType{
+				Name:     "second",
+				Expected: "string or error",
+				Found:    args[1].TypeName(),
+			}
+		}
+		if !e.Is(args[1]) {
+			return FalseValue, nil
+		}
+	}
+	return TrueValue, nil
+}
+
+func builtinNewError(ctx context.Context, args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	e := &Error{Value: args[0]}
+	if argsLen == 2 {
+		code, ok := args[1].(*String)
+		if !ok {
+			return nil, ErrInvalidArgumentType{
+				Name:     "second",
+				Expected: "string",
+				Found:    args[1].TypeName(),
+			}
+		}
+		e.Code = code.Value
+	}
+	return e, nil
+}
+
+func builtinWrap(ctx context.Context, args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 2 || argsLen == 3) {
+		return nil, ErrWrongNumArguments
+	}
+	cause, ok := args[0].(*Error)
+	if !ok {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "error",
+			Found:    args[0].TypeName(),
+		}
+	}
+	e := &Error{Value: args[1], Cause: cause}
+	if argsLen == 3 {
+		code, ok := args[2].(*String)
+		if !ok {
+			return nil, ErrInvalidArgumentType{
+				Name:     "third",
+				Expected: "string",
+				Found:    args[2].TypeName(),
+			}
+		}
+		e.Code = code.Value
+	}
+	return e, nil
+}
+
+func builtinUnwrap(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
-	if _, ok := args[0].(*Error); ok {
-		return TrueValue, nil
+	if e, ok := args[0].(*Error); ok && e.Cause != nil {
+		return e.Cause, nil
 	}
-	return FalseValue, nil
+	return UndefinedValue, nil
 }
 
 func builtinIsUndefined(ctx context.Context, args ...Object) (Object, error) {
@@ -232,13 +361,17 @@ func builtinLen(ctx context.Context, args ...Object) (Object, error) {
 	case *Bytes:
 		return &Int{Value: int64(len(arg.Value))}, nil
 	case *Map:
-		return &Int{Value: int64(len(arg.Value))}, nil
+		return &Int{Value: int64(len(arg.Value) + len(arg.Hashed))}, nil
 	case *ImmutableMap:
+		return &Int{Value: int64(len(arg.Value) + len(arg.Hashed))}, nil
+	case *Set:
 		return &Int{Value: int64(len(arg.Value))}, nil
+	case *Range:
+		return &Int{Value: arg.Len()}, nil
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
-			Expected: "array/string/bytes/map",
+			Expected: "array/string/bytes/map/set/range",
 			Found:    arg.TypeName(),
 		}
 	}
@@ -288,25 +421,7 @@ func builtinRange(ctx context.Context, args ...Object) (Object, error) {
 		step = &Int{Value: int64(1)}
 	}
 
-	return buildRange(start.Value, stop.Value, step.Value), nil
-}
-
-func buildRange(start, stop, step int64) *Array {
-	array := &Array{}
-	if start <= stop {
-		for i := start; i < stop; i += step {
-			array.Value = append(array.Value, &Int{
-				Value: i,
-			})
-		}
-	} else {
-		for i := start; i > stop; i -= step {
-			array.Value = append(array.Value, &Int{
-				Value: i,
-			})
-		}
-	}
-	return array
+	return &Range{Start: start.Value, Stop: stop.Value, Step: step.Value}, nil
 }
 
 func builtinFormat(ctx context.Context, args ...Object) (Object, error) {
@@ -326,7 +441,11 @@ func builtinFormat(ctx context.Context, args ...Object) (Object, error) {
 		// okay to return 'format' directly as String is immutable
 		return format, nil
 	}
-	s, err := Format(format.Value, args[1:]...)
+	fmtArgs, err := StrHooks(ctx, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	s, err := Format(format.Value, fmtArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -348,6 +467,18 @@ func builtinString(ctx context.Context, args ...Object) (Object, error) {
 	if _, ok := args[0].(*String); ok {
 		return args[0], nil
 	}
+	if vm, ok := ctx.Value(ContextKey("vm")).(*VM); ok {
+		if hook := hookOf(args[0], HookStr); hook != nil {
+			v, err := vm.toString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(v) > MaxStringLen {
+				return nil, ErrStringLimit
+			}
+			return &String{Value: v}, nil
+		}
+	}
 	v, ok := ToString(args[0])
 	if ok {
 		if len(v) > MaxStringLen {
@@ -397,6 +528,42 @@ func builtinFloat(ctx context.Context, args ...Object) (Object, error) {
 	return UndefinedValue, nil
 }
 
+func builtinBigInt(ctx context.Context, args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*BigInt); ok {
+		return args[0], nil
+	}
+	v, ok := ToBigInt(args[0])
+	if ok {
+		return &BigInt{Value: v}, nil
+	}
+	if argsLen == 2 {
+		return args[1], nil
+	}
+	return UndefinedValue, nil
+}
+
+func builtinDecimal(ctx context.Context, args ...Object) (Object, error) {
+	argsLen := len(args)
+	if !(argsLen == 1 || argsLen == 2) {
+		return nil, ErrWrongNumArguments
+	}
+	if _, ok := args[0].(*Decimal); ok {
+		return args[0], nil
+	}
+	v, ok := ToDecimal(args[0])
+	if ok {
+		return v, nil
+	}
+	if argsLen == 2 {
+		return args[1], nil
+	}
+	return UndefinedValue, nil
+}
+
 func builtinBool(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
@@ -476,6 +643,16 @@ func builtinTime(ctx context.Context, args ...Object) (Object, error) {
 	return UndefinedValue, nil
 }
 
+// builtinSet creates a set of the given elements.
+// usage: set(elem1, elem2, ...) or set(array...)
+func builtinSet(ctx context.Context, args ...Object) (Object, error) {
+	s, err := NewSet(args...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 // append(arr, items...)
 func builtinAppend(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) < 2 {
@@ -486,6 +663,8 @@ func builtinAppend(ctx context.Context, args ...Object) (Object, error) {
 		return &Array{Value: append(arg.Value, args[1:]...)}, nil
 	case *ImmutableArray:
 		return &Array{Value: append(arg.Value, args[1:]...)}, nil
+	case *Range:
+		return &Array{Value: append(arg.values(), args[1:]...)}, nil
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
@@ -495,32 +674,35 @@ func builtinAppend(ctx context.Context, args ...Object) (Object, error) {
 	}
 }
 
-// builtinDelete deletes Map keys
+// builtinDelete deletes Map keys and Set elements
 // usage: delete(map, "key")
-// key must be a string
+// key must be hashable
 func builtinDelete(ctx context.Context, args ...Object) (Object, error) {
 	argsLen := len(args)
 	if argsLen != 2 {
 		return nil, ErrWrongNumArguments
 	}
+	var err error
 	switch arg := args[0].(type) {
 	case *Map:
-		if key, ok := args[1].(*String); ok {
-			delete(arg.Value, key.Value)
-			return UndefinedValue, nil
-		}
-		return nil, ErrInvalidArgumentType{
-			Name:     "second",
-			Expected: "string",
-			Found:    args[1].TypeName(),
-		}
+		err = arg.Delete(args[1])
+	case *Set:
+		err = arg.Remove(args[1])
 	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
-			Expected: "map",
+			Expected: "map/set",
 			Found:    arg.TypeName(),
 		}
 	}
+	if err != nil {
+		return nil, ErrInvalidArgumentType{
+			Name:     "second",
+			Expected: "hashable",
+			Found:    args[1].TypeName(),
+		}
+	}
+	return UndefinedValue, nil
 }
 
 // builtinSplice deletes and changes given Array, returns deleted items.
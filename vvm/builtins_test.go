@@ -35,7 +35,7 @@ func Test_builtinDelete(t *testing.T) {
 			&vvm.String{}}}, wantErr: true,
 			wantedErr: vvm.ErrInvalidArgumentType{
 				Name:     "first",
-				Expected: "map",
+				Expected: "map/set",
 				Found:    "string"},
 		},
 		{name: "no-args",
@@ -52,10 +52,14 @@ func Test_builtinDelete(t *testing.T) {
 			want: vvm.UndefinedValue,
 		},
 		{name: "nil-map-nonstr-key",
+			args: args{[]vvm.Object{&vvm.Map{}, &vvm.Int{}}},
+			want: vvm.UndefinedValue,
+		},
+		{name: "nil-map-unhashable-key",
 			args: args{[]vvm.Object{
-				&vvm.Map{}, &vvm.Int{}}}, wantErr: true,
+				&vvm.Map{}, &vvm.Array{}}}, wantErr: true,
 			wantedErr: vvm.ErrInvalidArgumentType{
-				Name: "second", Expected: "string", Found: "int"},
+				Name: "second", Expected: "hashable", Found: "array"},
 		},
 		{name: "nil-map-no-key",
 			args: args{[]vvm.Object{&vvm.Map{}}}, wantErr: true,
@@ -498,10 +502,36 @@ func Test_builtinRange(t *testing.T) {
 				t.Errorf("builtinRange() error = %v, wantedErr %v",
 					err, tt.wantedErr)
 			}
-			if tt.result != nil && !reflect.DeepEqual(tt.result, got) {
+			if tt.result == nil {
+				return
+			}
+			// ranges are lazy; compare the elements they produce
+			elems := &vvm.Array{}
+			for it := got.Iterate(); it.Next(); {
+				elems.Value = append(elems.Value, it.Value())
+			}
+			if !reflect.DeepEqual(tt.result, elems) {
 				t.Errorf("builtinRange() arrays are not equal expected"+
-					" %s, got %s", tt.result, got.(*vvm.Array))
+					" %s, got %s", tt.result, elems)
 			}
 		})
 	}
 }
+
+func TestBuiltinIndices(t *testing.T) {
+	// the indices of compiled programs refer to these
+	names := []string{
+		"len", "copy", "append", "delete", "splice", "string", "int", "bool",
+		"float", "char", "bytes", "time", "is_int", "is_float", "is_string",
+		"is_bool", "is_char", "is_bytes", "is_array", "is_immutable_array",
+		"is_map", "is_immutable_map", "is_iterable", "is_time", "is_error",
+		"is_undefined", "is_function", "is_callable", "type_name", "format",
+		"range", "start", "abort", "chan",
+	}
+	builtins := vvm.GetAllBuiltinFunctions()
+	for i, name := range names {
+		if builtins[i].Name != name {
+			t.Fatalf("builtin %d: expected %s, got %s", i, name, builtins[i].Name)
+		}
+	}
+}
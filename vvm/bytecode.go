@@ -7,6 +7,17 @@ import (
 	"reflect"
 )
 
+// FormatVersion is the version of the bytecode encoding emitted by the
+// compiler. It has to be incremented whenever the operands of an existing
+// opcode or the encoding of an object change, so that bytecode encoded by an
+// older version can be decoded and upgraded when it is loaded.
+//
+//	0: initial encoding
+//	1: OpSliceIndex takes the number of slice components as operand
+//	2: maps encode their non-string keyed entries
+//	3: errors encode their code, cause and stack
+const FormatVersion = 3
+
 // Bytecode is a compiled instructions and constants.
 type Bytecode struct {
 	FileSet      *parser.SourceFileSet
@@ -91,7 +102,16 @@ func (b *Bytecode) FormatConstants() (output []string) {
 }
 
 // Unmarshal decodes Bytecode from the given data.
-func (b *Bytecode) Unmarshal(data []byte, modules *ModuleMap) (err error) {
+func (b *Bytecode) Unmarshal(data []byte, modules *ModuleMap) error {
+	return b.UnmarshalVersion(data, modules, FormatVersion)
+}
+
+// UnmarshalVersion decodes Bytecode encoded in the given format version from
+// the given data. The instructions are left in that version, see Upgrade.
+func (b *Bytecode) UnmarshalVersion(data []byte, modules *ModuleMap, version int) (err error) {
+	if version < 0 || version > FormatVersion {
+		return fmt.Errorf("unsupported bytecode version: %d", version)
+	}
 	if modules == nil {
 		modules = NewModuleMap()
 	}
@@ -104,7 +124,7 @@ func (b *Bytecode) Unmarshal(data []byte, modules *ModuleMap) (err error) {
 	}
 
 	var mainFuncObj Object
-	n, mainFuncObj, err = UnmarshalObject(n, data)
+	n, mainFuncObj, err = UnmarshalObject(n, data, version)
 	if err != nil {
 		return err
 	}
@@ -114,7 +134,7 @@ func (b *Bytecode) Unmarshal(data []byte, modules *ModuleMap) (err error) {
 	}
 	b.MainFunction = mainFunc
 
-	n, b.Constants, err = encoding.UnmarshalSlice[Object](n, data, UnmarshalObject)
+	n, b.Constants, err = encoding.UnmarshalSlice[Object](n, data, ObjectUnmarshaler(version))
 	if err != nil {
 		return err
 	}
@@ -134,6 +154,77 @@ func (b *Bytecode) Unmarshal(data []byte, modules *ModuleMap) (err error) {
 	return nil
 }
 
+// Upgrade converts the instructions of all compiled functions in the
+// Bytecode from the given format version to FormatVersion.
+func (b *Bytecode) Upgrade(version int) error {
+	if version < 0 || version > FormatVersion {
+		return fmt.Errorf("unsupported bytecode version: %d", version)
+	}
+	if version == FormatVersion {
+		return nil
+	}
+	b.MainFunction.Upgrade(version)
+	for _, c := range b.Constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			fn.Upgrade(version)
+		}
+	}
+	return nil
+}
+
+// Upgrade converts the instructions of the function from the given format
+// version to FormatVersion, relocating jump targets and the source map.
+func (o *CompiledFunction) Upgrade(version int) {
+	if version >= FormatVersion {
+		return
+	}
+
+	// pass 1. re-encode instructions with the current operands
+	var newInsts []byte
+	posMap := make(map[int]int) // old position to new position
+	for i := 0; i < len(o.Instructions); i++ {
+		opcode := o.Instructions[i]
+		numOperands := parser.OpcodeOperands[opcode]
+		if version < 1 && opcode == parser.OpSliceIndex {
+			numOperands = nil
+		}
+		operands, read := parser.ReadOperands(numOperands,
+			o.Instructions[i+1:])
+		if version < 1 && opcode == parser.OpSliceIndex {
+			operands = []int{2}
+		}
+		posMap[i] = len(newInsts)
+		newInsts = append(newInsts, MakeInstruction(opcode, operands...)...)
+		i += read
+	}
+	posMap[len(o.Instructions)] = len(newInsts)
+
+	// pass 2. update jump positions
+	iterateInstructions(newInsts,
+		func(pos int, opcode parser.Opcode, operands []int) bool {
+			switch opcode {
+			case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
+				parser.OpOrJump, parser.OpOptionalJump, parser.OpCoalesceJump:
+				if newDst, ok := posMap[operands[0]]; ok {
+					copy(newInsts[pos:], MakeInstruction(opcode, newDst))
+				}
+			}
+			return true
+		})
+
+	// pass 3. update source map
+	if o.SourceMap != nil {
+		newSourceMap := make(map[int]parser.Pos, len(o.SourceMap))
+		for pos, srcPos := range o.SourceMap {
+			if newPos, ok := posMap[pos]; ok {
+				newSourceMap[newPos] = srcPos
+			}
+		}
+		o.SourceMap = newSourceMap
+	}
+	o.Instructions = newInsts
+}
+
 // RemoveDuplicates finds and remove the duplicate values in Constants.
 // Note this function mutates Bytecode.
 func (b *Bytecode) RemoveDuplicates() {
@@ -145,6 +236,8 @@ func (b *Bytecode) RemoveDuplicates() {
 	strings := make(map[string]int)
 	floats := make(map[float64]int)
 	chars := make(map[rune]int)
+	bigInts := make(map[string]int)
+	decimals := make(map[string]int)
 	immutableMaps := make(map[string]int) // for modules
 
 	for curIdx, c := range b.Constants {
@@ -205,6 +298,26 @@ func (b *Bytecode) RemoveDuplicates() {
 				indexMap[curIdx] = newIdx
 				deduped = append(deduped, c)
 			}
+		case *BigInt:
+			key := c.Value.String()
+			if newIdx, ok := bigInts[key]; ok {
+				indexMap[curIdx] = newIdx
+			} else {
+				newIdx = len(deduped)
+				bigInts[key] = newIdx
+				indexMap[curIdx] = newIdx
+				deduped = append(deduped, c)
+			}
+		case *Decimal:
+			key := c.String()
+			if newIdx, ok := decimals[key]; ok {
+				indexMap[curIdx] = newIdx
+			} else {
+				newIdx = len(deduped)
+				decimals[key] = newIdx
+				indexMap[curIdx] = newIdx
+				deduped = append(deduped, c)
+			}
 		default:
 			panic(fmt.Errorf("unsupported top-level constant type: %s",
 				c.TypeName()))
@@ -262,6 +375,9 @@ func fixDecodedObject(
 			}
 			o.Value[k] = fv
 		}
+		if err := fixDecodedEntries(o.Hashed, modules); err != nil {
+			return nil, err
+		}
 	case *ImmutableMap:
 		modName := inferModuleName(o)
 		if mod := modules.GetBuiltinModule(modName); mod != nil {
@@ -280,10 +396,36 @@ func fixDecodedObject(
 			}
 			o.Value[k] = fv
 		}
+		if err := fixDecodedEntries(o.Hashed, modules); err != nil {
+			return nil, err
+		}
+	case *Set:
+		for k, v := range o.Value {
+			fv, err := fixDecodedObject(v, modules)
+			if err != nil {
+				return nil, err
+			}
+			o.Value[k] = fv
+		}
 	}
 	return o, nil
 }
 
+func fixDecodedEntries(hashed map[HashKey]MapEntry, modules *ModuleMap) error {
+	for k, e := range hashed {
+		fk, err := fixDecodedObject(e.Key, modules)
+		if err != nil {
+			return err
+		}
+		fv, err := fixDecodedObject(e.Value, modules)
+		if err != nil {
+			return err
+		}
+		hashed[k] = MapEntry{Key: fk, Value: fv}
+	}
+	return nil
+}
+
 func updateConstIndexes(insts []byte, indexMap map[int]int) {
 	i := 0
 	for i < len(insts) {
@@ -1,10 +1,12 @@
 package vvm_test
 
 import (
+	"math/big"
 	"testing"
 	"time"
 
 	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/encoding"
 	"github.com/malivvan/vv/vvm/parser"
 	"github.com/malivvan/vv/vvm/require"
 )
@@ -53,14 +55,16 @@ func TestBytecode(t *testing.T) {
 							vvm.UndefinedValue,
 						},
 					},
-					"true":  vvm.TrueValue,
-					"false": vvm.FalseValue,
-					"bytes": &vvm.Bytes{Value: make([]byte, 16)},
-					"char":  &vvm.Char{Value: 'Y'},
+					"true":   vvm.TrueValue,
+					"false":  vvm.FalseValue,
+					"bigint": &vvm.BigInt{Value: new(big.Int).Lsh(big.NewInt(3), 100)},
+					"bytes":  &vvm.Bytes{Value: make([]byte, 16)},
+					"char":   &vvm.Char{Value: 'Y'},
 					"error": &vvm.Error{Value: &vvm.String{
 						Value: "some error",
 					}},
-					"float": &vvm.Float{Value: -19.84},
+					"decimal": &vvm.Decimal{Value: big.NewInt(-1250), Scale: 3},
+					"float":   &vvm.Float{Value: -19.84},
 					"immutable_array": &vvm.ImmutableArray{
 						Value: []vvm.Object{
 							&vvm.Int{Value: 1},
@@ -92,6 +96,23 @@ func TestBytecode(t *testing.T) {
 							"f": vvm.UndefinedValue,
 						},
 					},
+					"hashed_map": func() vvm.Object {
+						m := &vvm.Map{Value: map[string]vvm.Object{
+							"a": &vvm.Int{Value: 1},
+						}}
+						_ = m.IndexSet(&vvm.Int{Value: 1}, vvm.TrueValue)
+						_ = m.IndexSet(&vvm.ImmutableArray{Value: []vvm.Object{
+							&vvm.Char{Value: 'a'},
+							&vvm.Float{Value: 1.5},
+						}}, &vvm.String{Value: "array key"})
+						return m
+					}(),
+					"set": func() vvm.Object {
+						s, _ := vvm.NewSet(&vvm.Int{Value: 1},
+							&vvm.String{Value: "a"},
+							&vvm.Time{Value: time.Unix(1257894000, 0)})
+						return s
+					}(),
 					"string":    &vvm.String{Value: "foo bar"},
 					"time":      &vvm.Time{Value: time.Now()},
 					"undefined": vvm.UndefinedValue,
@@ -251,6 +272,50 @@ func TestBytecode_CountObjects(t *testing.T) {
 	require.Equal(t, 7, b.CountObjects())
 }
 
+func TestBytecode_Upgrade(t *testing.T) {
+	// version 0 encoded OpSliceIndex without operands
+	v0 := concatInsts(
+		vvm.MakeInstruction(parser.OpGetGlobal, 0),
+		vvm.MakeInstruction(parser.OpJumpFalsy, 12),
+		vvm.MakeInstruction(parser.OpGetGlobal, 0),
+		vvm.MakeInstruction(parser.OpNull),
+		vvm.MakeInstruction(parser.OpNull),
+		[]byte{parser.OpSliceIndex},
+		vvm.MakeInstruction(parser.OpPop),
+		vvm.MakeInstruction(parser.OpJump, 3),
+		vvm.MakeInstruction(parser.OpSuspend))
+	fn := &vvm.CompiledFunction{
+		Instructions: v0,
+		SourceMap:    map[int]parser.Pos{0: 1, 10: 2, 12: 3},
+	}
+	b := &vvm.Bytecode{
+		MainFunction: &vvm.CompiledFunction{Instructions: v0},
+		Constants:    []vvm.Object{fn},
+	}
+	require.NoError(t, b.Upgrade(0))
+
+	expected := concatInsts(
+		vvm.MakeInstruction(parser.OpGetGlobal, 0),
+		vvm.MakeInstruction(parser.OpJumpFalsy, 13),
+		vvm.MakeInstruction(parser.OpGetGlobal, 0),
+		vvm.MakeInstruction(parser.OpNull),
+		vvm.MakeInstruction(parser.OpNull),
+		vvm.MakeInstruction(parser.OpSliceIndex, 2),
+		vvm.MakeInstruction(parser.OpPop),
+		vvm.MakeInstruction(parser.OpJump, 3),
+		vvm.MakeInstruction(parser.OpSuspend))
+	require.Equal(t, expected, b.MainFunction.Instructions)
+	require.Equal(t, expected, fn.Instructions)
+	require.Equal(t, 3, len(fn.SourceMap))
+	require.Equal(t, parser.Pos(1), fn.SourceMap[0])
+	require.Equal(t, parser.Pos(2), fn.SourceMap[10])
+	require.Equal(t, parser.Pos(3), fn.SourceMap[13])
+
+	require.NoError(t, b.Upgrade(vvm.FormatVersion))
+	require.Equal(t, expected, b.MainFunction.Instructions)
+	require.Error(t, b.Upgrade(vvm.FormatVersion+1))
+}
+
 func fileSet(files ...srcfile) *parser.SourceFileSet {
 	fileSet := parser.NewFileSet()
 	for _, f := range files {
@@ -294,3 +359,46 @@ func testBytecodeSerialization(t *testing.T, b *vvm.Bytecode) {
 	require.Equal(t, b.MainFunction, r.MainFunction)
 	require.Equal(t, b.Constants, r.Constants)
 }
+
+func TestUnmarshalObject_Version(t *testing.T) {
+	// version 1 encoded maps without their non-string keyed entries
+	value := map[string]vvm.Object{"a": &vvm.Int{Value: 1}}
+	for _, o := range []vvm.Object{
+		&vvm.Map{Value: value},
+		&vvm.ImmutableMap{Value: value},
+	} {
+		b := make([]byte, vvm.SizeOfObject(o))
+		vvm.MarshalObject(0, b, o)
+		v1 := b[:1+encoding.SizeMap(value, encoding.SizeString, vvm.SizeOfObject)]
+
+		n, decoded, err := vvm.UnmarshalObject(0, v1, 1)
+		require.NoError(t, err)
+		require.Equal(t, len(v1), n)
+		require.Equal(t, o, decoded)
+
+		_, _, err = vvm.UnmarshalObject(0, v1, vvm.FormatVersion)
+		require.Error(t, err)
+	}
+}
+
+func TestUnmarshalObject_ErrorVersion(t *testing.T) {
+	// version 2 encoded errors without their code, cause and stack
+	o := &vvm.Error{
+		Value: &vvm.String{Value: "boom"},
+		Code:  "E1",
+		Cause: &vvm.Error{Value: &vvm.String{Value: "cause"}},
+	}
+	b := make([]byte, vvm.SizeOfObject(o))
+	vvm.MarshalObject(0, b, o)
+	v2 := b[:1+vvm.SizeOfObject(o.Value)]
+
+	n, decoded, err := vvm.UnmarshalObject(0, v2, 2)
+	require.NoError(t, err)
+	require.Equal(t, len(v2), n)
+	require.Equal(t, &vvm.Error{Value: &vvm.String{Value: "boom"}}, decoded)
+
+	n, decoded, err = vvm.UnmarshalObject(0, b, vvm.FormatVersion)
+	require.NoError(t, err)
+	require.Equal(t, len(b), n)
+	require.Equal(t, o, decoded)
+}
@@ -12,12 +12,17 @@ import (
 	"github.com/malivvan/vv/vvm/token"
 )
 
+// maxMultiValues is the maximum number of values a function can return or
+// an assignment can unpack at once.
+const maxMultiValues = 255
+
 // compilationScope represents a compiled instructions and the last two
 // instructions that were emitted.
 type compilationScope struct {
 	Instructions []byte
 	SymbolInit   map[string]bool
 	SourceMap    map[int]parser.Pos
+	Generator    bool
 }
 
 // loop represents a loop construct that the compiler uses to track the current
@@ -54,6 +59,9 @@ type Compiler struct {
 	allowFileImport bool
 	loops           []*loop
 	loopIndex       int
+	chainJumps      [][]int
+	chainLink       bool
+	tailCalls       map[*parser.CallExpr]bool
 	trace           io.Writer
 	indent          int
 }
@@ -128,7 +136,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return err
 		}
 	case *parser.BinaryExpr:
-		if node.Token == token.LAnd || node.Token == token.LOr {
+		if node.Token == token.LAnd || node.Token == token.LOr ||
+			node.Token == token.Coalesce {
 			return c.compileLogical(node)
 		}
 		if node.Token == token.Less {
@@ -149,6 +158,16 @@ func (c *Compiler) Compile(node parser.Node) error {
 			}
 			c.emit(node, parser.OpBinaryOp, int(token.GreaterEq))
 			return nil
+		} else if node.Token == token.In {
+			// the container decides about membership
+			if err := c.Compile(node.RHS); err != nil {
+				return err
+			}
+			if err := c.Compile(node.LHS); err != nil {
+				return err
+			}
+			c.emit(node, parser.OpBinaryOp, int(token.In))
+			return nil
 		}
 		if err := c.Compile(node.LHS); err != nil {
 			return err
@@ -198,6 +217,16 @@ func (c *Compiler) Compile(node parser.Node) error {
 	case *parser.FloatLit:
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&Float{Value: node.Value}))
+	case *parser.BigIntLit:
+		c.emit(node, parser.OpConstant,
+			c.addConstant(&BigInt{Value: node.Value}))
+	case *parser.DecimalLit:
+		d, err := ParseDecimal(node.Value)
+		if err != nil {
+			return c.errorf(node, "invalid decimal literal: %s",
+				node.Literal)
+		}
+		c.emit(node, parser.OpConstant, c.addConstant(d))
 	case *parser.BoolLit:
 		if node.Value {
 			c.emit(node, parser.OpTrue)
@@ -210,6 +239,25 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&String{Value: node.Value}))
+	case *parser.TemplateLit:
+		numParts := 0
+		for i, str := range node.Strings {
+			if str != "" {
+				if len(str) > MaxStringLen {
+					return c.error(node, ErrStringLimit)
+				}
+				c.emit(node, parser.OpConstant,
+					c.addConstant(&String{Value: str}))
+				numParts++
+			}
+			if i < len(node.Exprs) {
+				if err := c.Compile(node.Exprs[i]); err != nil {
+					return err
+				}
+				numParts++
+			}
+		}
+		c.emit(node, parser.OpConcat, numParts)
 	case *parser.CharLit:
 		c.emit(node, parser.OpConstant,
 			c.addConstant(&Char{Value: node.Value}))
@@ -356,25 +404,39 @@ func (c *Compiler) Compile(node parser.Node) error {
 		c.emit(node, parser.OpMap, len(node.Elements)*2)
 
 	case *parser.SelectorExpr: // selector on RHS side
-		if err := c.Compile(node.Expr); err != nil {
+		chain := c.beginChain()
+		if err := c.compileChainExpr(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.emitOptionalJump(node)
+		}
 		if err := c.Compile(node.Sel); err != nil {
 			return err
 		}
 		c.emit(node, parser.OpIndex)
+		c.endChain(chain)
 	case *parser.IndexExpr:
-		if err := c.Compile(node.Expr); err != nil {
+		chain := c.beginChain()
+		if err := c.compileChainExpr(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.emitOptionalJump(node)
+		}
 		if err := c.Compile(node.Index); err != nil {
 			return err
 		}
 		c.emit(node, parser.OpIndex)
+		c.endChain(chain)
 	case *parser.SliceExpr:
-		if err := c.Compile(node.Expr); err != nil {
+		chain := c.beginChain()
+		if err := c.compileChainExpr(node.Expr); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.emitOptionalJump(node)
+		}
 		if node.Low != nil {
 			if err := c.Compile(node.Low); err != nil {
 				return err
@@ -389,7 +451,15 @@ func (c *Compiler) Compile(node parser.Node) error {
 		} else {
 			c.emit(node, parser.OpNull)
 		}
-		c.emit(node, parser.OpSliceIndex)
+		if node.Step != nil {
+			if err := c.Compile(node.Step); err != nil {
+				return err
+			}
+			c.emit(node, parser.OpSliceIndex, 3)
+		} else {
+			c.emit(node, parser.OpSliceIndex, 2)
+		}
+		c.endChain(chain)
 	case *parser.FuncLit:
 		c.enterScope()
 
@@ -400,6 +470,13 @@ func (c *Compiler) Compile(node parser.Node) error {
 			s.LocalAssigned = true
 		}
 
+		if node.Generator {
+			// calling a generator function suspends its frame right away
+			// and returns it as a generator object.
+			c.scopes[c.scopeIndex].Generator = true
+			c.emit(node, parser.OpGenerator)
+		}
+
 		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
@@ -483,16 +560,36 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return c.errorf(node, "return not allowed outside function")
 		}
 
-		if node.Result == nil {
-			c.emit(node, parser.OpReturn, 0)
-		} else {
-			if err := c.Compile(node.Result); err != nil {
+		if len(node.Results) > maxMultiValues {
+			return c.errorf(node, "too many return values")
+		}
+		if len(node.Results) == 1 && !c.scopes[c.scopeIndex].Generator {
+			c.markTailCalls(node.Results[0])
+		}
+		for _, expr := range node.Results {
+			if err := c.Compile(expr); err != nil {
 				return err
 			}
-			c.emit(node, parser.OpReturn, 1)
 		}
+		c.emit(node, parser.OpReturn, len(node.Results))
+	case *parser.YieldStmt:
+		if c.symbolTable.Parent(true) == nil {
+			// outside the function
+			return c.errorf(node, "yield not allowed outside function")
+		}
+		if !c.scopes[c.scopeIndex].Generator {
+			return c.errorf(node, "yield not allowed outside generator function")
+		}
+
+		if node.Result == nil {
+			c.emit(node, parser.OpNull)
+		} else if err := c.Compile(node.Result); err != nil {
+			return err
+		}
+		c.emit(node, parser.OpYield)
 	case *parser.CallExpr:
-		if err := c.Compile(node.Func); err != nil {
+		chain := c.beginChain()
+		if err := c.compileChainExpr(node.Func); err != nil {
 			return err
 		}
 		for _, arg := range node.Args {
@@ -504,7 +601,13 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if node.Ellipsis.IsValid() {
 			ellipsis = 1
 		}
-		c.emit(node, parser.OpCall, len(node.Args), ellipsis)
+		if c.tailCalls[node] {
+			delete(c.tailCalls, node)
+			c.emit(node, parser.OpTailCall, len(node.Args), ellipsis)
+		} else {
+			c.emit(node, parser.OpCall, len(node.Args), ellipsis)
+		}
+		c.endChain(chain)
 	case *parser.ImportExpr:
 		if node.ModuleName == "" {
 			return c.errorf(node, "empty module name")
@@ -640,8 +743,15 @@ func (c *Compiler) compileAssign(
 	op token.Token,
 ) error {
 	numLHS, numRHS := len(lhs), len(rhs)
-	if numLHS > 1 || numRHS > 1 {
-		return c.errorf(node, "tuple assignment not allowed")
+	if numLHS > 1 || numRHS > 1 || isPattern(lhs[0]) {
+		if op != token.Assign && op != token.Define {
+			return c.errorf(node, "tuple assignment not allowed")
+		}
+		return c.compileDestructure(node, lhs, rhs, op)
+	}
+
+	if isOptionalChain(lhs[0]) {
+		return c.errorf(node, "optional chaining not allowed in assignment")
 	}
 
 	// resolve and compile left-hand side
@@ -703,6 +813,169 @@ func (c *Compiler) compileAssign(
 	case token.ShrAssign:
 		c.emit(node, parser.OpBinaryOp, int(token.Shr))
 	}
+	return c.compileSetSymbol(node, symbol, selectors, op)
+}
+
+// compileDestructure compiles an assignment with multiple values on either
+// side or with an array or map pattern on the left-hand side. All values
+// are evaluated before any of the variables is assigned.
+func (c *Compiler) compileDestructure(
+	node parser.Node,
+	lhs, rhs []parser.Expr,
+	op token.Token,
+) error {
+	numLHS, numRHS := len(lhs), len(rhs)
+	if numRHS != 1 && numRHS != numLHS {
+		return c.errorf(node,
+			"assignment mismatch: %d variables but %d values",
+			numLHS, numRHS)
+	}
+
+	// with ':=' variables already declared in the current block are assigned
+	// and all others are defined
+	var newNames []string
+	if op == token.Define {
+		seen := make(map[string]bool)
+		for _, expr := range lhs {
+			err := walkPattern(expr, func(target parser.Expr) error {
+				switch target := target.(type) {
+				case *parser.Ident:
+					if target.Name == "_" {
+						return nil
+					}
+					if seen[target.Name] {
+						return c.errorf(target,
+							"'%s' repeated on left side of :=", target.Name)
+					}
+					seen[target.Name] = true
+					_, depth, exists := c.symbolTable.Resolve(target.Name, false)
+					if depth != 0 || !exists {
+						newNames = append(newNames, target.Name)
+					}
+					return nil
+				case *parser.SelectorExpr, *parser.IndexExpr:
+					// using selector on new variable does not make sense
+					return c.errorf(node,
+						"operator ':=' not allowed with selector")
+				}
+				return c.errorf(target, "invalid destructuring target")
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(newNames) == 0 {
+			return c.errorf(node, "no new variables on left side of :=")
+		}
+	}
+
+	for _, expr := range rhs {
+		if err := c.Compile(expr); err != nil {
+			return err
+		}
+	}
+	if numRHS == 1 && numLHS > 1 {
+		if numLHS > maxMultiValues {
+			return c.errorf(node, "too many variables")
+		}
+		c.emit(node, parser.OpDestructure, numLHS, 1)
+	}
+
+	// new variables are defined only after the values are compiled
+	defined := make(map[string]*Symbol, len(newNames))
+	for _, name := range newNames {
+		defined[name] = c.symbolTable.Define(name)
+	}
+
+	// values are on the stack in order: assign them right to left
+	for i := numLHS - 1; i >= 0; i-- {
+		err := c.compileAssignPattern(node, lhs[i], op, defined)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileAssignPattern assigns the value on top of the stack to the
+// target expression, unpacking it further if target is an array or map
+// pattern.
+func (c *Compiler) compileAssignPattern(
+	node parser.Node,
+	target parser.Expr,
+	op token.Token,
+	defined map[string]*Symbol,
+) error {
+	switch target := target.(type) {
+	case *parser.ArrayLit:
+		numElements := len(target.Elements)
+		if numElements == 0 || numElements > maxMultiValues {
+			return c.errorf(target, "invalid destructuring target")
+		}
+		c.emit(node, parser.OpDestructure, numElements, 0)
+		for i := numElements - 1; i >= 0; i-- {
+			err := c.compileAssignPattern(node, target.Elements[i], op,
+				defined)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parser.MapLit:
+		numElements := len(target.Elements)
+		if numElements == 0 || numElements > maxMultiValues {
+			return c.errorf(target, "invalid destructuring target")
+		}
+		for _, elt := range target.Elements {
+			c.emit(node, parser.OpConstant,
+				c.addConstant(&String{Value: elt.Key}))
+		}
+		c.emit(node, parser.OpDestructureMap, numElements)
+		for i := numElements - 1; i >= 0; i-- {
+			err := c.compileAssignPattern(node, target.Elements[i].Value,
+				op, defined)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parser.Ident:
+		if target.Name == "_" {
+			c.emit(node, parser.OpPop)
+			return nil
+		}
+	case *parser.SelectorExpr, *parser.IndexExpr:
+		if isOptionalChain(target) {
+			return c.errorf(target,
+				"optional chaining not allowed in assignment")
+		}
+	default:
+		return c.errorf(target, "invalid destructuring target")
+	}
+
+	ident, selectors := resolveAssignLHS(target)
+	if ident == "" {
+		return c.errorf(target, "invalid destructuring target")
+	}
+	symbol, ok := defined[ident]
+	if !ok {
+		symbol, _, ok = c.symbolTable.Resolve(ident, false)
+		if !ok {
+			return c.errorf(target, "unresolved reference '%s'", ident)
+		}
+	}
+	return c.compileSetSymbol(node, symbol, selectors, op)
+}
+
+// compileSetSymbol assigns the value on top of the stack to the symbol or
+// to the element of the symbol addressed by selectors.
+func (c *Compiler) compileSetSymbol(
+	node parser.Node,
+	symbol *Symbol,
+	selectors []parser.Expr,
+	op token.Token,
+) error {
+	numSel := len(selectors)
 
 	// compile selector expressions (right to left)
 	for i := numSel - 1; i >= 0; i-- {
@@ -752,9 +1025,12 @@ func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
 
 	// jump position
 	var jumpPos int
-	if node.Token == token.LAnd {
+	switch node.Token {
+	case token.LAnd:
 		jumpPos = c.emit(node, parser.OpAndJump, 0)
-	} else {
+	case token.Coalesce:
+		jumpPos = c.emit(node, parser.OpCoalesceJump, 0)
+	default:
 		jumpPos = c.emit(node, parser.OpOrJump, 0)
 	}
 
@@ -767,6 +1043,65 @@ func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
 	return nil
 }
 
+// beginChain starts a new optional chain unless the expression being
+// compiled continues the chain of its parent expression. It returns true if
+// a new chain was started.
+func (c *Compiler) beginChain() bool {
+	if c.chainLink {
+		c.chainLink = false
+		return false
+	}
+	c.chainJumps = append(c.chainJumps, nil)
+	return true
+}
+
+// endChain makes the optional jumps of the chain started by beginChain jump
+// past the whole chain.
+func (c *Compiler) endChain(started bool) {
+	if !started {
+		return
+	}
+	last := len(c.chainJumps) - 1
+	for _, pos := range c.chainJumps[last] {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.chainJumps = c.chainJumps[:last]
+}
+
+// markTailCalls marks the calls whose result is returned as is by the
+// returned expr, so that they can reuse the frame of the returning function.
+func (c *Compiler) markTailCalls(expr parser.Expr) {
+	switch expr := expr.(type) {
+	case *parser.CallExpr:
+		if c.tailCalls == nil {
+			c.tailCalls = make(map[*parser.CallExpr]bool)
+		}
+		c.tailCalls[expr] = true
+	case *parser.CondExpr:
+		c.markTailCalls(expr.True)
+		c.markTailCalls(expr.False)
+	case *parser.ParenExpr:
+		c.markTailCalls(expr.Expr)
+	}
+}
+
+// compileChainExpr compiles the operand of a selector, index, slice or call
+// expression as a part of the same optional chain.
+func (c *Compiler) compileChainExpr(expr parser.Expr) error {
+	switch expr.(type) {
+	case *parser.SelectorExpr, *parser.IndexExpr, *parser.SliceExpr,
+		*parser.CallExpr:
+		c.chainLink = true
+	}
+	return c.Compile(expr)
+}
+
+func (c *Compiler) emitOptionalJump(node parser.Node) {
+	pos := c.emit(node, parser.OpOptionalJump, 0)
+	last := len(c.chainJumps) - 1
+	c.chainJumps[last] = append(c.chainJumps[last], pos)
+}
+
 func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
 	c.symbolTable = c.symbolTable.Fork(true)
 	defer func() {
@@ -1146,7 +1481,8 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy,
-				parser.OpAndJump, parser.OpOrJump:
+				parser.OpAndJump, parser.OpOrJump,
+				parser.OpOptionalJump, parser.OpCoalesceJump:
 				dsts[operands[0]] = true
 			}
 			return true
@@ -1186,7 +1522,7 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 		func(pos int, opcode parser.Opcode, operands []int) bool {
 			switch opcode {
 			case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
-				parser.OpOrJump:
+				parser.OpOrJump, parser.OpOptionalJump, parser.OpCoalesceJump:
 				newDst, ok := posMap[operands[0]]
 				if ok {
 					copy(newInsts[pos:],
@@ -1257,6 +1593,49 @@ func (c *Compiler) printTrace(a ...interface{}) {
 	_, _ = fmt.Fprintln(c.trace, a...)
 }
 
+// isPattern returns true if expr is an array or map destructuring pattern.
+func isPattern(expr parser.Expr) bool {
+	switch expr.(type) {
+	case *parser.ArrayLit, *parser.MapLit:
+		return true
+	}
+	return false
+}
+
+// walkPattern calls fn for each assignment target of a destructuring
+// pattern.
+func walkPattern(expr parser.Expr, fn func(target parser.Expr) error) error {
+	switch expr := expr.(type) {
+	case *parser.ArrayLit:
+		for _, elt := range expr.Elements {
+			if err := walkPattern(elt, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parser.MapLit:
+		for _, elt := range expr.Elements {
+			if err := walkPattern(elt.Value, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fn(expr)
+}
+
+// isOptionalChain returns true if expr is an optional selector or index
+// expression or is applied to one.
+func isOptionalChain(expr parser.Expr) bool {
+	switch expr := expr.(type) {
+	case *parser.SelectorExpr:
+		return expr.Optional || isOptionalChain(expr.Expr)
+	case *parser.IndexExpr:
+		return expr.Optional || isOptionalChain(expr.Expr)
+	}
+	return false
+}
+
 func resolveAssignLHS(expr parser.Expr) (name string, selectors []parser.Expr) {
 	switch term := expr.(type) {
 	case *parser.SelectorExpr:
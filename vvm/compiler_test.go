@@ -251,6 +251,45 @@ func TestCompiler_Compile(t *testing.T) {
 				stringObject("ka"),
 				stringObject("mi"))))
 
+	expectCompile(t, "a := 1; `ka${a}mi${a + 1}`",
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpSetGlobal, 0),
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpGetGlobal, 0),
+				vvm.MakeInstruction(parser.OpConstant, 2),
+				vvm.MakeInstruction(parser.OpGetGlobal, 0),
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpBinaryOp, 11),
+				vvm.MakeInstruction(parser.OpConcat, 4),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				stringObject("ka"),
+				stringObject("mi"))))
+
+	expectCompile(t, `a := {}; a?.b.c ?? 1`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpMap, 0),
+				vvm.MakeInstruction(parser.OpSetGlobal, 0),
+				vvm.MakeInstruction(parser.OpGetGlobal, 0),
+				vvm.MakeInstruction(parser.OpOptionalJump, 20),
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpIndex),
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpIndex),
+				vvm.MakeInstruction(parser.OpCoalesceJump, 26),
+				vvm.MakeInstruction(parser.OpConstant, 2),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				stringObject("b"),
+				stringObject("c"),
+				intObject(1))))
+
 	expectCompile(t, `a := 1; b := 2; a += b`,
 		bytecode(
 			concatInsts(
@@ -422,7 +461,7 @@ func TestCompiler_Compile(t *testing.T) {
 				vvm.MakeInstruction(parser.OpArray, 3),
 				vvm.MakeInstruction(parser.OpNull),
 				vvm.MakeInstruction(parser.OpNull),
-				vvm.MakeInstruction(parser.OpSliceIndex),
+				vvm.MakeInstruction(parser.OpSliceIndex, 2),
 				vvm.MakeInstruction(parser.OpPop),
 				vvm.MakeInstruction(parser.OpSuspend)),
 			objectsArray(
@@ -439,7 +478,7 @@ func TestCompiler_Compile(t *testing.T) {
 				vvm.MakeInstruction(parser.OpArray, 3),
 				vvm.MakeInstruction(parser.OpConstant, 3),
 				vvm.MakeInstruction(parser.OpConstant, 1),
-				vvm.MakeInstruction(parser.OpSliceIndex),
+				vvm.MakeInstruction(parser.OpSliceIndex, 2),
 				vvm.MakeInstruction(parser.OpPop),
 				vvm.MakeInstruction(parser.OpSuspend)),
 			objectsArray(
@@ -457,7 +496,7 @@ func TestCompiler_Compile(t *testing.T) {
 				vvm.MakeInstruction(parser.OpArray, 3),
 				vvm.MakeInstruction(parser.OpNull),
 				vvm.MakeInstruction(parser.OpConstant, 1),
-				vvm.MakeInstruction(parser.OpSliceIndex),
+				vvm.MakeInstruction(parser.OpSliceIndex, 2),
 				vvm.MakeInstruction(parser.OpPop),
 				vvm.MakeInstruction(parser.OpSuspend)),
 			objectsArray(
@@ -474,7 +513,7 @@ func TestCompiler_Compile(t *testing.T) {
 				vvm.MakeInstruction(parser.OpArray, 3),
 				vvm.MakeInstruction(parser.OpConstant, 3),
 				vvm.MakeInstruction(parser.OpNull),
-				vvm.MakeInstruction(parser.OpSliceIndex),
+				vvm.MakeInstruction(parser.OpSliceIndex, 2),
 				vvm.MakeInstruction(parser.OpPop),
 				vvm.MakeInstruction(parser.OpSuspend)),
 			objectsArray(
@@ -483,6 +522,24 @@ func TestCompiler_Compile(t *testing.T) {
 				intObject(3),
 				intObject(0))))
 
+	expectCompile(t, `[1, 2, 3][::2]`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpConstant, 2),
+				vvm.MakeInstruction(parser.OpArray, 3),
+				vvm.MakeInstruction(parser.OpNull),
+				vvm.MakeInstruction(parser.OpNull),
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpSliceIndex, 3),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2),
+				intObject(3))))
+
 	expectCompile(t, `f1 := func(a) { return a }; f1([1, 2]...);`,
 		bytecode(
 			concatInsts(
@@ -502,6 +559,47 @@ func TestCompiler_Compile(t *testing.T) {
 				intObject(1),
 				intObject(2))))
 
+	expectCompile(t, `func() { a, b := [1, 2]; {c} := a; return b, c }`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 3),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2),
+				stringObject("c"),
+				compiledFunction(3, 0,
+					vvm.MakeInstruction(parser.OpConstant, 0),
+					vvm.MakeInstruction(parser.OpConstant, 1),
+					vvm.MakeInstruction(parser.OpArray, 2),
+					vvm.MakeInstruction(parser.OpDestructure, 2, 1),
+					vvm.MakeInstruction(parser.OpDefineLocal, 1),
+					vvm.MakeInstruction(parser.OpDefineLocal, 0),
+					vvm.MakeInstruction(parser.OpGetLocal, 0),
+					vvm.MakeInstruction(parser.OpConstant, 2),
+					vvm.MakeInstruction(parser.OpDestructureMap, 1),
+					vvm.MakeInstruction(parser.OpDefineLocal, 2),
+					vvm.MakeInstruction(parser.OpGetLocal, 1),
+					vvm.MakeInstruction(parser.OpGetLocal, 2),
+					vvm.MakeInstruction(parser.OpReturn, 2)))))
+
+	expectCompile(t, `a, b := 1, 2; a, b = b, a`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpSetGlobal, 1),
+				vvm.MakeInstruction(parser.OpSetGlobal, 0),
+				vvm.MakeInstruction(parser.OpGetGlobal, 1),
+				vvm.MakeInstruction(parser.OpGetGlobal, 0),
+				vvm.MakeInstruction(parser.OpSetGlobal, 1),
+				vvm.MakeInstruction(parser.OpSetGlobal, 0),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
+				intObject(2))))
+
 	expectCompile(t, `func() { return 5 + 10 }`,
 		bytecode(
 			concatInsts(
@@ -517,6 +615,21 @@ func TestCompiler_Compile(t *testing.T) {
 					vvm.MakeInstruction(parser.OpBinaryOp, 11),
 					vvm.MakeInstruction(parser.OpReturn, 1)))))
 
+	expectCompile(t, `func(a) { yield a; yield }`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 0),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				compiledFunction(1, 1,
+					vvm.MakeInstruction(parser.OpGenerator),
+					vvm.MakeInstruction(parser.OpGetLocal, 0),
+					vvm.MakeInstruction(parser.OpYield),
+					vvm.MakeInstruction(parser.OpNull),
+					vvm.MakeInstruction(parser.OpYield),
+					vvm.MakeInstruction(parser.OpReturn, 0)))))
+
 	expectCompile(t, `func() { 5 + 10 }`,
 		bytecode(
 			concatInsts(
@@ -813,10 +926,26 @@ func TestCompiler_Compile(t *testing.T) {
 				vvm.MakeInstruction(parser.OpPop),
 				vvm.MakeInstruction(parser.OpSuspend)),
 			objectsArray(
+				compiledFunction(0, 0,
+					vvm.MakeInstruction(parser.OpGetBuiltin, 0),
+					vvm.MakeInstruction(parser.OpArray, 0),
+					vvm.MakeInstruction(parser.OpTailCall, 1, 0),
+					vvm.MakeInstruction(parser.OpReturn, 1)))))
+
+	expectCompile(t, `func() { return (len([]) + 1) }`,
+		bytecode(
+			concatInsts(
+				vvm.MakeInstruction(parser.OpConstant, 1),
+				vvm.MakeInstruction(parser.OpPop),
+				vvm.MakeInstruction(parser.OpSuspend)),
+			objectsArray(
+				intObject(1),
 				compiledFunction(0, 0,
 					vvm.MakeInstruction(parser.OpGetBuiltin, 0),
 					vvm.MakeInstruction(parser.OpArray, 0),
 					vvm.MakeInstruction(parser.OpCall, 1, 0),
+					vvm.MakeInstruction(parser.OpConstant, 0),
+					vvm.MakeInstruction(parser.OpBinaryOp, 11),
 					vvm.MakeInstruction(parser.OpReturn, 1)))))
 
 	expectCompile(t, `func(a) { func(b) { return a + b } }`,
@@ -1020,8 +1149,20 @@ func TestCompilerErrorReport(t *testing.T) {
 
 	expectCompileError(t, `a = 1`,
 		"Compile Error: unresolved reference 'a'\n\tat test:1:1")
-	expectCompileError(t, `a, b := 1, 2`,
+	expectCompileError(t, `a, b := 1, 2, 3`,
+		"Compile Error: assignment mismatch: 2 variables but 3 values\n\tat test:1:1")
+	expectCompileError(t, `[a] += [1]`,
 		"Compile Error: tuple assignment not allowed\n\tat test:1:1")
+	expectCompileError(t, `a := 1; a, _ := 1, 2`,
+		"Compile Error: no new variables on left side of :=\n\tat test:1:9")
+	expectCompileError(t, `a, a := 1, 2`,
+		"Compile Error: 'a' repeated on left side of :=\n\tat test:1:4")
+	expectCompileError(t, `[a, 1] := [1, 2]`,
+		"Compile Error: invalid destructuring target\n\tat test:1:5")
+	expectCompileError(t, `a, b = 1, 2`,
+		"Compile Error: unresolved reference 'b'\n\tat test:1:4")
+	expectCompileError(t, `a.b, c := 1, 2`,
+		"not allowed with selector")
 	expectCompileError(t, `a.b := 1`,
 		"not allowed with selector")
 	expectCompileError(t, `a:=1; a:=3`,
@@ -1029,6 +1170,8 @@ func TestCompilerErrorReport(t *testing.T) {
 
 	expectCompileError(t, `return 5`,
 		"Compile Error: return not allowed outside function\n\tat test:1:1")
+	expectCompileError(t, `yield 5`,
+		"Compile Error: yield not allowed outside function\n\tat test:1:1")
 	expectCompileError(t, `func() { break }`,
 		"Compile Error: break not allowed outside loop\n\tat test:1:10")
 	expectCompileError(t, `func() { continue }`,
@@ -0,0 +1,222 @@
+package vvm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/malivvan/vv/vvm/token"
+)
+
+// decimalQuoDigits is the number of fractional digits a decimal quotient is
+// computed to beyond the larger scale of its operands.
+const decimalQuoDigits = 16
+
+var bigTen = big.NewInt(10)
+
+// ParseDecimal parses a decimal number in plain or exponent notation such as
+// "-12.50" or "1.5e3". The scale of the result is the number of fractional
+// digits the string represents, so "12.50" keeps its trailing zero.
+func ParseDecimal(s string) (*Decimal, error) {
+	str := s
+	exp := int64(0)
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(str[i+1:], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal: %q", s)
+		}
+		exp = e
+		str = str[:i]
+	}
+	neg := false
+	if str != "" && (str[0] == '-' || str[0] == '+') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(str, ".")
+	digits := intPart + fracPart
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid decimal: %q", s)
+	}
+	coef, _ := new(big.Int).SetString(digits, 10)
+	if neg {
+		coef.Neg(coef)
+	}
+	scale := int64(len(fracPart)) - exp
+	if scale < 0 {
+		coef.Mul(coef, pow10(-scale))
+		scale = 0
+	}
+	if scale > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid decimal: %q", s)
+	}
+	return &Decimal{Value: coef, Scale: int32(scale)}, nil
+}
+
+func pow10(n int64) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(n), nil)
+}
+
+func decimalFromInt(v int64) *Decimal {
+	return &Decimal{Value: big.NewInt(v)}
+}
+
+func decimalFromBigInt(v *big.Int) *Decimal {
+	return &Decimal{Value: new(big.Int).Set(v)}
+}
+
+// decimalFromFloat converts v using its shortest decimal representation,
+// so 0.1 becomes 0.1 rather than the exact binary value.
+func decimalFromFloat(v float64) (*Decimal, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("cannot convert %v to decimal", v)
+	}
+	return ParseDecimal(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// toDecimal converts an int, bigint, float or decimal to a decimal.
+func toDecimal(o Object) (*Decimal, error) {
+	switch o := o.(type) {
+	case *Decimal:
+		return o, nil
+	case *Int:
+		return decimalFromInt(o.Value), nil
+	case *BigInt:
+		return decimalFromBigInt(o.Value), nil
+	case *Float:
+		return decimalFromFloat(o.Value)
+	}
+	return nil, ErrInvalidOperator
+}
+
+// coefficient returns the coefficient of the decimal at the given scale,
+// which must not be less than its scale.
+func (o *Decimal) coefficient(scale int32) *big.Int {
+	if scale == o.Scale {
+		return o.Value
+	}
+	return new(big.Int).Mul(o.Value, pow10(int64(scale-o.Scale)))
+}
+
+// decimalAlign returns the coefficients of a and b at their common scale.
+func decimalAlign(a, b *Decimal) (x, y *big.Int, scale int32) {
+	scale = max(a.Scale, b.Scale)
+	return a.coefficient(scale), b.coefficient(scale), scale
+}
+
+// decimalQuoRound divides x by y and rounds the quotient half to even.
+func decimalQuoRound(x, y *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	c := new(big.Int).Abs(r)
+	c.Lsh(c, 1)
+	switch cmp := c.Cmp(new(big.Int).Abs(y)); {
+	case cmp > 0, cmp == 0 && q.Bit(0) == 1:
+		if (x.Sign() < 0) != (y.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Round returns the decimal rounded half to even to the given number of fractional
+// digits. Rounding never increases the scale.
+func (o *Decimal) Round(scale int32) *Decimal {
+	if scale >= o.Scale {
+		return o
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	return &Decimal{
+		Value: decimalQuoRound(o.Value, pow10(int64(o.Scale-scale))),
+		Scale: scale,
+	}
+}
+
+// trim removes trailing fractional zeros down to the given scale.
+func (o *Decimal) trim(scale int32) *Decimal {
+	coef, s := o.Value, o.Scale
+	r := new(big.Int)
+	for s > scale {
+		q, m := new(big.Int).QuoRem(coef, bigTen, r)
+		if m.Sign() != 0 {
+			break
+		}
+		coef = q
+		s--
+	}
+	if s == o.Scale {
+		return o
+	}
+	return &Decimal{Value: coef, Scale: s}
+}
+
+// decimalQuo divides a by b. The quotient is rounded to 16 fractional digits
+// beyond the larger operand scale and trailing zeros are trimmed.
+func decimalQuo(a, b *Decimal) (*Decimal, error) {
+	if b.Value.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+	scale := max(a.Scale, b.Scale)
+	target := scale + decimalQuoDigits
+	x := new(big.Int).Mul(a.Value, pow10(int64(target+b.Scale-a.Scale)))
+	q := &Decimal{Value: decimalQuoRound(x, b.Value), Scale: target}
+	return q.trim(scale), nil
+}
+
+// Cmp compares the decimal with x and returns -1, 0 or +1.
+func (o *Decimal) Cmp(x *Decimal) int {
+	a, b, _ := decimalAlign(o, x)
+	return a.Cmp(b)
+}
+
+// IsInt returns true if the decimal has no fractional part.
+func (o *Decimal) IsInt() bool {
+	if o.Scale == 0 {
+		return true
+	}
+	return new(big.Int).Rem(o.Value, pow10(int64(o.Scale))).Sign() == 0
+}
+
+// Int returns the integer part of the decimal, truncated towards zero.
+func (o *Decimal) Int() *big.Int {
+	if o.Scale == 0 {
+		return new(big.Int).Set(o.Value)
+	}
+	return new(big.Int).Quo(o.Value, pow10(int64(o.Scale)))
+}
+
+// Float64 returns the float64 value nearest to the decimal.
+func (o *Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(o.String(), 64)
+	return f
+}
+
+// compareOp returns the result of the comparison operator op for operands
+// that compare as c, or false if op is not a comparison.
+func compareOp(op token.Token, c int) (Object, bool) {
+	var res bool
+	switch op {
+	case token.Less:
+		res = c < 0
+	case token.LessEq:
+		res = c <= 0
+	case token.Greater:
+		res = c > 0
+	case token.GreaterEq:
+		res = c >= 0
+	default:
+		return nil, false
+	}
+	if res {
+		return TrueValue, true
+	}
+	return FalseValue, true
+}
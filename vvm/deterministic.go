@@ -0,0 +1,536 @@
+package vvm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace records a deterministic run: the seed of its random numbers, the
+// start of its virtual clock, and in order every scheduling decision,
+// channel operation and environment lookup of its routines. A run replayed
+// from a trace takes the same decisions and sees the same environment.
+type Trace struct {
+	Name   string       `json:"name,omitempty"` // name of the script
+	Seed   int64        `json:"seed"`
+	Clock  time.Time    `json:"clock"`
+	Events []TraceEvent `json:"events"`
+}
+
+// TraceEvent is an event of a deterministic run. Op is one of:
+//
+//	run      Routine is scheduled to run
+//	start    Routine starts the routine Key
+//	send     Routine sends to channel Key
+//	recv     Routine receives from channel Key
+//	close    Routine closes channel Key
+//	env      Routine looks up environment variable Key, which is Value or Unset
+//	environ  Routine reads the environment, which is Value
+type TraceEvent struct {
+	Routine string `json:"routine"`
+	Op      string `json:"op"`
+	Key     string `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Unset   bool   `json:"unset,omitempty"`
+}
+
+func (e TraceEvent) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", e.Routine, e.Op, e.Key))
+}
+
+// Deterministic makes a run reproducible. Random numbers come from the seed
+// of the run, the time is read from a virtual clock that only advances when
+// all routines sleep, and routines run one at a time, switching only when
+// the running routine blocks. Events are recorded into a Trace, or checked
+// against the Trace being replayed.
+//
+// Operations that reach outside the VM, such as file or process access, are
+// neither recorded nor replayed.
+type Deterministic struct {
+	mu      sync.Mutex
+	trace   *Trace
+	replay  bool
+	pos     int // next event when replaying
+	rand    *rand.Rand
+	clock   time.Time
+	main    *task
+	running *task
+	waiting []*task // in the order they blocked
+	chans   int
+	err     error
+}
+
+// NewDeterministic creates a Deterministic that records a run with random
+// numbers generated from seed and a virtual clock starting at clock.
+func NewDeterministic(seed int64, clock time.Time) *Deterministic {
+	return newDeterministic(&Trace{Seed: seed, Clock: clock}, false)
+}
+
+// NewReplay creates a Deterministic that replays trace.
+func NewReplay(trace *Trace) *Deterministic {
+	return newDeterministic(trace, true)
+}
+
+func newDeterministic(trace *Trace, replay bool) *Deterministic {
+	d := &Deterministic{
+		trace:  trace,
+		replay: replay,
+		rand:   rand.New(&lockedSource{src: rand.NewSource(trace.Seed)}),
+		clock:  trace.Clock,
+	}
+	d.main = d.newTask("main")
+	d.running = d.main
+	return d
+}
+
+// DeterministicOf returns the Deterministic of the VM running ctx, as
+// passed to builtin functions, or nil if the run is not deterministic.
+func DeterministicOf(ctx context.Context) *Deterministic {
+	if v, ok := ctx.Value(ContextKey("vm")).(*VM); ok {
+		return v.deterministic
+	}
+	return nil
+}
+
+// Trace returns the trace recorded so far, or the trace being replayed.
+func (d *Deterministic) Trace() *Trace {
+	return d.trace
+}
+
+// Err returns the error that stopped the run, such as a deadlock or a
+// divergence from the replayed trace. A replay that did not consume the
+// whole trace has diverged as well.
+func (d *Deterministic) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil && d.replay && d.pos < len(d.trace.Events) {
+		return fmt.Errorf("%w: run ended before event %d: %s",
+			ErrReplayDiverged, d.pos, d.trace.Events[d.pos])
+	}
+	return d.err
+}
+
+// Rand returns the random number generator of the run. It is safe for
+// concurrent use.
+func (d *Deterministic) Rand() *rand.Rand {
+	return d.rand
+}
+
+// Now returns the time of the virtual clock.
+func (d *Deterministic) Now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.clock
+}
+
+// Sleep blocks the routine running ctx until the virtual clock has advanced
+// by dur. Other routines run in the meantime.
+func (d *Deterministic) Sleep(ctx context.Context, dur time.Duration) error {
+	t := d.taskOf(ctx)
+	d.mu.Lock()
+	deadline := d.clock.Add(dur)
+	d.mu.Unlock()
+	_, err := t.block(ctx, never, deadline)
+	return err
+}
+
+// Wait blocks the routine running ctx until ready returns true. Other
+// routines run in the meantime. ready is called with the scheduler locked and
+// must neither block nor change any state. Builtin functions wait with Wait
+// instead of blocking the run on Go synchronization.
+func (d *Deterministic) Wait(ctx context.Context, ready func() bool) error {
+	return d.taskOf(ctx).wait(ctx, ready)
+}
+
+// WaitUntil is like Wait, but also returns once the virtual clock reaches
+// deadline. It reports whether the deadline was reached before ready
+// returned true.
+func (d *Deterministic) WaitUntil(ctx context.Context, ready func() bool, deadline time.Time) (bool, error) {
+	d.mu.Lock()
+	ok, timedOut := ready(), !deadline.After(d.clock)
+	d.mu.Unlock()
+	if ok || timedOut {
+		return !ok, nil
+	}
+	return d.taskOf(ctx).block(ctx, ready, deadline)
+}
+
+// LookupEnv is like os.LookupEnv, but records the result, or returns the
+// recorded result when replaying.
+func (d *Deterministic) LookupEnv(ctx context.Context, key string) (string, bool, error) {
+	t := d.taskOf(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ev := TraceEvent{Routine: t.id, Op: "env", Key: key}
+	if !d.replay {
+		value, ok := os.LookupEnv(key)
+		ev.Value, ev.Unset = value, !ok
+	}
+	ev, err := d.event(ev)
+	if err != nil {
+		return "", false, err
+	}
+	return ev.Value, !ev.Unset, nil
+}
+
+// Environ is like os.Environ, but records the result, or returns the
+// recorded result when replaying.
+func (d *Deterministic) Environ(ctx context.Context) ([]string, error) {
+	t := d.taskOf(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ev := TraceEvent{Routine: t.id, Op: "environ"}
+	if !d.replay {
+		ev.Value = strings.Join(os.Environ(), "\x00")
+	}
+	ev, err := d.event(ev)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Value == "" {
+		return []string{}, nil
+	}
+	return strings.Split(ev.Value, "\x00"), nil
+}
+
+// event records ev, or checks it against the next event of the trace and
+// returns that event when replaying. It is called with d.mu held.
+func (d *Deterministic) event(ev TraceEvent) (TraceEvent, error) {
+	if d.err != nil {
+		return ev, d.err
+	}
+	if !d.replay {
+		d.trace.Events = append(d.trace.Events, ev)
+		return ev, nil
+	}
+	if d.pos == len(d.trace.Events) {
+		d.fail(fmt.Errorf("%w: unexpected event %d: %s",
+			ErrReplayDiverged, d.pos, ev))
+		return ev, d.err
+	}
+	rec := d.trace.Events[d.pos]
+	if rec.Routine != ev.Routine || rec.Op != ev.Op || rec.Key != ev.Key {
+		d.fail(fmt.Errorf("%w: event %d: expected %s, got %s",
+			ErrReplayDiverged, d.pos, rec, ev))
+		return ev, d.err
+	}
+	d.pos++
+	return rec, nil
+}
+
+// fail stops the run with err and wakes up all blocked routines. It is
+// called with d.mu held.
+func (d *Deterministic) fail(err error) {
+	d.err = err
+	for _, t := range d.waiting {
+		t.wake <- err
+	}
+	d.waiting = nil
+	d.running = nil
+}
+
+// schedule passes control to the next routine. It is called with d.mu held
+// by the running routine when it blocks or exits.
+func (d *Deterministic) schedule() {
+	d.running = nil
+	if len(d.waiting) == 0 || d.err != nil {
+		return
+	}
+	i, err := d.pick()
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	t := d.waiting[i]
+	if _, err := d.event(TraceEvent{Routine: t.id, Op: "run"}); err != nil {
+		return
+	}
+	d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+	if t.ready != nil && !t.ready() {
+		if t.deadline.After(d.clock) {
+			d.clock = t.deadline
+		}
+		t.timedOut = true
+	}
+	d.running = t
+	t.wake <- nil
+}
+
+// pick returns the index of the next waiting routine to run: the routine
+// named by the trace when replaying, otherwise the first routine that is
+// ready, or the routine with the earliest deadline if none is.
+func (d *Deterministic) pick() (int, error) {
+	if d.replay {
+		if d.pos == len(d.trace.Events) || d.trace.Events[d.pos].Op != "run" {
+			return 0, fmt.Errorf("%w: unexpected switch of routines at event %d",
+				ErrReplayDiverged, d.pos)
+		}
+		id := d.trace.Events[d.pos].Routine
+		for i, t := range d.waiting {
+			if t.id == id && (t.isReady() || !t.deadline.IsZero()) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: event %d: routine %s cannot run",
+			ErrReplayDiverged, d.pos, id)
+	}
+	next := -1
+	for i, t := range d.waiting {
+		if t.isReady() {
+			return i, nil
+		}
+		if !t.deadline.IsZero() &&
+			(next < 0 || t.deadline.Before(d.waiting[next].deadline)) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return 0, ErrDeadlock
+	}
+	return next, nil
+}
+
+// remove removes t from the waiting routines and reports whether it was
+// waiting. It is called with d.mu held.
+func (d *Deterministic) remove(t *task) bool {
+	for i, w := range d.waiting {
+		if w == t {
+			d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// start creates the task of a routine started by the routine running ctx.
+// The routine runs once the task is scheduled; see task.await.
+func (d *Deterministic) start(ctx context.Context) (*task, error) {
+	p := d.taskOf(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p.started++
+	t := d.newTask(fmt.Sprintf("%s.%d", p.id, p.started))
+	if _, err := d.event(TraceEvent{Routine: p.id, Op: "start", Key: t.id}); err != nil {
+		return nil, err
+	}
+	d.waiting = append(d.waiting, t)
+	return t, nil
+}
+
+func (d *Deterministic) newTask(id string) *task {
+	return &task{d: d, id: id, wake: make(chan error, 1)}
+}
+
+// taskOf returns the task of the routine running ctx.
+func (d *Deterministic) taskOf(ctx context.Context) *task {
+	if t, ok := ctx.Value(ContextKey("task")).(*task); ok && t.d == d {
+		return t
+	}
+	return d.main
+}
+
+// task is a routine of a deterministic run.
+type task struct {
+	d        *Deterministic
+	id       string
+	started  int // number of routines started
+	done     bool
+	wake     chan error
+	ready    func() bool // nil if the task can run
+	deadline time.Time   // zero if the task waits without timeout
+	timedOut bool
+}
+
+func never() bool { return false }
+
+func (t *task) isReady() bool {
+	return t.ready == nil || t.ready() ||
+		(!t.deadline.IsZero() && !t.deadline.After(t.d.clock))
+}
+
+// wait blocks the task until ready returns true. It returns without passing
+// control to other routines if ready already does.
+func (t *task) wait(ctx context.Context, ready func() bool) error {
+	t.d.mu.Lock()
+	if t.d.err != nil {
+		t.d.mu.Unlock()
+		return t.d.err
+	}
+	ok := ready()
+	t.d.mu.Unlock()
+	if ok {
+		return nil
+	}
+	_, err := t.block(ctx, ready, time.Time{})
+	return err
+}
+
+// block passes control to the next routine and returns once the task is
+// scheduled again, which is when ready returns true or when the virtual
+// clock reaches deadline, unless deadline is zero. It reports whether the
+// deadline was reached.
+func (t *task) block(ctx context.Context, ready func() bool, deadline time.Time) (bool, error) {
+	d := t.d
+	d.mu.Lock()
+	if d.err != nil {
+		d.mu.Unlock()
+		return false, d.err
+	}
+	t.ready, t.deadline, t.timedOut = ready, deadline, false
+	d.waiting = append(d.waiting, t)
+	if d.running == t || d.running == nil {
+		d.schedule()
+	}
+	d.mu.Unlock()
+	if err := t.await(ctx); err != nil {
+		return false, err
+	}
+	return t.timedOut, nil
+}
+
+// await blocks until the task is scheduled.
+func (t *task) await(ctx context.Context) error {
+	select {
+	case err := <-t.wake:
+		return err
+	case <-ctx.Done():
+		d := t.d
+		d.mu.Lock()
+		if !d.remove(t) && d.running == t {
+			// scheduled in the meantime: pass control on
+			d.schedule()
+		}
+		d.mu.Unlock()
+		return ErrVMAborted
+	}
+}
+
+// exit marks the task as done and passes control to the next routine.
+func (t *task) exit() {
+	d := t.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.done {
+		return
+	}
+	d.remove(t)
+	t.done = true
+	if d.running == t || d.running == nil {
+		d.schedule()
+	}
+}
+
+// detChan is a channel of a deterministic run. Only the running routine
+// operates on it.
+type detChan struct {
+	d      *Deterministic
+	id     string
+	size   int
+	buf    []Object
+	closed bool
+	sent   int
+	recvd  int
+}
+
+func (d *Deterministic) newChan(size int) *detChan {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.chans++
+	return &detChan{d: d, id: fmt.Sprintf("chan%d", d.chans), size: size}
+}
+
+// send is like objChan.send. The send to an unbuffered channel returns
+// once the value was received.
+func (c *detChan) send(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 {
+		return nil, ErrWrongNumArguments
+	}
+	t := c.d.taskOf(ctx)
+	err := t.wait(ctx, func() bool {
+		return c.closed || len(c.buf) < max(c.size, 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.d.mu.Lock()
+	if c.closed {
+		c.d.mu.Unlock()
+		return nil, fmt.Errorf("send on closed channel")
+	}
+	c.buf = append(c.buf, args[0])
+	c.sent++
+	seq := c.sent
+	_, err = c.d.event(TraceEvent{Routine: t.id, Op: "send", Key: c.id})
+	c.d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if c.size == 0 {
+		err = t.wait(ctx, func() bool { return c.closed || c.recvd >= seq })
+	}
+	return nil, err
+}
+
+// recv is like objChan.recv.
+func (c *detChan) recv(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	t := c.d.taskOf(ctx)
+	err := t.wait(ctx, func() bool { return c.closed || len(c.buf) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	var obj Object
+	if len(c.buf) > 0 {
+		obj = c.buf[0]
+		c.buf = c.buf[1:]
+		c.recvd++
+	}
+	if _, err := c.d.event(TraceEvent{Routine: t.id, Op: "recv", Key: c.id}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// close is like objChan.close.
+func (c *detChan) close(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	t := c.d.taskOf(ctx)
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("close of closed channel")
+	}
+	c.closed = true
+	if _, err := c.d.event(TraceEvent{Routine: t.id, Op: "close", Key: c.id}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// lockedSource is a rand.Source that is safe for concurrent use.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
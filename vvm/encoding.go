@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/malivvan/vv/vvm/encoding"
 	"github.com/malivvan/vv/vvm/parser"
+	"math/big"
 	"time"
 )
 
@@ -23,6 +24,9 @@ const (
 	_objectPtr        byte = 13
 	_compiledFunction byte = 14
 	_error            byte = 15
+	_set              byte = 16
+	_bigInt           byte = 17
+	_decimal          byte = 18
 	_arrayIterator    byte = 100
 	_mapIterator      byte = 101
 	_stringIterator   byte = 102
@@ -47,6 +51,9 @@ var _typeMap = map[byte]func() Object{
 	_compiledFunction: func() Object { return &CompiledFunction{SourceMap: make(map[int]parser.Pos)} },
 	_builtinFunction:  func() Object { return &BuiltinFunction{} },
 	_error:            func() Object { return &Error{} },
+	_set:              func() Object { return &Set{} },
+	_bigInt:           func() Object { return &BigInt{} },
+	_decimal:          func() Object { return &Decimal{} },
 }
 
 // MakeObject creates a new object based on the given type code.
@@ -98,6 +105,12 @@ func TypeOfObject(o Object) byte {
 		return _builtinFunction
 	case *Error:
 		return _error
+	case *Set:
+		return _set
+	case *BigInt:
+		return _bigInt
+	case *Decimal:
+		return _decimal
 	default:
 		return 0
 	}
@@ -128,11 +141,15 @@ func SizeOfObject(o Object) int {
 	case _array:
 		return encoding.SizeByte() + encoding.SizeSlice(o.(*Array).Value, SizeOfObject)
 	case _map:
-		return encoding.SizeByte() + encoding.SizeMap(o.(*Map).Value, encoding.SizeString, SizeOfObject)
+		s := encoding.SizeMap(o.(*Map).Value, encoding.SizeString, SizeOfObject)
+		s += encoding.SizeSlice(hashedEntries(o.(*Map).Hashed), SizeOfObject)
+		return encoding.SizeByte() + s
 	case _immutableArray:
 		return encoding.SizeByte() + encoding.SizeSlice(o.(*ImmutableArray).Value, SizeOfObject)
 	case _immutableMap:
-		return encoding.SizeByte() + encoding.SizeMap(o.(*ImmutableMap).Value, encoding.SizeString, SizeOfObject)
+		s := encoding.SizeMap(o.(*ImmutableMap).Value, encoding.SizeString, SizeOfObject)
+		s += encoding.SizeSlice(hashedEntries(o.(*ImmutableMap).Hashed), SizeOfObject)
+		return encoding.SizeByte() + s
 	case _objectPtr:
 		v := o.(*ObjectPtr)
 		if v.Value != nil {
@@ -151,7 +168,22 @@ func SizeOfObject(o Object) int {
 		s := encoding.SizeString(o.(*BuiltinFunction).Name)
 		return encoding.SizeByte() + s
 	case _error:
-		return encoding.SizeByte() + SizeOfObject(o.(*Error).Value)
+		v := o.(*Error)
+		s := SizeOfObject(v.Value)
+		s += encoding.SizeString(v.Code)
+		if v.Cause != nil {
+			s += SizeOfObject(v.Cause)
+		} else {
+			s += SizeOfObject(nil)
+		}
+		s += encoding.SizeSlice(v.Stack, parser.SizeFilePos)
+		return encoding.SizeByte() + s
+	case _set:
+		return encoding.SizeByte() + encoding.SizeSlice(setElements(o.(*Set)), SizeOfObject)
+	case _bigInt:
+		return encoding.SizeByte() + encoding.SizeString(o.(*BigInt).Value.String())
+	case _decimal:
+		return encoding.SizeByte() + encoding.SizeString(o.(*Decimal).String())
 	default:
 		panic("sizeof: unsupported type: " + o.TypeName())
 	}
@@ -192,12 +224,14 @@ func MarshalObject(n int, b []byte, o Object) int {
 	case _map:
 		n = encoding.MarshalByte(n, b, _map)
 		n = encoding.MarshalMap(n, b, o.(*Map).Value, encoding.MarshalString, MarshalObject)
+		n = encoding.MarshalSlice(n, b, hashedEntries(o.(*Map).Hashed), MarshalObject)
 	case _immutableArray:
 		n = encoding.MarshalByte(n, b, _immutableArray)
 		n = encoding.MarshalSlice(n, b, o.(*ImmutableArray).Value, MarshalObject)
 	case _immutableMap:
 		n = encoding.MarshalByte(n, b, _immutableMap)
 		n = encoding.MarshalMap(n, b, o.(*ImmutableMap).Value, encoding.MarshalString, MarshalObject)
+		n = encoding.MarshalSlice(n, b, hashedEntries(o.(*ImmutableMap).Hashed), MarshalObject)
 	case _objectPtr:
 		n = encoding.MarshalByte(n, b, _objectPtr)
 		if o.(*ObjectPtr).Value != nil {
@@ -218,16 +252,34 @@ func MarshalObject(n int, b []byte, o Object) int {
 		n = encoding.MarshalByte(n, b, _builtinFunction)
 		n = encoding.MarshalString(n, b, o.(*BuiltinFunction).Name)
 	case _error:
+		v := o.(*Error)
 		n = encoding.MarshalByte(n, b, _error)
-		n = MarshalObject(n, b, o.(*Error).Value)
+		n = MarshalObject(n, b, v.Value)
+		n = encoding.MarshalString(n, b, v.Code)
+		if v.Cause != nil {
+			n = MarshalObject(n, b, v.Cause)
+		} else {
+			n = MarshalObject(n, b, nil)
+		}
+		n = encoding.MarshalSlice(n, b, v.Stack, parser.MarshalFilePos)
+	case _set:
+		n = encoding.MarshalByte(n, b, _set)
+		n = encoding.MarshalSlice(n, b, setElements(o.(*Set)), MarshalObject)
+	case _bigInt:
+		n = encoding.MarshalByte(n, b, _bigInt)
+		n = encoding.MarshalString(n, b, o.(*BigInt).Value.String())
+	case _decimal:
+		n = encoding.MarshalByte(n, b, _decimal)
+		n = encoding.MarshalString(n, b, o.(*Decimal).String())
 	default:
 		panic("marshal: unsupported type: " + o.TypeName())
 	}
 	return n
 }
 
-// UnmarshalObject unmarshals the given byte slice into an object.
-func UnmarshalObject(nn int, b []byte) (n int, o Object, err error) {
+// UnmarshalObject unmarshals the given byte slice, encoded in the given
+// format version, into an object.
+func UnmarshalObject(nn int, b []byte, version int) (n int, o Object, err error) {
 	if b[nn] == 0 {
 		return nn + 1, nil, nil
 	}
@@ -285,32 +337,44 @@ func UnmarshalObject(nn int, b []byte) (n int, o Object, err error) {
 		o.(*Time).Value = time.Unix(0, v).In(time.UTC)
 		return n, o, nil
 	case _array:
-		n, o.(*Array).Value, err = encoding.UnmarshalSlice[Object](n, b, UnmarshalObject)
+		n, o.(*Array).Value, err = encoding.UnmarshalSlice[Object](n, b, ObjectUnmarshaler(version))
 		if err != nil {
 			return nn, nil, err
 		}
 		return n, o, nil
 	case _map:
-		n, o.(*Map).Value, err = encoding.UnmarshalMap[string, Object](n, b, encoding.UnmarshalString, UnmarshalObject)
+		n, o.(*Map).Value, err = encoding.UnmarshalMap[string, Object](n, b, encoding.UnmarshalString, ObjectUnmarshaler(version))
 		if err != nil {
 			return nn, nil, err
 		}
+		if version >= 2 {
+			n, o.(*Map).Hashed, err = unmarshalHashedEntries(n, b, version)
+			if err != nil {
+				return nn, nil, err
+			}
+		}
 		return n, o, nil
 	case _immutableArray:
-		n, o.(*ImmutableArray).Value, err = encoding.UnmarshalSlice[Object](n, b, UnmarshalObject)
+		n, o.(*ImmutableArray).Value, err = encoding.UnmarshalSlice[Object](n, b, ObjectUnmarshaler(version))
 		if err != nil {
 			return nn, nil, err
 		}
 		return n, o, nil
 	case _immutableMap:
-		n, o.(*ImmutableMap).Value, err = encoding.UnmarshalMap[string, Object](n, b, encoding.UnmarshalString, UnmarshalObject)
+		n, o.(*ImmutableMap).Value, err = encoding.UnmarshalMap[string, Object](n, b, encoding.UnmarshalString, ObjectUnmarshaler(version))
 		if err != nil {
 			return nn, nil, err
 		}
+		if version >= 2 {
+			n, o.(*ImmutableMap).Hashed, err = unmarshalHashedEntries(n, b, version)
+			if err != nil {
+				return nn, nil, err
+			}
+		}
 		return n, o, nil
 	case _objectPtr:
 		var v Object
-		n, v, err = UnmarshalObject(n, b)
+		n, v, err = UnmarshalObject(n, b, version)
 		if err != nil {
 			return nn, nil, err
 		}
@@ -341,7 +405,7 @@ func UnmarshalObject(nn int, b []byte) (n int, o Object, err error) {
 		}
 		n, o.(*CompiledFunction).Free, err = encoding.UnmarshalSlice[*ObjectPtr](n, b, func(nn int, b []byte) (n int, o *ObjectPtr, err error) {
 			var v Object
-			n, v, err = UnmarshalObject(nn, b)
+			n, v, err = UnmarshalObject(nn, b, version)
 			if err != nil {
 				return nn, nil, err
 			}
@@ -364,11 +428,115 @@ func UnmarshalObject(nn int, b []byte) (n int, o Object, err error) {
 		}
 		return n, o, nil
 	case _error:
-		n, o.(*Error).Value, err = UnmarshalObject(n, b)
+		v := o.(*Error)
+		n, v.Value, err = UnmarshalObject(n, b, version)
+		if err != nil {
+			return nn, nil, err
+		}
+		if version < 3 {
+			return n, o, nil
+		}
+		n, v.Code, err = encoding.UnmarshalString(n, b)
+		if err != nil {
+			return nn, nil, err
+		}
+		var cause Object
+		n, cause, err = UnmarshalObject(n, b, version)
+		if err != nil {
+			return nn, nil, err
+		}
+		if cause != nil {
+			c, ok := cause.(*Error)
+			if !ok {
+				return nn, nil, errors.New("unmarshal: invalid error cause: " + cause.TypeName())
+			}
+			v.Cause = c
+		}
+		n, v.Stack, err = encoding.UnmarshalSlice[parser.SourceFilePos](n, b, parser.UnmarshalFilePos)
+		if err != nil {
+			return nn, nil, err
+		}
+		return n, o, nil
+	case _set:
+		var elems []Object
+		n, elems, err = encoding.UnmarshalSlice[Object](n, b, ObjectUnmarshaler(version))
+		if err != nil {
+			return nn, nil, err
+		}
+		set, err := NewSet(elems...)
+		if err != nil {
+			return nn, nil, err
+		}
+		return n, set, nil
+	case _bigInt:
+		var v string
+		n, v, err = encoding.UnmarshalString(n, b)
 		if err != nil {
 			return nn, nil, err
 		}
+		i, ok := new(big.Int).SetString(v, 10)
+		if !ok {
+			return nn, nil, errors.New("unmarshal: invalid bigint: " + v)
+		}
+		o.(*BigInt).Value = i
 		return n, o, nil
+	case _decimal:
+		var v string
+		n, v, err = encoding.UnmarshalString(n, b)
+		if err != nil {
+			return nn, nil, err
+		}
+		d, err := ParseDecimal(v)
+		if err != nil {
+			return nn, nil, err
+		}
+		return n, d, nil
 	}
 	return nn, nil, errors.New("unmarshal: unsupported type: " + o.TypeName())
 }
+
+// ObjectUnmarshaler returns a function unmarshaling objects encoded in the
+// given format version, for decoding slices and maps of objects.
+func ObjectUnmarshaler(version int) func(nn int, b []byte) (int, Object, error) {
+	return func(nn int, b []byte) (int, Object, error) {
+		return UnmarshalObject(nn, b, version)
+	}
+}
+
+// hashedEntries flattens the non-string keyed entries of a map into a slice
+// of alternating keys and values.
+func hashedEntries(hashed map[HashKey]MapEntry) []Object {
+	entries := make([]Object, 0, 2*len(hashed))
+	for _, e := range hashed {
+		entries = append(entries, e.Key, e.Value)
+	}
+	return entries
+}
+
+func unmarshalHashedEntries(nn int, b []byte, version int) (n int, hashed map[HashKey]MapEntry, err error) {
+	var entries []Object
+	n, entries, err = encoding.UnmarshalSlice[Object](nn, b, ObjectUnmarshaler(version))
+	if err != nil || len(entries) == 0 {
+		return n, nil, err
+	}
+	if len(entries)%2 != 0 {
+		return nn, nil, errors.New("unmarshal: odd number of map entries")
+	}
+	hashed = make(map[HashKey]MapEntry, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		key, ok := ToHashKey(entries[i])
+		if !ok {
+			return nn, nil, ErrNotHashable{Type: entries[i].TypeName()}
+		}
+		hashed[key] = MapEntry{Key: entries[i], Value: entries[i+1]}
+	}
+	return n, hashed, nil
+}
+
+func setElements(s *Set) []Object {
+	elems := make([]Object, 0, len(s.Value))
+	for _, e := range s.Value {
+		elems = append(elems, e)
+	}
+	return elems
+}
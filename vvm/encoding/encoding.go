@@ -23,11 +23,10 @@ func SkipString(n int, b []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	s := int(us)
-
-	if len(b)-n < s {
+	if us > uint(len(b)-n) {
 		return n, ErrBufTooSmall
 	}
+	s := int(us)
 	return n + s, nil
 }
 
@@ -49,11 +48,10 @@ func UnmarshalString(n int, b []byte) (int, string, error) {
 	if err != nil {
 		return 0, "", err
 	}
-	s := int(us)
-
-	if len(b)-n < s {
+	if us > uint(len(b)-n) {
 		return n, "", ErrBufTooSmall
 	}
+	s := int(us)
 	return n + s, string(b[n : n+s]), nil
 }
 
@@ -120,6 +118,9 @@ func UnmarshalSlice[T any](n int, b []byte, unmarshaler interface{}) (int, []T,
 	if err != nil {
 		return 0, nil, err
 	}
+	if us > uint(len(b)-n) { // each element takes a byte at least
+		return 0, nil, ErrBufTooSmall
+	}
 	s := int(us)
 
 	var t T
@@ -214,6 +215,9 @@ func UnmarshalMap[K comparable, V any](n int, b []byte, kUnmarshaler interface{}
 	if err != nil {
 		return 0, nil, err
 	}
+	if us > uint(len(b)-n) { // each entry takes a byte at least
+		return 0, nil, ErrBufTooSmall
+	}
 	s := int(us)
 
 	var k K
@@ -290,10 +294,10 @@ func SkipBytes(n int, b []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	s := int(us)
-	if len(b)-n < s {
+	if us > uint(len(b)-n) {
 		return n, ErrBufTooSmall
 	}
+	s := int(us)
 	return n + s, nil
 }
 
@@ -315,10 +319,10 @@ func UnmarshalBytes(n int, b []byte) (int, []byte, error) {
 	if err != nil {
 		return 0, nil, err
 	}
-	s := int(us)
-	if len(b)-n < s {
+	if us > uint(len(b)-n) {
 		return 0, nil, ErrBufTooSmall
 	}
+	s := int(us)
 	return n + s, b[n : n+s], nil
 }
 
@@ -3,6 +3,9 @@ package vvm
 import (
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/malivvan/vv/vvm/parser"
 )
 
 var (
@@ -55,6 +58,26 @@ var (
 
 	// ErrVMAborted is an error to denote the VM was forcibly terminated without proper exit.
 	ErrVMAborted = errors.New("virtual machine aborted")
+
+	// ErrDivisionByZero is an error where a bigint or decimal is divided by
+	// zero.
+	ErrDivisionByZero = errors.New("division by zero")
+
+	// ErrDeadlock is an error where all routines of a deterministic run are
+	// blocked.
+	ErrDeadlock = errors.New("all routines are asleep - deadlock")
+
+	// ErrReplayDiverged is an error where a replayed run does not follow its
+	// trace.
+	ErrReplayDiverged = errors.New("replay diverged from trace")
+
+	// ErrNotRunning is an error where a VM that is not running is asked for
+	// a snapshot.
+	ErrNotRunning = errors.New("virtual machine is not running")
+
+	// ErrInvalidSnapshot is an error where a snapshot is malformed or does
+	// not belong to the bytecode it is restored with.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
 )
 
 // ErrInvalidArgumentType represents an invalid argument value type error.
@@ -68,3 +91,167 @@ func (e ErrInvalidArgumentType) Error() string {
 	return fmt.Sprintf("invalid type for argument '%s': expected %s, found %s",
 		e.Name, e.Expected, e.Found)
 }
+
+// ErrNotHashable represents an error where an object that is not hashable
+// is used as a map key or set element.
+type ErrNotHashable struct {
+	Type string
+}
+
+func (e ErrNotHashable) Error() string {
+	return fmt.Sprintf("unhashable type: %s", e.Type)
+}
+
+// ErrNotSnapshotable represents an error where the state of a VM holds a
+// value that cannot be saved in a snapshot, such as an open file.
+type ErrNotSnapshotable struct {
+	Type string // type name of the value
+	Path string // where the value was found, e.g. "globals[2].file"
+}
+
+func (e ErrNotSnapshotable) Error() string {
+	return fmt.Sprintf("cannot snapshot %s at %s", e.Type, e.Path)
+}
+
+// ErrorCoder is implemented by Go errors that carry a machine-readable code.
+// The code becomes the code of the script error created for them.
+type ErrorCoder interface {
+	ErrorCode() string
+}
+
+// errorCodes are the codes of the errors defined by this package.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrStackOverflow, "stack_overflow"},
+	{ErrObjectAllocLimit, "alloc_limit"},
+	{ErrIndexOutOfBounds, "index_out_of_bounds"},
+	{ErrInvalidIndexType, "invalid_index_type"},
+	{ErrInvalidIndexValueType, "invalid_index_value_type"},
+	{ErrInvalidIndexOnError, "invalid_index"},
+	{ErrInvalidOperator, "invalid_operator"},
+	{ErrWrongNumArguments, "wrong_num_arguments"},
+	{ErrBytesLimit, "bytes_limit"},
+	{ErrStringLimit, "string_limit"},
+	{ErrNotIndexable, "not_indexable"},
+	{ErrNotIndexAssignable, "not_index_assignable"},
+	{ErrNotImplemented, "not_implemented"},
+	{ErrInvalidRangeStep, "invalid_range_step"},
+	{ErrVMAborted, "aborted"},
+	{ErrDivisionByZero, "division_by_zero"},
+	{ErrDeadlock, "deadlock"},
+	{ErrReplayDiverged, "replay_diverged"},
+	{ErrNotRunning, "not_running"},
+	{ErrInvalidSnapshot, "invalid_snapshot"},
+}
+
+// ErrorCode returns the machine-readable code of err: the code reported by
+// an ErrorCoder in its chain, the code of an error defined by this package,
+// or "error" for any other error.
+func ErrorCode(err error) string {
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	switch {
+	case errors.As(err, new(ErrInvalidArgumentType)):
+		return "invalid_argument_type"
+	case errors.As(err, new(ErrNotHashable)):
+		return "not_hashable"
+	case errors.As(err, new(ErrNotSnapshotable)):
+		return "not_snapshotable"
+	case errors.As(err, new(ErrPanic)):
+		return "panic"
+	}
+	return "error"
+}
+
+// NewError creates a script error from a Go error. The message of err
+// becomes its value and ErrorCode(err) its code. If err is a RuntimeError
+// the error keeps its script call stack.
+func NewError(err error) *Error {
+	e := &Error{
+		Value: &String{Value: err.Error()},
+		Code:  ErrorCode(err),
+	}
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		e.Value = &String{Value: rerr.Err.Error()}
+		e.Stack = rerr.Stack
+	}
+	return e
+}
+
+// invalidOperation is the error for an operator its operands do not support.
+// It unwraps to ErrInvalidOperator.
+type invalidOperation string
+
+func (e invalidOperation) Error() string {
+	return "invalid operation: " + string(e)
+}
+
+func (e invalidOperation) Unwrap() error {
+	return ErrInvalidOperator
+}
+
+// RuntimeError is the error returned when a script fails at run time. It
+// wraps the Go error that stopped the VM.
+type RuntimeError struct {
+	Err    error                  // underlying error
+	File   string                 // file of the failing instruction
+	Line   int                    // line of the failing instruction
+	Column int                    // column of the failing instruction
+	Stack  []parser.SourceFilePos // script call stack, innermost first
+
+	// Routines holds the errors of routines that failed while the VM ran.
+	Routines []error
+}
+
+func newRuntimeError(err error, stack []parser.SourceFilePos) *RuntimeError {
+	e := &RuntimeError{Err: err, Stack: stack}
+	if len(stack) > 0 {
+		e.File = stack[0].Filename
+		e.Line = stack[0].Line
+		e.Column = stack[0].Column
+	}
+	return e
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	var perr ErrPanic
+	if errors.As(e.Err, &perr) {
+		fmt.Fprintf(&sb, "\nRuntime Panic: %v", perr.perr)
+	} else {
+		fmt.Fprintf(&sb, "\nRuntime Error: %v", e.Err)
+	}
+	for _, pos := range e.Stack {
+		fmt.Fprintf(&sb, "\n\tat %s", pos)
+	}
+	if perr.stack != nil {
+		fmt.Fprintf(&sb, "\n%s", perr.stack)
+	}
+	if len(e.Routines) > 0 {
+		sb.WriteString("\n")
+		for _, rerr := range e.Routines {
+			fmt.Fprintf(&sb, "%v\n", rerr)
+		}
+	}
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the machine-readable code of the underlying error.
+func (e *RuntimeError) ErrorCode() string {
+	return ErrorCode(e.Err)
+}
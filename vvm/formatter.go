@@ -1,6 +1,7 @@
 package vvm
 
 import (
+	"math/big"
 	"strconv"
 	"sync"
 	"unicode/utf8"
@@ -865,6 +866,53 @@ func (p *pp) fmtFloat(v float64, size int, verb rune) {
 	}
 }
 
+// fmtBigInt formats a bigint. Integer verbs are handled by big.Int, which
+// reads the flags through the State methods of pp.
+func (p *pp) fmtBigInt(v *BigInt, verb rune) {
+	switch verb {
+	case 'd', 'b', 'o', 'O', 'x', 'X':
+		v.Value.Format(p, verb)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		p.fmtFloat(v.Float64(), 64, verb)
+	case 's', 'q':
+		p.fmtString(v.String(), verb)
+	default:
+		p.badVerb(verb)
+	}
+}
+
+// fmtDecimal formats a decimal. Without an explicit precision %f prints all
+// digits of the decimal; with one the value is rounded half to even before
+// it is printed.
+func (p *pp) fmtDecimal(v *Decimal, verb rune) {
+	switch verb {
+	case 'f', 'F':
+		prec, precPresent := p.fmt.prec, p.fmt.precPresent
+		if !precPresent {
+			p.fmt.prec, p.fmt.precPresent = int(v.Scale), true
+		}
+		v = v.Round(int32(p.fmt.prec))
+		decimalBigFloat(v).Format(p, 'f')
+		p.fmt.prec, p.fmt.precPresent = prec, precPresent
+	case 'e', 'E', 'g', 'G':
+		decimalBigFloat(v).Format(p, verb)
+	case 'd':
+		p.fmtBigInt(&BigInt{Value: v.Int()}, verb)
+	case 's', 'q':
+		p.fmtString(v.String(), verb)
+	default:
+		p.badVerb(verb)
+	}
+}
+
+// decimalBigFloat converts v to a big.Float precise enough to print all of
+// its digits.
+func decimalBigFloat(v *Decimal) *big.Float {
+	str := v.String()
+	f, _, _ := big.ParseFloat(str, 10, uint(len(str))*4+64, big.ToNearestEven)
+	return f
+}
+
 func (p *pp) fmtString(v string, verb rune) {
 	switch verb {
 	case 'v':
@@ -951,6 +999,10 @@ func (p *pp) printArg(arg Object, verb rune) {
 		p.fmtFloat(f.Value, 64, verb)
 	case *Int:
 		p.fmtInteger(uint64(f.Value), signed, verb)
+	case *BigInt:
+		p.fmtBigInt(f, verb)
+	case *Decimal:
+		p.fmtDecimal(f, verb)
 	case *String:
 		p.fmtString(f.Value, verb)
 	case *Bytes:
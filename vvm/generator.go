@@ -0,0 +1,201 @@
+package vvm
+
+import (
+	"fmt"
+
+	"github.com/malivvan/vv/vvm/parser"
+)
+
+// Generator is the lazy iterable returned by calling a function that
+// contains a yield statement. It holds the suspended frame of the function:
+// the instruction pointer and a copy of its locals. Each call to Next resumes
+// the frame until the next yield or the end of the function.
+//
+// A VM iterating a generator in a for-in loop resumes the frame natively on
+// its own stack. Next called from Go runs the frame in a shallow clone of the
+// VM that created the generator.
+type Generator struct {
+	ObjectImpl
+	fn       *CompiledFunction
+	freeVars []*ObjectPtr
+	stack    []Object
+	ip       int
+	vm       *VM
+	value    Object
+	count    int64
+	running  bool
+	done     bool
+	err      error
+}
+
+// generatorNext is the compiled form of func(g) { return g.next() } used to
+// resume a generator from Go.
+var generatorNext = &CompiledFunction{
+	Instructions: concatInsts(
+		MakeInstruction(parser.OpGetLocal, 0),
+		MakeInstruction(parser.OpIteratorNext),
+		MakeInstruction(parser.OpReturn, 1),
+	),
+	NumLocals:     1,
+	NumParameters: 1,
+}
+
+// TypeName returns the name of the type.
+func (g *Generator) TypeName() string {
+	return "generator"
+}
+
+func (g *Generator) String() string {
+	return "<generator>"
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (g *Generator) Equals(x Object) bool {
+	return g == x
+}
+
+// Copy returns a copy of the type. The copy resumes from the same point as
+// the original but advances independently.
+func (g *Generator) Copy() Object {
+	c := *g
+	c.stack = append([]Object(nil), g.stack...)
+	return &c
+}
+
+// Iterate returns the generator itself; a generator can be iterated only
+// once.
+func (g *Generator) Iterate() Iterator {
+	return g
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (g *Generator) CanIterate() bool {
+	return true
+}
+
+// Next resumes the generator and returns true if it yielded another value.
+func (g *Generator) Next() bool {
+	if g.done || g.vm == nil {
+		return false
+	}
+	res, err := g.vm.ShallowClone().RunCompiled(generatorNext, g)
+	if err != nil {
+		g.err = err
+		g.finish()
+		return false
+	}
+	return !res.IsFalsy()
+}
+
+// Key returns the zero-based index of the current value.
+func (g *Generator) Key() Object {
+	return &Int{Value: g.count - 1}
+}
+
+// Value returns the value of the last yield.
+func (g *Generator) Value() Object {
+	return g.value
+}
+
+// Err returns the runtime error that stopped a generator resumed by Next.
+func (g *Generator) Err() error {
+	return g.err
+}
+
+func (g *Generator) finish() {
+	g.done = true
+	g.running = false
+	g.stack = nil
+	g.value = UndefinedValue
+}
+
+// newGenerator suspends the current frame, which has just been entered by
+// calling a generator function, and returns it to the caller as a Generator.
+func (v *VM) newGenerator() {
+	bp := v.curFrame.basePointer
+	g := &Generator{
+		fn:       v.curFrame.fn,
+		freeVars: v.curFrame.freeVars,
+		stack:    append([]Object(nil), v.stack[bp:v.sp]...),
+		ip:       v.ip,
+		vm:       v,
+		value:    UndefinedValue,
+	}
+	v.allocs--
+	if v.allocs == 0 {
+		v.err = ErrObjectAllocLimit
+		return
+	}
+	v.leaveGenerator(g)
+}
+
+// resumeGenerator pushes the suspended frame of g on top of the generator
+// object at the top of the stack. The result of the iteration replaces the
+// generator object once the frame yields or returns.
+func (v *VM) resumeGenerator(g *Generator) {
+	if g.running {
+		v.err = fmt.Errorf("generator already running")
+		return
+	}
+	if v.framesIndex >= MaxFrames {
+		v.err = ErrStackOverflow
+		return
+	}
+	v.checkGrowStack(len(g.stack))
+	if v.err != nil {
+		return
+	}
+
+	v.curFrame.ip = v.ip
+	if v.framesIndex >= len(v.frames) {
+		v.frames = append(v.frames, &frame{})
+	}
+	v.curFrame = v.frames[v.framesIndex]
+	v.curFrame.fn = g.fn
+	v.curFrame.freeVars = g.freeVars
+	v.curFrame.basePointer = v.sp
+	v.curFrame.gen = g
+	v.curFrame.tailCalls = v.curFrame.tailCalls[:0]
+	v.curInsts = g.fn.Instructions
+	v.ip = g.ip
+	v.framesIndex++
+	v.sp += copy(v.stack[v.sp:], g.stack)
+	g.running = true
+}
+
+// yieldGenerator suspends the generator frame with the value at the top of
+// the stack as the current value.
+func (v *VM) yieldGenerator() {
+	g := v.curFrame.gen
+	if g == nil {
+		v.err = fmt.Errorf("yield outside generator")
+		return
+	}
+	g.value = v.stack[v.sp-1]
+	v.sp--
+	g.stack = append(g.stack[:0], v.stack[v.curFrame.basePointer:v.sp]...)
+	g.ip = v.ip
+	g.count++
+	g.running = false
+	v.leaveGenerator(TrueValue)
+}
+
+// returnGenerator ends the generator frame. Any returned values are
+// discarded.
+func (v *VM) returnGenerator() {
+	v.curFrame.gen.finish()
+	v.leaveGenerator(FalseValue)
+}
+
+// leaveGenerator pops the generator frame and leaves res in the slot below
+// its base pointer.
+func (v *VM) leaveGenerator(res Object) {
+	v.curFrame.gen = nil
+	v.framesIndex--
+	v.curFrame = v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = v.curFrame.ip
+	v.sp = v.frames[v.framesIndex].basePointer
+	v.stack[v.sp-1] = res
+}
@@ -0,0 +1,297 @@
+package vvm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/malivvan/vv/vvm/parser"
+	"github.com/malivvan/vv/vvm/token"
+)
+
+// Names of the protocol hooks a map can define to customize how the VM
+// treats it. A hook is a callable map entry; it receives the map itself as
+// its first argument.
+//
+// Hooks are only consulted where the built-in behavior of a map gives up, so
+// maps without hooks pay at most one extra lookup at the start of a for-in
+// loop, on equality checks and in string conversions.
+const (
+	HookIter  = "__iter__"  // for-in: func(self) => iterable
+	HookEqual = "__eq__"    // == and !=: func(self, other) => bool
+	HookIndex = "__index__" // reading a missing key: func(self, key) => value
+	HookCall  = "__call__"  // calling the map: func(self, ...args) => value
+	HookStr   = "__str__"   // string(), templates and format: func(self) => string
+)
+
+// binaryHooks are the hooks for binary operators as the VM sees them. The
+// compiler turns "a < b" into "b > a", so the comparison hooks are also looked
+// up on the right operand with the mirrored name.
+var binaryHooks = map[token.Token]string{
+	token.Add:       "__add__",
+	token.Sub:       "__sub__",
+	token.Mul:       "__mul__",
+	token.Quo:       "__quo__",
+	token.Rem:       "__rem__",
+	token.And:       "__and__",
+	token.Or:        "__or__",
+	token.Xor:       "__xor__",
+	token.AndNot:    "__andnot__",
+	token.Shl:       "__shl__",
+	token.Shr:       "__shr__",
+	token.Greater:   "__gt__",
+	token.GreaterEq: "__ge__",
+}
+
+var mirroredHooks = map[token.Token]string{
+	token.Greater:   "__lt__",
+	token.GreaterEq: "__le__",
+}
+
+// hookCall is func(hook, a, b) { return hook(a, b) }.
+var hookCall = &CompiledFunction{
+	Instructions: concatInsts(
+		MakeInstruction(parser.OpGetLocal, 0),
+		MakeInstruction(parser.OpGetLocal, 1),
+		MakeInstruction(parser.OpGetLocal, 2),
+		MakeInstruction(parser.OpCall, 2, 0),
+		MakeInstruction(parser.OpReturn, 1),
+	),
+	NumLocals:     3,
+	NumParameters: 3,
+}
+
+// hookIter calls hook(a) and returns an iterator for its result, which may
+// be another map with an __iter__ hook.
+var hookIter = &CompiledFunction{
+	Instructions: concatInsts(
+		MakeInstruction(parser.OpGetLocal, 0),
+		MakeInstruction(parser.OpGetLocal, 1),
+		MakeInstruction(parser.OpCall, 1, 0),
+		MakeInstruction(parser.OpIteratorInit),
+		MakeInstruction(parser.OpReturn, 1),
+	),
+	NumLocals:     2,
+	NumParameters: 2,
+}
+
+// hookEqual is func(hook, a, b) { return !!hook(a, b) }.
+var hookEqual = &CompiledFunction{
+	Instructions: concatInsts(
+		MakeInstruction(parser.OpGetLocal, 0),
+		MakeInstruction(parser.OpGetLocal, 1),
+		MakeInstruction(parser.OpGetLocal, 2),
+		MakeInstruction(parser.OpCall, 2, 0),
+		MakeInstruction(parser.OpLNot),
+		MakeInstruction(parser.OpLNot),
+		MakeInstruction(parser.OpReturn, 1),
+	),
+	NumLocals:     3,
+	NumParameters: 3,
+}
+
+// hookNotEqual is func(hook, a, b) { return !hook(a, b) }.
+var hookNotEqual = &CompiledFunction{
+	Instructions: concatInsts(
+		MakeInstruction(parser.OpGetLocal, 0),
+		MakeInstruction(parser.OpGetLocal, 1),
+		MakeInstruction(parser.OpGetLocal, 2),
+		MakeInstruction(parser.OpCall, 2, 0),
+		MakeInstruction(parser.OpLNot),
+		MakeInstruction(parser.OpReturn, 1),
+	),
+	NumLocals:     3,
+	NumParameters: 3,
+}
+
+// hookOf returns the hook with the given name if o is a map that defines it
+// as a callable entry.
+func hookOf(o Object, name string) Object {
+	var m map[string]Object
+	switch o := o.(type) {
+	case *Map:
+		m = o.Value
+	case *ImmutableMap:
+		m = o.Value
+	default:
+		return nil
+	}
+	if hook, ok := m[name]; ok && hook.CanCall() {
+		return hook
+	}
+	return nil
+}
+
+// mayHook reports whether o is a map with string keys, which may hold hooks.
+func mayHook(o Object) bool {
+	switch o := o.(type) {
+	case *Map:
+		return len(o.Value) > 0
+	case *ImmutableMap:
+		return len(o.Value) > 0
+	}
+	return false
+}
+
+// equalHook returns the __eq__ hook of either operand and the operands in
+// the order the hook expects them. Comparisons of operands which cannot
+// hold hooks, such as maps with only non-string keys, skip the lookups.
+func equalHook(left, right Object) (hook, self, other Object) {
+	if !mayHook(left) && !mayHook(right) {
+		return nil, nil, nil
+	}
+	if hook = hookOf(left, HookEqual); hook != nil {
+		return hook, left, right
+	}
+	if hook = hookOf(right, HookEqual); hook != nil {
+		return hook, right, left
+	}
+	return nil, nil, nil
+}
+
+// binaryHook returns the hook for the binary operator tok and the operands in
+// the order the hook expects them.
+func binaryHook(tok token.Token, left, right Object) (hook, self, other Object) {
+	if name, ok := binaryHooks[tok]; ok {
+		if hook = hookOf(left, name); hook != nil {
+			return hook, left, right
+		}
+	}
+	if name, ok := mirroredHooks[tok]; ok {
+		if hook = hookOf(right, name); hook != nil {
+			return hook, right, left
+		}
+	}
+	return nil, nil, nil
+}
+
+// callHook calls hook with the given arguments in a new frame running
+// wrapper, which takes the hook and the arguments as its parameters. When the
+// frame returns, its result is stored at stack[base] and the stack pointer is
+// set right above it.
+func (v *VM) callHook(wrapper *CompiledFunction, base int, hook Object, args ...Object) {
+	if v.framesIndex >= MaxFrames {
+		v.err = ErrStackOverflow
+		return
+	}
+	v.sp = base + 1
+	v.checkGrowStack(wrapper.NumLocals)
+	if v.err != nil {
+		return
+	}
+	v.stack[v.sp] = hook
+	copy(v.stack[v.sp+1:], args)
+
+	v.curFrame.ip = v.ip
+	if v.framesIndex >= len(v.frames) {
+		v.frames = append(v.frames, &frame{})
+	}
+	v.curFrame = v.frames[v.framesIndex]
+	v.curFrame.fn = wrapper
+	v.curFrame.freeVars = nil
+	v.curFrame.basePointer = v.sp
+	v.curFrame.tailCalls = v.curFrame.tailCalls[:0]
+	v.curInsts = wrapper.Instructions
+	v.ip = -1
+	v.framesIndex++
+	v.sp += wrapper.NumLocals
+}
+
+// Iterate returns an iterator over x the way a for-in loop does, calling the
+// __iter__ hook of x if it has one. It is meant for builtin functions that
+// accept any iterable; ctx is the context they were called with.
+func Iterate(ctx context.Context, x Object) (Iterator, error) {
+	for depth := 0; ; depth++ {
+		hook := hookOf(x, HookIter)
+		if hook == nil {
+			break
+		}
+		if depth == MaxFrames {
+			return nil, ErrStackOverflow
+		}
+		res, err := Invoke(ctx, hook, x)
+		if err != nil {
+			return nil, err
+		}
+		x = res
+	}
+	if !x.CanIterate() {
+		return nil, fmt.Errorf("not iterable: %s", x.TypeName())
+	}
+	return x.Iterate(), nil
+}
+
+// BinaryOp evaluates "a op b" the way the VM does, calling the protocol
+// hooks of the operands if they do not support the operator. ctx is the
+// context builtin functions were called with.
+func BinaryOp(ctx context.Context, op token.Token, a, b Object) (Object, error) {
+	switch op {
+	case token.Less:
+		op, a, b = token.Greater, b, a
+	case token.LessEq:
+		op, a, b = token.GreaterEq, b, a
+	}
+	res, err := a.BinaryOp(op, b)
+	if err != ErrInvalidOperator {
+		return res, err
+	}
+	if hook, self, other := binaryHook(op, a, b); hook != nil {
+		return Invoke(ctx, hook, self, other)
+	}
+	return nil, invalidOperation(fmt.Sprintf("%s %s %s",
+		a.TypeName(), op.String(), b.TypeName()))
+}
+
+// toString converts o to a string, calling its __str__ hook if it has one.
+func (v *VM) toString(o Object) (string, error) {
+	if hook := hookOf(o, HookStr); hook != nil {
+		res, err := v.Invoke(hook, o)
+		if err != nil {
+			return "", err
+		}
+		o = res
+	}
+	if str, ok := ToString(o); ok {
+		return str, nil
+	}
+	return o.String(), nil
+}
+
+// StrHooks returns args with each object that has a __str__ hook replaced
+// by one whose String method returns the result of the hook, so that
+// ToString and Format convert it the way string() does. ctx is the context
+// builtin functions were called with.
+func StrHooks(ctx context.Context, args []Object) ([]Object, error) {
+	var res []Object
+	for i, arg := range args {
+		hook := hookOf(arg, HookStr)
+		if hook == nil {
+			continue
+		}
+		str, err := Invoke(ctx, hook, arg)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = append([]Object(nil), args...)
+		}
+		s, ok := ToString(str)
+		if !ok {
+			s = str.String()
+		}
+		res[i] = &hookedStr{Object: arg, str: s}
+	}
+	if res == nil {
+		return args, nil
+	}
+	return res, nil
+}
+
+// hookedStr is an object whose __str__ hook returned str.
+type hookedStr struct {
+	Object
+	str string
+}
+
+func (o *hookedStr) String() string {
+	return o.str
+}
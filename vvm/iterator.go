@@ -112,10 +112,28 @@ type MapIterator struct {
 	ObjectImpl
 	v map[string]Object
 	k []string
+	h []MapEntry
 	i int
 	l int
 }
 
+func newMapIterator(value map[string]Object, hashed map[HashKey]MapEntry) *MapIterator {
+	keys := make([]string, 0, len(value))
+	for k := range value {
+		keys = append(keys, k)
+	}
+	var entries []MapEntry
+	for _, e := range hashed {
+		entries = append(entries, e)
+	}
+	return &MapIterator{
+		v: value,
+		k: keys,
+		h: entries,
+		l: len(keys) + len(entries),
+	}
+}
+
 // TypeName returns the name of the type.
 func (i *MapIterator) TypeName() string {
 	return "map-iterator"
@@ -138,7 +156,7 @@ func (i *MapIterator) Equals(Object) bool {
 
 // Copy returns a copy of the type.
 func (i *MapIterator) Copy() Object {
-	return &MapIterator{v: i.v, k: i.k, i: i.i, l: i.l}
+	return &MapIterator{v: i.v, k: i.k, h: i.h, i: i.i, l: i.l}
 }
 
 // Next returns true if there are more elements to iterate.
@@ -149,16 +167,71 @@ func (i *MapIterator) Next() bool {
 
 // Key returns the key or index value of the current element.
 func (i *MapIterator) Key() Object {
+	if i.i > len(i.k) {
+		return i.h[i.i-len(i.k)-1].Key
+	}
 	k := i.k[i.i-1]
 	return &String{Value: k}
 }
 
 // Value returns the value of the current element.
 func (i *MapIterator) Value() Object {
+	if i.i > len(i.k) {
+		return i.h[i.i-len(i.k)-1].Value
+	}
 	k := i.k[i.i-1]
 	return i.v[k]
 }
 
+// RangeIterator is an iterator for a range.
+type RangeIterator struct {
+	ObjectImpl
+	r *Range
+	i int64
+	l int64
+}
+
+// TypeName returns the name of the type.
+func (i *RangeIterator) TypeName() string {
+	return "range-iterator"
+}
+
+func (i *RangeIterator) String() string {
+	return "<range-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *RangeIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *RangeIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *RangeIterator) Copy() Object {
+	return &RangeIterator{r: i.r, i: i.i, l: i.l}
+}
+
+// Next returns true if there are more elements to iterate.
+func (i *RangeIterator) Next() bool {
+	i.i++
+	return i.i <= i.l
+}
+
+// Key returns the key or index value of the current element.
+func (i *RangeIterator) Key() Object {
+	return &Int{Value: i.i - 1}
+}
+
+// Value returns the value of the current element.
+func (i *RangeIterator) Value() Object {
+	return &Int{Value: i.r.At(i.i - 1)}
+}
+
 // StringIterator represents an iterator for a string.
 type StringIterator struct {
 	ObjectImpl
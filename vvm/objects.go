@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -149,6 +150,20 @@ func (o *ObjectImpl) CanCall() bool {
 	return false
 }
 
+// HashKey is a comparable representation of the value of a hashable object.
+// Two objects that are equal have the same hash key.
+type HashKey struct {
+	Type  string
+	Value string
+}
+
+// Hashable is implemented by objects that can be used as map keys and set
+// elements. HashKey returns false if the value of the object cannot be
+// hashed, e.g. an immutable array containing a mutable map.
+type Hashable interface {
+	HashKey() (HashKey, bool)
+}
+
 // Array represents an array of objects.
 type Array struct {
 	ObjectImpl
@@ -171,6 +186,9 @@ func (o *Array) String() string {
 // BinaryOp returns another object that is the result of a given binary
 // operator and a right-hand side object.
 func (o *Array) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op == token.In {
+		return arrayContains(o.Value, rhs), nil
+	}
 	if rhs, ok := rhs.(*Array); ok {
 		switch op {
 		case token.Add:
@@ -227,7 +245,7 @@ func (o *Array) IndexGet(index Object) (res Object, err error) {
 		err = ErrInvalidIndexType
 		return
 	}
-	idxVal := int(intIdx.Value)
+	idxVal := normalizeIndex(int(intIdx.Value), len(o.Value))
 	if idxVal < 0 || idxVal >= len(o.Value) {
 		res = UndefinedValue
 		return
@@ -243,6 +261,7 @@ func (o *Array) IndexSet(index, value Object) (err error) {
 		err = ErrInvalidIndexType
 		return
 	}
+	intIdx = normalizeIndex(intIdx, len(o.Value))
 	if intIdx < 0 || intIdx >= len(o.Value) {
 		err = ErrIndexOutOfBounds
 		return
@@ -264,6 +283,128 @@ func (o *Array) CanIterate() bool {
 	return true
 }
 
+func arrayContains(value []Object, x Object) Object {
+	for _, elem := range value {
+		if elem.Equals(x) {
+			return TrueValue
+		}
+	}
+	return FalseValue
+}
+
+// BigInt represents an arbitrary-precision integer value.
+type BigInt struct {
+	ObjectImpl
+	Value *big.Int
+}
+
+func (o *BigInt) String() string {
+	return o.Value.String()
+}
+
+// TypeName returns the name of the type.
+func (o *BigInt) TypeName() string {
+	return "bigint"
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object. An int operand is promoted to a
+// bigint, the result of mixing a bigint with a float is a float and with a
+// decimal is a decimal.
+func (o *BigInt) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	var y *big.Int
+	switch rhs := rhs.(type) {
+	case *BigInt:
+		y = rhs.Value
+	case *Int:
+		y = big.NewInt(rhs.Value)
+	case *Float:
+		return (&Float{Value: o.Float64()}).BinaryOp(op, rhs)
+	case *Decimal:
+		return decimalFromBigInt(o.Value).BinaryOp(op, rhs)
+	default:
+		return nil, ErrInvalidOperator
+	}
+	if res, ok := compareOp(op, o.Value.Cmp(y)); ok {
+		return res, nil
+	}
+	r := new(big.Int)
+	switch op {
+	case token.Add:
+		r.Add(o.Value, y)
+	case token.Sub:
+		r.Sub(o.Value, y)
+	case token.Mul:
+		r.Mul(o.Value, y)
+	case token.Quo:
+		if y.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		r.Quo(o.Value, y)
+	case token.Rem:
+		if y.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		r.Rem(o.Value, y)
+	case token.And:
+		r.And(o.Value, y)
+	case token.Or:
+		r.Or(o.Value, y)
+	case token.Xor:
+		r.Xor(o.Value, y)
+	case token.AndNot:
+		r.AndNot(o.Value, y)
+	case token.Shl, token.Shr:
+		if y.Sign() < 0 || !y.IsInt64() {
+			return nil, fmt.Errorf("invalid shift count: %s", y)
+		}
+		if op == token.Shl {
+			r.Lsh(o.Value, uint(y.Int64()))
+		} else {
+			r.Rsh(o.Value, uint(y.Int64()))
+		}
+	default:
+		return nil, ErrInvalidOperator
+	}
+	return &BigInt{Value: r}, nil
+}
+
+// Copy returns a copy of the type.
+func (o *BigInt) Copy() Object {
+	return &BigInt{Value: new(big.Int).Set(o.Value)}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *BigInt) IsFalsy() bool {
+	return o.Value.Sign() == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object. Ints and decimals with the same numeric value are equal.
+func (o *BigInt) Equals(x Object) bool {
+	switch t := x.(type) {
+	case *BigInt:
+		return o.Value.Cmp(t.Value) == 0
+	case *Int:
+		return o.Value.IsInt64() && o.Value.Int64() == t.Value
+	case *Decimal:
+		return t.Equals(o)
+	}
+	return false
+}
+
+// HashKey returns the hash key of the value. It is the same as the hash key
+// of an int with the same value.
+func (o *BigInt) HashKey() (HashKey, bool) {
+	return HashKey{Type: "int", Value: o.Value.String()}, true
+}
+
+// Float64 returns the float64 value nearest to the integer.
+func (o *BigInt) Float64() float64 {
+	f, _ := new(big.Float).SetInt(o.Value).Float64()
+	return f
+}
+
 // Bool represents a boolean value.
 type Bool struct {
 	ObjectImpl
@@ -302,11 +443,31 @@ func (o *Bool) Equals(x Object) bool {
 	return o == x
 }
 
+// HashKey returns the hash key of the value.
+func (o *Bool) HashKey() (HashKey, bool) {
+	return HashKey{Type: "bool", Value: strconv.FormatBool(o.value)}, true
+}
+
 // BuiltinFunction represents a builtin function.
 type BuiltinFunction struct {
 	ObjectImpl
 	Name  string
 	Value CallableFunc
+
+	// Restartable is set for a function that can be interrupted by a
+	// snapshot and called again from the start, because it has no effect
+	// until it returns, like receiving from a channel. Snapshots wait for
+	// the calls of other functions to return.
+	Restartable bool
+
+	method *boundMethod // set for the methods of routine and chan objects
+}
+
+// boundMethod identifies the object and method a builtin function calls, so
+// that snapshots can save it.
+type boundMethod struct {
+	recv interface{} // *routineVM or *objChan
+	name string
 }
 
 // TypeName returns the name of the type.
@@ -320,7 +481,7 @@ func (o *BuiltinFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *BuiltinFunction) Copy() Object {
-	return &BuiltinFunction{Value: o.Value}
+	return &BuiltinFunction{Value: o.Value, Restartable: o.Restartable, method: o.method}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -410,6 +571,11 @@ func (o *Bytes) Equals(x Object) bool {
 	return bytes.Equal(o.Value, t.Value)
 }
 
+// HashKey returns the hash key of the value.
+func (o *Bytes) HashKey() (HashKey, bool) {
+	return HashKey{Type: "bytes", Value: string(o.Value)}, true
+}
+
 // IndexGet returns an element (as Int) at a given index.
 func (o *Bytes) IndexGet(index Object) (res Object, err error) {
 	intIdx, ok := index.(*Int)
@@ -417,7 +583,7 @@ func (o *Bytes) IndexGet(index Object) (res Object, err error) {
 		err = ErrInvalidIndexType
 		return
 	}
-	idxVal := int(intIdx.Value)
+	idxVal := normalizeIndex(int(intIdx.Value), len(o.Value))
 	if idxVal < 0 || idxVal >= len(o.Value) {
 		res = UndefinedValue
 		return
@@ -552,6 +718,11 @@ func (o *Char) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// HashKey returns the hash key of the value.
+func (o *Char) HashKey() (HashKey, bool) {
+	return HashKey{Type: "char", Value: strconv.FormatInt(int64(o.Value), 10)}, true
+}
+
 // CompiledFunction represents a compiled function.
 type CompiledFunction struct {
 	ObjectImpl
@@ -606,10 +777,113 @@ func (o *CompiledFunction) CanCall() bool {
 	return true
 }
 
-// Error represents an error value.
+// Decimal represents an arbitrary-precision decimal number: the integer
+// Value scaled by 10^-Scale. The scale is kept through arithmetic, so 1.50
+// stays 1.50.
+type Decimal struct {
+	ObjectImpl
+	Value *big.Int
+	Scale int32
+}
+
+func (o *Decimal) String() string {
+	if o.Scale == 0 {
+		return o.Value.String()
+	}
+	digits := new(big.Int).Abs(o.Value).String()
+	scale := int(o.Scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	s := digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	if o.Value.Sign() < 0 {
+		return "-" + s
+	}
+	return s
+}
+
+// TypeName returns the name of the type.
+func (o *Decimal) TypeName() string {
+	return "decimal"
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object. Int, bigint and float operands are
+// promoted to decimals. Sums, differences and remainders have the larger
+// scale of the operands and products the sum of their scales. Quotients are
+// rounded half to even to 16 digits beyond the larger scale.
+func (o *Decimal) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	y, err := toDecimal(rhs)
+	if err != nil {
+		return nil, err
+	}
+	a, b, scale := decimalAlign(o, y)
+	if res, ok := compareOp(op, a.Cmp(b)); ok {
+		return res, nil
+	}
+	switch op {
+	case token.Add:
+		return &Decimal{Value: new(big.Int).Add(a, b), Scale: scale}, nil
+	case token.Sub:
+		return &Decimal{Value: new(big.Int).Sub(a, b), Scale: scale}, nil
+	case token.Mul:
+		return &Decimal{
+			Value: new(big.Int).Mul(o.Value, y.Value),
+			Scale: o.Scale + y.Scale,
+		}, nil
+	case token.Quo:
+		return decimalQuo(o, y)
+	case token.Rem:
+		if b.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return &Decimal{Value: new(big.Int).Rem(a, b), Scale: scale}, nil
+	}
+	return nil, ErrInvalidOperator
+}
+
+// Copy returns a copy of the type.
+func (o *Decimal) Copy() Object {
+	return &Decimal{Value: new(big.Int).Set(o.Value), Scale: o.Scale}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Decimal) IsFalsy() bool {
+	return o.Value.Sign() == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object. The scale is ignored, and ints and bigints with the same
+// numeric value are equal.
+func (o *Decimal) Equals(x Object) bool {
+	switch t := x.(type) {
+	case *Decimal:
+		return o.Cmp(t) == 0
+	case *Int:
+		return o.Cmp(decimalFromInt(t.Value)) == 0
+	case *BigInt:
+		return o.Cmp(&Decimal{Value: t.Value}) == 0
+	}
+	return false
+}
+
+// HashKey returns the hash key of the value. Integral decimals have the
+// same hash key as an int with the same value.
+func (o *Decimal) HashKey() (HashKey, bool) {
+	if o.IsInt() {
+		return HashKey{Type: "int", Value: o.Int().String()}, true
+	}
+	return HashKey{Type: "decimal", Value: o.trim(0).String()}, true
+}
+
+// Error represents an error value. Errors created by a running script
+// record the script call stack at their creation, innermost frame first.
 type Error struct {
 	ObjectImpl
 	Value Object
+	Code  string                 // machine-readable kind, if any
+	Cause *Error                 // wrapped error, if any
+	Stack []parser.SourceFilePos // script call stack
 }
 
 // TypeName returns the name of the type.
@@ -631,7 +905,11 @@ func (o *Error) IsFalsy() bool {
 
 // Copy returns a copy of the type.
 func (o *Error) Copy() Object {
-	return &Error{Value: o.Value.Copy()}
+	c := &Error{Value: o.Value.Copy(), Code: o.Code, Stack: o.Stack}
+	if o.Cause != nil {
+		c.Cause = o.Cause.Copy().(*Error)
+	}
+	return c
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -640,16 +918,47 @@ func (o *Error) Equals(x Object) bool {
 	return o == x // pointer equality
 }
 
-// IndexGet returns an element at a given index.
+// IndexGet returns an element at a given index. Errors have the selectors
+// value, code, cause and stack.
 func (o *Error) IndexGet(index Object) (res Object, err error) {
-	if strIdx, _ := ToString(index); strIdx != "value" {
+	strIdx, _ := ToString(index)
+	switch strIdx {
+	case "value":
+		res = o.Value
+	case "code":
+		res = UndefinedValue
+		if o.Code != "" {
+			res = &String{Value: o.Code}
+		}
+	case "cause":
+		res = UndefinedValue
+		if o.Cause != nil {
+			res = o.Cause
+		}
+	case "stack":
+		stack := make([]Object, len(o.Stack))
+		for i, pos := range o.Stack {
+			stack[i] = &String{Value: pos.String()}
+		}
+		res = &ImmutableArray{Value: stack}
+	default:
 		err = ErrInvalidIndexOnError
-		return
 	}
-	res = o.Value
 	return
 }
 
+// Is returns true if the error or any error it wraps is target, or has the
+// code target if target is a string.
+func (o *Error) Is(target Object) bool {
+	code, isCode := target.(*String)
+	for e := o; e != nil; e = e.Cause {
+		if isCode && e.Code == code.Value || e == target {
+			return true
+		}
+	}
+	return false
+}
+
 // Float represents a floating point number value.
 type Float struct {
 	ObjectImpl
@@ -763,6 +1072,14 @@ func (o *Float) BinaryOp(op token.Token, rhs Object) (Object, error) {
 			}
 			return FalseValue, nil
 		}
+	case *BigInt:
+		return o.BinaryOp(op, &Float{Value: rhs.Float64()})
+	case *Decimal:
+		d, err := decimalFromFloat(o.Value)
+		if err != nil {
+			return nil, err
+		}
+		return d.BinaryOp(op, rhs)
 	}
 	return nil, ErrInvalidOperator
 }
@@ -787,6 +1104,15 @@ func (o *Float) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// HashKey returns the hash key of the value.
+func (o *Float) HashKey() (HashKey, bool) {
+	v := o.Value
+	if v == 0 {
+		v = 0 // -0 and 0 are equal
+	}
+	return HashKey{Type: "float", Value: strconv.FormatUint(math.Float64bits(v), 16)}, true
+}
+
 // ImmutableArray represents an immutable array of objects.
 type ImmutableArray struct {
 	ObjectImpl
@@ -809,6 +1135,9 @@ func (o *ImmutableArray) String() string {
 // BinaryOp returns another object that is the result of a given binary
 // operator and a right-hand side object.
 func (o *ImmutableArray) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op == token.In {
+		return arrayContains(o.Value, rhs), nil
+	}
 	if rhs, ok := rhs.(*ImmutableArray); ok {
 		switch op {
 		case token.Add:
@@ -855,6 +1184,25 @@ func (o *ImmutableArray) Equals(x Object) bool {
 	return true
 }
 
+// HashKey returns the hash key of the value. An immutable array is hashable
+// if all of its elements are hashable.
+func (o *ImmutableArray) HashKey() (HashKey, bool) {
+	var sb strings.Builder
+	for _, elem := range o.Value {
+		key, ok := ToHashKey(elem)
+		if !ok {
+			return HashKey{}, false
+		}
+		sb.WriteString(strconv.Itoa(len(key.Type)))
+		sb.WriteByte(':')
+		sb.WriteString(key.Type)
+		sb.WriteString(strconv.Itoa(len(key.Value)))
+		sb.WriteByte(':')
+		sb.WriteString(key.Value)
+	}
+	return HashKey{Type: "immutable-array", Value: sb.String()}, true
+}
+
 // IndexGet returns an element at a given index.
 func (o *ImmutableArray) IndexGet(index Object) (res Object, err error) {
 	intIdx, ok := index.(*Int)
@@ -862,7 +1210,7 @@ func (o *ImmutableArray) IndexGet(index Object) (res Object, err error) {
 		err = ErrInvalidIndexType
 		return
 	}
-	idxVal := int(intIdx.Value)
+	idxVal := normalizeIndex(int(intIdx.Value), len(o.Value))
 	if idxVal < 0 || idxVal >= len(o.Value) {
 		res = UndefinedValue
 		return
@@ -888,6 +1236,9 @@ func (o *ImmutableArray) CanIterate() bool {
 type ImmutableMap struct {
 	ObjectImpl
 	Value map[string]Object
+	// Hashed holds the entries whose keys are hashable objects other than
+	// strings.
+	Hashed map[HashKey]MapEntry
 }
 
 // TypeName returns the name of the type.
@@ -896,76 +1247,42 @@ func (o *ImmutableMap) TypeName() string {
 }
 
 func (o *ImmutableMap) String() string {
-	var pairs []string
-	for k, v := range o.Value {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", k, v.String()))
+	return mapString(o.Value, o.Hashed)
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object.
+func (o *ImmutableMap) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op == token.In {
+		return mapContains(o.Value, o.Hashed, rhs), nil
 	}
-	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+	return nil, ErrInvalidOperator
 }
 
 // Copy returns a copy of the type.
 func (o *ImmutableMap) Copy() Object {
-	c := make(map[string]Object)
-	for k, v := range o.Value {
-		c[k] = v.Copy()
-	}
-	return &Map{Value: c}
+	return mapCopy(o.Value, o.Hashed)
 }
 
 // IsFalsy returns true if the value of the type is falsy.
 func (o *ImmutableMap) IsFalsy() bool {
-	return len(o.Value) == 0
+	return len(o.Value)+len(o.Hashed) == 0
 }
 
 // IndexGet returns the value for the given key.
 func (o *ImmutableMap) IndexGet(index Object) (res Object, err error) {
-	strIdx, ok := ToString(index)
-	if !ok {
-		err = ErrInvalidIndexType
-		return
-	}
-	res, ok = o.Value[strIdx]
-	if !ok {
-		res = UndefinedValue
-	}
-	return
+	return mapIndexGet(o.Value, o.Hashed, index)
 }
 
 // Equals returns true if the value of the type is equal to the value of
 // another object.
 func (o *ImmutableMap) Equals(x Object) bool {
-	var xVal map[string]Object
-	switch x := x.(type) {
-	case *Map:
-		xVal = x.Value
-	case *ImmutableMap:
-		xVal = x.Value
-	default:
-		return false
-	}
-	if len(o.Value) != len(xVal) {
-		return false
-	}
-	for k, v := range o.Value {
-		tv := xVal[k]
-		if !v.Equals(tv) {
-			return false
-		}
-	}
-	return true
+	return mapEquals(o.Value, o.Hashed, x)
 }
 
 // Iterate creates an immutable map iterator.
 func (o *ImmutableMap) Iterate() Iterator {
-	var keys []string
-	for k := range o.Value {
-		keys = append(keys, k)
-	}
-	return &MapIterator{
-		v: o.Value,
-		k: keys,
-		l: len(keys),
-	}
+	return newMapIterator(o.Value, o.Hashed)
 }
 
 // CanIterate returns whether the Object can be Iterated.
@@ -1112,6 +1429,10 @@ func (o *Int) BinaryOp(op token.Token, rhs Object) (Object, error) {
 			}
 			return FalseValue, nil
 		}
+	case *BigInt:
+		return (&BigInt{Value: big.NewInt(o.Value)}).BinaryOp(op, rhs)
+	case *Decimal:
+		return decimalFromInt(o.Value).BinaryOp(op, rhs)
 	case *Char:
 		switch op {
 		case token.Add:
@@ -1156,17 +1477,33 @@ func (o *Int) IsFalsy() bool {
 // Equals returns true if the value of the type is equal to the value of
 // another object.
 func (o *Int) Equals(x Object) bool {
-	t, ok := x.(*Int)
-	if !ok {
-		return false
+	switch t := x.(type) {
+	case *Int:
+		return o.Value == t.Value
+	case *BigInt, *Decimal:
+		return t.Equals(o)
 	}
-	return o.Value == t.Value
+	return false
+}
+
+// HashKey returns the hash key of the value.
+func (o *Int) HashKey() (HashKey, bool) {
+	return HashKey{Type: "int", Value: strconv.FormatInt(o.Value, 10)}, true
 }
 
 // Map represents a map of objects.
 type Map struct {
 	ObjectImpl
 	Value map[string]Object
+	// Hashed holds the entries whose keys are hashable objects other than
+	// strings. It is nil until such a key is set.
+	Hashed map[HashKey]MapEntry
+}
+
+// MapEntry represents a map entry with a non-string key.
+type MapEntry struct {
+	Key   Object
+	Value Object
 }
 
 // TypeName returns the name of the type.
@@ -1175,92 +1512,168 @@ func (o *Map) TypeName() string {
 }
 
 func (o *Map) String() string {
-	var pairs []string
-	for k, v := range o.Value {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", k, v.String()))
+	return mapString(o.Value, o.Hashed)
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object.
+func (o *Map) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op == token.In {
+		return mapContains(o.Value, o.Hashed, rhs), nil
 	}
-	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+	return nil, ErrInvalidOperator
 }
 
 // Copy returns a copy of the type.
 func (o *Map) Copy() Object {
-	c := make(map[string]Object)
-	for k, v := range o.Value {
-		c[k] = v.Copy()
-	}
-	return &Map{Value: c}
+	return mapCopy(o.Value, o.Hashed)
 }
 
 // IsFalsy returns true if the value of the type is falsy.
 func (o *Map) IsFalsy() bool {
-	return len(o.Value) == 0
+	return len(o.Value)+len(o.Hashed) == 0
 }
 
 // Equals returns true if the value of the type is equal to the value of
 // another object.
 func (o *Map) Equals(x Object) bool {
+	return mapEquals(o.Value, o.Hashed, x)
+}
+
+// IndexGet returns the value for the given key.
+func (o *Map) IndexGet(index Object) (res Object, err error) {
+	return mapIndexGet(o.Value, o.Hashed, index)
+}
+
+// IndexSet sets the value for the given key.
+func (o *Map) IndexSet(index, value Object) (err error) {
+	if strIdx, ok := index.(*String); ok {
+		o.Value[strIdx.Value] = value
+		return nil
+	}
+	key, ok := ToHashKey(index)
+	if !ok {
+		return ErrInvalidIndexType
+	}
+	if o.Hashed == nil {
+		o.Hashed = make(map[HashKey]MapEntry)
+	}
+	o.Hashed[key] = MapEntry{Key: index, Value: value}
+	return nil
+}
+
+// Delete removes the entry with the given key.
+func (o *Map) Delete(index Object) (err error) {
+	if strIdx, ok := index.(*String); ok {
+		delete(o.Value, strIdx.Value)
+		return nil
+	}
+	key, ok := ToHashKey(index)
+	if !ok {
+		return ErrInvalidIndexType
+	}
+	delete(o.Hashed, key)
+	return nil
+}
+
+// Iterate creates a map iterator.
+func (o *Map) Iterate() Iterator {
+	return newMapIterator(o.Value, o.Hashed)
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Map) CanIterate() bool {
+	return true
+}
+
+func mapString(value map[string]Object, hashed map[HashKey]MapEntry) string {
+	var pairs []string
+	for k, v := range value {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", k, v.String()))
+	}
+	for _, e := range hashed {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", e.Key.String(), e.Value.String()))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+}
+
+func mapCopy(value map[string]Object, hashed map[HashKey]MapEntry) *Map {
+	c := make(map[string]Object)
+	for k, v := range value {
+		c[k] = v.Copy()
+	}
+	m := &Map{Value: c}
+	if len(hashed) > 0 {
+		m.Hashed = make(map[HashKey]MapEntry, len(hashed))
+		for k, e := range hashed {
+			m.Hashed[k] = MapEntry{Key: e.Key, Value: e.Value.Copy()}
+		}
+	}
+	return m
+}
+
+func mapEquals(value map[string]Object, hashed map[HashKey]MapEntry, x Object) bool {
 	var xVal map[string]Object
+	var xHashed map[HashKey]MapEntry
 	switch x := x.(type) {
 	case *Map:
-		xVal = x.Value
+		xVal, xHashed = x.Value, x.Hashed
 	case *ImmutableMap:
-		xVal = x.Value
+		xVal, xHashed = x.Value, x.Hashed
 	default:
 		return false
 	}
-	if len(o.Value) != len(xVal) {
+	if len(value) != len(xVal) || len(hashed) != len(xHashed) {
 		return false
 	}
-	for k, v := range o.Value {
+	for k, v := range value {
 		tv := xVal[k]
 		if !v.Equals(tv) {
 			return false
 		}
 	}
+	for k, e := range hashed {
+		te, ok := xHashed[k]
+		if !ok || !e.Value.Equals(te.Value) {
+			return false
+		}
+	}
 	return true
 }
 
-// IndexGet returns the value for the given key.
-func (o *Map) IndexGet(index Object) (res Object, err error) {
-	strIdx, ok := ToString(index)
-	if !ok {
-		err = ErrInvalidIndexType
+func mapIndexGet(value map[string]Object, hashed map[HashKey]MapEntry, index Object) (res Object, err error) {
+	if strIdx, ok := index.(*String); ok {
+		res, ok = value[strIdx.Value]
+		if !ok {
+			res = UndefinedValue
+		}
 		return
 	}
-	res, ok = o.Value[strIdx]
-	if !ok {
-		res = UndefinedValue
-	}
-	return
-}
-
-// IndexSet sets the value for the given key.
-func (o *Map) IndexSet(index, value Object) (err error) {
-	strIdx, ok := ToString(index)
+	key, ok := ToHashKey(index)
 	if !ok {
 		err = ErrInvalidIndexType
 		return
 	}
-	o.Value[strIdx] = value
-	return nil
+	e, ok := hashed[key]
+	if !ok {
+		return UndefinedValue, nil
+	}
+	return e.Value, nil
 }
 
-// Iterate creates a map iterator.
-func (o *Map) Iterate() Iterator {
-	var keys []string
-	for k := range o.Value {
-		keys = append(keys, k)
+func mapContains(value map[string]Object, hashed map[HashKey]MapEntry, index Object) Object {
+	if strIdx, ok := index.(*String); ok {
+		if _, ok := value[strIdx.Value]; ok {
+			return TrueValue
+		}
+		return FalseValue
 	}
-	return &MapIterator{
-		v: o.Value,
-		k: keys,
-		l: len(keys),
+	if key, ok := ToHashKey(index); ok {
+		if _, ok := hashed[key]; ok {
+			return TrueValue
+		}
 	}
-}
-
-// CanIterate returns whether the Object can be Iterated.
-func (o *Map) CanIterate() bool {
-	return true
+	return FalseValue
 }
 
 // ObjectPtr represents a free variable.
@@ -1294,6 +1707,344 @@ func (o *ObjectPtr) Equals(x Object) bool {
 	return o == x
 }
 
+// Range represents a lazy sequence of integers from Start (inclusive) to Stop
+// (exclusive). Step is always positive; the sequence counts down if Stop is
+// less than Start.
+type Range struct {
+	ObjectImpl
+	Start int64
+	Stop  int64
+	Step  int64
+}
+
+// TypeName returns the name of the type.
+func (o *Range) TypeName() string {
+	return "range"
+}
+
+func (o *Range) String() string {
+	if o.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", o.Start, o.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", o.Start, o.Stop, o.Step)
+}
+
+// Len returns the number of elements in the range.
+func (o *Range) Len() int64 {
+	var d uint64
+	if o.Start <= o.Stop {
+		d = uint64(o.Stop) - uint64(o.Start)
+	} else {
+		d = uint64(o.Start) - uint64(o.Stop)
+	}
+	n := d / uint64(o.Step)
+	if d%uint64(o.Step) != 0 {
+		n++
+	}
+	return int64(n)
+}
+
+// At returns the i-th element of the range. i must be within [0, Len()).
+func (o *Range) At(i int64) int64 {
+	if o.Start <= o.Stop {
+		return o.Start + i*o.Step
+	}
+	return o.Start - i*o.Step
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object.
+func (o *Range) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op != token.In {
+		return nil, ErrInvalidOperator
+	}
+	if o.Contains(rhs) {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
+// Contains returns true if elem is an element of the range.
+func (o *Range) Contains(elem Object) bool {
+	i, ok := elem.(*Int)
+	if !ok {
+		return false
+	}
+	var d uint64
+	switch {
+	case o.Start <= o.Stop && o.Start <= i.Value && i.Value < o.Stop:
+		d = uint64(i.Value) - uint64(o.Start)
+	case o.Start > o.Stop && o.Stop < i.Value && i.Value <= o.Start:
+		d = uint64(o.Start) - uint64(i.Value)
+	default:
+		return false
+	}
+	return d%uint64(o.Step) == 0
+}
+
+// Copy returns a copy of the type.
+func (o *Range) Copy() Object {
+	return &Range{Start: o.Start, Stop: o.Stop, Step: o.Step}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Range) IsFalsy() bool {
+	return o.Len() == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Range) Equals(x Object) bool {
+	t, ok := x.(*Range)
+	if !ok {
+		return false
+	}
+	n := o.Len()
+	if n != t.Len() {
+		return false
+	}
+	switch n {
+	case 0:
+		return true
+	case 1:
+		return o.Start == t.Start
+	}
+	return o.Start == t.Start && o.At(1) == t.At(1)
+}
+
+// IndexGet returns the element at a given index.
+func (o *Range) IndexGet(index Object) (Object, error) {
+	intIdx, ok := index.(*Int)
+	if !ok {
+		return nil, ErrInvalidIndexType
+	}
+	idxVal := intIdx.Value
+	if idxVal < 0 {
+		idxVal += o.Len()
+	}
+	if idxVal < 0 || idxVal >= o.Len() {
+		return UndefinedValue, nil
+	}
+	return &Int{Value: o.At(idxVal)}, nil
+}
+
+// values returns the elements of the range as a slice of objects.
+func (o *Range) values() []Object {
+	values := make([]Object, o.Len())
+	for i := range values {
+		values[i] = &Int{Value: o.At(int64(i))}
+	}
+	return values
+}
+
+// Iterate creates a range iterator.
+func (o *Range) Iterate() Iterator {
+	return &RangeIterator{r: o, l: o.Len()}
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Range) CanIterate() bool {
+	return true
+}
+
+// Set represents an unordered set of hashable objects.
+type Set struct {
+	ObjectImpl
+	Value map[HashKey]Object
+}
+
+// NewSet creates a set of the given elements. It returns ErrNotHashable if
+// one of the elements is not hashable.
+func NewSet(elems ...Object) (*Set, error) {
+	s := &Set{Value: make(map[HashKey]Object, len(elems))}
+	for _, elem := range elems {
+		if err := s.Add(elem); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// TypeName returns the name of the type.
+func (o *Set) TypeName() string {
+	return "set"
+}
+
+func (o *Set) String() string {
+	var elements []string
+	for _, e := range o.Value {
+		elements = append(elements, e.String())
+	}
+	return fmt.Sprintf("set(%s)", strings.Join(elements, ", "))
+}
+
+// BinaryOp returns another object that is the result of a given binary
+// operator and a right-hand side object.
+func (o *Set) BinaryOp(op token.Token, rhs Object) (Object, error) {
+	if op == token.In {
+		if o.Contains(rhs) {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	}
+	rhsSet, ok := rhs.(*Set)
+	if !ok {
+		return nil, ErrInvalidOperator
+	}
+	switch op {
+	case token.Or: // union
+		res := &Set{Value: make(map[HashKey]Object, len(o.Value)+len(rhsSet.Value))}
+		for k, v := range o.Value {
+			res.Value[k] = v
+		}
+		for k, v := range rhsSet.Value {
+			res.Value[k] = v
+		}
+		return res, nil
+	case token.And: // intersection
+		res := &Set{Value: make(map[HashKey]Object)}
+		for k, v := range o.Value {
+			if _, ok := rhsSet.Value[k]; ok {
+				res.Value[k] = v
+			}
+		}
+		return res, nil
+	case token.Sub: // difference
+		res := &Set{Value: make(map[HashKey]Object)}
+		for k, v := range o.Value {
+			if _, ok := rhsSet.Value[k]; !ok {
+				res.Value[k] = v
+			}
+		}
+		return res, nil
+	case token.Xor: // symmetric difference
+		res := &Set{Value: make(map[HashKey]Object)}
+		for k, v := range o.Value {
+			if _, ok := rhsSet.Value[k]; !ok {
+				res.Value[k] = v
+			}
+		}
+		for k, v := range rhsSet.Value {
+			if _, ok := o.Value[k]; !ok {
+				res.Value[k] = v
+			}
+		}
+		return res, nil
+	case token.Greater: // proper superset
+		if len(o.Value) > len(rhsSet.Value) && o.isSuperset(rhsSet) {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	case token.GreaterEq: // superset
+		if o.isSuperset(rhsSet) {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	}
+	return nil, ErrInvalidOperator
+}
+
+func (o *Set) isSuperset(x *Set) bool {
+	for k := range x.Value {
+		if _, ok := o.Value[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy returns a copy of the type. The elements are immutable and thus are
+// not copied.
+func (o *Set) Copy() Object {
+	c := make(map[HashKey]Object, len(o.Value))
+	for k, v := range o.Value {
+		c[k] = v
+	}
+	return &Set{Value: c}
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (o *Set) IsFalsy() bool {
+	return len(o.Value) == 0
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *Set) Equals(x Object) bool {
+	t, ok := x.(*Set)
+	if !ok {
+		return false
+	}
+	return len(o.Value) == len(t.Value) && o.isSuperset(t)
+}
+
+// Add adds an element to the set.
+func (o *Set) Add(elem Object) error {
+	key, ok := ToHashKey(elem)
+	if !ok {
+		return ErrNotHashable{Type: elem.TypeName()}
+	}
+	o.Value[key] = elem
+	return nil
+}
+
+// Remove removes an element from the set.
+func (o *Set) Remove(elem Object) error {
+	key, ok := ToHashKey(elem)
+	if !ok {
+		return ErrNotHashable{Type: elem.TypeName()}
+	}
+	delete(o.Value, key)
+	return nil
+}
+
+// Contains returns true if elem is an element of the set.
+func (o *Set) Contains(elem Object) bool {
+	key, ok := ToHashKey(elem)
+	if !ok {
+		return false
+	}
+	_, ok = o.Value[key]
+	return ok
+}
+
+// IndexGet returns true if the given index is an element of the set.
+func (o *Set) IndexGet(index Object) (Object, error) {
+	if _, ok := ToHashKey(index); !ok {
+		return nil, ErrInvalidIndexType
+	}
+	if o.Contains(index) {
+		return TrueValue, nil
+	}
+	return FalseValue, nil
+}
+
+// IndexSet adds the given index to the set if value is truthy and removes
+// it otherwise.
+func (o *Set) IndexSet(index, value Object) error {
+	if _, ok := ToHashKey(index); !ok {
+		return ErrInvalidIndexType
+	}
+	if value.IsFalsy() {
+		return o.Remove(index)
+	}
+	return o.Add(index)
+}
+
+// Iterate creates a set iterator.
+func (o *Set) Iterate() Iterator {
+	elems := make([]Object, 0, len(o.Value))
+	for _, v := range o.Value {
+		elems = append(elems, v)
+	}
+	return &ArrayIterator{v: elems, l: len(elems)}
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *Set) CanIterate() bool {
+	return true
+}
+
 // String represents a string value.
 type String struct {
 	ObjectImpl
@@ -1384,6 +2135,11 @@ func (o *String) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// HashKey returns the hash key of the value.
+func (o *String) HashKey() (HashKey, bool) {
+	return HashKey{Type: "string", Value: o.Value}, true
+}
+
 // IndexGet returns a character at a given index.
 func (o *String) IndexGet(index Object) (res Object, err error) {
 	intIdx, ok := index.(*Int)
@@ -1391,10 +2147,10 @@ func (o *String) IndexGet(index Object) (res Object, err error) {
 		err = ErrInvalidIndexType
 		return
 	}
-	idxVal := int(intIdx.Value)
 	if o.runeStr == nil {
 		o.runeStr = []rune(o.Value)
 	}
+	idxVal := normalizeIndex(int(intIdx.Value), len(o.runeStr))
 	if idxVal < 0 || idxVal >= len(o.runeStr) {
 		res = UndefinedValue
 		return
@@ -1500,6 +2256,11 @@ func (o *Time) Equals(x Object) bool {
 	return o.Value.Equal(t.Value)
 }
 
+// HashKey returns the hash key of the value.
+func (o *Time) HashKey() (HashKey, bool) {
+	return HashKey{Type: "time", Value: fmt.Sprintf("%d.%09d", o.Value.Unix(), o.Value.Nanosecond())}, true
+}
+
 // Undefined represents an undefined value.
 type Undefined struct {
 	ObjectImpl
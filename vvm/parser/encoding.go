@@ -111,3 +111,42 @@ func UnmarshalPos(nn int, b []byte) (n int, p Pos, err error) {
 	p = Pos(v)
 	return n, p, nil
 }
+
+// SizeFilePos returns the size of the encoded SourceFilePos.
+func SizeFilePos(p SourceFilePos) int {
+	s := encoding.SizeString(p.Filename)
+	s += encoding.SizeInt(p.Offset)
+	s += encoding.SizeInt(p.Line)
+	s += encoding.SizeInt(p.Column)
+	return s
+}
+
+// MarshalFilePos encodes the SourceFilePos into the buffer.
+func MarshalFilePos(n int, b []byte, p SourceFilePos) int {
+	n = encoding.MarshalString(n, b, p.Filename)
+	n = encoding.MarshalInt(n, b, p.Offset)
+	n = encoding.MarshalInt(n, b, p.Line)
+	n = encoding.MarshalInt(n, b, p.Column)
+	return n
+}
+
+// UnmarshalFilePos decodes the SourceFilePos from the buffer.
+func UnmarshalFilePos(nn int, b []byte) (n int, p SourceFilePos, err error) {
+	n, p.Filename, err = encoding.UnmarshalString(nn, b)
+	if err != nil {
+		return nn, p, err
+	}
+	n, p.Offset, err = encoding.UnmarshalInt(n, b)
+	if err != nil {
+		return nn, p, err
+	}
+	n, p.Line, err = encoding.UnmarshalInt(n, b)
+	if err != nil {
+		return nn, p, err
+	}
+	n, p.Column, err = encoding.UnmarshalInt(n, b)
+	if err != nil {
+		return nn, p, err
+	}
+	return n, p, nil
+}
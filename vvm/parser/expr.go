@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"math/big"
 	"strings"
 
 	"github.com/malivvan/vv/vvm/token"
@@ -61,6 +62,29 @@ func (e *BadExpr) String() string {
 	return "<bad expression>"
 }
 
+// BigIntLit represents an arbitrary-precision integer literal.
+type BigIntLit struct {
+	Value    *big.Int
+	ValuePos Pos
+	Literal  string
+}
+
+func (e *BigIntLit) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *BigIntLit) Pos() Pos {
+	return e.ValuePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *BigIntLit) End() Pos {
+	return Pos(int(e.ValuePos) + len(e.Literal))
+}
+
+func (e *BigIntLit) String() string {
+	return e.Literal
+}
+
 // BinaryExpr represents a binary operator expression.
 type BinaryExpr struct {
 	LHS      Expr
@@ -190,6 +214,30 @@ func (e *CondExpr) String() string {
 		" : " + e.False.String() + ")"
 }
 
+// DecimalLit represents a decimal literal. Value holds the literal without
+// its suffix.
+type DecimalLit struct {
+	Value    string
+	ValuePos Pos
+	Literal  string
+}
+
+func (e *DecimalLit) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *DecimalLit) Pos() Pos {
+	return e.ValuePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *DecimalLit) End() Pos {
+	return Pos(int(e.ValuePos) + len(e.Literal))
+}
+
+func (e *DecimalLit) String() string {
+	return e.Literal
+}
+
 // ErrorExpr represents an error expression
 type ErrorExpr struct {
 	Expr     Expr
@@ -239,8 +287,9 @@ func (e *FloatLit) String() string {
 
 // FuncLit represents a function literal.
 type FuncLit struct {
-	Type *FuncType
-	Body *BlockStmt
+	Type      *FuncType
+	Body      *BlockStmt
+	Generator bool // body contains a yield statement
 }
 
 func (e *FuncLit) exprNode() {}
@@ -356,10 +405,11 @@ func (e *ImportExpr) String() string {
 
 // IndexExpr represents an index expression.
 type IndexExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Index  Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Index    Expr
+	RBrack   Pos
+	Optional bool // '?['
 }
 
 func (e *IndexExpr) exprNode() {}
@@ -379,7 +429,7 @@ func (e *IndexExpr) String() string {
 	if e.Index != nil {
 		index = e.Index.String()
 	}
-	return e.Expr.String() + "[" + index + "]"
+	return e.Expr.String() + optionalString(e.Optional) + "[" + index + "]"
 }
 
 // IntLit represents an integer literal.
@@ -481,8 +531,9 @@ func (e *ParenExpr) String() string {
 
 // SelectorExpr represents a selector expression.
 type SelectorExpr struct {
-	Expr Expr
-	Sel  Expr
+	Expr     Expr
+	Sel      Expr
+	Optional bool // '?.'
 }
 
 func (e *SelectorExpr) exprNode() {}
@@ -498,16 +549,18 @@ func (e *SelectorExpr) End() Pos {
 }
 
 func (e *SelectorExpr) String() string {
-	return e.Expr.String() + "." + e.Sel.String()
+	return e.Expr.String() + optionalString(e.Optional) + "." + e.Sel.String()
 }
 
 // SliceExpr represents a slice expression.
 type SliceExpr struct {
-	Expr   Expr
-	LBrack Pos
-	Low    Expr
-	High   Expr
-	RBrack Pos
+	Expr     Expr
+	LBrack   Pos
+	Low      Expr
+	High     Expr
+	Step     Expr // nil for '[low:high]'
+	RBrack   Pos
+	Optional bool // '?['
 }
 
 func (e *SliceExpr) exprNode() {}
@@ -530,7 +583,11 @@ func (e *SliceExpr) String() string {
 	if e.High != nil {
 		high = e.High.String()
 	}
-	return e.Expr.String() + "[" + low + ":" + high + "]"
+	if e.Step != nil {
+		high += ":" + e.Step.String()
+	}
+	return e.Expr.String() + optionalString(e.Optional) +
+		"[" + low + ":" + high + "]"
 }
 
 // StringLit represents a string literal.
@@ -556,6 +613,43 @@ func (e *StringLit) String() string {
 	return e.Literal
 }
 
+// TemplateLit represents a template string literal with embedded
+// expressions.
+type TemplateLit struct {
+	Strings  []string // string parts, one more than expressions
+	Exprs    []Expr
+	ValuePos Pos
+	EndPos   Pos
+}
+
+func (e *TemplateLit) exprNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (e *TemplateLit) Pos() Pos {
+	return e.ValuePos
+}
+
+// End returns the position of first character immediately after the node.
+func (e *TemplateLit) End() Pos {
+	return e.EndPos
+}
+
+func (e *TemplateLit) String() string {
+	var sb strings.Builder
+	sb.WriteByte('`')
+	for i, str := range e.Strings {
+		// a '${' of the string is written as an expression
+		sb.WriteString(strings.ReplaceAll(str, "${", `${"${"}`))
+		if i < len(e.Exprs) {
+			sb.WriteString("${")
+			sb.WriteString(e.Exprs[i].String())
+			sb.WriteString("}")
+		}
+	}
+	sb.WriteByte('`')
+	return sb.String()
+}
+
 // UnaryExpr represents an unary operator expression.
 type UnaryExpr struct {
 	Expr     Expr
@@ -599,3 +693,10 @@ func (e *UndefinedLit) End() Pos {
 func (e *UndefinedLit) String() string {
 	return "undefined"
 }
+
+func optionalString(optional bool) string {
+	if optional {
+		return "?"
+	}
+	return ""
+}
@@ -3,8 +3,10 @@ package parser
 import (
 	"fmt"
 	"io"
+	"math/big"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/malivvan/vv/vvm/token"
 )
@@ -17,6 +19,7 @@ var stmtStart = map[token.Token]bool{
 	token.For:      true,
 	token.If:       true,
 	token.Return:   true,
+	token.Yield:    true,
 	token.Export:   true,
 }
 
@@ -98,9 +101,11 @@ type Parser struct {
 	pos       Pos
 	token     token.Token
 	tokenLit  string
-	exprLevel int // < 0: in control clause, >= 0: in expression
-	syncPos   Pos // last sync position
-	syncCount int // number of advance calls without progress
+	exprLevel int  // < 0: in control clause, >= 0: in expression
+	noIn      bool // 'in' ends the expression (for-in clause)
+	yields    int  // number of yield statements in the current function
+	syncPos   Pos  // last sync position
+	syncCount int  // number of advance calls without progress
 	trace     bool
 	indent    int
 	traceOut  io.Writer
@@ -177,7 +182,7 @@ func (p *Parser) parseBinaryExpr(prec1 int) Expr {
 
 	for {
 		op, prec := p.token, p.token.Precedence()
-		if prec < prec1 {
+		if prec < prec1 || (op == token.In && p.noIn) {
 			return x
 		}
 
@@ -250,7 +255,20 @@ L:
 				p.advance(stmtStart)
 				return &BadExpr{From: pos, To: p.pos}
 			}
-		case token.LBrack:
+		case token.QuestionPeriod:
+			p.next()
+
+			switch p.token {
+			case token.Ident:
+				x = p.parseSelector(x)
+				x.(*SelectorExpr).Optional = true
+			default:
+				pos := p.pos
+				p.errorExpected(pos, "selector")
+				p.advance(stmtStart)
+				return &BadExpr{From: pos, To: p.pos}
+			}
+		case token.LBrack, token.QuestionLBrack:
 			x = p.parseIndexOrSlice(x)
 		case token.LParen:
 			x = p.parseCall(x)
@@ -315,20 +333,27 @@ func (p *Parser) parseIndexOrSlice(x Expr) Expr {
 		defer untracep(tracep(p, "IndexOrSlice"))
 	}
 
-	lbrack := p.expect(token.LBrack)
+	lbrack := p.pos
+	optional := p.token == token.QuestionLBrack
+	if optional {
+		p.next()
+	} else {
+		p.expect(token.LBrack)
+	}
 	p.exprLevel++
 
-	var index [2]Expr
+	var index [3]Expr
 	if p.token != token.Colon {
 		index[0] = p.parseExpr()
 	}
 	numColons := 0
-	if p.token == token.Colon {
+	for p.token == token.Colon && numColons < 2 {
 		numColons++
 		p.next()
 
-		if p.token != token.RBrack && p.token != token.EOF {
-			index[1] = p.parseExpr()
+		if p.token != token.Colon && p.token != token.RBrack &&
+			p.token != token.EOF {
+			index[numColons] = p.parseExpr()
 		}
 	}
 
@@ -338,18 +363,21 @@ func (p *Parser) parseIndexOrSlice(x Expr) Expr {
 	if numColons > 0 {
 		// slice expression
 		return &SliceExpr{
-			Expr:   x,
-			LBrack: lbrack,
-			RBrack: rbrack,
-			Low:    index[0],
-			High:   index[1],
+			Expr:     x,
+			LBrack:   lbrack,
+			RBrack:   rbrack,
+			Low:      index[0],
+			High:     index[1],
+			Step:     index[2],
+			Optional: optional,
 		}
 	}
 	return &IndexExpr{
-		Expr:   x,
-		LBrack: lbrack,
-		RBrack: rbrack,
-		Index:  index[0],
+		Expr:     x,
+		LBrack:   lbrack,
+		RBrack:   rbrack,
+		Index:    index[0],
+		Optional: optional,
 	}
 }
 
@@ -392,8 +420,32 @@ func (p *Parser) parseOperand() Expr {
 		}
 		p.next()
 		return x
+	case token.BigInt:
+		v, ok := new(big.Int).SetString(
+			strings.TrimSuffix(p.tokenLit, "n"), 0)
+		if !ok {
+			p.error(p.pos, "illegal bigint literal")
+			v = new(big.Int)
+		}
+		x := &BigIntLit{
+			Value:    v,
+			ValuePos: p.pos,
+			Literal:  p.tokenLit,
+		}
+		p.next()
+		return x
+	case token.Decimal:
+		x := &DecimalLit{
+			Value:    strings.TrimSuffix(p.tokenLit, "d"),
+			ValuePos: p.pos,
+			Literal:  p.tokenLit,
+		}
+		p.next()
+		return x
 	case token.Char:
 		return p.parseCharLit()
+	case token.TemplateHead:
+		return p.parseTemplateLit()
 	case token.String:
 		v, _ := strconv.Unquote(p.tokenLit)
 		x := &StringLit{
@@ -478,6 +530,50 @@ func (p *Parser) parseImportExpr() Expr {
 	return expr
 }
 
+func (p *Parser) parseTemplateLit() Expr {
+	if p.trace {
+		defer untracep(tracep(p, "TemplateLit"))
+	}
+
+	pos := p.pos
+	x := &TemplateLit{ValuePos: pos}
+	x.Strings = append(x.Strings, templateString(p.token, p.tokenLit))
+	p.next()
+
+	p.exprLevel++
+	defer func() { p.exprLevel-- }()
+	for {
+		x.Exprs = append(x.Exprs, p.parseExpr())
+		if p.token == token.Semicolon && p.tokenLit == "\n" {
+			p.next() // newline before closing '}'
+		}
+
+		switch p.token {
+		case token.TemplateMiddle:
+			x.Strings = append(x.Strings, templateString(p.token, p.tokenLit))
+			p.next()
+		case token.TemplateTail:
+			x.Strings = append(x.Strings, templateString(p.token, p.tokenLit))
+			x.EndPos = p.pos + Pos(len(p.tokenLit))
+			p.next()
+			return x
+		default:
+			p.errorExpected(p.pos, "'}'")
+			p.advance(stmtStart)
+			return &BadExpr{From: pos, To: p.pos}
+		}
+	}
+}
+
+// templateString returns the string part of a template token literal,
+// stripping the delimiters around it.
+func templateString(tok token.Token, lit string) string {
+	if tok == token.TemplateTail {
+		return strings.TrimSuffix(lit[1:], "`")
+	}
+	return lit[1 : len(lit)-2]
+}
+
 func (p *Parser) parseCharLit() Expr {
 	if n := len(p.tokenLit); n >= 3 {
 		code, _, _, err := strconv.UnquoteChar(p.tokenLit[1:n-1], '\'')
@@ -507,12 +603,17 @@ func (p *Parser) parseFuncLit() Expr {
 	}
 
 	typ := p.parseFuncType()
+	yields := p.yields
+	p.yields = 0
 	p.exprLevel++
 	body := p.parseBody()
 	p.exprLevel--
+	generator := p.yields > 0
+	p.yields = yields
 	return &FuncLit{
-		Type: typ,
-		Body: body,
+		Type:      typ,
+		Body:      body,
+		Generator: generator,
 	}
 }
 
@@ -669,15 +770,18 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 	switch p.token {
 	case // simple statements
 		token.Func, token.Error, token.Immutable, token.Ident, token.Int,
-		token.Float, token.Char, token.String, token.True, token.False,
-		token.Undefined, token.Import, token.LParen, token.LBrace,
-		token.LBrack, token.Add, token.Sub, token.Mul, token.And, token.Xor,
-		token.Not:
+		token.Float, token.BigInt, token.Decimal, token.Char, token.String,
+		token.TemplateHead,
+		token.True, token.False, token.Undefined, token.Import, token.LParen,
+		token.LBrace, token.LBrack, token.Add, token.Sub, token.Mul,
+		token.And, token.Xor, token.Not:
 		s := p.parseSimpleStmt(false)
 		p.expectSemi()
 		return s
 	case token.Return:
 		return p.parseReturnStmt()
+	case token.Yield:
+		return p.parseYieldStmt()
 	case token.Export:
 		return p.parseExportStmt()
 	case token.If:
@@ -900,14 +1004,34 @@ func (p *Parser) parseReturnStmt() Stmt {
 	pos := p.pos
 	p.expect(token.Return)
 
-	var x Expr
+	var x []Expr
 	if p.token != token.Semicolon && p.token != token.RBrace {
-		x = p.parseExpr()
+		x = p.parseExprList()
 	}
 	p.expectSemi()
 	return &ReturnStmt{
 		ReturnPos: pos,
-		Result:    x,
+		Results:   x,
+	}
+}
+
+func (p *Parser) parseYieldStmt() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "YieldStmt"))
+	}
+
+	pos := p.pos
+	p.expect(token.Yield)
+
+	var x Expr
+	if p.token != token.Semicolon && p.token != token.RBrace {
+		x = p.parseExpr()
+	}
+	p.expectSemi()
+	p.yields++
+	return &YieldStmt{
+		YieldPos: pos,
+		Result:   x,
 	}
 }
 
@@ -931,7 +1055,10 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 		defer untracep(tracep(p, "SimpleStmt"))
 	}
 
+	prevNoIn := p.noIn
+	p.noIn = forIn
 	x := p.parseExprList()
+	p.noIn = prevNoIn
 
 	switch p.token {
 	case token.Assign, token.Define: // assignment statement
@@ -1036,7 +1163,17 @@ func (p *Parser) parseMapElementLit() *MapElementLit {
 	} else {
 		p.errorExpected(pos, "map key")
 	}
+	isIdent := p.token == token.Ident
 	p.next()
+	if isIdent && (p.token == token.Comma || p.token == token.RBrace ||
+		(p.token == token.Semicolon && p.tokenLit == "\n")) {
+		// shorthand element: {name} is {name: name}
+		return &MapElementLit{
+			Key:    name,
+			KeyPos: pos,
+			Value:  &Ident{Name: name, NamePos: pos},
+		}
+	}
 	colonPos := p.expect(token.Colon)
 	valueExpr := p.parseExpr()
 	return &MapElementLit{
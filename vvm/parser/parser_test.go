@@ -3,6 +3,7 @@ package parser_test
 import (
 	"fmt"
 	"io"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
@@ -251,6 +252,49 @@ func TestParseAssignment(t *testing.T) {
 	})
 }
 
+func TestParseBigNumbers(t *testing.T) {
+	expectParse(t, "123456789012345678901234567890n", func(p pfn) []Stmt {
+		v, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		return stmts(
+			exprStmt(
+				bigIntLit(v, p(1, 1))))
+	})
+
+	expectParse(t, "0xffn * 2", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				binaryExpr(
+					bigIntLit(big.NewInt(255), p(1, 1)),
+					intLit(2, p(1, 9)),
+					token.Mul,
+					p(1, 7))))
+	})
+
+	expectParse(t, "-1.50d", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				unaryExpr(
+					decimalLit("1.50", p(1, 2)),
+					token.Sub,
+					p(1, 1))))
+	})
+
+	expectParse(t, "a = [1d, .5d, 2e3d]", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(
+					arrayLit(p(1, 5), p(1, 19),
+						decimalLit("1", p(1, 6)),
+						decimalLit(".5", p(1, 10)),
+						decimalLit("2e3", p(1, 15)))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParseString(t, "1n + 1.5d", "(1n + 1.5d)")
+}
+
 func TestParseBoolean(t *testing.T) {
 	expectParse(t, "true", func(p pfn) []Stmt {
 		return stmts(
@@ -504,6 +548,44 @@ c`, func(p pfn) []Stmt {
 	expectParseError(t, `(a ? b) : e`)
 }
 
+func TestParseDestructuring(t *testing.T) {
+	expectParse(t, "a, b := f()", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1)), ident("b", p(1, 4))),
+				exprs(callExpr(ident("f", p(1, 9)), p(1, 10), p(1, 11), NoPos)),
+				token.Define,
+				p(1, 6)))
+	})
+
+	expectParse(t, "[x, {y, z: w}] = v", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(arrayLit(p(1, 1), p(1, 14),
+					ident("x", p(1, 2)),
+					mapLit(p(1, 5), p(1, 13),
+						mapElementLit("y", p(1, 6), NoPos, ident("y", p(1, 6))),
+						mapElementLit("z", p(1, 9), p(1, 10),
+							ident("w", p(1, 12)))))),
+				exprs(ident("v", p(1, 18))),
+				token.Assign,
+				p(1, 16)))
+	})
+
+	expectParse(t, "return a, b", func(p pfn) []Stmt {
+		return stmts(
+			returnStmt(p(1, 1), ident("a", p(1, 8)), ident("b", p(1, 11))))
+	})
+
+	expectParseString(t, "{a, b} := c", "{a: a, b: b} := c")
+	expectParseString(t, "x := {a,\n b\n}", "x := {a: a, b: b}")
+	expectParseString(t, "return a, b + 1, c", "return a, (b + 1), c")
+
+	expectParseError(t, "{a b} := c")
+	expectParseError(t, `{"a"} := c`)
+	expectParseError(t, "a, b += 1, 2")
+}
+
 func TestParseError(t *testing.T) {
 	expectParse(t, `error(1234)`, func(p pfn) []Stmt {
 		return stmts(
@@ -600,6 +682,36 @@ func TestParseForIn(t *testing.T) {
 				blockStmt(p(1, 28), p(1, 29)),
 				p(1, 1)))
 	})
+
+	expectParse(t, "for x in y in z {}", func(p pfn) []Stmt {
+		return stmts(
+			forInStmt(
+				ident("_", p(1, 5)),
+				ident("x", p(1, 5)),
+				binaryExpr(
+					ident("y", p(1, 10)),
+					ident("z", p(1, 15)),
+					token.In,
+					p(1, 12)),
+				blockStmt(p(1, 17), p(1, 18)),
+				p(1, 1)))
+	})
+}
+
+func TestParseIn(t *testing.T) {
+	expectParse(t, "a in b", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				binaryExpr(
+					ident("a", p(1, 1)),
+					ident("b", p(1, 6)),
+					token.In,
+					p(1, 3))))
+	})
+
+	expectParseString(t, "a + 1 in b == c", "(((a + 1) in b) == c)")
+	expectParseString(t, "x := a in b", "x := (a in b)")
+	expectParseString(t, "if a in b {}", "if (a in b) {}")
 }
 
 func TestParseFor(t *testing.T) {
@@ -759,6 +871,45 @@ func TestParseVariadicFunction(t *testing.T) {
 	})
 }
 
+func TestParseGenerator(t *testing.T) {
+	expectParse(t, "a = func() { yield 1; yield }", func(p pfn) []Stmt {
+		gen := funcLit(
+			funcType(identList(p(1, 9), p(1, 10), false), p(1, 5)),
+			blockStmt(p(1, 12), p(1, 29),
+				yieldStmt(p(1, 14), intLit(1, p(1, 20))),
+				yieldStmt(p(1, 23), nil)))
+		gen.Generator = true
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(gen),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	// yield only marks the innermost function as a generator
+	expectParse(t, "func() { f := func() { yield 1 } }", func(p pfn) []Stmt {
+		inner := funcLit(
+			funcType(identList(p(1, 19), p(1, 20), false), p(1, 15)),
+			blockStmt(p(1, 22), p(1, 32),
+				yieldStmt(p(1, 24), intLit(1, p(1, 30)))))
+		inner.Generator = true
+		return stmts(
+			exprStmt(
+				funcLit(
+					funcType(identList(p(1, 5), p(1, 6), false), p(1, 1)),
+					blockStmt(p(1, 8), p(1, 34),
+						assignStmt(
+							exprs(ident("f", p(1, 10))),
+							exprs(inner),
+							token.Define,
+							p(1, 12))))))
+	})
+
+	expectParseString(t, "func() { yield x + 1 }", "func() {yield (x + 1)}")
+	expectParseError(t, "yield := 1")
+}
+
 func TestParseVariadicFunctionWithArgs(t *testing.T) {
 	expectParse(t, "a = func(x, y, ...z) { return z }", func(p pfn) []Stmt {
 		return stmts(
@@ -1077,6 +1228,37 @@ func TestParseIndex(t *testing.T) {
 					p(1, 10), p(1, 16))))
 	})
 
+	expectParse(t, "a[1:b:-1]", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&SliceExpr{
+					Expr: ident("a", p(1, 1)),
+					Low:  intLit(1, p(1, 3)),
+					High: ident("b", p(1, 5)),
+					Step: unaryExpr(
+						intLit(1, p(1, 8)),
+						token.Sub,
+						p(1, 7)),
+					LBrack: p(1, 2),
+					RBrack: p(1, 9),
+				}))
+	})
+
+	expectParse(t, "a[::2]", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&SliceExpr{
+					Expr:   ident("a", p(1, 1)),
+					Step:   intLit(2, p(1, 5)),
+					LBrack: p(1, 2),
+					RBrack: p(1, 6),
+				}))
+	})
+
+	expectParseString(t, "a[1::]", "a[1:]")
+	expectParseString(t, "a[:2:3]", "a[:2:3]")
+	expectParseError(t, "a[1:2:3:4]")
+
 	expectParse(t, "[1, 2, 3][a + 3 : b - 8]", func(p pfn) []Stmt {
 		return stmts(
 			exprStmt(
@@ -1267,6 +1449,41 @@ func TestParsePrecedence(t *testing.T) {
 	expectParseString(t, `a + b + c`, `((a + b) + c)`)
 	expectParseString(t, `a + b * c`, `(a + (b * c))`)
 	expectParseString(t, `x = 2 * 1 + 3 / 4`, `x = ((2 * 1) + (3 / 4))`)
+	expectParseString(t, `a ?? b || c`, `(a ?? (b || c))`)
+	expectParseString(t, `a || b ?? c + d`, `((a || b) ?? (c + d))`)
+	expectParseString(t, `a ?? b ?? c`, `((a ?? b) ?? c)`)
+}
+
+func TestParseOptionalChain(t *testing.T) {
+	expectParse(t, "a?.b?[c]?[:d]", func(p pfn) []Stmt {
+		return stmts(
+			exprStmt(
+				&SliceExpr{
+					Expr: &IndexExpr{
+						Expr: &SelectorExpr{
+							Expr:     ident("a", p(1, 1)),
+							Sel:      stringLit("b", p(1, 4)),
+							Optional: true,
+						},
+						Index:    ident("c", p(1, 7)),
+						LBrack:   p(1, 5),
+						RBrack:   p(1, 8),
+						Optional: true,
+					},
+					High:     ident("d", p(1, 12)),
+					LBrack:   p(1, 9),
+					RBrack:   p(1, 13),
+					Optional: true,
+				}))
+	})
+
+	expectParseString(t, "a?.b.c(d?.e)?[f]", "a?.b.c(d?.e)?[f]")
+	expectParseString(t, "x = a ? b : c", "x = (a ? b : c)")
+	expectParseString(t, "x = a ?.5 : c", "x = (a ? .5 : c)")
+	expectParseString(t, "x = a ?? b?.c", "x = (a ?? b?.c)")
+
+	expectParseError(t, "a?.1")
+	expectParseError(t, "a?.(b)")
 }
 
 func TestParseSelector(t *testing.T) {
@@ -1505,6 +1722,75 @@ func TestParseString(t *testing.T) {
 	})
 }
 
+func TestParseTemplate(t *testing.T) {
+	expectParse(t, "a = `x${b}y${c + 1}`", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(templateLit(
+					[]string{"x", "y", ""},
+					exprs(
+						ident("b", p(1, 9)),
+						binaryExpr(
+							ident("c", p(1, 14)),
+							intLit(1, p(1, 18)),
+							token.Add,
+							p(1, 16))),
+					p(1, 5), p(1, 21))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParse(t, "a = `${`${b}`}\\${c`", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(templateLit(
+					[]string{"", "\\${c"},
+					exprs(templateLit(
+						[]string{"", ""},
+						exprs(ident("b", p(1, 11))),
+						p(1, 8), p(1, 14))),
+					p(1, 5), p(1, 20))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParse(t, "a = `${ {b: 1}.b }`", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(templateLit(
+					[]string{"", ""},
+					exprs(selectorExpr(
+						mapLit(p(1, 9), p(1, 14),
+							mapElementLit("b", p(1, 10), p(1, 11),
+								intLit(1, p(1, 13)))),
+						stringLit("b", p(1, 16)))),
+					p(1, 5), p(1, 20))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParse(t, "a = `\\${b`", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(stringLit("\\${b", p(1, 5))),
+				token.Assign,
+				p(1, 3)))
+	})
+
+	expectParseString(t, "a = `x${b}\\${c}${ d }`", "a = `x${b}\\${c}${d}`")
+	expectParseString(t, "a = `${b}\\${`", "a = `${b}\\${\"${\"}`")
+	expectParseString(t, "a = `${b +\n c\n}`", "a = `${(b + c)}`")
+
+	expectParseError(t, "a = `${}`")
+	expectParseError(t, "a = `${b c}`")
+	expectParseString(t, "a = `${b`", "a = `${b`")
+	expectParseError(t, "a = `${b}")
+}
+
 type pfn func(int, int) Pos          // position conversion function
 type expectedFn func(pos pfn) []Stmt // callback function to return expected results
 
@@ -1618,8 +1904,12 @@ func emptyStmt(implicit bool, pos Pos) *EmptyStmt {
 	return &EmptyStmt{Implicit: implicit, Semicolon: pos}
 }
 
-func returnStmt(pos Pos, result Expr) *ReturnStmt {
-	return &ReturnStmt{Result: result, ReturnPos: pos}
+func returnStmt(pos Pos, results ...Expr) *ReturnStmt {
+	return &ReturnStmt{Results: results, ReturnPos: pos}
+}
+
+func yieldStmt(pos Pos, result Expr) *YieldStmt {
+	return &YieldStmt{Result: result, YieldPos: pos}
 }
 
 func forStmt(
@@ -1723,6 +2013,14 @@ func intLit(value int64, pos Pos) *IntLit {
 	return &IntLit{Value: value, ValuePos: pos}
 }
 
+func bigIntLit(value *big.Int, pos Pos) *BigIntLit {
+	return &BigIntLit{Value: value, ValuePos: pos}
+}
+
+func decimalLit(value string, pos Pos) *DecimalLit {
+	return &DecimalLit{Value: value, ValuePos: pos}
+}
+
 func floatLit(value float64, pos Pos) *FloatLit {
 	return &FloatLit{Value: value, ValuePos: pos}
 }
@@ -1731,6 +2029,14 @@ func stringLit(value string, pos Pos) *StringLit {
 	return &StringLit{Value: value, ValuePos: pos}
 }
 
+func templateLit(
+	strs []string,
+	list []Expr,
+	pos, end Pos,
+) *TemplateLit {
+	return &TemplateLit{Strings: strs, Exprs: list, ValuePos: pos, EndPos: end}
+}
+
 func charLit(value rune, pos Pos) *CharLit {
 	return &CharLit{
 		Value: value, ValuePos: pos, Literal: fmt.Sprintf("'%c'", value),
@@ -1875,10 +2181,15 @@ func equalStmt(t *testing.T, expected, actual Stmt) {
 		require.Equal(t, expected.ForPos,
 			actual.(*ForInStmt).ForPos)
 	case *ReturnStmt:
-		equalExpr(t, expected.Result,
-			actual.(*ReturnStmt).Result)
+		equalExprs(t, expected.Results,
+			actual.(*ReturnStmt).Results)
 		require.Equal(t, expected.ReturnPos,
 			actual.(*ReturnStmt).ReturnPos)
+	case *YieldStmt:
+		equalExpr(t, expected.Result,
+			actual.(*YieldStmt).Result)
+		require.Equal(t, expected.YieldPos,
+			actual.(*YieldStmt).YieldPos)
 	case *BranchStmt:
 		equalExpr(t, expected.Label,
 			actual.(*BranchStmt).Label)
@@ -1910,6 +2221,16 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*IntLit).Value)
 		require.Equal(t, int(expected.ValuePos),
 			int(actual.(*IntLit).ValuePos))
+	case *BigIntLit:
+		require.Equal(t, expected.Value.String(),
+			actual.(*BigIntLit).Value.String())
+		require.Equal(t, int(expected.ValuePos),
+			int(actual.(*BigIntLit).ValuePos))
+	case *DecimalLit:
+		require.Equal(t, expected.Value,
+			actual.(*DecimalLit).Value)
+		require.Equal(t, int(expected.ValuePos),
+			int(actual.(*DecimalLit).ValuePos))
 	case *FloatLit:
 		require.Equal(t, expected.Value,
 			actual.(*FloatLit).Value)
@@ -1930,6 +2251,14 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*StringLit).Value)
 		require.Equal(t, int(expected.ValuePos),
 			int(actual.(*StringLit).ValuePos))
+	case *TemplateLit:
+		require.Equal(t, expected.Strings,
+			actual.(*TemplateLit).Strings)
+		equalExprs(t, expected.Exprs, actual.(*TemplateLit).Exprs)
+		require.Equal(t, int(expected.ValuePos),
+			int(actual.(*TemplateLit).ValuePos))
+		require.Equal(t, int(expected.EndPos),
+			int(actual.(*TemplateLit).EndPos))
 	case *ArrayLit:
 		require.Equal(t, expected.LBrack,
 			actual.(*ArrayLit).LBrack)
@@ -1965,6 +2294,8 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*FuncLit).Type)
 		equalStmt(t, expected.Body,
 			actual.(*FuncLit).Body)
+		require.Equal(t, expected.Generator,
+			actual.(*FuncLit).Generator)
 	case *CallExpr:
 		equalExpr(t, expected.Func,
 			actual.(*CallExpr).Func)
@@ -1990,6 +2321,8 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*IndexExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*IndexExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*IndexExpr).Optional)
 	case *SliceExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SliceExpr).Expr)
@@ -1997,15 +2330,21 @@ func equalExpr(t *testing.T, expected, actual Expr) {
 			actual.(*SliceExpr).Low)
 		equalExpr(t, expected.High,
 			actual.(*SliceExpr).High)
+		equalExpr(t, expected.Step,
+			actual.(*SliceExpr).Step)
 		require.Equal(t, expected.LBrack,
 			actual.(*SliceExpr).LBrack)
 		require.Equal(t, expected.RBrack,
 			actual.(*SliceExpr).RBrack)
+		require.Equal(t, expected.Optional,
+			actual.(*SliceExpr).Optional)
 	case *SelectorExpr:
 		equalExpr(t, expected.Expr,
 			actual.(*SelectorExpr).Expr)
 		equalExpr(t, expected.Sel,
 			actual.(*SelectorExpr).Sel)
+		require.Equal(t, expected.Optional,
+			actual.(*SelectorExpr).Optional)
 	case *ImportExpr:
 		require.Equal(t, expected.ModuleName,
 			actual.(*ImportExpr).ModuleName)
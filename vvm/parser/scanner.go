@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"unicode"
 	"unicode/utf8"
@@ -36,6 +37,7 @@ type Scanner struct {
 	errorHandler ScannerErrorHandler // error reporting; or nil
 	errorCount   int                 // number of errors encountered
 	mode         ScanMode
+	templates    []int // brace depth of open template expressions
 }
 
 // NewScanner creates a Scanner.
@@ -90,7 +92,8 @@ func (s *Scanner) Scan() (
 		tok = token.Lookup(literal)
 		switch tok {
 		case token.Ident, token.Break, token.Continue, token.Return,
-			token.Export, token.True, token.False, token.Undefined:
+			token.Yield, token.Export, token.True, token.False,
+			token.Undefined:
 			insertSemi = true
 		}
 	case '0' <= ch && ch <= '9':
@@ -119,9 +122,8 @@ func (s *Scanner) Scan() (
 			tok = token.Char
 			literal = s.scanRune()
 		case '`':
-			insertSemi = true
-			tok = token.String
-			literal = s.scanRawString()
+			tok, literal = s.scanTemplate(true)
+			insertSemi = tok == token.String
 		case ':':
 			tok = s.switch2(token.Colon, token.Define)
 		case '.':
@@ -139,7 +141,20 @@ func (s *Scanner) Scan() (
 		case ',':
 			tok = token.Comma
 		case '?':
-			tok = token.Question
+			switch {
+			case s.ch == '.' && !isDigit(rune(s.peek())):
+				// '?.5' is a conditional followed by a float
+				s.next()
+				tok = token.QuestionPeriod
+			case s.ch == '[':
+				s.next()
+				tok = token.QuestionLBrack
+			case s.ch == '?':
+				s.next()
+				tok = token.Coalesce
+			default:
+				tok = token.Question
+			}
 		case ';':
 			tok = token.Semicolon
 			literal = ";"
@@ -155,7 +170,19 @@ func (s *Scanner) Scan() (
 			tok = token.RBrack
 		case '{':
 			tok = token.LBrace
+			if n := len(s.templates); n > 0 {
+				s.templates[n-1]++
+			}
 		case '}':
+			if n := len(s.templates); n > 0 && s.templates[n-1] == 0 {
+				// end of a template expression
+				s.templates = s.templates[:n-1]
+				tok, literal = s.scanTemplate(false)
+				insertSemi = tok == token.TemplateTail
+				break
+			} else if n > 0 {
+				s.templates[n-1]--
+			}
 			insertSemi = true
 			tok = token.RBrace
 		case '+':
@@ -393,6 +420,16 @@ func (s *Scanner) scanNumber(
 	tok = token.Int
 
 	defer func() {
+		// a trailing 'n' makes an integer a bigint, a trailing 'd' makes
+		// a decimal integer or float a decimal
+		switch {
+		case s.ch == 'n' && tok == token.Int:
+			s.next()
+			tok = token.BigInt
+		case s.ch == 'd':
+			s.next()
+			tok = token.Decimal
+		}
 		lit = string(s.src[offs:s.offset])
 	}()
 
@@ -568,14 +605,25 @@ func (s *Scanner) scanString() string {
 	return string(s.src[offs:s.offset])
 }
 
-func (s *Scanner) scanRawString() string {
-	offs := s.offset - 1 // '`' opening already consumed
+// scanTemplate scans the part of a backtick string up to the closing '`' or
+// the next '${' with a matching '}'. If head is true and the string contains
+// no expressions, it is returned as a raw string literal.
+func (s *Scanner) scanTemplate(head bool) (token.Token, string) {
+	offs := s.offset - 1 // '`' or '}' already consumed
 
+	tok := token.TemplateTail
+	if head {
+		tok = token.String
+	}
 	hasCR := false
 	for {
 		ch := s.ch
 		if ch < 0 {
-			s.error(offs, "raw string literal not terminated")
+			if tok == token.String {
+				s.error(offs, "raw string literal not terminated")
+			} else {
+				s.error(offs, "template literal not terminated")
+			}
 			break
 		}
 
@@ -584,7 +632,16 @@ func (s *Scanner) scanRawString() string {
 		if ch == '`' {
 			break
 		}
-
+		if ch == '$' && s.ch == '{' && skipBraces(s.src, s.offset+1) >= 0 {
+			s.next()
+			s.templates = append(s.templates, 0)
+			if head {
+				tok = token.TemplateHead
+			} else {
+				tok = token.TemplateMiddle
+			}
+			break
+		}
 		if ch == '\r' {
 			hasCR = true
 		}
@@ -594,7 +651,70 @@ func (s *Scanner) scanRawString() string {
 	if hasCR {
 		lit = StripCR(lit, false)
 	}
-	return string(lit)
+	return tok, string(lit)
+}
+
+// skipBraces returns the offset after the '}' that closes a '{' found
+// before offs in src, skipping the literals and comments in between, or -1
+// if there is none. A '${' without one is part of the backtick string.
+func skipBraces(src []byte, offs int) int {
+	depth := 1
+	for i := offs; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case '"', '\'':
+			q := src[i]
+			for i++; i < len(src) && src[i] != q; i++ {
+				if src[i] == '\n' {
+					return -1
+				}
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '`':
+			if i = skipTemplate(src, i+1); i < 0 {
+				return -1
+			}
+			i-- // at the closing '`'
+		case '/':
+			if i+1 < len(src) && src[i+1] == '/' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else if i+1 < len(src) && src[i+1] == '*' {
+				end := bytes.Index(src[i+2:], []byte("*/"))
+				if end < 0 {
+					return -1
+				}
+				i += end + 3
+			}
+		}
+	}
+	return -1
+}
+
+// skipTemplate returns the offset after the '`' that closes a backtick
+// string starting before offs in src, or -1 if there is none.
+func skipTemplate(src []byte, offs int) int {
+	for i := offs; i < len(src); i++ {
+		switch src[i] {
+		case '`':
+			return i + 1
+		case '$':
+			if i+1 < len(src) && src[i+1] == '{' {
+				if end := skipBraces(src, i+2); end >= 0 {
+					i = end - 1
+				}
+			}
+		}
+	}
+	return -1
 }
 
 // StripCR removes carriage return characters.
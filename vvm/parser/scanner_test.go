@@ -50,6 +50,13 @@ func TestScanner_Scan(t *testing.T) {
 		{token.Float, "1e+100"},
 		{token.Float, "1e-100"},
 		{token.Float, "2.71828e-1000"},
+		{token.BigInt, "0n"},
+		{token.BigInt, "123456789012345678901234567890n"},
+		{token.BigInt, "0xcafebaben"},
+		{token.Decimal, "0d"},
+		{token.Decimal, "1.50d"},
+		{token.Decimal, ".5d"},
+		{token.Decimal, "1e-3d"},
 		{token.Char, "'a'"},
 		{token.Char, "'\\000'"},
 		{token.Char, "'\\xFF'"},
@@ -108,6 +115,10 @@ func TestScanner_Scan(t *testing.T) {
 		{token.RBrace, "}"},
 		{token.Semicolon, ";"},
 		{token.Colon, ":"},
+		{token.Question, "?"},
+		{token.QuestionPeriod, "?."},
+		{token.QuestionLBrack, "?["},
+		{token.Coalesce, "??"},
 		{token.Break, "break"},
 		{token.Continue, "continue"},
 		{token.Else, "else"},
@@ -115,6 +126,7 @@ func TestScanner_Scan(t *testing.T) {
 		{token.Func, "func"},
 		{token.If, "if"},
 		{token.Return, "return"},
+		{token.Yield, "yield"},
 		{token.Export, "export"},
 	}
 
@@ -194,6 +206,41 @@ func TestScanner_Scan(t *testing.T) {
 		parser.DontInsertSemis, expectedSkipComments...)
 }
 
+func TestScanner_ScanTemplate(t *testing.T) {
+	scanExpect(t, "`a${b}c${ {d: `${e}`} }\\${f`", parser.DontInsertSemis,
+		scanResult{token.TemplateHead, "`a${", 1, 1},
+		scanResult{token.Ident, "b", 1, 5},
+		scanResult{token.TemplateMiddle, "}c${", 1, 6},
+		scanResult{token.LBrace, "", 1, 11},
+		scanResult{token.Ident, "d", 1, 12},
+		scanResult{token.Colon, "", 1, 13},
+		scanResult{token.TemplateHead, "`${", 1, 15},
+		scanResult{token.Ident, "e", 1, 18},
+		scanResult{token.TemplateTail, "}`", 1, 19},
+		scanResult{token.RBrace, "", 1, 21},
+		scanResult{token.TemplateTail, "}\\${f`", 1, 23},
+	)
+	// a '${' without a closing '}' is part of the string
+	scanExpect(t, "`C:\\new\\table ${`", parser.DontInsertSemis,
+		scanResult{token.String, "`C:\\new\\table ${`", 1, 1},
+	)
+	scanExpect(t, "`${ \"}\" + `${` }`", parser.DontInsertSemis,
+		scanResult{token.TemplateHead, "`${", 1, 1},
+		scanResult{token.String, "\"}\"", 1, 5},
+		scanResult{token.Add, "", 1, 9},
+		scanResult{token.String, "`${`", 1, 11},
+		scanResult{token.TemplateTail, "}`", 1, 16},
+	)
+	scanExpect(t, "`${a}`\n`b`", 0,
+		scanResult{token.TemplateHead, "`${", 1, 1},
+		scanResult{token.Ident, "a", 1, 4},
+		scanResult{token.TemplateTail, "}`", 1, 5},
+		scanResult{token.Semicolon, "\n", 1, 7},
+		scanResult{token.String, "`b`", 2, 1},
+		scanResult{token.Semicolon, "\n", 2, 4},
+	)
+}
+
 func TestStripCR(t *testing.T) {
 	for _, tc := range []struct {
 		input  string
@@ -323,7 +323,7 @@ func (s *IncDecStmt) String() string {
 // ReturnStmt represents a return statement.
 type ReturnStmt struct {
 	ReturnPos Pos
-	Result    Expr
+	Results   []Expr
 }
 
 func (s *ReturnStmt) stmtNode() {}
@@ -335,15 +335,47 @@ func (s *ReturnStmt) Pos() Pos {
 
 // End returns the position of first character immediately after the node.
 func (s *ReturnStmt) End() Pos {
-	if s.Result != nil {
-		return s.Result.End()
+	if n := len(s.Results); n > 0 {
+		return s.Results[n-1].End()
 	}
 	return s.ReturnPos + 6
 }
 
 func (s *ReturnStmt) String() string {
-	if s.Result != nil {
-		return "return " + s.Result.String()
+	if len(s.Results) > 0 {
+		var results []string
+		for _, e := range s.Results {
+			results = append(results, e.String())
+		}
+		return "return " + strings.Join(results, ", ")
 	}
 	return "return"
 }
+
+// YieldStmt represents a yield statement.
+type YieldStmt struct {
+	YieldPos Pos
+	Result   Expr
+}
+
+func (s *YieldStmt) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *YieldStmt) Pos() Pos {
+	return s.YieldPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *YieldStmt) End() Pos {
+	if s.Result != nil {
+		return s.Result.End()
+	}
+	return s.YieldPos + 5
+}
+
+func (s *YieldStmt) String() string {
+	if s.Result != nil {
+		return "yield " + s.Result.String()
+	}
+	return "yield"
+}
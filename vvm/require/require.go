@@ -157,9 +157,13 @@ func Equal(t *testing.T, expected, actual interface{}, msg ...interface{}) {
 	case *vvm.Map:
 		equalObjectMap(t, expected.Value,
 			actual.(*vvm.Map).Value, msg...)
+		equalHashedEntries(t, expected.Hashed,
+			actual.(*vvm.Map).Hashed, msg...)
 	case *vvm.ImmutableMap:
 		equalObjectMap(t, expected.Value,
 			actual.(*vvm.ImmutableMap).Value, msg...)
+		equalHashedEntries(t, expected.Hashed,
+			actual.(*vvm.ImmutableMap).Hashed, msg...)
 	case *vvm.CompiledFunction:
 		equalCompiledFunction(t, expected,
 			actual.(*vvm.CompiledFunction), msg...)
@@ -283,6 +287,20 @@ func equalObjectMap(
 	}
 }
 
+func equalHashedEntries(
+	t *testing.T,
+	expected, actual map[vvm.HashKey]vvm.MapEntry,
+	msg ...interface{},
+) {
+	Equal(t, len(expected), len(actual), msg...)
+	for key, expectedEntry := range expected {
+		actualEntry, ok := actual[key]
+		True(t, ok, msg...)
+		Equal(t, expectedEntry.Key, actualEntry.Key, msg...)
+		Equal(t, expectedEntry.Value, actualEntry.Value, msg...)
+	}
+}
+
 func equalCompiledFunction(
 	t *testing.T,
 	expected, actual vvm.Object,
@@ -2,17 +2,35 @@ package vvm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"runtime/debug"
+	"sort"
 	"sync/atomic"
 	"time"
+
+	"github.com/malivvan/vv/vvm/parser"
 )
 
-func init() {
-	addBuiltinFunction("start", builtinStart)
-	addBuiltinFunction("abort", builtinAbort)
-	addBuiltinFunction("chan", builtinChan)
-}
+// failure policies of routines
+const (
+	failReport  = "report"  // the run of the parent returns the error
+	failParent  = "fail"    // the parent is aborted and returns the error
+	failIgnore  = "ignore"  // the error is only returned by result
+	failRestart = "restart" // the function is called again
+)
+
+// defaultMaxRestarts is the number of times a routine is restarted by
+// default before its failure is reported.
+const defaultMaxRestarts = 3
+
+// routine states
+const (
+	routineRunning = "running"
+	routineWaiting = "waiting"
+	routineDone    = "done"
+)
 
 type ret struct {
 	val Object
@@ -23,7 +41,43 @@ type routineVM struct {
 	*VM      // if not nil, run CompiledFunction in VM
 	ret      // return value
 	waitChan chan ret
+	exited   chan struct{} // closed once the routine has finished
 	done     int64
+	task     *task    // set in a deterministic run
+	next     *task    // task of the next run after a failure
+	fn       Object   // function run by the routine
+	args     []Object // arguments of fn, kept to restart it
+
+	id          int64
+	name        string
+	parent      int64   // ID of the routine that started it, 0 for the main function
+	policy      string  // failure policy
+	maxRestarts int     // negative for no limit
+	restarts    int64   // accessed atomically
+	calls       int32   // builtin calls in progress, accessed atomically
+	site        int64   // position of the last builtin call, accessed atomically
+	callers     []frame // call stack it was started from, if fn is not compiled
+}
+
+func newRoutine(fn Object) *routineVM {
+	return &routineVM{
+		waitChan:    make(chan ret, 1),
+		exited:      make(chan struct{}),
+		fn:          fn,
+		policy:      failReport,
+		maxRestarts: defaultMaxRestarts,
+	}
+}
+
+// RoutineInfo describes a routine of a run. The routines form a tree through
+// their Parent.
+type RoutineInfo struct {
+	ID       int64
+	Name     string
+	Parent   int64                // ID of the routine that started it, 0 for the main function
+	State    string               // "running", "waiting" in a builtin call, or "done"
+	Pos      parser.SourceFilePos // position of the builtin call it waits in or made last
+	Restarts int
 }
 
 // Starts a independent concurrent routine which runs fn(arg1, arg2, ...)
@@ -44,66 +98,574 @@ type routineVM struct {
 // The latter 2 cases will trigger aborting procedure of all the descendant routineVMs,
 // which will further result in #1 above.
 func builtinStart(ctx context.Context, args ...Object) (Object, error) {
-	vm := ctx.Value(ContextKey("vm")).(*VM)
 	if len(args) == 0 {
 		return nil, ErrWrongNumArguments
 	}
+	return startRoutine(ctx, newRoutine(args[0]), args[1:])
+}
 
-	fn := args[0]
-	if !fn.CanCall() {
+// Starts a routine like start with the options given as first argument:
+// its name, the failure policy on_failure and max_restarts.
+func builtinStartWith(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) < 2 {
+		return nil, ErrWrongNumArguments
+	}
+	var opts map[string]Object
+	switch o := args[0].(type) {
+	case *Map:
+		opts = o.Value
+	case *ImmutableMap:
+		opts = o.Value
+	default:
 		return nil, ErrInvalidArgumentType{
 			Name:     "first",
+			Expected: "map",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if !args[1].CanCall() {
+		return nil, ErrInvalidArgumentType{
+			Name:     "second",
 			Expected: "callable function",
-			Found:    fn.TypeName(),
+			Found:    args[1].TypeName(),
 		}
 	}
+	gvm := newRoutine(args[1])
+	for key, value := range opts {
+		var ok bool
+		switch key {
+		case "name":
+			gvm.name, ok = ToString(value)
+		case "on_failure":
+			gvm.policy, ok = ToString(value)
+			switch gvm.policy {
+			case failReport, failParent, failIgnore, failRestart:
+			default:
+				return nil, fmt.Errorf("invalid failure policy: %s", gvm.policy)
+			}
+		case "max_restarts":
+			gvm.maxRestarts, ok = ToInt(value)
+		default:
+			return nil, fmt.Errorf("unknown routine option: %s", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid routine option %s: %s", key, value.TypeName())
+		}
+	}
+	return startRoutine(ctx, gvm, args[2:])
+}
 
-	gvm := &routineVM{
-		waitChan: make(chan ret, 1),
+// Start starts a routine running fn with args on behalf of the VM running
+// ctx, as passed to builtin functions, like the start builtin function does.
+// It returns the routine object.
+func Start(ctx context.Context, fn Object, args ...Object) (Object, error) {
+	if _, ok := ctx.Value(ContextKey("vm")).(*VM); !ok {
+		return nil, errors.New("cannot start a routine outside a VM")
 	}
+	return startRoutine(ctx, newRoutine(fn), args)
+}
 
-	var callers []frame
-	cfn, compiled := fn.(*CompiledFunction)
-	if compiled {
-		gvm.VM = vm.ShallowClone()
+// startRoutine starts gvm, which runs its function with args, as a child of
+// the VM running ctx.
+func startRoutine(ctx context.Context, gvm *routineVM, args []Object) (Object, error) {
+	vm := ctx.Value(ContextKey("vm")).(*VM)
+	if !gvm.fn.CanCall() {
+		return nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "callable function",
+			Found:    gvm.fn.TypeName(),
+		}
+	}
+
+	// args is a view of the caller's stack, which changes while the routine
+	// runs
+	gvm.args = append([]Object(nil), args...)
+	gvm.id = atomic.AddInt64(&vm.pause.lastID, 1)
+	_, compiled := gvm.fn.(*CompiledFunction)
+	if starter, ok := ctx.Value(ContextKey("routine")).(*routineVM); ok {
+		// started by a routine running a Go function while vm runs on: it
+		// is started where that routine was
+		gvm.parent = starter.id
+		gvm.site = atomic.LoadInt64(&starter.site)
+		gvm.callers = starter.callers
 	} else {
-		callers = vm.callers()
+		if vm.routine != nil {
+			gvm.parent = vm.routine.id
+		}
+		gvm.site = int64(vm.pos())
+		if !compiled {
+			gvm.callers = vm.callers()
+		}
+	}
+
+	runCtx := ctx
+	if !compiled {
+		// ctx may be interrupted by a snapshot, which the routine outlives
+		runCtx = context.WithValue(vm.ctx, ContextKey("routine"), gvm)
+	}
+
+	if d := vm.deterministic; d != nil {
+		// the task is started by the routine running ctx, which differs
+		// from the one of vm when a Go function starts the routine
+		t, err := d.start(ctx)
+		if err != nil {
+			return nil, err
+		}
+		gvm.task = t
+		if !compiled {
+			runCtx = context.WithValue(runCtx, ContextKey("task"), t)
+		}
+	}
+	if compiled {
+		gvm.VM = gvm.newVM(vm)
 	}
 
 	if err := vm.addChild(gvm.VM); err != nil {
+		if gvm.task != nil {
+			gvm.task.exit()
+		}
 		return nil, err
 	}
-	go func() {
-		var val Object
-		var err error
-		defer func() {
-			if perr := recover(); perr != nil {
-				if callers == nil {
-					panic("callers not saved")
+	vm.pause.addRoutine(gvm)
+	go gvm.run(vm, runCtx, gvm.callers)
+
+	return gvm.object(), nil
+}
+
+// newVM creates the VM running the compiled function of gvm, a routine
+// started by vm.
+func (gvm *routineVM) newVM(vm *VM) *VM {
+	cvm := vm.ShallowClone()
+	cvm.routine = gvm
+	if gvm.task != nil {
+		cvm.task = gvm.task
+		cvm.ctx = context.WithValue(cvm.ctx, ContextKey("task"), gvm.task)
+	}
+	return cvm
+}
+
+// resume starts gvm, a routine restored from a snapshot, as a child of vm.
+func (vm *VM) resume(gvm *routineVM) {
+	if err := vm.addChild(gvm.VM); err != nil {
+		gvm.waitChan <- ret{nil, err}
+		close(gvm.exited)
+		return
+	}
+	vm.pause.addRoutine(gvm)
+	go gvm.run(vm, vm.ctx, nil)
+}
+
+// run runs the function of the routine, or continues the state of its VM
+// restored from a snapshot, and reports the result. callers is the call
+// stack of the VM that started a routine running a Go function.
+func (gvm *routineVM) run(vm *VM, ctx context.Context, callers []frame) {
+	var val Object
+	var err error
+	defer func() {
+		if perr := recover(); perr != nil {
+			if callers == nil {
+				panic("callers not saved")
+			}
+			err = newRuntimeError(ErrPanic{perr, debug.Stack()}, vm.stackTrace(callers))
+		}
+		if gvm.task != nil {
+			gvm.task.exit()
+		}
+		if err != nil {
+			gvm.report(vm, err)
+		}
+		gvm.waitChan <- ret{val, err}
+		close(gvm.exited)
+		vm.pause.delRoutine(gvm)
+		vm.delChild(gvm.VM)
+		gvm.VM = nil
+	}()
+
+	if gvm.task != nil {
+		if err = gvm.await(ctx); err != nil {
+			return
+		}
+	}
+	for {
+		val, err = gvm.call(ctx)
+		if !gvm.restart(vm, err) {
+			return
+		}
+	}
+}
+
+// await waits for the turn of the routine in a deterministic run.
+func (gvm *routineVM) await(ctx context.Context) error {
+	if gvm.VM != nil {
+		ctx = gvm.VM.ctx
+	}
+	return gvm.task.await(ctx)
+}
+
+// call calls the function of the routine once.
+func (gvm *routineVM) call(ctx context.Context) (Object, error) {
+	switch fn := gvm.fn.(type) {
+	case *CompiledFunction:
+		if gvm.VM.restored {
+			return gvm.RunCompiled(nil)
+		}
+		return gvm.RunCompiled(fn, gvm.args...)
+	default:
+		atomic.AddInt32(&gvm.calls, 1)
+		defer atomic.AddInt32(&gvm.calls, -1)
+		return fn.Call(ctx, gvm.args...)
+	}
+}
+
+// canRestart reports whether the routine restarts after its function
+// failed with err.
+func (gvm *routineVM) canRestart(err error) bool {
+	return err != nil && !errors.Is(err, ErrVMAborted) && gvm.policy == failRestart &&
+		(gvm.maxRestarts < 0 || atomic.LoadInt64(&gvm.restarts) < int64(gvm.maxRestarts))
+}
+
+// startNext starts the task of the next run of the routine while the task
+// of v, its VM that failed, still runs. The task of the routine is replaced
+// before the old one exits, so that waiting for the routine goes on.
+func (gvm *routineVM) startNext(v *VM) {
+	d := v.deterministic
+	next, err := d.start(v.ctx)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	gvm.task, gvm.next = next, next
+	d.mu.Unlock()
+}
+
+// restart prepares the next call of the function of gvm, a routine of vm,
+// after it returned err, and reports whether the routine restarts.
+func (gvm *routineVM) restart(vm *VM, err error) bool {
+	if gvm.VM != nil {
+		// failures of the routines it started are reported by them
+		err = gvm.VM.err
+	}
+	next := gvm.next
+	gvm.next = nil
+	if !gvm.canRestart(err) || atomic.LoadInt64(&vm.aborting) != 0 ||
+		(gvm.VM != nil && gvm.task != nil && next == nil) {
+		if next != nil {
+			next.exit()
+		}
+		return false
+	}
+	atomic.AddInt64(&gvm.restarts, 1)
+	if gvm.VM != nil {
+		cvm := gvm.newVM(vm)
+		if err := vm.addChild(cvm); err != nil {
+			return false
+		}
+		old := gvm.VM
+		vm.pause.replaceVM(gvm, cvm)
+		vm.delChild(old)
+	}
+	if next != nil {
+		return gvm.await(vm.ctx) == nil
+	}
+	return true
+}
+
+// report handles the failure of the routine, started by vm, according to
+// its policy.
+func (gvm *routineVM) report(vm *VM, err error) {
+	switch gvm.policy {
+	case failIgnore:
+	case failParent:
+		vm.addError(err)
+		vm.Abort()
+	default:
+		vm.addError(err)
+	}
+}
+
+// info describes the routine. fileSet holds the source of the run.
+func (gvm *routineVM) info(fileSet *parser.SourceFileSet) RoutineInfo {
+	state := routineRunning
+	select {
+	case <-gvm.exited:
+		state = routineDone
+	default:
+		if atomic.LoadInt32(&gvm.calls) > 0 {
+			state = routineWaiting
+		}
+	}
+	return RoutineInfo{
+		ID:       gvm.id,
+		Name:     gvm.name,
+		Parent:   gvm.parent,
+		State:    state,
+		Pos:      fileSet.Position(parser.Pos(atomic.LoadInt64(&gvm.site))),
+		Restarts: int(atomic.LoadInt64(&gvm.restarts)),
+	}
+}
+
+// Routines returns the routines of the run of v that have not finished,
+// ordered by ID. It is safe to call from any goroutine, for example to
+// monitor a run.
+func (v *VM) Routines() []RoutineInfo {
+	p := v.pause
+	p.Lock()
+	routines := make([]*routineVM, 0, len(p.routines))
+	for gvm := range p.routines {
+		routines = append(routines, gvm)
+	}
+	p.Unlock()
+	infos := make([]RoutineInfo, len(routines))
+	for i, gvm := range routines {
+		infos[i] = gvm.info(v.fileSet)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// object returns the map of the routine info as seen by scripts.
+func (info RoutineInfo) object() Object {
+	return &Map{Value: map[string]Object{
+		"id":       &Int{Value: info.ID},
+		"name":     &String{Value: info.Name},
+		"parent":   &Int{Value: info.Parent},
+		"state":    &String{Value: info.State},
+		"position": &String{Value: info.Pos.String()},
+		"restarts": &Int{Value: int64(info.Restarts)},
+	}}
+}
+
+// Returns the routines of the run that have not finished, as maps with the
+// id, name, parent, state, position and restarts of each routine.
+func builtinRoutines(ctx context.Context, args ...Object) (Object, error) {
+	vm := ctx.Value(ContextKey("vm")).(*VM)
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	var arr []Object
+	for _, info := range vm.Routines() {
+		arr = append(arr, info.object())
+	}
+	return &Array{Value: arr}, nil
+}
+
+// routinesArg returns the routine objects in the array arg and their
+// routines.
+func routinesArg(arg Object) ([]Object, []*routineVM, error) {
+	var elems []Object
+	switch arg := arg.(type) {
+	case *Array:
+		elems = arg.Value
+	case *ImmutableArray:
+		elems = arg.Value
+	default:
+		return nil, nil, ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "array",
+			Found:    arg.TypeName(),
+		}
+	}
+	routines := make([]*routineVM, len(elems))
+	for i, elem := range elems {
+		if m, ok := elem.(*Map); ok {
+			if fn, ok := m.Value["wait"].(*BuiltinFunction); ok && fn.method != nil {
+				routines[i], _ = fn.method.recv.(*routineVM)
+			}
+		}
+		if routines[i] == nil {
+			return nil, nil, ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "array of routines",
+				Found:    elem.TypeName(),
+			}
+		}
+	}
+	return elems, routines, nil
+}
+
+// waitRoutines waits up to timeout seconds, or forever if timeout is
+// negative, until all routines have finished, or any if all is false. It
+// returns the index of a finished routine, or -1 on timeout.
+func waitRoutines(ctx context.Context, routines []*routineVM, all bool, timeout int64) (int, error) {
+	finished := func() int {
+		idx := -1
+		for i, gvm := range routines {
+			select {
+			case <-gvm.exited:
+				if !all {
+					return i
+				}
+				idx = i
+			default:
+				if all {
+					return -1
 				}
-				err = fmt.Errorf("\nRuntime Panic: %v%s\n%s", perr, vm.callStack(callers), debug.Stack())
 			}
-			if err != nil {
-				vm.addError(err)
+		}
+		return idx
+	}
+	if i := finished(); i >= 0 || (all && len(routines) == 0) {
+		return max(i, 0), nil
+	}
+
+	if d := DeterministicOf(ctx); d != nil {
+		// wait on the virtual clock, the routines exit their tasks right
+		// before they finish
+		var deadline time.Time
+		if timeout >= 0 {
+			deadline = d.Now().Add(time.Duration(timeout) * time.Second)
+		}
+		ready := func() bool {
+			for _, gvm := range routines {
+				if gvm.task.done != all {
+					return !all
+				}
 			}
-			gvm.waitChan <- ret{val, err}
-			vm.delChild(gvm.VM)
-			gvm.VM = nil
-		}()
+			return all
+		}
+		timedOut, err := d.taskOf(ctx).block(ctx, ready, deadline)
+		if err != nil || timedOut {
+			return -1, err
+		}
+		timeout = -1
+	}
 
-		if cfn != nil {
-			val, err = gvm.RunCompiled(cfn, args[1:]...)
-		} else {
-			val, err = fn.Call(ctx, args[1:]...)
+	if timeout < 0 {
+		timeout = 3153600000 // 100 years
+	}
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)},
+	}
+	for _, gvm := range routines {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(gvm.exited),
+		})
+	}
+	for {
+		chosen, _, _ := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return -1, ErrVMAborted
+		case 1:
+			return -1, nil
 		}
-	}()
+		if i := finished(); i >= 0 {
+			return i, nil
+		}
+		// wait for the others
+		cases[chosen].Chan = reflect.ValueOf((chan struct{})(nil))
+	}
+}
+
+// timeoutArg returns the optional timeout in seconds in args, or -1.
+func timeoutArg(args []Object) (int64, error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	t, ok := ToInt64(args[0])
+	if !ok {
+		return 0, ErrInvalidArgumentType{
+			Name:     "second",
+			Expected: "int(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	return t, nil
+}
 
-	obj := map[string]Object{
-		"result": &BuiltinFunction{Value: gvm.getRet},
-		"wait":   &BuiltinFunction{Value: gvm.waitTimeout},
-		"abort":  &BuiltinFunction{Value: gvm.abort},
+// Waits for all routines in the array given as first argument to complete
+// up to timeout seconds. Returns true if they did within the timeout. Waits
+// forever if the optional timeout is not specified, or timeout < 0.
+func builtinWaitAll(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, ErrWrongNumArguments
+	}
+	_, routines, err := routinesArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := timeoutArg(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	i, err := waitRoutines(ctx, routines, true, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return FalseValue, nil
+	}
+	return TrueValue, nil
+}
+
+// Waits for any of the routines in the array given as first argument to
+// complete up to timeout seconds, and returns the routine that did, or
+// undefined if none did within the timeout. Waits forever if the optional
+// timeout is not specified, or timeout < 0.
+func builtinWaitAny(ctx context.Context, args ...Object) (Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, ErrWrongNumArguments
+	}
+	elems, routines, err := routinesArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(routines) == 0 {
+		return nil, errors.New("wait_any of no routines")
+	}
+	timeout, err := timeoutArg(args[1:])
+	if err != nil {
+		return nil, err
 	}
-	return &Map{Value: obj}, nil
+	i, err := waitRoutines(ctx, routines, false, timeout)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+	return elems[i], nil
+}
+
+// object returns the routine object of gvm, which has the id and name of
+// the routine and the result, wait, abort and info methods.
+func (gvm *routineVM) object() Object {
+	return &Map{Value: map[string]Object{
+		"id":     &Int{Value: gvm.id},
+		"name":   &String{Value: gvm.name},
+		"result": gvm.method("result"),
+		"wait":   gvm.method("wait"),
+		"abort":  gvm.method("abort"),
+		"info":   gvm.method("info"),
+	}}
+}
+
+// method returns the method of gvm called name.
+func (gvm *routineVM) method(name string) *BuiltinFunction {
+	fn := &BuiltinFunction{method: &boundMethod{recv: gvm, name: name}}
+	switch name {
+	case "result":
+		fn.Value, fn.Restartable = gvm.getRet, true
+	case "wait":
+		fn.Value, fn.Restartable = gvm.waitTimeout, true
+	case "abort":
+		fn.Value = gvm.abort
+	case "info":
+		fn.Value = gvm.getInfo
+	default:
+		return nil
+	}
+	return fn
+}
+
+// Returns the info map of the routineVM, as returned by routines().
+func (gvm *routineVM) getInfo(ctx context.Context, args ...Object) (Object, error) {
+	vm := ctx.Value(ContextKey("vm")).(*VM)
+	if len(args) != 0 {
+		return nil, ErrWrongNumArguments
+	}
+	return gvm.info(vm.fileSet).object(), nil
 }
 
 // Triggers the termination process of the current VM and all its descendant VMs.
@@ -117,9 +679,25 @@ func builtinAbort(ctx context.Context, args ...Object) (Object, error) {
 }
 
 // Returns true if the routineVM is done
-func (gvm *routineVM) wait(seconds int64) bool {
+func (gvm *routineVM) wait(ctx context.Context, seconds int64) (bool, error) {
 	if atomic.LoadInt64(&gvm.done) == 1 {
-		return true
+		return true, nil
+	}
+
+	if t := gvm.task; t != nil {
+		// wait on the virtual clock, the result follows right after exit
+		var deadline time.Time
+		if seconds >= 0 {
+			deadline = t.d.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		timedOut, err := t.d.taskOf(ctx).block(ctx, func() bool { return t.done }, deadline)
+		if err != nil {
+			return false, err
+		}
+		if timedOut {
+			return false, nil
+		}
+		seconds = -1
 	}
 
 	if seconds < 0 {
@@ -130,10 +708,12 @@ func (gvm *routineVM) wait(seconds int64) bool {
 	case gvm.ret = <-gvm.waitChan:
 		atomic.StoreInt64(&gvm.done, 1)
 	case <-time.After(time.Duration(seconds) * time.Second):
-		return false
+		return false, nil
+	case <-ctx.Done():
+		return false, ErrVMAborted
 	}
 
-	return true
+	return true, nil
 }
 
 // Waits for the routineVM to complete up to timeout seconds.
@@ -156,7 +736,11 @@ func (gvm *routineVM) waitTimeout(ctx context.Context, args ...Object) (Object,
 		timeOut = t
 	}
 
-	if gvm.wait(int64(timeOut)) {
+	done, err := gvm.wait(ctx, int64(timeOut))
+	if err != nil {
+		return nil, err
+	}
+	if done {
 		return TrueValue, nil
 	}
 	return FalseValue, nil
@@ -180,15 +764,21 @@ func (gvm *routineVM) getRet(ctx context.Context, args ...Object) (Object, error
 		return nil, ErrWrongNumArguments
 	}
 
-	gvm.wait(-1)
+	if _, err := gvm.wait(ctx, -1); err != nil {
+		return nil, err
+	}
 	if gvm.ret.err != nil {
-		return &Error{Value: &String{Value: gvm.ret.err.Error()}}, nil
+		return NewError(gvm.ret.err), nil
 	}
 
 	return gvm.ret.val, nil
 }
 
-type objchan chan Object
+// objChan is the channel of a chan object.
+type objChan struct {
+	ch     chan Object
+	closed int32 // set once the channel is closed
+}
 
 // Makes a channel to send/receive object
 // Returns a chan object that has send, recv, close methods.
@@ -210,39 +800,69 @@ func builtinChan(ctx context.Context, args ...Object) (Object, error) {
 		return nil, ErrWrongNumArguments
 	}
 
-	oc := make(objchan, size)
-	obj := map[string]Object{
-		"send":  &BuiltinFunction{Value: oc.send},
-		"recv":  &BuiltinFunction{Value: oc.recv},
-		"close": &BuiltinFunction{Value: oc.close},
+	if d := DeterministicOf(ctx); d != nil {
+		dc := d.newChan(size)
+		return &Map{Value: map[string]Object{
+			"send":  &BuiltinFunction{Value: dc.send},
+			"recv":  &BuiltinFunction{Value: dc.recv},
+			"close": &BuiltinFunction{Value: dc.close},
+		}}, nil
+	}
+
+	oc := &objChan{ch: make(chan Object, size)}
+	return oc.object(), nil
+}
+
+// object returns the chan object of oc, which has the send, recv and close
+// methods.
+func (oc *objChan) object() Object {
+	return &Map{Value: map[string]Object{
+		"send":  oc.method("send"),
+		"recv":  oc.method("recv"),
+		"close": oc.method("close"),
+	}}
+}
+
+// method returns the method of oc called name.
+func (oc *objChan) method(name string) *BuiltinFunction {
+	fn := &BuiltinFunction{method: &boundMethod{recv: oc, name: name}}
+	switch name {
+	case "send":
+		fn.Value, fn.Restartable = oc.send, true
+	case "recv":
+		fn.Value, fn.Restartable = oc.recv, true
+	case "close":
+		fn.Value = oc.close
+	default:
+		return nil
 	}
-	return &Map{Value: obj}, nil
+	return fn
 }
 
 // Sends an obj to the channel, will block if channel is full and the VM has not been aborted.
 // Sends to a closed channel causes panic.
-func (oc objchan) send(ctx context.Context, args ...Object) (Object, error) {
+func (oc *objChan) send(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 1 {
 		return nil, ErrWrongNumArguments
 	}
 	select {
 	case <-ctx.Done():
 		return nil, ErrVMAborted
-	case oc <- args[0]:
+	case oc.ch <- args[0]:
 	}
 	return nil, nil
 }
 
 // Receives an obj from the channel, will block if channel is empty and the VM has not been aborted.
 // Receives from a closed channel returns undefined value.
-func (oc objchan) recv(ctx context.Context, args ...Object) (Object, error) {
+func (oc *objChan) recv(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 0 {
 		return nil, ErrWrongNumArguments
 	}
 	select {
 	case <-ctx.Done():
 		return nil, ErrVMAborted
-	case obj, ok := <-oc:
+	case obj, ok := <-oc.ch:
 		if ok {
 			return obj, nil
 		}
@@ -251,10 +871,11 @@ func (oc objchan) recv(ctx context.Context, args ...Object) (Object, error) {
 }
 
 // Closes the channel.
-func (oc objchan) close(ctx context.Context, args ...Object) (Object, error) {
+func (oc *objChan) close(ctx context.Context, args ...Object) (Object, error) {
 	if len(args) != 0 {
 		return nil, ErrWrongNumArguments
 	}
-	close(oc)
+	atomic.StoreInt32(&oc.closed, 1)
+	close(oc.ch)
 	return nil, nil
 }
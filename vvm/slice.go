@@ -0,0 +1,105 @@
+package vvm
+
+import (
+	"fmt"
+)
+
+// normalizeIndex converts a negative index, counting backwards from the end
+// of a sequence of the given length, into an absolute index. Non-negative
+// indices are returned unchanged.
+func normalizeIndex(idx, length int) int {
+	if idx < 0 {
+		return idx + length
+	}
+	return idx
+}
+
+// sliceIndices resolves the low, high and step components of a slice
+// expression against a sequence of the given length. Missing components are
+// passed as UndefinedValue. It returns the index of the first element, the
+// number of selected elements and the distance between two of them.
+func sliceIndices(
+	length int,
+	low, high, step Object,
+) (start, count, stride int, err error) {
+	stride = 1
+	if step != UndefinedValue {
+		s, ok := step.(*Int)
+		if !ok {
+			err = fmt.Errorf("invalid slice step type: %s", step.TypeName())
+			return
+		}
+		if s.Value == 0 {
+			err = fmt.Errorf("invalid slice step: 0")
+			return
+		}
+		stride = int(s.Value)
+	}
+
+	var lowIdx, highIdx int
+	if stride > 0 {
+		lowIdx, highIdx = 0, length
+	} else {
+		lowIdx, highIdx = length-1, -1
+	}
+	if low != UndefinedValue {
+		l, ok := low.(*Int)
+		if !ok {
+			err = fmt.Errorf("invalid slice index type: %s", low.TypeName())
+			return
+		}
+		lowIdx = normalizeIndex(int(l.Value), length)
+	}
+	if high != UndefinedValue {
+		h, ok := high.(*Int)
+		if !ok {
+			err = fmt.Errorf("invalid slice index type: %s", high.TypeName())
+			return
+		}
+		highIdx = normalizeIndex(int(h.Value), length)
+	}
+
+	if stride > 0 {
+		if lowIdx > highIdx {
+			err = fmt.Errorf("invalid slice index: %d > %d", lowIdx, highIdx)
+			return
+		}
+		lowIdx = clampIndex(lowIdx, 0, length)
+		highIdx = clampIndex(highIdx, 0, length)
+		start = lowIdx
+		count = (highIdx - lowIdx + stride - 1) / stride
+		return
+	}
+
+	if lowIdx < highIdx {
+		err = fmt.Errorf("invalid slice index: %d < %d", lowIdx, highIdx)
+		return
+	}
+	lowIdx = clampIndex(lowIdx, -1, length-1)
+	highIdx = clampIndex(highIdx, -1, length-1)
+	start = lowIdx
+	count = (lowIdx - highIdx - stride - 1) / -stride
+	return
+}
+
+func clampIndex(idx, lo, hi int) int {
+	if idx < lo {
+		return lo
+	} else if idx > hi {
+		return hi
+	}
+	return idx
+}
+
+// sliceOf returns the elements of s selected by the result of sliceIndices.
+// A contiguous selection shares the underlying array with s.
+func sliceOf[T any](s []T, start, count, stride int) []T {
+	if stride == 1 {
+		return s[start : start+count]
+	}
+	res := make([]T, count)
+	for i := range res {
+		res[i] = s[start+i*stride]
+	}
+	return res
+}
@@ -0,0 +1,1460 @@
+package vvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/malivvan/vv/vvm/encoding"
+	"github.com/malivvan/vv/vvm/parser"
+)
+
+const (
+	snapshotMagic   = "VVMS"
+	snapshotVersion = 2
+)
+
+// type codes of the objects only found in snapshots
+const (
+	_range         byte = 19
+	_rangeIterator byte = 105
+	_generator     byte = 106
+	_routineMethod byte = 107
+	_chanMethod    byte = 108
+)
+
+// errSnapshot is the cause of the calls interrupted by a snapshot.
+var errSnapshot = errors.New("snapshot")
+
+// pauseCtl stops the VMs of a run at safe points while a snapshot is taken.
+// It is shared by a root VM and all its clones.
+type pauseCtl struct {
+	sync.Mutex
+	cond     sync.Cond
+	req      int32 // set while a snapshot is taken
+	root     *VM
+	active   bool                    // the root VM is running
+	running  map[*VM]struct{}        // VMs running their instructions
+	parked   map[*VM]struct{}        // VMs stopped for the snapshot
+	routines map[*routineVM]struct{} // routines that have not finished
+	lastID   int64                   // ID of the last routine started, accessed atomically
+}
+
+func newPauseCtl(root *VM) *pauseCtl {
+	p := &pauseCtl{
+		root:     root,
+		running:  make(map[*VM]struct{}),
+		parked:   make(map[*VM]struct{}),
+		routines: make(map[*routineVM]struct{}),
+	}
+	p.cond.L = &p.Mutex
+	return p
+}
+
+// enter registers v as running its instructions.
+func (p *pauseCtl) enter(v *VM) {
+	p.Lock()
+	defer p.Unlock()
+	p.running[v] = struct{}{}
+	if v == p.root {
+		p.active = true
+	}
+	if atomic.LoadInt32(&p.req) != 0 {
+		v.interrupt(errSnapshot)
+	}
+}
+
+// leave registers that v stopped running its instructions.
+func (p *pauseCtl) leave(v *VM) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.running, v)
+	p.cond.Broadcast()
+}
+
+// exit registers that the run of v and its routines ended.
+func (p *pauseCtl) exit(v *VM) {
+	if v != p.root {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.active = false
+	p.cond.Broadcast()
+}
+
+func (p *pauseCtl) addRoutine(gvm *routineVM) {
+	p.Lock()
+	defer p.Unlock()
+	p.routines[gvm] = struct{}{}
+}
+
+// replaceVM replaces the VM of gvm, which restarts, with cvm.
+func (p *pauseCtl) replaceVM(gvm *routineVM, cvm *VM) {
+	p.Lock()
+	defer p.Unlock()
+	gvm.VM = cvm
+}
+
+func (p *pauseCtl) delRoutine(gvm *routineVM) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.routines, gvm)
+	p.cond.Broadcast()
+}
+
+// stopped reports whether all VMs of the run are stopped for a snapshot.
+func (p *pauseCtl) stopped() (bool, error) {
+	if atomic.LoadInt64(&p.root.aborting) != 0 || p.root.err != nil {
+		return false, ErrNotRunning
+	}
+	for v := range p.parked {
+		if v.invoked {
+			return false, ErrNotSnapshotable{
+				Type: "callback",
+				Path: "a function called from Go",
+			}
+		}
+	}
+	if _, ok := p.parked[p.root]; !ok {
+		// the main function either runs or has returned
+		if _, ok := p.running[p.root]; ok {
+			return false, nil
+		}
+	}
+	for gvm := range p.routines {
+		if gvm.VM == nil {
+			return false, ErrNotSnapshotable{
+				Type: gvm.fn.TypeName(),
+				Path: "routine",
+			}
+		}
+		if _, ok := p.parked[gvm.VM]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// park stops v until the snapshot in progress is taken.
+func (v *VM) park() {
+	p := v.pause
+	p.Lock()
+	defer p.Unlock()
+	p.parked[v] = struct{}{}
+	p.cond.Broadcast()
+	for atomic.LoadInt32(&p.req) != 0 {
+		p.cond.Wait()
+	}
+	delete(p.parked, v)
+	if v.callCtx.Err() != nil {
+		v.callCtx, v.interrupt = context.WithCancelCause(v.ctx)
+	}
+}
+
+// interrupted reports whether the call v made last was interrupted by a
+// snapshot.
+func (v *VM) interrupted() bool {
+	return context.Cause(v.callCtx) == errSnapshot &&
+		atomic.LoadInt64(&v.aborting) == 0
+}
+
+// Snapshot saves the execution state of the running VM and its routines:
+// the call frames, stacks and globals, the objects they refer to, and the
+// routines and channels of the run. Restore continues the execution from
+// the snapshot.
+//
+// The VMs are stopped before their next instruction, and Snapshot waits for
+// the calls of Go functions in progress to return. Only the calls of
+// restartable builtin functions, such as receiving from a channel or
+// sleeping, are interrupted and made again from the start once the
+// snapshot is taken, and when it is restored. Snapshot waits until all VMs
+// have stopped, so it must not be called by the VM itself.
+//
+// Objects implemented in Go cannot be saved, except the values, routines
+// and channels of this package and the builtin functions and modules the
+// program refers to. Snapshot returns an ErrNotSnapshotable for a value
+// such as an open file, a routine running a Go function, or a callback
+// running on behalf of a Go function.
+func (v *VM) Snapshot() ([]byte, error) {
+	if v.deterministic != nil {
+		return nil, errors.New("snapshots of deterministic runs are not supported")
+	}
+	p := v.pause
+	p.Lock()
+	defer p.Unlock()
+	for atomic.LoadInt32(&p.req) != 0 {
+		p.cond.Wait() // another snapshot is taken
+	}
+	if !p.active {
+		return nil, ErrNotRunning
+	}
+
+	atomic.StoreInt32(&p.req, 1)
+	defer func() {
+		atomic.StoreInt32(&p.req, 0)
+		p.cond.Broadcast()
+	}()
+	for cvm := range p.running {
+		cvm.interrupt(errSnapshot)
+	}
+	for {
+		ok, err := p.stopped()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		p.cond.Wait()
+	}
+	return newSnapshotEncoder(p.root).encode()
+}
+
+// Restore creates a VM that continues the execution saved by
+// VM.Snapshot. bytecode must be the bytecode the snapshot was taken from,
+// compiled with the same modules. Run continues the execution, including
+// the routines that were running.
+func Restore(bytecode *Bytecode, snapshot []byte) (*VM, error) {
+	v := NewVM(context.Background(), bytecode, nil, -1)
+	d := &snapshotDecoder{
+		snapshotReader: snapshotReader{b: snapshot},
+		root:           v,
+		known:          knownObjects(bytecode.Constants, bytecode.MainFunction),
+		unhashed:       make(map[Object][]MapEntry),
+		keySizes:       make(map[*ImmutableArray]int),
+	}
+	if err := d.decode(bytecode); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return v, nil
+}
+
+// knownObjects returns the objects a snapshot refers to by index instead of
+// saving them: the objects of the package, the main function and the
+// constants of the bytecode, including the members of the builtin modules
+// it imports.
+func knownObjects(constants []Object, main *CompiledFunction) []Object {
+	known := []Object{
+		UndefinedValue, TrueValue, FalseValue,
+		emptyEntry, funcWrapper, generatorNext,
+		hookCall, hookIter, hookEqual, hookNotEqual,
+	}
+	for _, fn := range builtinFuncs {
+		known = append(known, fn)
+	}
+	known = append(known, main)
+
+	var walk func(o Object)
+	walk = func(o Object) {
+		known = append(known, o)
+		switch o := o.(type) {
+		case *ImmutableArray:
+			for _, x := range o.Value {
+				walk(x)
+			}
+		case *ImmutableMap:
+			for _, k := range sortedKeys(o.Value) {
+				walk(o.Value[k])
+			}
+		}
+	}
+	for _, c := range constants {
+		walk(c)
+	}
+	return known
+}
+
+// snapshotHash identifies the bytecode a snapshot was taken from.
+func snapshotHash(constants []Object, main *CompiledFunction) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(main.Instructions)
+	for _, c := range constants {
+		_, _ = h.Write([]byte(c.TypeName()))
+		if fn, ok := c.(*CompiledFunction); ok {
+			_, _ = h.Write(fn.Instructions)
+		}
+	}
+	for _, fn := range builtinFuncs {
+		_, _ = h.Write([]byte(fn.Name))
+	}
+	return h.Sum64()
+}
+
+func sortedKeys(m map[string]Object) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// snapshotEncoder saves the state of a stopped run. It first collects the
+// objects reachable from the VMs, assigning them IDs, and then writes them
+// followed by the state of the VMs, which refers to objects by ID.
+//
+// An ID is 0 for nil, negative for a known object and positive for an
+// object of the snapshot. The objects are written in two passes, first their
+// types and the values without references, then the contents of the
+// containers, so that cycles can be restored.
+type snapshotEncoder struct {
+	snapshotWriter
+	root       *VM
+	main       *CompiledFunction
+	known      map[Object]int
+	bases      map[*byte]*CompiledFunction // known functions by instructions
+	ids        map[Object]int
+	objects    []Object
+	routines   []*routineVM
+	routineIDs map[*routineVM]int
+	chans      []*objChan
+	chanIDs    map[*objChan]int
+	chanItems  [][]Object
+}
+
+func newSnapshotEncoder(root *VM) *snapshotEncoder {
+	e := &snapshotEncoder{
+		root:       root,
+		main:       root.frames[0].fn,
+		known:      make(map[Object]int),
+		bases:      make(map[*byte]*CompiledFunction),
+		ids:        make(map[Object]int),
+		routineIDs: make(map[*routineVM]int),
+		chanIDs:    make(map[*objChan]int),
+	}
+	for i, o := range knownObjects(root.constants, e.main) {
+		if _, ok := e.known[o]; ok {
+			continue
+		}
+		e.known[o] = i
+		if fn, ok := o.(*CompiledFunction); ok && len(fn.Instructions) > 0 {
+			e.bases[&fn.Instructions[0]] = fn
+		}
+	}
+	return e
+}
+
+func (e *snapshotEncoder) encode() ([]byte, error) {
+	for i, g := range e.root.globals {
+		if err := e.ref(g, "globals["+strconv.Itoa(i)+"]"); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.scanVM(e.root, ""); err != nil {
+		return nil, err
+	}
+	for gvm := range e.root.pause.routines {
+		e.routine(gvm)
+	}
+	for i := 0; i < len(e.routines); i++ { // scanning may add routines
+		if err := e.scanRoutine(i); err != nil {
+			return nil, err
+		}
+	}
+
+	e.b = append(e.b, snapshotMagic...)
+	e.writeByte(snapshotVersion)
+	e.writeUint64(snapshotHash(e.root.constants, e.main))
+	e.writeInt(len(e.routines))
+	e.writeInt(len(e.chans))
+	e.writeInt(len(e.objects))
+	for _, o := range e.objects {
+		e.writeHead(o)
+	}
+	for _, o := range e.objects {
+		e.writeBody(o)
+	}
+
+	last := len(e.root.globals)
+	for last > 0 && e.root.globals[last-1] == nil {
+		last--
+	}
+	e.writeRefs(e.root.globals[:last])
+	e.writeVM(e.root)
+	for _, gvm := range e.routines {
+		e.writeRoutine(gvm)
+	}
+	for i, oc := range e.chans {
+		e.writeInt(cap(oc.ch))
+		e.writeBool(atomic.LoadInt32(&oc.closed) != 0)
+		e.writeRefs(e.chanItems[i])
+	}
+	e.writeInt(len(e.root.childCtl.errors))
+	for _, err := range e.root.childCtl.errors {
+		e.writeString(err.Error())
+	}
+	return e.b, nil
+}
+
+// ref collects o and the objects it refers to. path describes where o was
+// found for errors.
+func (e *snapshotEncoder) ref(o Object, path string) error {
+	if o == nil {
+		return nil
+	}
+	if _, ok := e.known[o]; ok {
+		return nil
+	}
+	switch o.(type) {
+	case *Undefined, *Bool:
+		return nil // saved as the known values
+	}
+	if _, ok := e.ids[o]; ok {
+		return nil
+	}
+
+	switch o := o.(type) {
+	case *Int, *Float, *String, *Char, *Bytes, *Time, *BigInt, *Decimal,
+		*Range, *BytesIterator, *StringIterator:
+		e.add(o)
+	case *Array:
+		e.add(o)
+		return e.refs(o.Value, path)
+	case *ImmutableArray:
+		e.add(o)
+		return e.refs(o.Value, path)
+	case *Map:
+		e.add(o)
+		return e.refMap(o.Value, o.Hashed, path)
+	case *ImmutableMap:
+		e.add(o)
+		return e.refMap(o.Value, o.Hashed, path)
+	case *Set:
+		e.add(o)
+		return e.refs(setElements(o), path)
+	case *ObjectPtr:
+		e.add(o)
+		if o.Value != nil {
+			return e.ref(*o.Value, path)
+		}
+	case *CompiledFunction:
+		e.add(o)
+		for i, free := range o.Free {
+			if err := e.ref(free, path+".free["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case *Error:
+		e.add(o)
+		if err := e.ref(o.Value, path+".value"); err != nil {
+			return err
+		}
+		if o.Cause != nil {
+			return e.ref(o.Cause, path+".cause")
+		}
+	case *BuiltinFunction:
+		if o.method == nil {
+			return ErrNotSnapshotable{Type: o.TypeName(), Path: path}
+		}
+		e.add(o)
+		switch recv := o.method.recv.(type) {
+		case *routineVM:
+			e.routine(recv)
+		case *objChan:
+			return e.channel(recv, path)
+		}
+	case *ArrayIterator:
+		e.add(o)
+		return e.refs(o.v, path)
+	case *MapIterator:
+		e.add(o)
+		for _, k := range o.k {
+			if err := e.ref(o.v[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+		for _, en := range o.h {
+			if err := e.ref(en.Key, path); err != nil {
+				return err
+			}
+			if err := e.ref(en.Value, path); err != nil {
+				return err
+			}
+		}
+	case *RangeIterator:
+		e.add(o)
+		return e.ref(o.r, path)
+	case *Generator:
+		if o.running {
+			return ErrNotSnapshotable{Type: "running generator", Path: path}
+		}
+		e.add(o)
+		if err := e.ref(o.fn, path); err != nil {
+			return err
+		}
+		for _, free := range o.freeVars {
+			if err := e.ref(free, path); err != nil {
+				return err
+			}
+		}
+		if err := e.refs(o.stack, path); err != nil {
+			return err
+		}
+		return e.ref(o.value, path)
+	default:
+		return ErrNotSnapshotable{Type: o.TypeName(), Path: path}
+	}
+	return nil
+}
+
+func (e *snapshotEncoder) add(o Object) {
+	e.objects = append(e.objects, o)
+	e.ids[o] = len(e.objects)
+}
+
+func (e *snapshotEncoder) refs(objs []Object, path string) error {
+	for i, o := range objs {
+		if err := e.ref(o, path+"["+strconv.Itoa(i)+"]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *snapshotEncoder) refMap(
+	m map[string]Object,
+	hashed map[HashKey]MapEntry,
+	path string,
+) error {
+	for _, k := range sortedKeys(m) {
+		if err := e.ref(m[k], path+"."+k); err != nil {
+			return err
+		}
+	}
+	for _, en := range hashed {
+		if err := e.ref(en.Key, path); err != nil {
+			return err
+		}
+		if err := e.ref(en.Value, path+"["+en.Key.String()+"]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routine collects gvm, whose state is scanned by scanRoutine.
+func (e *snapshotEncoder) routine(gvm *routineVM) int {
+	if id, ok := e.routineIDs[gvm]; ok {
+		return id
+	}
+	e.routines = append(e.routines, gvm)
+	e.routineIDs[gvm] = len(e.routines) - 1
+	return len(e.routines) - 1
+}
+
+func (e *snapshotEncoder) scanRoutine(i int) error {
+	gvm := e.routines[i]
+	path := "routine " + strconv.Itoa(i)
+	if err := e.ref(gvm.fn, path); err != nil {
+		return err
+	}
+	for j, arg := range gvm.args {
+		if err := e.ref(arg, path+" argument "+strconv.Itoa(j)); err != nil {
+			return err
+		}
+	}
+	if e.finished(gvm) {
+		return e.ref(gvm.ret.val, path+" result")
+	}
+	return e.scanVM(gvm.VM, path+" ")
+}
+
+// finished reports whether gvm has finished, and receives its result if it
+// has not been received yet.
+func (e *snapshotEncoder) finished(gvm *routineVM) bool {
+	if atomic.LoadInt64(&gvm.done) == 1 {
+		return true
+	}
+	select {
+	case gvm.ret = <-gvm.waitChan:
+		atomic.StoreInt64(&gvm.done, 1)
+		return true
+	default:
+		return false
+	}
+}
+
+// channel collects oc and the objects buffered in it. The objects are
+// received and sent again, which does not race with the VMs since they are
+// stopped.
+func (e *snapshotEncoder) channel(oc *objChan, path string) error {
+	if _, ok := e.chanIDs[oc]; ok {
+		return nil
+	}
+	items := make([]Object, len(oc.ch))
+	for i := range items {
+		items[i] = <-oc.ch
+	}
+	if atomic.LoadInt32(&oc.closed) != 0 {
+		oc.ch = make(chan Object, cap(oc.ch))
+	}
+	for _, item := range items {
+		oc.ch <- item
+	}
+	if atomic.LoadInt32(&oc.closed) != 0 && len(items) != 0 {
+		close(oc.ch)
+	}
+
+	e.chans = append(e.chans, oc)
+	e.chanIDs[oc] = len(e.chans) - 1
+	e.chanItems = append(e.chanItems, items)
+	return e.refs(items, path+" buffer")
+}
+
+func (e *snapshotEncoder) scanVM(v *VM, path string) error {
+	if err := e.refs(v.stack[:v.sp], path+"stack"); err != nil {
+		return err
+	}
+	for i := 0; i < v.framesIndex; i++ {
+		f := v.frames[i]
+		if f.gen != nil {
+			return ErrNotSnapshotable{
+				Type: "running generator",
+				Path: path + "frame " + strconv.Itoa(i),
+			}
+		}
+		if err := e.ref(f.fn, path+"frame "+strconv.Itoa(i)); err != nil {
+			return err
+		}
+		for _, free := range f.freeVars {
+			if err := e.ref(free, path+"frame "+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// id returns the ID of o, which has been collected.
+func (e *snapshotEncoder) id(o Object) int {
+	if o == nil {
+		return 0
+	}
+	if i, ok := e.known[o]; ok {
+		return -i - 1
+	}
+	switch o := o.(type) {
+	case *Undefined:
+		return e.id(UndefinedValue)
+	case *Bool:
+		if o.IsFalsy() {
+			return e.id(FalseValue)
+		}
+		return e.id(TrueValue)
+	}
+	return e.ids[o]
+}
+
+func (e *snapshotEncoder) writeRef(o Object) {
+	e.writeInt(e.id(o))
+}
+
+func (e *snapshotEncoder) writeRefs(objs []Object) {
+	e.writeInt(len(objs))
+	for _, o := range objs {
+		e.writeRef(o)
+	}
+}
+
+// writeHead writes the type of o, or all of it if it holds no references.
+func (e *snapshotEncoder) writeHead(o Object) {
+	switch o := o.(type) {
+	case *Range:
+		e.writeByte(_range)
+		e.writeInt64(o.Start)
+		e.writeInt64(o.Stop)
+		e.writeInt64(o.Step)
+	case *BytesIterator:
+		e.writeByte(_bytesIterator)
+		e.writeBytes(o.v)
+		e.writeInt(o.i)
+		e.writeInt(o.l)
+	case *StringIterator:
+		e.writeByte(_stringIterator)
+		e.writeInt(len(o.v))
+		for _, r := range o.v {
+			e.writeInt(int(r))
+		}
+		e.writeInt(o.i)
+		e.writeInt(o.l)
+	case *ArrayIterator:
+		e.writeByte(_arrayIterator)
+	case *MapIterator:
+		e.writeByte(_mapIterator)
+	case *RangeIterator:
+		e.writeByte(_rangeIterator)
+	case *Generator:
+		e.writeByte(_generator)
+	case *BuiltinFunction:
+		if gvm, ok := o.method.recv.(*routineVM); ok {
+			e.writeByte(_routineMethod)
+			e.writeInt(e.routineIDs[gvm])
+		} else {
+			e.writeByte(_chanMethod)
+			e.writeInt(e.chanIDs[o.method.recv.(*objChan)])
+		}
+		e.writeString(o.method.name)
+	case *Array, *ImmutableArray, *Map, *ImmutableMap, *Set, *ObjectPtr,
+		*CompiledFunction, *Error:
+		e.writeByte(TypeOfObject(o))
+	default:
+		e.writeObject(o)
+	}
+}
+
+// writeBody writes the references of o.
+func (e *snapshotEncoder) writeBody(o Object) {
+	switch o := o.(type) {
+	case *Array:
+		e.writeRefs(o.Value)
+	case *ImmutableArray:
+		e.writeRefs(o.Value)
+	case *Map:
+		e.writeMap(o.Value, o.Hashed)
+	case *ImmutableMap:
+		e.writeMap(o.Value, o.Hashed)
+	case *Set:
+		e.writeRefs(setElements(o))
+	case *ObjectPtr:
+		e.writeBool(o.Value != nil)
+		if o.Value != nil {
+			e.writeRef(*o.Value)
+		}
+	case *CompiledFunction:
+		// closures share the instructions of the function they are made of
+		var base *CompiledFunction
+		if len(o.Instructions) > 0 {
+			base = e.bases[&o.Instructions[0]]
+		}
+		if base != nil && len(base.Instructions) == len(o.Instructions) {
+			e.writeRef(base)
+		} else {
+			e.writeRef(nil)
+			e.writeBytes(o.Instructions)
+		}
+		e.writeInt(o.NumLocals)
+		e.writeInt(o.NumParameters)
+		e.writeBool(o.VarArgs)
+		e.writeFree(o.Free)
+	case *Error:
+		e.writeRef(o.Value)
+		e.writeString(o.Code)
+		if o.Cause != nil {
+			e.writeRef(o.Cause)
+		} else {
+			e.writeRef(nil)
+		}
+		n := e.grow(encoding.SizeSlice(o.Stack, parser.SizeFilePos))
+		encoding.MarshalSlice(n, e.b, o.Stack, parser.MarshalFilePos)
+	case *ArrayIterator:
+		e.writeRefs(o.v)
+		e.writeInt(o.i)
+		e.writeInt(o.l)
+	case *MapIterator:
+		e.writeInt(len(o.k))
+		for _, k := range o.k {
+			e.writeString(k)
+			e.writeRef(o.v[k])
+		}
+		e.writeInt(len(o.h))
+		for _, en := range o.h {
+			e.writeRef(en.Key)
+			e.writeRef(en.Value)
+		}
+		e.writeInt(o.i)
+		e.writeInt(o.l)
+	case *RangeIterator:
+		e.writeRef(o.r)
+		e.writeInt64(o.i)
+		e.writeInt64(o.l)
+	case *Generator:
+		e.writeRef(o.fn)
+		e.writeFree(o.freeVars)
+		e.writeRefs(o.stack)
+		e.writeInt(o.ip)
+		e.writeRef(o.value)
+		e.writeInt64(o.count)
+		e.writeBool(o.done)
+		if o.err != nil {
+			e.writeString(o.err.Error())
+		} else {
+			e.writeString("")
+		}
+	}
+}
+
+func (e *snapshotEncoder) writeMap(m map[string]Object, hashed map[HashKey]MapEntry) {
+	e.writeInt(len(m))
+	for _, k := range sortedKeys(m) {
+		e.writeString(k)
+		e.writeRef(m[k])
+	}
+	e.writeInt(len(hashed))
+	for _, en := range hashed {
+		e.writeRef(en.Key)
+		e.writeRef(en.Value)
+	}
+}
+
+func (e *snapshotEncoder) writeFree(free []*ObjectPtr) {
+	e.writeInt(len(free))
+	for _, p := range free {
+		e.writeRef(p)
+	}
+}
+
+func (e *snapshotEncoder) writeRoutine(gvm *routineVM) {
+	e.writeRef(gvm.fn)
+	e.writeRefs(gvm.args)
+	e.writeInt64(gvm.id)
+	e.writeString(gvm.name)
+	e.writeInt64(gvm.parent)
+	e.writeString(gvm.policy)
+	e.writeInt(gvm.maxRestarts)
+	e.writeInt64(gvm.restarts)
+	e.writeInt64(gvm.site)
+	if atomic.LoadInt64(&gvm.done) == 1 {
+		e.writeBool(true)
+		e.writeRef(gvm.ret.val)
+		if gvm.ret.err != nil {
+			e.writeString(gvm.ret.err.Error())
+		} else {
+			e.writeString("")
+		}
+		return
+	}
+	e.writeBool(false)
+	e.writeVM(gvm.VM)
+}
+
+func (e *snapshotEncoder) writeVM(v *VM) {
+	_, parked := v.pause.parked[v]
+	e.writeBool(v.result)
+	e.writeBool(!parked) // the main function has returned
+	e.writeInt(v.pendingCall)
+	e.writeInt(len(v.stack))
+	e.writeRefs(v.stack[:v.sp])
+	e.writeInt(v.framesIndex)
+	for i := 0; i < v.framesIndex; i++ {
+		f := v.frames[i]
+		e.writeRef(f.fn)
+		e.writeFree(f.freeVars)
+		e.writeInt(f.ip)
+		e.writeInt(f.basePointer)
+	}
+	e.writeInt(v.ip)
+}
+
+// snapshotDecoder restores the state saved by a snapshotEncoder.
+type snapshotDecoder struct {
+	snapshotReader
+	root     *VM
+	known    []Object
+	objects  []Object
+	routines []*routineVM
+	chans    []*objChan
+	unhashed map[Object][]MapEntry // set elements and non-string map keys
+	keySizes map[*ImmutableArray]int
+}
+
+func (d *snapshotDecoder) decode(bytecode *Bytecode) error {
+	if len(d.b) < len(snapshotMagic) || string(d.b[:len(snapshotMagic)]) != snapshotMagic {
+		return errors.New("invalid magic number")
+	}
+	d.n = len(snapshotMagic)
+	if version := d.readByte(); d.err == nil && version != snapshotVersion {
+		return fmt.Errorf("unsupported version: %d", version)
+	}
+	if hash := d.readUint64(); d.err == nil &&
+		hash != snapshotHash(bytecode.Constants, bytecode.MainFunction) {
+		return errors.New("snapshot of other bytecode")
+	}
+
+	d.routines = make([]*routineVM, d.readLen())
+	for i := range d.routines {
+		d.routines[i] = newRoutine(nil)
+	}
+	d.chans = make([]*objChan, d.readLen())
+	for i := range d.chans {
+		d.chans[i] = &objChan{}
+	}
+	d.objects = make([]Object, d.readLen())
+	for i := range d.objects {
+		d.objects[i] = d.readHead()
+	}
+	for _, o := range d.objects {
+		d.readBody(o)
+	}
+	// hash the keys once their contents are restored; they are collected
+	// after the objects they are found in
+	for i := len(d.objects) - 1; i >= 0 && d.err == nil; i-- {
+		d.hash(d.objects[i])
+	}
+
+	globals := d.readRefs()
+	if len(globals) > len(d.root.globals) {
+		d.fail(errors.New("too many globals"))
+	}
+	copy(d.root.globals, globals)
+	d.readVM(d.root)
+	for _, gvm := range d.routines {
+		d.readRoutine(gvm)
+	}
+	for _, oc := range d.chans {
+		size := d.readLen()
+		closed := d.readBool()
+		items := d.readValues()
+		if d.err != nil {
+			break
+		}
+		if len(items) > size {
+			size = len(items)
+		}
+		oc.ch = make(chan Object, size)
+		for _, item := range items {
+			oc.ch <- item
+		}
+		if closed {
+			oc.closed = 1
+			close(oc.ch)
+		}
+	}
+	errs := make([]error, d.readLen())
+	for i := range errs {
+		errs[i] = errors.New(d.readString())
+	}
+	d.root.childCtl.errors = errs
+	if d.err == nil && d.n != len(d.b) {
+		d.fail(errors.New("trailing data"))
+	}
+	return d.err
+}
+
+// obj returns the object with the given ID.
+func (d *snapshotDecoder) obj(id int) Object {
+	switch {
+	case id == 0:
+		return nil
+	case id < 0 && -id <= len(d.known):
+		return d.known[-id-1]
+	case id > 0 && id <= len(d.objects):
+		return d.objects[id-1]
+	}
+	d.fail(fmt.Errorf("invalid object reference: %d", id))
+	return nil
+}
+
+func (d *snapshotDecoder) readRef() Object {
+	return d.obj(d.readInt())
+}
+
+func (d *snapshotDecoder) readRefs() []Object {
+	objs := make([]Object, d.readLen())
+	for i := range objs {
+		objs[i] = d.readRef()
+	}
+	return objs
+}
+
+// readValue reads a reference to a value that cannot be nil, like an
+// element of an array.
+func (d *snapshotDecoder) readValue() Object {
+	o := d.readRef()
+	if o == nil && d.err == nil {
+		d.fail(errors.New("invalid nil value"))
+	}
+	return o
+}
+
+func (d *snapshotDecoder) readValues() []Object {
+	objs := make([]Object, d.readLen())
+	for i := range objs {
+		objs[i] = d.readValue()
+	}
+	return objs
+}
+
+func (d *snapshotDecoder) readFree() []*ObjectPtr {
+	free := make([]*ObjectPtr, d.readLen())
+	for i := range free {
+		p, ok := d.readRef().(*ObjectPtr)
+		if !ok {
+			d.fail(errors.New("invalid free variable"))
+		}
+		free[i] = p
+	}
+	return free
+}
+
+func (d *snapshotDecoder) readFunc() *CompiledFunction {
+	fn, ok := d.readRef().(*CompiledFunction)
+	if !ok {
+		d.fail(errors.New("invalid function"))
+	}
+	return fn
+}
+
+// readHead reads an object written by writeHead.
+func (d *snapshotDecoder) readHead() Object {
+	if d.err == nil && d.n >= len(d.b) {
+		d.fail(encoding.ErrBufTooSmall)
+	}
+	if d.err != nil {
+		return nil
+	}
+	switch code := d.b[d.n]; code {
+	case _array:
+		d.n++
+		return &Array{}
+	case _immutableArray:
+		d.n++
+		return &ImmutableArray{}
+	case _map:
+		d.n++
+		return &Map{}
+	case _immutableMap:
+		d.n++
+		return &ImmutableMap{}
+	case _set:
+		d.n++
+		return &Set{Value: make(map[HashKey]Object)}
+	case _objectPtr:
+		d.n++
+		return &ObjectPtr{}
+	case _compiledFunction:
+		d.n++
+		return &CompiledFunction{}
+	case _error:
+		d.n++
+		return &Error{}
+	case _arrayIterator:
+		d.n++
+		return &ArrayIterator{}
+	case _mapIterator:
+		d.n++
+		return &MapIterator{}
+	case _rangeIterator:
+		d.n++
+		return &RangeIterator{}
+	case _generator:
+		d.n++
+		return &Generator{}
+	case _range:
+		d.n++
+		return &Range{Start: d.readInt64(), Stop: d.readInt64(), Step: d.readInt64()}
+	case _bytesIterator:
+		d.n++
+		return &BytesIterator{v: d.readBytes(), i: d.readInt(), l: d.readInt()}
+	case _stringIterator:
+		d.n++
+		v := make([]rune, d.readLen())
+		for i := range v {
+			v[i] = rune(d.readInt())
+		}
+		return &StringIterator{v: v, i: d.readInt(), l: d.readInt()}
+	case _routineMethod, _chanMethod:
+		d.n++
+		i := d.readInt()
+		name := d.readString()
+		var fn *BuiltinFunction
+		switch {
+		case code == _routineMethod && i >= 0 && i < len(d.routines):
+			fn = d.routines[i].method(name)
+		case code == _chanMethod && i >= 0 && i < len(d.chans):
+			fn = d.chans[i].method(name)
+		}
+		if fn == nil {
+			d.fail(fmt.Errorf("invalid method: %s", name))
+			return nil
+		}
+		return fn
+	case _int, _float, _string, _char, _bytes, _time, _bigInt, _decimal:
+		var o Object
+		d.n, o, d.err = UnmarshalObject(d.n, d.b, FormatVersion)
+		if b, ok := o.(*Bytes); ok {
+			b.Value = append([]byte(nil), b.Value...) // do not share the snapshot
+		}
+		return o
+	default:
+		d.fail(fmt.Errorf("invalid type code: %d", code))
+		return nil
+	}
+}
+
+// readBody reads the references of o written by writeBody.
+func (d *snapshotDecoder) readBody(o Object) {
+	if d.err != nil {
+		return
+	}
+	switch o := o.(type) {
+	case *Array:
+		o.Value = d.readValues()
+	case *ImmutableArray:
+		o.Value = d.readValues()
+	case *Map:
+		o.Value = d.readMap()
+		d.unhashed[o] = d.readEntries()
+	case *ImmutableMap:
+		o.Value = d.readMap()
+		d.unhashed[o] = d.readEntries()
+	case *Set:
+		for _, elem := range d.readValues() {
+			d.unhashed[o] = append(d.unhashed[o], MapEntry{Key: elem})
+		}
+	case *ObjectPtr:
+		if d.readBool() {
+			v := d.readRef()
+			o.Value = &v
+		}
+	case *CompiledFunction:
+		if base, ok := d.readRef().(*CompiledFunction); ok {
+			o.Instructions = base.Instructions
+			o.SourceMap = base.SourceMap
+		} else {
+			o.Instructions = d.readBytes()
+		}
+		o.NumLocals = d.readInt()
+		o.NumParameters = d.readInt()
+		o.VarArgs = d.readBool()
+		o.Free = d.readFree()
+	case *Error:
+		o.Value = d.readRef()
+		o.Code = d.readString()
+		if cause := d.readRef(); cause != nil {
+			c, ok := cause.(*Error)
+			if !ok {
+				d.fail(errors.New("invalid error cause"))
+			}
+			o.Cause = c
+		}
+		if d.err == nil {
+			d.n, o.Stack, d.err = encoding.UnmarshalSlice[parser.SourceFilePos](
+				d.n, d.b, parser.UnmarshalFilePos)
+		}
+	case *ArrayIterator:
+		o.v = d.readValues()
+		o.i = d.readInt()
+		o.l = d.readInt()
+	case *MapIterator:
+		o.v = make(map[string]Object)
+		o.k = make([]string, d.readLen())
+		for i := range o.k {
+			o.k[i] = d.readString()
+			o.v[o.k[i]] = d.readValue()
+		}
+		o.h = make([]MapEntry, d.readLen())
+		for i := range o.h {
+			o.h[i] = MapEntry{Key: d.readValue(), Value: d.readValue()}
+		}
+		o.i = d.readInt()
+		o.l = d.readInt()
+	case *RangeIterator:
+		r, ok := d.readRef().(*Range)
+		if !ok {
+			d.fail(errors.New("invalid range"))
+		}
+		o.r = r
+		o.i = d.readInt64()
+		o.l = d.readInt64()
+	case *Generator:
+		o.fn = d.readFunc()
+		o.freeVars = d.readFree()
+		o.stack = d.readRefs()
+		o.ip = d.readInt()
+		o.value = d.readRef()
+		o.count = d.readInt64()
+		o.done = d.readBool()
+		if msg := d.readString(); msg != "" {
+			o.err = errors.New(msg)
+		}
+		if !o.done {
+			o.vm = d.root
+		}
+	}
+}
+
+func (d *snapshotDecoder) readMap() map[string]Object {
+	m := make(map[string]Object)
+	for i, n := 0, d.readLen(); i < n && d.err == nil; i++ {
+		k := d.readString()
+		m[k] = d.readValue()
+	}
+	return m
+}
+
+// readEntries reads the entries with non-string keys of a map, which are
+// hashed by hash.
+func (d *snapshotDecoder) readEntries() []MapEntry {
+	entries := make([]MapEntry, d.readLen())
+	for i := range entries {
+		entries[i] = MapEntry{Key: d.readValue(), Value: d.readValue()}
+	}
+	return entries
+}
+
+// hash adds the set elements or non-string map keys of o.
+func (d *snapshotDecoder) hash(o Object) {
+	entries := d.unhashed[o]
+	if len(entries) == 0 {
+		return
+	}
+	hashed := make(map[HashKey]MapEntry, len(entries))
+	for _, en := range entries {
+		if n := keySize(en.Key, d.keySizes); n < 0 || n > maxKeySize {
+			d.fail(errors.New("invalid key"))
+			return
+		}
+		key, ok := ToHashKey(en.Key)
+		if !ok {
+			d.fail(ErrNotHashable{Type: en.Key.TypeName()})
+			return
+		}
+		hashed[key] = en
+	}
+	switch o := o.(type) {
+	case *Map:
+		o.Hashed = hashed
+	case *ImmutableMap:
+		o.Hashed = hashed
+	case *Set:
+		for key, en := range hashed {
+			o.Value[key] = en.Key
+		}
+	}
+}
+
+// maxKeySize is the number of values a restored key can hold.
+const maxKeySize = 1 << 20
+
+// keySize returns the number of values in o, a key, or -1 if it is an
+// immutable array that contains itself, whose hash key cannot be computed.
+// sizes holds the sizes of the arrays seen so far.
+func keySize(o Object, sizes map[*ImmutableArray]int) int {
+	arr, ok := o.(*ImmutableArray)
+	if !ok {
+		return 1
+	}
+	if n, ok := sizes[arr]; ok {
+		return n // -1 while its elements are counted
+	}
+	sizes[arr] = -1
+	n := 1
+	for _, elem := range arr.Value {
+		m := keySize(elem, sizes)
+		if m < 0 {
+			return -1
+		}
+		if n += m; n > maxKeySize {
+			n = maxKeySize + 1
+		}
+	}
+	sizes[arr] = n
+	return n
+}
+
+func (d *snapshotDecoder) readRoutine(gvm *routineVM) {
+	gvm.fn = d.readRef()
+	if _, ok := gvm.fn.(*CompiledFunction); !ok && d.err == nil {
+		d.fail(errors.New("invalid routine function"))
+		return
+	}
+	gvm.args = d.readValues()
+	gvm.id = d.readInt64()
+	gvm.name = d.readString()
+	gvm.parent = d.readInt64()
+	gvm.policy = d.readString()
+	gvm.maxRestarts = d.readInt()
+	gvm.restarts = d.readInt64()
+	gvm.site = d.readInt64()
+	if gvm.id > d.root.pause.lastID {
+		d.root.pause.lastID = gvm.id
+	}
+	if d.readBool() {
+		close(gvm.exited)
+		gvm.done = 1
+		gvm.ret.val = d.readRef()
+		if msg := d.readString(); msg != "" {
+			gvm.ret.err = errors.New(msg)
+		}
+		return
+	}
+	gvm.VM = d.root.ShallowClone()
+	gvm.VM.routine = gvm
+	d.readVM(gvm.VM)
+	d.root.resumes = append(d.root.resumes, gvm)
+}
+
+func (d *snapshotDecoder) readVM(v *VM) {
+	v.result = d.readBool()
+	v.finished = d.readBool()
+	v.pendingCall = d.readInt()
+	size := d.readInt()
+	stack := d.readRefs()
+	if d.err != nil {
+		return
+	}
+	if size < len(stack) || size > StackSize {
+		d.fail(errors.New("invalid stack size"))
+		return
+	}
+	v.stack = make([]Object, size)
+	copy(v.stack, stack)
+	v.sp = len(stack)
+	if v.pendingCall < 0 || v.pendingCall > v.sp {
+		d.fail(errors.New("invalid pending call"))
+		return
+	}
+
+	v.framesIndex = d.readLen()
+	if v.framesIndex < 1 || v.framesIndex > MaxFrames {
+		d.fail(errors.New("invalid number of frames"))
+		return
+	}
+	v.frames = make([]*frame, v.framesIndex)
+	for i := range v.frames {
+		f := &frame{fn: d.readFunc(), freeVars: d.readFree()}
+		f.ip = d.readInt()
+		f.basePointer = d.readInt()
+		if d.err != nil {
+			return
+		}
+		if f.ip < -1 || f.ip >= len(f.fn.Instructions) ||
+			f.basePointer < 0 || f.basePointer > v.sp {
+			d.fail(errors.New("invalid frame"))
+			return
+		}
+		v.frames[i] = f
+	}
+	v.ip = d.readInt()
+	v.curFrame = v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	if v.ip < -1 || v.ip >= len(v.curInsts) {
+		d.fail(errors.New("invalid instruction pointer"))
+	}
+	v.restored = true
+}
+
+// snapshotWriter appends the values of a snapshot to a buffer.
+type snapshotWriter struct {
+	b []byte
+}
+
+// grow extends the buffer by size bytes and returns the offset of the first.
+func (w *snapshotWriter) grow(size int) int {
+	n := len(w.b)
+	w.b = append(w.b, make([]byte, size)...)
+	return n
+}
+
+func (w *snapshotWriter) writeByte(c byte) {
+	w.b = append(w.b, c)
+}
+
+func (w *snapshotWriter) writeBool(v bool) {
+	encoding.MarshalBool(w.grow(encoding.SizeBool()), w.b, v)
+}
+
+func (w *snapshotWriter) writeInt(v int) {
+	encoding.MarshalInt(w.grow(encoding.SizeInt(v)), w.b, v)
+}
+
+func (w *snapshotWriter) writeInt64(v int64) {
+	encoding.MarshalInt64(w.grow(encoding.SizeInt64()), w.b, v)
+}
+
+func (w *snapshotWriter) writeUint64(v uint64) {
+	encoding.MarshalUint64(w.grow(encoding.SizeUint64()), w.b, v)
+}
+
+func (w *snapshotWriter) writeString(v string) {
+	encoding.MarshalString(w.grow(encoding.SizeString(v)), w.b, v)
+}
+
+func (w *snapshotWriter) writeBytes(v []byte) {
+	encoding.MarshalBytes(w.grow(encoding.SizeBytes(v)), w.b, v)
+}
+
+func (w *snapshotWriter) writeObject(o Object) {
+	MarshalObject(w.grow(SizeOfObject(o)), w.b, o)
+}
+
+// snapshotReader reads the values of a snapshot. The first error stops the
+// reading; later reads return zero values.
+type snapshotReader struct {
+	b   []byte
+	n   int
+	err error
+}
+
+func (r *snapshotReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *snapshotReader) readByte() (v byte) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalByte(r.n, r.b)
+	}
+	return
+}
+
+func (r *snapshotReader) readBool() (v bool) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalBool(r.n, r.b)
+	}
+	return
+}
+
+func (r *snapshotReader) readInt() (v int) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalInt(r.n, r.b)
+	}
+	return
+}
+
+// readLen reads a number of items, each of which takes at least a byte.
+func (r *snapshotReader) readLen() int {
+	v := r.readInt()
+	if r.err == nil && (v < 0 || v > len(r.b)-r.n) {
+		r.fail(fmt.Errorf("invalid length: %d", v))
+	}
+	if r.err != nil {
+		return 0
+	}
+	return v
+}
+
+func (r *snapshotReader) readInt64() (v int64) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalInt64(r.n, r.b)
+	}
+	return
+}
+
+func (r *snapshotReader) readUint64() (v uint64) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalUint64(r.n, r.b)
+	}
+	return
+}
+
+func (r *snapshotReader) readString() (v string) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalString(r.n, r.b)
+	}
+	return
+}
+
+func (r *snapshotReader) readBytes() (v []byte) {
+	if r.err == nil {
+		r.n, v, r.err = encoding.UnmarshalBytes(r.n, r.b)
+	}
+	return append([]byte(nil), v...)
+}
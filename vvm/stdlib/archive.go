@@ -0,0 +1,762 @@
+package stdlib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/vfs"
+)
+
+var archiveModule = map[string]vvm.Object{
+	"list":    &vvm.BuiltinFunction{Name: "list", Value: archiveList},
+	"extract": &vvm.BuiltinFunction{Name: "extract", Value: archiveExtract},
+	"create":  &vvm.BuiltinFunction{Name: "create", Value: archiveCreate},
+}
+
+// archiveFormats are the names of the archive formats create writes.
+var archiveFormats = []string{"tar", "tar.gz", "zip"}
+
+// archiveFormatOf returns the archive format of the file name by its
+// extension, or "" if it has none.
+func archiveFormatOf(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archivePath returns the cleaned slash separated form of the entry name, or
+// an error if it is absolute or leaves the directory it is extracted to.
+func archivePath(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", fmt.Errorf("archive: unsafe path %q", name)
+	}
+	return clean, nil
+}
+
+// archiveEntry is an entry read from an archive.
+type archiveEntry struct {
+	name string
+	info fs.FileInfo
+	link string
+}
+
+// object returns the script object describing e, the information of
+// a file as returned by os.stat with the full name and the link target of
+// links.
+func (e archiveEntry) object() *vvm.ImmutableMap {
+	obj := makeOSFileInfo(e.info)
+	obj.Value["name"] = &vvm.String{Value: e.name}
+	if e.link != "" {
+		obj.Value["link"] = &vvm.String{Value: e.link}
+	}
+	return obj
+}
+
+// archiveOpen returns the source argument at index i of list and extract: an
+// archive file name, the data of an archive or an object with a read
+// method. The returned function releases the source.
+func archiveOpen(ctx context.Context, args []vvm.Object, i int) (io.Reader, func(), error) {
+	if s, ok := args[i].(*vvm.String); ok {
+		if err := checkPath(ctx, CapFileRead, s.Value); err != nil {
+			return nil, nil, err
+		}
+		f, err := vmFS(ctx).Open(s.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { _ = f.Close() }, nil
+	}
+	if b, ok := args[i].(*vvm.Bytes); ok {
+		return bytes.NewReader(b.Value), func() {}, nil
+	}
+	if read := streamMethod(args[i], "read"); read != nil {
+		return &objectReader{ctx: ctx, read: read}, func() {}, nil
+	}
+	return nil, nil, vvm.ErrInvalidArgumentType{
+		Name:     ordinal(i),
+		Expected: "string/bytes/reader",
+		Found:    args[i].TypeName(),
+	}
+}
+
+// archiveWalk calls fn for each entry of the archive read from r, which is
+// a tar, a gzip compressed tar or a zip archive. The body of the entry can
+// be read until fn returns.
+func archiveWalk(ctx context.Context, r io.Reader, fn func(e archiveEntry, body io.Reader) error) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return archiveWalkZip(ctx, r, br, fn)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = zr.Close() }()
+		return archiveWalkTar(ctx, zr, fn)
+	}
+	return archiveWalkTar(ctx, br, fn)
+}
+
+func archiveWalkTar(ctx context.Context, r io.Reader, fn func(e archiveEntry, body io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		if ctx.Err() != nil {
+			return vvm.ErrVMAborted
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		e := archiveEntry{name: hdr.Name, info: hdr.FileInfo()}
+		if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			e.link = hdr.Linkname
+		}
+		if err := fn(e, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveWalkZip walks the zip archive read from r, which br buffers. Files
+// supporting random access are read in place, other sources are read into
+// memory first.
+func archiveWalkZip(ctx context.Context, r io.Reader, br *bufio.Reader, fn func(e archiveEntry, body io.Reader) error) error {
+	var ra io.ReaderAt
+	var size int64
+	if f, ok := r.(vfs.File); ok {
+		if at, ok := f.(io.ReaderAt); ok {
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			ra, size = at, info.Size()
+		}
+	}
+	if ra == nil {
+		data, err := readAllLimit(br)
+		if err != nil {
+			return err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if ctx.Err() != nil {
+			return vvm.ErrVMAborted
+		}
+		body, err := f.Open()
+		if err != nil {
+			return err
+		}
+		e := archiveEntry{name: f.Name, info: f.FileInfo()}
+		if e.info.Mode()&fs.ModeSymlink != 0 {
+			link, err := readAllLimit(body)
+			if err != nil {
+				_ = body.Close()
+				return err
+			}
+			e.link = string(link)
+		}
+		err = fn(e, body)
+		_ = body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func archiveList(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	r, release, err := archiveOpen(ctx, args, 0)
+	if _, ok := err.(vvm.ErrInvalidArgumentType); ok {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	defer release()
+	arr := &vvm.Array{}
+	err = archiveWalk(ctx, r, func(e archiveEntry, _ io.Reader) error {
+		arr.Value = append(arr.Value, e.object())
+		return nil
+	})
+	return streamResult(arr, err)
+}
+
+func archiveExtract(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	dir, err := netStringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	r, release, err := archiveOpen(ctx, args, 0)
+	if _, ok := err.(vvm.ErrInvalidArgumentType); ok {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	defer release()
+	dest, err := archiveOpenDest(ctx, vmFS(ctx), dir)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	defer func() { _ = dest.Close() }()
+	arr := &vvm.Array{}
+	err = archiveWalk(ctx, r, func(e archiveEntry, body io.Reader) error {
+		name, err := archivePath(e.name)
+		if err != nil {
+			return err
+		}
+		target := filepath.FromSlash(name)
+		mode := e.info.Mode()
+		switch {
+		case mode.IsDir():
+			if err := archiveMkdirAll(ctx, dest, dir, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := archiveExtractFile(ctx, dest, dir, target, mode.Perm(), body); err != nil {
+				return err
+			}
+		default:
+			// links and special files are not extracted
+			return nil
+		}
+		arr.Value = append(arr.Value, &vvm.String{Value: name})
+		return nil
+	})
+	return streamResult(arr, err)
+}
+
+// archiveExtractFile writes the file name of dest, the directory dir, with
+// the permissions perm and the contents read from r.
+func archiveExtractFile(ctx context.Context, dest archiveDest, dir, name string, perm fs.FileMode, r io.Reader) error {
+	if err := archiveMkdirAll(ctx, dest, dir, filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := checkPath(ctx, CapFileWrite, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0o644
+	}
+	f, err := dest.Create(name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// archiveDest is the directory an archive is extracted to. Its methods take
+// local names and fail for names leading out of the directory, also by
+// symbolic links.
+type archiveDest interface {
+	// Mkdir creates the directory name.
+	Mkdir(name string, perm fs.FileMode) error
+
+	// Stat returns the information of the file name.
+	Stat(name string) (fs.FileInfo, error)
+
+	// Create creates or truncates the file name for writing.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+
+	io.Closer
+}
+
+// archiveOpenDest creates the directory dir of fsys and returns it as the
+// destination of an extraction. Directories of the host filesystem are
+// opened as an os.Root, which does not follow links out of them.
+func archiveOpenDest(ctx context.Context, fsys vfs.FS, dir string) (archiveDest, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := fsys.Stat(dir); err != nil {
+		if err := checkPath(ctx, CapFileWrite, dir); err != nil {
+			return nil, err
+		}
+		if err := fsys.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if vfs.IsOS(fsys) {
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		return archiveRootDest{root}, nil
+	}
+	return &archiveFSDest{fsys: fsys, dir: dir}, nil
+}
+
+// archiveRootDest is a destination on the host filesystem.
+type archiveRootDest struct {
+	*os.Root
+}
+
+func (d archiveRootDest) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	f, err := d.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// archiveFSDest is a destination on a virtual filesystem. Names are checked
+// to resolve to a location inside the directory before they are used.
+type archiveFSDest struct {
+	fsys vfs.FS
+	dir  string
+}
+
+// path returns the path of name, which must not lead out of the directory.
+func (d *archiveFSDest) path(name string) (string, error) {
+	p := filepath.Join(d.dir, name)
+	r, ok := d.fsys.(vfs.Resolver)
+	if !ok {
+		return p, nil
+	}
+	dir, err := r.Resolve(d.dir)
+	if err != nil {
+		return "", err
+	}
+	real, err := r.Resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, real); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("archive: unsafe path %q", filepath.ToSlash(name))
+	}
+	return p, nil
+}
+
+func (d *archiveFSDest) Mkdir(name string, perm fs.FileMode) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	return d.fsys.Mkdir(p, perm)
+}
+
+func (d *archiveFSDest) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.fsys.Stat(p)
+}
+
+func (d *archiveFSDest) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.fsys.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *archiveFSDest) Close() error {
+	return nil
+}
+
+// archiveMkdirAll creates the directory name of dest, the directory dir,
+// with its parents. Each created directory is checked with checkPath.
+func archiveMkdirAll(ctx context.Context, dest archiveDest, dir, name string) error {
+	if name == "." {
+		return nil
+	}
+	if info, err := dest.Stat(name); err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: filepath.ToSlash(name), Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if err := archiveMkdirAll(ctx, dest, dir, filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := checkPath(ctx, CapFileWrite, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return dest.Mkdir(name, 0o755)
+}
+
+// archiveWriter writes the entries of an archive.
+type archiveWriter interface {
+	// add writes an entry with the contents read from r, which is nil for
+	// directories.
+	add(name string, info archiveFileInfo, r io.Reader) error
+
+	io.Closer
+}
+
+// archiveFileInfo describes an entry written to an archive.
+type archiveFileInfo struct {
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+type archiveTarWriter struct {
+	tw *tar.Writer
+	zw io.Closer
+}
+
+func (w *archiveTarWriter) add(name string, info archiveFileInfo, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.mode.Perm()),
+		ModTime: info.modTime,
+	}
+	if info.mode.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+	} else {
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.size
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(w.tw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *archiveTarWriter) Close() error {
+	err := w.tw.Close()
+	if w.zw != nil {
+		if zerr := w.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+	return err
+}
+
+type archiveZipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *archiveZipWriter) add(name string, info archiveFileInfo, r io.Reader) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: info.modTime,
+	}
+	hdr.SetMode(info.mode)
+	if info.mode.IsDir() {
+		hdr.Method = zip.Store
+	}
+	fw, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(fw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *archiveZipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// archiveNewWriter returns the writer of an archive of format to w.
+func archiveNewWriter(format string, w io.Writer) archiveWriter {
+	switch format {
+	case "zip":
+		return &archiveZipWriter{zw: zip.NewWriter(w)}
+	case "tar.gz":
+		zw := gzip.NewWriter(w)
+		return &archiveTarWriter{tw: tar.NewWriter(zw), zw: zw}
+	}
+	return &archiveTarWriter{tw: tar.NewWriter(w)}
+}
+
+// archiveSource is an entry to be added to an archive: a file or directory
+// of the filesystem, or in-memory data.
+type archiveSource struct {
+	name string
+	path string
+	data []byte
+	dir  bool
+	mode fs.FileMode
+	time time.Time
+}
+
+// archiveSourceArg returns the entry described by the element o of the
+// entries argument of create.
+func archiveSourceArg(ctx context.Context, o vvm.Object) (archiveSource, error) {
+	if s, ok := o.(*vvm.String); ok {
+		name := strings.TrimLeft(path.Clean(filepath.ToSlash(s.Value)), "/")
+		if name == "" || name == "." {
+			name = "."
+		}
+		return archiveSource{name: name, path: s.Value}, nil
+	}
+	var fields map[string]vvm.Object
+	switch m := o.(type) {
+	case *vvm.Map:
+		fields = m.Value
+	case *vvm.ImmutableMap:
+		fields = m.Value
+	default:
+		return archiveSource{}, vvm.ErrInvalidArgumentType{
+			Name:     "second",
+			Expected: "array(string/map)",
+			Found:    "array(" + o.TypeName() + ")",
+		}
+	}
+	var src archiveSource
+	for key, v := range fields {
+		var ok bool
+		switch key {
+		case "name":
+			src.name, ok = vvm.ToString(v)
+		case "path":
+			src.path, ok = vvm.ToString(v)
+		case "data":
+			switch d := v.(type) {
+			case *vvm.Bytes:
+				src.data, ok = d.Value, true
+			case *vvm.String:
+				src.data, ok = []byte(d.Value), true
+			}
+		case "mode":
+			var mode int64
+			mode, ok = vvm.ToInt64(v)
+			src.mode = fs.FileMode(mode)
+		case "mtime":
+			src.time, ok = vvm.ToTime(v)
+		default:
+			return archiveSource{}, fmt.Errorf("archive: unknown entry field %s", key)
+		}
+		if !ok {
+			return archiveSource{}, fmt.Errorf("archive: invalid entry field %s: %s", key, v.TypeName())
+		}
+	}
+	if src.name == "" {
+		return archiveSource{}, errors.New("archive: entry without name")
+	}
+	if src.path != "" && src.data != nil {
+		return archiveSource{}, errors.New("archive: entry with both path and data")
+	}
+	if src.path != "" {
+		return src, nil
+	}
+	if src.data == nil {
+		src.dir = strings.HasSuffix(src.name, "/")
+		if !src.dir {
+			src.data = []byte{}
+		}
+	}
+	if src.time.IsZero() {
+		src.time = timesNowOf(ctx)
+	}
+	return src, nil
+}
+
+// archiveAdd writes the entry src to w, adding directories of the filesystem
+// with all their contents.
+func archiveAdd(ctx context.Context, fsys vfs.FS, w archiveWriter, src archiveSource) error {
+	if ctx.Err() != nil {
+		return vvm.ErrVMAborted
+	}
+	name, err := archivePath(src.name)
+	if err != nil {
+		return err
+	}
+	if src.path == "" {
+		info := archiveFileInfo{size: int64(len(src.data)), mode: src.mode.Perm(), modTime: src.time}
+		if src.dir {
+			if info.mode == 0 {
+				info.mode = 0o755
+			}
+			info.mode |= fs.ModeDir
+			return w.add(name+"/", info, nil)
+		}
+		if info.mode == 0 {
+			info.mode = 0o644
+		}
+		return w.add(name, info, bytes.NewReader(src.data))
+	}
+
+	if err := checkPath(ctx, CapFileRead, src.path); err != nil {
+		return err
+	}
+	stat, err := fsys.Stat(src.path)
+	if err != nil {
+		return err
+	}
+	info := archiveFileInfo{size: stat.Size(), mode: stat.Mode(), modTime: stat.ModTime()}
+	if src.mode != 0 {
+		info.mode = info.mode&^fs.ModePerm | src.mode.Perm()
+	}
+	if !src.time.IsZero() {
+		info.modTime = src.time
+	}
+	switch {
+	case stat.IsDir():
+		if name != "." {
+			if err := w.add(name+"/", info, nil); err != nil {
+				return err
+			}
+		}
+		entries, err := fsys.ReadDir(src.path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			child := archiveSource{name: path.Join(name, e.Name()), path: filepath.Join(src.path, e.Name())}
+			if err := archiveAdd(ctx, fsys, w, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case stat.Mode().IsRegular():
+		f, err := fsys.Open(src.path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		return w.add(name, info, io.LimitReader(f, info.size))
+	}
+	// links and special files are not archived
+	return nil
+}
+
+func archiveCreate(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var entries []vvm.Object
+	switch arr := args[1].(type) {
+	case *vvm.Array:
+		entries = arr.Value
+	case *vvm.ImmutableArray:
+		entries = arr.Value
+	default:
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "second",
+			Expected: "array",
+			Found:    args[1].TypeName(),
+		}
+	}
+	opts, err := optionsArg(args, 2, "third")
+	if err != nil {
+		return nil, err
+	}
+	var format string
+	if dst, ok := args[0].(*vvm.String); ok {
+		format = archiveFormatOf(dst.Value)
+	}
+	for key, v := range opts {
+		switch key {
+		case "format":
+			s, ok := vvm.ToString(v)
+			if !ok {
+				return nil, fmt.Errorf("invalid archive option format: %s", v.TypeName())
+			}
+			format = s
+		default:
+			return nil, fmt.Errorf("unknown archive option %s", key)
+		}
+	}
+	if format == "" {
+		return nil, errors.New("archive: missing format")
+	}
+	known := false
+	for _, f := range archiveFormats {
+		known = known || f == format
+	}
+	if !known {
+		return nil, fmt.Errorf("archive: unknown format %q", format)
+	}
+	sources := make([]archiveSource, 0, len(entries))
+	for _, e := range entries {
+		src, err := archiveSourceArg(ctx, e)
+		if _, ok := err.(vvm.ErrInvalidArgumentType); ok {
+			return nil, err
+		} else if err != nil {
+			return wrapError(err), nil
+		}
+		sources = append(sources, src)
+	}
+
+	fsys := vmFS(ctx)
+	var dst io.Writer
+	var file vfs.File
+	if s, ok := args[0].(*vvm.String); ok {
+		if err := checkPath(ctx, CapFileWrite, s.Value); err != nil {
+			return wrapError(err), nil
+		}
+		if file, err = fsys.Create(s.Value); err != nil {
+			return wrapError(err), nil
+		}
+		dst = file
+	} else if dst, err = destinationArg(ctx, args, 0); err != nil {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string/writer",
+			Found:    args[0].TypeName(),
+		}
+	}
+	w := archiveNewWriter(format, dst)
+	for _, src := range sources {
+		if err = archiveAdd(ctx, fsys, w, src); err != nil {
+			break
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return streamResult(vvm.TrueValue, err)
+}
@@ -0,0 +1,256 @@
+package stdlib_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+	"github.com/malivvan/vv/vvm/vfs"
+)
+
+const archiveImports = `archive := import("archive"); os := import("os"); compress := import("compress");`
+
+func TestArchive(t *testing.T) {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src")
+		require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("file a"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("file b"), 0o644))
+
+		expect(t, archiveImports+`
+path := dir + "/out" + ext
+archive.create(path, [
+	{name: "src", path: dir + "/src"},
+	{name: "notes/readme.md", data: "# readme", mode: 384},
+	{name: "empty/"},
+	{name: "renamed.txt", path: dir + "/src/a.txt"}
+])
+names := []
+for e in archive.list(path) {
+	names = append(names, [e.name, e.directory, e.size])
+}
+f := os.open(path)
+opened := len(archive.list(f))
+f.close()
+extracted := archive.extract(path, dir + "/dst")
+out := [
+	names,
+	opened,
+	extracted,
+	string(os.read_file(dir + "/dst/src/sub/b.txt")),
+	string(os.read_file(dir + "/dst/notes/readme.md")),
+	string(os.read_file(dir + "/dst/renamed.txt")),
+	os.stat(dir + "/dst/notes/readme.md").mode & 511,
+	os.stat(dir + "/dst/empty").directory
+]
+`, nil, map[string]interface{}{"dir": dir, "ext": ext}, ARR{
+			ARR{
+				ARR{"src/", true, 0},
+				ARR{"src/a.txt", false, 6},
+				ARR{"src/sub/", true, 0},
+				ARR{"src/sub/b.txt", false, 6},
+				ARR{"notes/readme.md", false, 8},
+				ARR{"empty/", true, 0},
+				ARR{"renamed.txt", false, 6},
+			},
+			7,
+			ARR{"src", "src/a.txt", "src/sub", "src/sub/b.txt", "notes/readme.md", "empty", "renamed.txt"},
+			"file b", "# readme", "file a", 0o600, true,
+		})
+	}
+}
+
+func TestArchiveStream(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(file, []byte("on disk"), 0o644))
+	expect(t, archiveImports+`
+chunks := []
+sink := {write: func(b) { chunks = append(chunks, copy(b)); return len(b) }}
+archive.create(sink, [{name: "a.txt", data: bytes("in memory")}, file], {format: "tar.gz"})
+data := bytes("")
+for c in chunks {
+	data += c
+}
+tarball := compress.decompress("gzip", data)
+out := [
+	archive.list(data)[0].name,
+	archive.list(tarball)[0].size,
+	archive.list(compress.reader("gzip", data))[0].name,
+	archive.list(tarball)[1].name
+]
+`, nil, map[string]interface{}{"file": file}, ARR{"a.txt", 9, "a.txt", strings.TrimPrefix(filepath.ToSlash(file), "/")})
+}
+
+func TestArchiveTraversal(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "/etc/evil.txt", "a/../../evil.txt"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "ok.txt", Mode: 0o644, Size: 2}))
+		_, _ = tw.Write([]byte("ok"))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 4}))
+		_, _ = tw.Write([]byte("evil"))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+		require.NoError(t, tw.Close())
+
+		dir := t.TempDir()
+		dst := filepath.Join(dir, "a", "b")
+		expect(t, archiveImports+`
+res := archive.extract(data, dst)
+out := [is_error(res), string(res.value), archive.list(data)[2].link]
+`, nil, map[string]interface{}{"data": buf.Bytes(), "dst": dst}, ARR{true, "archive: unsafe path \"" + name + "\"", "/etc/passwd"})
+		_, err := os.Stat(filepath.Join(dir, "evil.txt"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(dir, "a", "evil.txt"))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	expect(t, archiveImports+`
+out := is_error(archive.create({write: func(b) { return len(b) }}, [{name: "../x", data: "x"}], {format: "tar"}))
+`, nil, nil, true)
+}
+
+func TestArchiveSymlinkedDir(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "sub/evil.txt", Mode: 0o644, Size: 4}))
+	_, _ = tw.Write([]byte("evil"))
+	require.NoError(t, tw.Close())
+
+	for _, chroot := range []bool{false, true} {
+		root := t.TempDir()
+		outside := filepath.Join(root, "outside")
+		dst := filepath.Join(root, "dst")
+		require.NoError(t, os.Mkdir(outside, 0o755))
+		require.NoError(t, os.Mkdir(dst, 0o755))
+		if err := os.Symlink(outside, filepath.Join(dst, "sub")); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+
+		s := vv.NewScript([]byte(`archive := import("archive"); out := is_error(archive.extract(data, dst))`))
+		require.NoError(t, s.Add("data", buf.Bytes()))
+		if chroot {
+			s.SetFS(vfs.Chroot(vfs.OS(), root))
+			require.NoError(t, s.Add("dst", "/dst"))
+		} else {
+			require.NoError(t, s.Add("dst", dst))
+		}
+		s.SetImports(stdlib.GetModuleMap("archive"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(true), c.Get("out").Object())
+		_, err = os.Stat(filepath.Join(outside, "evil.txt"))
+		require.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`archive := import("archive");` + input))
+		s.SetImports(stdlib.GetModuleMap("archive"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`archive.list(1)`, "invalid type for argument 'first'")
+	expectErr(`archive.create("out.rar", [])`, "archive: missing format")
+	expectErr(`archive.create("out", [], {format: "rar"})`, `archive: unknown format "rar"`)
+	expectErr(`archive.create("out.zip", [], {level: 1})`, "unknown archive option level")
+	expectErr(`archive.create("out.zip", "x")`, "invalid type for argument 'second'")
+	expectErr(`archive.create(1, [], {format: "zip"})`, "invalid type for argument 'first'")
+
+	expect(t, archiveImports+`
+out := [
+	is_error(archive.list("/nonexistent/x.zip")),
+	is_error(archive.list(bytes("PK\x03\x04broken"))),
+	is_error(archive.create(path, [{data: "x"}])),
+	is_error(archive.create(path, [{name: "x", size: 1}])),
+	is_error(archive.create(path, [{name: "x", path: "/nonexistent"}]))
+]
+`, nil, map[string]interface{}{"path": filepath.Join(t.TempDir(), "x.zip")}, ARR{true, true, true, true, true})
+}
+
+func TestArchivePolicy(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.zip")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src.txt"), []byte("src"), 0o644))
+	for _, tc := range []struct {
+		policy *stdlib.Policy
+		want   interface{}
+	}{
+		{&stdlib.Policy{}, ARR{true, "permission_denied", "permission_denied", "permission_denied"}},
+		{&stdlib.Policy{FileWrite: []string{dir + "/**"}}, ARR{true, true, "permission_denied", "permission_denied"}},
+		{&stdlib.Policy{FileRead: []string{dir + "/**"}, FileWrite: []string{dir + "/**"}}, ARR{true, true, ARR{"x"}, true}},
+	} {
+		s := vv.NewScript([]byte(`
+archive := import("archive")
+mem := archive.list(bytes(""))
+c := archive.create(out, [{name: "x", data: "x"}])
+e := archive.extract(out, dir + "/dst")
+f := archive.create(out, [{name: "y", path: dir + "/src.txt"}])
+out = [!is_error(mem), is_error(c) ? c.code : c, is_error(e) ? e.code : e, is_error(f) ? f.code : f]
+`))
+		require.NoError(t, s.Add("out", out))
+		require.NoError(t, s.Add("dir", dir))
+		s.SetImports(stdlib.GetPolicyModuleMap(tc.policy, "archive"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(tc.want), c.Get("out").Object())
+	}
+}
+
+func TestArchivePolicyFiles(t *testing.T) {
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree")
+	require.NoError(t, os.MkdirAll(filepath.Join(tree, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(tree, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tree, "sub", "b.txt"), []byte("b"), 0o644))
+	out := filepath.Join(dir, "out.tar")
+
+	// files below the directory added to an archive are checked
+	policy := &stdlib.Policy{
+		FileRead:  []string{tree, tree + "/*.txt", tree + "/sub"},
+		FileWrite: []string{out},
+	}
+	s := vv.NewScript([]byte(`
+archive := import("archive")
+res := archive.create(out, [{name: "tree", path: tree}])
+out = [res.code, string(res.value)]
+`))
+	require.NoError(t, s.Add("out", out))
+	require.NoError(t, s.Add("tree", tree))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "archive"))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{"permission_denied", "permission denied: file_read " + tree + "/sub/b.txt"}), c.Get("out").Object())
+
+	// files and directories below the directory extracted to are checked
+	expect(t, archiveImports+`out := archive.create(file, [{name: "tree", path: tree}])`, nil,
+		map[string]interface{}{"file": out, "tree": tree}, true)
+	dst := filepath.Join(dir, "dst")
+	policy = &stdlib.Policy{
+		FileRead:  []string{out},
+		FileWrite: []string{dst, dst + "/tree", dst + "/tree/*.txt"},
+	}
+	s = vv.NewScript([]byte(`
+archive := import("archive")
+res := archive.extract(out, dst)
+out = [res.code, string(res.value)]
+`))
+	require.NoError(t, s.Add("out", out))
+	require.NoError(t, s.Add("dst", dst))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "archive"))
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{"permission_denied", "permission denied: file_write " + dst + "/tree/sub"}), c.Get("out").Object())
+	_, err = os.Stat(filepath.Join(dst, "tree", "a.txt"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dst, "tree", "sub"))
+	require.True(t, os.IsNotExist(err))
+}
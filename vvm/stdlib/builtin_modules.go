@@ -6,14 +6,22 @@ import (
 
 // BuiltinModules are builtin type standard library modules.
 var BuiltinModules = map[string]map[string]vvm.Object{
-	"math":   mathModule,
-	"os":     osModule,
-	"text":   textModule,
-	"times":  timesModule,
-	"rand":   randModule,
-	"fmt":    fmtModule,
-	"json":   jsonModule,
-	"base64": base64Module,
-	"hex":    hexModule,
-	"cui":    cuiModule,
+	"math":        mathModule,
+	"os":          osModule,
+	"text":        textModule,
+	"times":       timesModule,
+	"rand":        randModule,
+	"fmt":         fmtModule,
+	"json":        jsonModule,
+	"base64":      base64Module,
+	"hex":         hexModule,
+	"cui":         cuiModule,
+	"collections": collectionsModule,
+	"sync":        syncModule,
+	"cron":        cronModule,
+	"http":        httpModule,
+	"net":         netModule,
+	"crypto":      cryptoModule,
+	"compress":    compressModule,
+	"archive":     archiveModule,
 }
@@ -0,0 +1,395 @@
+package stdlib
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/token"
+)
+
+var collectionsModule = map[string]vvm.Object{
+	"map":      &vvm.BuiltinFunction{Name: "map", Value: collectionsMap},
+	"filter":   &vvm.BuiltinFunction{Name: "filter", Value: collectionsFilter},
+	"reduce":   &vvm.BuiltinFunction{Name: "reduce", Value: collectionsReduce},
+	"sort":     &vvm.BuiltinFunction{Name: "sort", Value: collectionsSort},
+	"zip":      &vvm.BuiltinFunction{Name: "zip", Value: collectionsZip},
+	"keys":     &vvm.BuiltinFunction{Name: "keys", Value: collectionsKeys},
+	"values":   &vvm.BuiltinFunction{Name: "values", Value: collectionsValues},
+	"group_by": &vvm.BuiltinFunction{Name: "group_by", Value: collectionsGroupBy},
+	"unique":   &vvm.BuiltinFunction{Name: "unique", Value: collectionsUnique},
+}
+
+// iterator returns an iterator over the argument x.
+func iterator(ctx context.Context, name string, x vvm.Object) (vvm.Iterator, error) {
+	if !x.CanIterate() && hookOf(x, vvm.HookIter) == nil {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "iterable",
+			Found:    x.TypeName(),
+		}
+	}
+	return vvm.Iterate(ctx, x)
+}
+
+// hookOf returns the callable entry name of x if x is a map.
+func hookOf(x vvm.Object, name string) vvm.Object {
+	var hook vvm.Object
+	switch x := x.(type) {
+	case *vvm.Map:
+		hook = x.Value[name]
+	case *vvm.ImmutableMap:
+		hook = x.Value[name]
+	}
+	if hook != nil && hook.CanCall() {
+		return hook
+	}
+	return nil
+}
+
+// iterErr returns the error that stopped it, if any.
+func iterErr(it vvm.Iterator) error {
+	if e, ok := it.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
+
+// each calls fn for every element of the iterable x.
+func each(ctx context.Context, name string, x vvm.Object, fn func(k, v vvm.Object) error) error {
+	it, err := iterator(ctx, name, x)
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return iterErr(it)
+}
+
+// checkCallable returns an error if the argument fn cannot be called.
+func checkCallable(name string, fn vvm.Object) error {
+	if fn.CanCall() || hookOf(fn, vvm.HookCall) != nil {
+		return nil
+	}
+	return vvm.ErrInvalidArgumentType{
+		Name:     name,
+		Expected: "callable",
+		Found:    fn.TypeName(),
+	}
+}
+
+// map(x, fn) => [fn(v) for v in x]
+func collectionsMap(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	var res []vvm.Object
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		r, err := vvm.Invoke(ctx, args[1], v)
+		if err != nil {
+			return err
+		}
+		res = append(res, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: res}, nil
+}
+
+// filter(x, fn) => [v for v in x if fn(v)]
+func collectionsFilter(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	var res []vvm.Object
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		r, err := vvm.Invoke(ctx, args[1], v)
+		if err != nil {
+			return err
+		}
+		if !r.IsFalsy() {
+			res = append(res, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: res}, nil
+}
+
+// reduce(x, fn[, initial]) folds x into a single value by calling
+// fn(acc, v) for each element. Without initial value the first element is
+// used instead.
+func collectionsReduce(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	acc := vvm.UndefinedValue
+	first := len(args) == 2
+	if !first {
+		acc = args[2]
+	}
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		if first {
+			acc, first = v, false
+			return nil
+		}
+		r, err := vvm.Invoke(ctx, args[1], acc, v)
+		if err != nil {
+			return err
+		}
+		acc = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// sort(x[, less]) returns the elements of x in a new array, sorted by the
+// '<' operator or by the given less(a, b) function. The sort is stable.
+func collectionsSort(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var less vvm.Object
+	if len(args) == 2 {
+		if err := checkCallable("second", args[1]); err != nil {
+			return nil, err
+		}
+		less = args[1]
+	}
+	var res []vvm.Object
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		res = append(res, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var r vvm.Object
+		if less != nil {
+			r, err = vvm.Invoke(ctx, less, res[i], res[j])
+		} else {
+			r, err = vvm.BinaryOp(ctx, token.Less, res[i], res[j])
+		}
+		return err == nil && !r.IsFalsy()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: res}, nil
+}
+
+// zip(x1, x2, ...) => [[x1[0], x2[0], ...], [x1[1], x2[1], ...], ...]
+// The result is as long as the shortest argument.
+func collectionsZip(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) == 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	its := make([]vvm.Iterator, len(args))
+	for i, arg := range args {
+		it, err := iterator(ctx, ordinal(i), arg)
+		if err != nil {
+			return nil, err
+		}
+		its[i] = it
+	}
+	var res []vvm.Object
+	for {
+		tuple := make([]vvm.Object, len(its))
+		for i, it := range its {
+			if !it.Next() {
+				if err := iterErr(it); err != nil {
+					return nil, err
+				}
+				return &vvm.Array{Value: res}, nil
+			}
+			tuple[i] = it.Value()
+		}
+		res = append(res, &vvm.Array{Value: tuple})
+	}
+}
+
+// ordinal returns the name of the i-th argument used in errors.
+func ordinal(i int) string {
+	switch i {
+	case 0:
+		return "first"
+	case 1:
+		return "second"
+	case 2:
+		return "third"
+	}
+	return strconv.Itoa(i+1) + "th"
+}
+
+// entries returns the keys and values of x. The entries of maps are sorted
+// by key so that the result does not depend on the map iteration order.
+func entries(ctx context.Context, x vvm.Object) (keys, values []vvm.Object, err error) {
+	err = each(ctx, "first", x, func(k, v vvm.Object) error {
+		keys = append(keys, k)
+		values = append(values, v)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	switch x.(type) {
+	case *vvm.Map, *vvm.ImmutableMap:
+		hashes := make([]vvm.HashKey, len(keys))
+		for i, k := range keys {
+			hashes[i], _ = vvm.ToHashKey(k)
+		}
+		sort.Sort(byHashKey{hashes, keys, values})
+	}
+	return keys, values, nil
+}
+
+type byHashKey struct {
+	hashes []vvm.HashKey
+	keys   []vvm.Object
+	values []vvm.Object
+}
+
+func (s byHashKey) Len() int {
+	return len(s.hashes)
+}
+
+func (s byHashKey) Less(i, j int) bool {
+	if s.hashes[i].Type != s.hashes[j].Type {
+		return s.hashes[i].Type < s.hashes[j].Type
+	}
+	return s.hashes[i].Value < s.hashes[j].Value
+}
+
+func (s byHashKey) Swap(i, j int) {
+	s.hashes[i], s.hashes[j] = s.hashes[j], s.hashes[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+// keys(x) returns the keys of a map, sorted, or the indices of an iterable.
+func collectionsKeys(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	keys, _, err := entries(ctx, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: keys}, nil
+}
+
+// values(x) returns the values of a map, sorted by key, or the elements of
+// an iterable.
+func collectionsValues(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	_, values, err := entries(ctx, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: values}, nil
+}
+
+// group_by(x, fn) returns a map from fn(v) to the array of elements v of x
+// with that key, in iteration order.
+func collectionsGroupBy(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	res := &vvm.Map{Value: make(map[string]vvm.Object)}
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		key, err := vvm.Invoke(ctx, args[1], v)
+		if err != nil {
+			return err
+		}
+		if _, ok := vvm.ToHashKey(key); !ok {
+			return vvm.ErrNotHashable{Type: key.TypeName()}
+		}
+		group, err := res.IndexGet(key)
+		if err != nil {
+			return err
+		}
+		if group, ok := group.(*vvm.Array); ok {
+			group.Value = append(group.Value, v)
+			return nil
+		}
+		return res.IndexSet(key, &vvm.Array{Value: []vvm.Object{v}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// unique(x[, fn]) returns the elements of x without duplicates, keeping the
+// first occurrence. Elements are compared by fn(v) if given.
+func collectionsUnique(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if len(args) == 2 {
+		if err := checkCallable("second", args[1]); err != nil {
+			return nil, err
+		}
+	}
+	var res []vvm.Object
+	seen := make(map[vvm.HashKey]bool)
+	var unhashable []vvm.Object
+	err := each(ctx, "first", args[0], func(_, v vvm.Object) error {
+		key := v
+		if len(args) == 2 {
+			var err error
+			if key, err = vvm.Invoke(ctx, args[1], v); err != nil {
+				return err
+			}
+		}
+		if h, ok := vvm.ToHashKey(key); ok {
+			if seen[h] {
+				return nil
+			}
+			seen[h] = true
+		} else {
+			for _, u := range unhashable {
+				if u.Equals(key) {
+					return nil
+				}
+			}
+			unhashable = append(unhashable, key)
+		}
+		res = append(res, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.Array{Value: res}, nil
+}
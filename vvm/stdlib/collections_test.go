@@ -0,0 +1,74 @@
+package stdlib_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+func TestCollections(t *testing.T) {
+	c := `c := import("collections");`
+
+	expect(t, c+`out := string(c.map([1, 2, 3], func(x) { return x * 2 }))`, nil, nil, "[2, 4, 6]")
+	expect(t, c+`out := string(c.map("ab", func(x) { return x + 1 }))`, nil, nil, "[b, c]")
+	expect(t, c+`out := string(c.map([], func(x) { return x }))`, nil, nil, "[]")
+	expect(t, c+`out := string(c.filter([1, 2, 3, 4], func(x) { return x % 2 == 0 }))`, nil, nil, "[2, 4]")
+	expect(t, c+`out := string(c.filter(range(0, 10, 3), func(x) { return x > 0 }))`, nil, nil, "[3, 6, 9]")
+
+	expect(t, c+`out := c.reduce([1, 2, 3], func(acc, x) { return acc + x }, 10)`, nil, nil, int64(16))
+	expect(t, c+`out := c.reduce([1, 2, 3], func(acc, x) { return acc * x })`, nil, nil, int64(6))
+	expect(t, c+`out := is_undefined(c.reduce([], func(acc, x) { return acc + x }))`, nil, nil, true)
+
+	expect(t, c+`out := string(c.sort([3, 1, 2]))`, nil, nil, "[1, 2, 3]")
+	expect(t, c+`out := string(c.sort(["b", "c", "a"], func(a, b) { return a > b }))`, nil, nil, `["c", "b", "a"]`)
+	expect(t, c+`
+people := [{n: "a", age: 3}, {n: "b", age: 1}, {n: "c", age: 3}, {n: "d", age: 1}]
+out := string(c.map(c.sort(people, func(a, b) { return a.age < b.age }), func(p) { return p.n }))`, nil, nil,
+		`["b", "d", "a", "c"]`)
+
+	expect(t, c+`out := string(c.zip([1, 2, 3], "ab"))`, nil, nil, "[[1, a], [2, b]]")
+	expect(t, c+`
+gen := func() { for i := 0; true; i++ { yield i } }
+out := string(c.zip(gen(), ["x", "y"]))`, nil, nil, `[[0, "x"], [1, "y"]]`)
+
+	expect(t, c+`out := string(c.keys({b: 2, a: 1, c: 3}))`, nil, nil, `["a", "b", "c"]`)
+	expect(t, c+`out := string(c.values({b: 2, a: 1, c: 3}))`, nil, nil, "[1, 2, 3]")
+	expect(t, c+`out := string(c.keys(["x", "y"]))`, nil, nil, "[0, 1]")
+
+	expect(t, c+`
+g := c.group_by([1, 2, 3, 4, 5], func(x) { return x % 2 == 0 ? "even" : "odd" })
+out := string(g.odd) + string(g.even)`, nil, nil, "[1, 3, 5][2, 4]")
+	expect(t, c+`out := string(c.unique([1, 2, 1, 3, 2]))`, nil, nil, "[1, 2, 3]")
+	expect(t, c+`out := string(c.unique([[1], [2], [1]]))`, nil, nil, "[[1], [2]]")
+	expect(t, c+`out := string(c.unique(["a", "B", "b"], func(s) { return import("text").to_lower(s) }))`, nil, nil,
+		`["a", "B"]`)
+
+	expect(t, c+`
+it := {__iter__: func(self) { return [3, 1, 2] }}
+out := string(c.sort(it))`, nil, nil, "[1, 2, 3]")
+	expect(t, c+`
+double := {__call__: func(self, x) { return x * 2 }}
+out := string(c.map([1, 2], double))`, nil, nil, "[2, 4]")
+}
+
+func TestCollectionsErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(input))
+		s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	c := `c := import("collections");`
+
+	expectErr(c+`c.map(1, func(x) { return x })`, "iterable")
+	expectErr(c+`c.map([1], 1)`, "callable")
+	expectErr(c+`c.zip()`, "wrong number of arguments")
+	expectErr(c+`c.map([1, 2], func(x) { return x.y })`, "not indexable: string\n\tat (main):1:61\n\tat (main):1:28")
+	expectErr(c+`c.sort([1, "a"])`, "invalid operation")
+	expectErr(c+`c.sort([2, 1], func(a, b) { return a() })`, "not callable")
+	expectErr(c+`c.group_by([1], func(x) { return [x] })`, "unhashable")
+}
@@ -0,0 +1,445 @@
+package stdlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/malivvan/vv/vvm"
+)
+
+var compressModule = map[string]vvm.Object{
+	"compress":   &vvm.BuiltinFunction{Name: "compress", Value: compressCompress},
+	"decompress": &vvm.BuiltinFunction{Name: "decompress", Value: compressDecompress},
+	"writer":     &vvm.BuiltinFunction{Name: "writer", Value: compressWriter},
+	"reader":     &vvm.BuiltinFunction{Name: "reader", Value: compressReader},
+}
+
+// compressFormat creates the compressing writers and decompressing readers
+// of a compression format.
+type compressFormat struct {
+	writer func(w io.Writer, level int, leveled bool) (io.WriteCloser, error)
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressFormats = map[string]compressFormat{
+	"gzip": {
+		writer: func(w io.Writer, level int, leveled bool) (io.WriteCloser, error) {
+			if !leveled {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"zlib": {
+		writer: func(w io.Writer, level int, leveled bool) (io.WriteCloser, error) {
+			if !leveled {
+				level = zlib.DefaultCompression
+			}
+			return zlib.NewWriterLevel(w, level)
+		},
+		reader: zlib.NewReader,
+	},
+	"zstd": {
+		writer: func(w io.Writer, level int, leveled bool) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+			if leveled {
+				if level < 1 || level > 22 {
+					return nil, fmt.Errorf("compress: invalid zstd level %d", level)
+				}
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	"snappy": {
+		writer: func(w io.Writer, level int, leveled bool) (io.WriteCloser, error) {
+			if leveled {
+				return nil, errors.New("compress: snappy has no compression levels")
+			}
+			return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), nil
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(s2.NewReader(r)), nil
+		},
+	},
+}
+
+// compressFormatArg returns the compression format named by the argument at
+// index i.
+func compressFormatArg(args []vvm.Object, i int) (compressFormat, error) {
+	name, err := netStringArg(args, i)
+	if err != nil {
+		return compressFormat{}, err
+	}
+	f, ok := compressFormats[name]
+	if !ok {
+		return compressFormat{}, fmt.Errorf("compress: unknown format %q", name)
+	}
+	return f, nil
+}
+
+// compressLevelArg returns the optional compression level at index i.
+func compressLevelArg(args []vvm.Object, i int) (level int, leveled bool, err error) {
+	if len(args) <= i {
+		return 0, false, nil
+	}
+	level, ok := vvm.ToInt(args[i])
+	if !ok {
+		return 0, false, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "int(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return level, true, nil
+}
+
+// readAllLimit reads r until EOF, failing with vvm.ErrBytesLimit if it
+// yields more than vvm.MaxBytesLen bytes.
+func readAllLimit(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(vvm.MaxBytesLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > vvm.MaxBytesLen {
+		return nil, vvm.ErrBytesLimit
+	}
+	return data, nil
+}
+
+// streamError returns the Go error of the error value returned by a read or
+// write method, turning "EOF" into io.EOF.
+func streamError(ret vvm.Object) error {
+	e, ok := ret.(*vvm.Error)
+	if !ok {
+		return nil
+	}
+	msg, _ := vvm.ToString(e.Value)
+	if msg == io.EOF.Error() {
+		return io.EOF
+	}
+	return errors.New(msg)
+}
+
+// streamMethod returns the method name of the map obj, or nil if it has
+// none.
+func streamMethod(obj vvm.Object, name string) vvm.Object {
+	var fields map[string]vvm.Object
+	switch o := obj.(type) {
+	case *vvm.Map:
+		fields = o.Value
+	case *vvm.ImmutableMap:
+		fields = o.Value
+	}
+	if fn := fields[name]; fn != nil && fn.CanCall() {
+		return fn
+	}
+	return nil
+}
+
+// objectReader reads from a script object with a read(buf) method, such as
+// an os file object, a net connection or a compress reader.
+type objectReader struct {
+	ctx  context.Context
+	read vvm.Object
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	ret, err := vvm.Invoke(r.ctx, r.read, &vvm.Bytes{Value: p})
+	if err != nil {
+		return 0, err
+	}
+	if err := streamError(ret); err != nil {
+		return 0, err
+	}
+	n, ok := vvm.ToInt(ret)
+	if !ok || n < 0 || n > len(p) {
+		return 0, fmt.Errorf("invalid read result: %s", ret.TypeName())
+	}
+	return n, nil
+}
+
+// objectWriter writes to a script object with a write(data) method, such as
+// an os file object, a net connection or a compress writer.
+type objectWriter struct {
+	ctx   context.Context
+	write vvm.Object
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	ret, err := vvm.Invoke(w.ctx, w.write, &vvm.Bytes{Value: p})
+	if err != nil {
+		return 0, err
+	}
+	if err := streamError(ret); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sourceArg returns a reader of the argument at index i: the data of a
+// string or bytes, or an object with a read method.
+func sourceArg(ctx context.Context, args []vvm.Object, i int) (io.Reader, error) {
+	if read := streamMethod(args[i], "read"); read != nil {
+		return &objectReader{ctx: ctx, read: read}, nil
+	}
+	data, err := netDataArg(args, i)
+	if err != nil {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "string/bytes/reader",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return bytes.NewReader(data), nil
+}
+
+// destinationArg returns a writer to the object with a write method at
+// index i.
+func destinationArg(ctx context.Context, args []vvm.Object, i int) (io.Writer, error) {
+	write := streamMethod(args[i], "write")
+	if write == nil {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "writer",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return &objectWriter{ctx: ctx, write: write}, nil
+}
+
+// streamResult turns the error of a stream operation into the result of a
+// builtin: limits and aborts fail the call, other errors are returned as
+// error values.
+func streamResult(ret vvm.Object, err error) (vvm.Object, error) {
+	switch {
+	case err == nil:
+		return ret, nil
+	case errors.Is(err, vvm.ErrBytesLimit), errors.Is(err, vvm.ErrVMAborted):
+		return nil, err
+	}
+	return wrapError(err), nil
+}
+
+func compressCompress(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	f, err := compressFormatArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	level, leveled, err := compressLevelArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := f.writer(&buf, level, leveled)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return wrapError(err), nil
+	}
+	if err := w.Close(); err != nil {
+		return wrapError(err), nil
+	}
+	if buf.Len() > vvm.MaxBytesLen {
+		return nil, vvm.ErrBytesLimit
+	}
+	return &vvm.Bytes{Value: buf.Bytes()}, nil
+}
+
+func compressDecompress(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	f, err := compressFormatArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f.reader(bytes.NewReader(data))
+	if err != nil {
+		return wrapError(err), nil
+	}
+	defer func() { _ = r.Close() }()
+	data, err = readAllLimit(r)
+	return streamResult(&vvm.Bytes{Value: data}, err)
+}
+
+func compressWriter(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	f, err := compressFormatArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destinationArg(ctx, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	level, leveled, err := compressLevelArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	w, err := f.writer(dst, level, leveled)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return compressWriterObject(dst.(*objectWriter), w), nil
+}
+
+// compressWriterObject returns the script object of the compressing writer
+// w, which writes to dst.
+func compressWriterObject(dst *objectWriter, w io.WriteCloser) *vvm.ImmutableMap {
+	// the writer calls the destination with the context of the caller
+	bind := func(ctx context.Context) { dst.ctx = ctx }
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"write": &vvm.BuiltinFunction{
+				Name: "write",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 1 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					data, err := netDataArg(args, 0)
+					if err != nil {
+						return nil, err
+					}
+					bind(ctx)
+					n, err := w.Write(data)
+					return streamResult(&vvm.Int{Value: int64(n)}, err)
+				},
+			},
+			"flush": &vvm.BuiltinFunction{
+				Name: "flush",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					f, ok := w.(interface{ Flush() error })
+					if !ok {
+						return vvm.TrueValue, nil
+					}
+					bind(ctx)
+					return streamResult(vvm.TrueValue, f.Flush())
+				},
+			},
+			"close": &vvm.BuiltinFunction{
+				Name: "close",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					bind(ctx)
+					return streamResult(vvm.TrueValue, w.Close())
+				},
+			},
+		},
+	}
+}
+
+func compressReader(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	f, err := compressFormatArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	src, err := sourceArg(ctx, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	// gzip and zlib read their header when the reader is created
+	r, err := f.reader(src)
+	if err != nil {
+		return streamResult(nil, err)
+	}
+	return compressReaderObject(src, r), nil
+}
+
+// compressReaderObject returns the script object of the decompressing reader
+// r, which reads from src.
+func compressReaderObject(src io.Reader, r io.ReadCloser) *vvm.ImmutableMap {
+	bind := func(ctx context.Context) {
+		if o, ok := src.(*objectReader); ok {
+			o.ctx = ctx
+		}
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"read": &vvm.BuiltinFunction{
+				Name: "read",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 1 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					buf, err := netBufArg(args, 0)
+					if err != nil {
+						return nil, err
+					}
+					bind(ctx)
+					n, err := io.ReadAtLeast(r, buf, 1)
+					if n > 0 {
+						// a final error is returned again by the next read
+						return &vvm.Int{Value: int64(n)}, nil
+					}
+					if errors.Is(err, io.ErrShortBuffer) {
+						return &vvm.Int{}, nil
+					}
+					return streamResult(nil, err)
+				},
+			},
+			"read_all": &vvm.BuiltinFunction{
+				Name: "read_all",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					bind(ctx)
+					data, err := readAllLimit(r)
+					return streamResult(&vvm.Bytes{Value: data}, err)
+				},
+			},
+			"close": &vvm.BuiltinFunction{
+				Name: "close",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					return wrapError(r.Close()), nil
+				},
+			},
+		},
+	}
+}
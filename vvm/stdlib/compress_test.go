@@ -0,0 +1,112 @@
+package stdlib_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+const compressImports = `compress := import("compress"); os := import("os");`
+
+func TestCompress(t *testing.T) {
+	for _, format := range []string{"gzip", "zlib", "zstd", "snappy"} {
+		expect(t, compressImports+`
+data := ""
+for i := 0; i < 100; i++ {
+	data += "hello compress "
+}
+c := compress.compress(name, data)
+out := [len(c) < len(data), string(compress.decompress(name, c)), len(compress.decompress(name, compress.compress(name, bytes(""))))]
+`, nil, map[string]interface{}{"name": format}, ARR{true, strings.Repeat("hello compress ", 100), 0})
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("from go"))
+	require.NoError(t, zw.Close())
+	module(t, "compress").call("decompress", "gzip", buf.Bytes()).expect([]byte("from go"))
+
+	expect(t, compressImports+`
+out := [
+	string(compress.decompress("gzip", compress.compress("gzip", "x", 9))),
+	string(compress.decompress("zstd", compress.compress("zstd", "x", 19))),
+	is_error(compress.compress("gzip", "x", 12)),
+	is_error(compress.compress("zstd", "x", 0)),
+	is_error(compress.compress("snappy", "x", 1)),
+	is_error(compress.decompress("gzip", "not gzip")),
+	is_error(compress.decompress("zstd", "not zstd"))
+]
+`, nil, nil, ARR{"x", "x", true, true, true, true, true})
+}
+
+func TestCompressStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.gz")
+	expect(t, compressImports+`
+f := os.create(path)
+w := compress.writer("gzip", f)
+w.write("hello ")
+w.flush()
+w.write(bytes("stream"))
+w.close()
+f.close()
+
+f = os.open(path)
+r := compress.reader("gzip", f)
+buf := bytes(4)
+first := string(buf[:r.read(buf)])
+rest := string(r.read_all())
+eof := r.read(buf)
+r.close()
+f.close()
+
+chunks := []
+sink := {write: func(b) { chunks = append(chunks, b); return len(b) }}
+z := compress.writer("zstd", sink)
+z.write("in memory")
+z.close()
+data := bytes("")
+for c in chunks {
+	data += c
+}
+out := [first, rest, is_error(eof), string(compress.decompress("zstd", data)),
+	string(compress.reader("zstd", data).read_all())]
+`, nil, map[string]interface{}{"path": path}, ARR{"hell", "o stream", true, "in memory", "in memory"})
+
+	// the stream written by the script is a regular gzip file
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, "hello stream", string(data))
+}
+
+func TestCompressErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`compress := import("compress");` + input))
+		s.SetImports(stdlib.GetModuleMap("compress"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`compress.compress("lz4", "x")`, `unknown format "lz4"`)
+	expectErr(`compress.compress("gzip", undefined)`, "invalid type for argument 'second'")
+	expectErr(`compress.compress("gzip", "x", "best")`, "invalid type for argument 'third'")
+	expectErr(`compress.writer("gzip", "x")`, "invalid type for argument 'second'")
+	expectErr(`compress.reader("gzip", undefined)`, "invalid type for argument 'second'")
+
+	expect(t, compressImports+`
+r := compress.reader("gzip", {read: func(b) { return error("broken") }})
+out := [is_error(r), string(r.value)]
+`, nil, nil, ARR{true, "broken"})
+}
@@ -0,0 +1,382 @@
+package stdlib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/malivvan/vv/vvm"
+)
+
+var cronModule = map[string]vvm.Object{
+	"parse":    &vvm.BuiltinFunction{Name: "parse", Value: cronParseFunc},       // parse(expr string, tz string) => schedule
+	"schedule": &vvm.BuiltinFunction{Name: "schedule", Value: cronScheduleFunc}, // schedule(expr string, fn func, opts map) => job
+}
+
+// overlap policies of jobs, applied when a run is due while the previous
+// one still runs
+const (
+	cronSkip       = "skip"       // the due run is dropped
+	cronQueue      = "queue"      // the due run starts after the previous one
+	cronConcurrent = "concurrent" // the due run starts anyway
+)
+
+// cronLocation returns the time zone named by the argument at index i, or
+// the local time zone if there is none.
+func cronLocation(args []vvm.Object, i int, name string) (*time.Location, error) {
+	if len(args) <= i {
+		return time.Local, nil
+	}
+	tz, ok := vvm.ToString(args[i])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "string(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("cron: unknown time zone %q", tz)
+	}
+	return loc, nil
+}
+
+func cronParseFunc(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	expr, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	loc, err := cronLocation(args, 1, "second")
+	if err != nil {
+		return nil, err
+	}
+	s, err := cronParse(expr, loc)
+	if err != nil {
+		return nil, err
+	}
+	return s.object(), nil
+}
+
+// object returns the schedule object of s.
+func (s *cronSchedule) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"expr":     &vvm.String{Value: s.expr},
+		"location": &vvm.String{Value: s.loc.String()},
+		"next": &vvm.BuiltinFunction{
+			Name: "next",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) > 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				t, err := cronFrom(ctx, args, 0, "first")
+				if err != nil {
+					return nil, err
+				}
+				return cronTime(s.next(t)), nil
+			},
+		}, // next(t time) => time
+		"next_n": &vvm.BuiltinFunction{
+			Name: "next_n",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 1 && len(args) != 2 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				n, ok := vvm.ToInt(args[0])
+				if !ok {
+					return nil, vvm.ErrInvalidArgumentType{
+						Name:     "first",
+						Expected: "int(compatible)",
+						Found:    args[0].TypeName(),
+					}
+				}
+				t, err := cronFrom(ctx, args, 1, "second")
+				if err != nil {
+					return nil, err
+				}
+				arr := &vvm.Array{}
+				for ; n > 0; n-- {
+					if t = s.next(t); t.IsZero() {
+						break
+					}
+					arr.Value = append(arr.Value, &vvm.Time{Value: t})
+				}
+				return arr, nil
+			},
+		}, // next_n(n int, t time) => [time]
+	}}
+}
+
+// cronFrom returns the time argument at index i, or the current time if
+// there is none.
+func cronFrom(ctx context.Context, args []vvm.Object, i int, name string) (time.Time, error) {
+	if len(args) <= i {
+		return timesNowOf(ctx), nil
+	}
+	t, ok := vvm.ToTime(args[i])
+	if !ok {
+		return time.Time{}, vvm.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "time(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return t, nil
+}
+
+// cronTime returns t as an object, undefined for the zero time.
+func cronTime(t time.Time) vvm.Object {
+	if t.IsZero() {
+		return vvm.UndefinedValue
+	}
+	return &vvm.Time{Value: t}
+}
+
+// cronJob is a function scheduled by cron.schedule. Its scheduler routine
+// waits for the due runs and starts a routine for each.
+type cronJob struct {
+	sched   *cronSchedule
+	fn      vvm.Object
+	args    []vvm.Object
+	overlap string
+	jitter  time.Duration
+	timer   *timesTimer
+
+	mu      sync.Mutex
+	due     time.Time // next run without jitter
+	nextAt  time.Time // zero if no run is due
+	runs    int64     // runs started
+	skipped int64     // runs dropped by the skip policy
+	queued  int64     // runs waiting by the queue policy
+	running int64     // routines running the function
+}
+
+func cronScheduleFunc(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	expr, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	job := &cronJob{fn: args[1], overlap: cronSkip}
+	loc := time.Local
+	if len(args) == 3 {
+		var opts map[string]vvm.Object
+		switch o := args[2].(type) {
+		case *vvm.Map:
+			opts = o.Value
+		case *vvm.ImmutableMap:
+			opts = o.Value
+		default:
+			return nil, vvm.ErrInvalidArgumentType{
+				Name:     "third",
+				Expected: "map",
+				Found:    args[2].TypeName(),
+			}
+		}
+		for key, value := range opts {
+			ok := true
+			switch key {
+			case "overlap":
+				job.overlap, ok = vvm.ToString(value)
+				switch job.overlap {
+				case cronSkip, cronQueue, cronConcurrent:
+				default:
+					return nil, fmt.Errorf("cron: invalid overlap policy: %s", job.overlap)
+				}
+			case "tz":
+				var err error
+				if loc, err = cronLocation([]vvm.Object{value}, 0, "tz"); err != nil {
+					return nil, err
+				}
+			case "jitter":
+				var n int64
+				n, ok = vvm.ToInt64(value)
+				job.jitter = time.Duration(n)
+			case "args":
+				switch a := value.(type) {
+				case *vvm.Array:
+					job.args = append(job.args, a.Value...)
+				case *vvm.ImmutableArray:
+					job.args = append(job.args, a.Value...)
+				default:
+					ok = false
+				}
+			default:
+				return nil, fmt.Errorf("cron: unknown job option: %s", key)
+			}
+			if !ok {
+				return nil, fmt.Errorf("cron: invalid job option %s: %s", key, value.TypeName())
+			}
+		}
+	}
+	sched, err := cronParse(expr, loc)
+	if err != nil {
+		return nil, err
+	}
+	job.sched = sched
+	job.timer = &timesTimer{stop: make(chan struct{})}
+	// the first run is planned before the scheduler runs, so that it is
+	// known as soon as the job is returned
+	job.plan(ctx, timesNowOf(ctx))
+
+	routine, err := vvm.Start(ctx, &vvm.BuiltinFunction{Name: "cron", Value: job.schedule})
+	if err != nil {
+		return nil, err
+	}
+	return job.object(routine), nil
+}
+
+// schedule waits for the due runs of job until it is stopped.
+func (job *cronJob) schedule(ctx context.Context, _ ...vvm.Object) (vvm.Object, error) {
+	for {
+		job.mu.Lock()
+		at := job.nextAt
+		job.mu.Unlock()
+		if at.IsZero() || !job.timer.reset(at) {
+			return vvm.UndefinedValue, nil
+		}
+		v, err := job.timer.recv(ctx)
+		if err != nil || v == vvm.UndefinedValue {
+			return v, err
+		}
+		if err := job.fire(ctx); err != nil {
+			return nil, err
+		}
+		job.plan(ctx, job.due)
+	}
+}
+
+// plan sets the next run of job to the first one due after prev, or after
+// the current time if that has passed already: runs missed while the
+// scheduler was delayed are dropped.
+func (job *cronJob) plan(ctx context.Context, prev time.Time) {
+	now := timesNowOf(ctx)
+	due := job.sched.next(prev)
+	if !due.IsZero() && !due.After(now) {
+		due = job.sched.next(now)
+	}
+	at := due
+	if !due.IsZero() && job.jitter > 0 {
+		at = at.Add(time.Duration(cronRand(ctx).Int63n(int64(job.jitter))))
+	}
+	job.mu.Lock()
+	job.due, job.nextAt = due, at
+	job.mu.Unlock()
+}
+
+// cronRand returns the random number generator used for jitter.
+func cronRand(ctx context.Context) interface{ Int63n(int64) int64 } {
+	if d := vvm.DeterministicOf(ctx); d != nil {
+		return d.Rand()
+	}
+	return globalRand{}
+}
+
+// fire starts a run of job as its overlap policy allows.
+func (job *cronJob) fire(ctx context.Context) error {
+	job.mu.Lock()
+	if job.overlap != cronConcurrent && job.running > 0 {
+		if job.overlap == cronQueue {
+			job.queued++
+		} else {
+			job.skipped++
+		}
+		job.mu.Unlock()
+		return nil
+	}
+	job.running++
+	job.runs++
+	job.mu.Unlock()
+	_, err := vvm.Start(ctx, &vvm.BuiltinFunction{Name: "cron_run", Value: job.run})
+	if err != nil {
+		job.mu.Lock()
+		job.running--
+		job.mu.Unlock()
+	}
+	return err
+}
+
+// run calls the function of job, and again for each run queued meanwhile
+// unless job was stopped.
+func (job *cronJob) run(ctx context.Context, _ ...vvm.Object) (vvm.Object, error) {
+	for {
+		res, err := vvm.Invoke(ctx, job.fn, job.args...)
+		job.mu.Lock()
+		if err == nil && job.queued > 0 && !job.timer.cancelled() {
+			job.queued--
+			job.runs++
+			job.mu.Unlock()
+			continue
+		}
+		job.running--
+		job.mu.Unlock()
+		return res, err
+	}
+}
+
+// object returns the job object of job, whose scheduler runs in routine.
+func (job *cronJob) object(routine vvm.Object) vvm.Object {
+	count := func(name string, n *int64) *vvm.BuiltinFunction {
+		return &vvm.BuiltinFunction{
+			Name: name,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				job.mu.Lock()
+				defer job.mu.Unlock()
+				return &vvm.Int{Value: *n}, nil
+			},
+		}
+	}
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"expr":    &vvm.String{Value: job.sched.expr},
+		"routine": routine,
+		"stop": &vvm.BuiltinFunction{
+			Name: "stop",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				if job.timer.shutdown() {
+					return vvm.TrueValue, nil
+				}
+				return vvm.FalseValue, nil
+			},
+		},
+		"next": &vvm.BuiltinFunction{
+			Name: "next",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				job.mu.Lock()
+				defer job.mu.Unlock()
+				if job.timer.cancelled() {
+					return vvm.UndefinedValue, nil
+				}
+				return cronTime(job.nextAt), nil
+			},
+		},
+		"runs":    count("runs", &job.runs),
+		"skipped": count("skipped", &job.skipped),
+		"running": count("running", &job.running),
+	}}
+}
@@ -0,0 +1,300 @@
+package stdlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	expr                 string
+	second, minute, hour uint64
+	dom, month, dow      uint64
+	domAny, dowAny       bool          // day field starts with * or is ?
+	every                time.Duration // set for @every
+	loc                  *time.Location
+}
+
+// cronField describes the values of a field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecond = cronField{name: "second", min: 0, max: 59}
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is Sunday as well
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// cronParse parses a cron expression of 5 fields, or 6 with seconds first,
+// a descriptor such as @daily, or "@every <duration>". A CRON_TZ= or TZ=
+// prefix sets the time zone, which otherwise is loc.
+func cronParse(expr string, loc *time.Location) (*cronSchedule, error) {
+	s := &cronSchedule{expr: expr, loc: loc}
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("cron: missing fields in %q", expr)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("cron: unknown time zone %q", name)
+		}
+		s.loc, spec = l, strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("cron: invalid duration in %q", expr)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("cron: non-positive duration in %q", expr)
+		}
+		s.every = d
+		return s, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		d, ok := cronDescriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown descriptor %q", spec)
+		}
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields in %q, found %d", expr, len(fields))
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.second, cronSecond},
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dom, cronDom},
+		{&s.month, cronMonth},
+		{&s.dow, cronDow},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[3], "*") || fields[3] == "?"
+	s.dowAny = strings.HasPrefix(fields[5], "*") || fields[5] == "?"
+	return s, nil
+}
+
+// parse returns the bit set of the values matched by the field value s,
+// a comma-separated list of values, ranges and steps.
+func (f cronField) parse(s string) (uint64, error) {
+	top := f.max
+	if f.max == 7 {
+		top = 6 // 7 only names Sunday
+	}
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %s field %q", f.name, s)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := f.min, top
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("cron: invalid range in %s field %q", f.name, s)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			if step > 1 {
+				// a/n is a-max/n
+				hi = top
+			} else {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value returns the number or name s of a value of the field.
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron: invalid %s %q", f.name, s)
+	}
+	return v, nil
+}
+
+// next returns the first time after t that s matches, in the location of t,
+// or the zero time if there is none within five years. Schedules match the
+// wall clock of their location: a wall-clock time repeated by a daylight
+// saving change matches once, and one skipped by it matches at the change.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	w := cronWall(t.In(s.loc))
+	for {
+		if w = s.nextWall(w); w.IsZero() {
+			return time.Time{}
+		}
+		// a repeated wall-clock time that matched before t already
+		if n := cronLocal(w, s.loc); n.After(t) {
+			return n.In(t.Location())
+		}
+	}
+}
+
+// nextWall returns the first wall-clock time after w, given in UTC, that s
+// matches, or the zero time if there is none within five years.
+func (s *cronSchedule) nextWall(t time.Time) time.Time {
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	added := false
+	limit := t.Year() + 5
+
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Hour)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for s.second&(1<<uint(t.Second())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// cronWall returns the wall-clock time of t as a time in UTC.
+func cronWall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// cronLocal returns the first time in loc with the wall-clock time w, or the
+// time of the daylight saving change that skipped w.
+func cronLocal(w time.Time, loc *time.Location) time.Time {
+	t := time.Date(w.Year(), w.Month(), w.Day(),
+		w.Hour(), w.Minute(), w.Second(), w.Nanosecond(), loc)
+	if cronWall(t).Equal(w) {
+		return t
+	}
+	start, end := t.ZoneBounds()
+	if cronWall(t).After(w) {
+		return start
+	}
+	return end
+}
+
+// dayMatches reports whether the day of t matches both day fields, or
+// either one if neither starts with * or is ?, as in standard cron.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,162 @@
+package stdlib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+func TestCronParse(t *testing.T) {
+	next := func(expr, tz string, n int) string {
+		return `
+cron := import("cron")
+times := import("times")
+from := times.parse(times.format_rfc3339, "2024-01-01T00:00:00Z")
+out := []
+for t in cron.parse("` + expr + `"` + tz + `).next_n(` + string(rune('0'+n)) + `, from) {
+	out = append(out, times.time_format(t, times.format_rfc3339))
+}
+out = string(out)`
+	}
+	for _, tc := range []struct {
+		expr, tz string
+		n        int
+		want     string
+	}{
+		{"*/15 * * * *", "", 2, `["2024-01-01T00:15:00Z", "2024-01-01T00:30:00Z"]`},
+		{"0 9 * * mon-fri", "", 1, `["2024-01-01T09:00:00Z"]`},
+		{"30 * * * * *", "", 2, `["2024-01-01T00:00:30Z", "2024-01-01T00:01:30Z"]`},
+		{"0 0 29 2 *", "", 2, `["2024-02-29T00:00:00Z", "2028-02-29T00:00:00Z"]`},
+		{"0 0 30 2 *", "", 1, `[]`},
+		{"0 0 1,15 * sun", "", 3, `["2024-01-07T00:00:00Z", "2024-01-14T00:00:00Z", "2024-01-15T00:00:00Z"]`},
+		{"0 0 */10 * 7", "", 2, `["2024-01-21T00:00:00Z", "2024-02-11T00:00:00Z"]`},
+		{"@weekly", "", 1, `["2024-01-07T00:00:00Z"]`},
+		{"@every 90s", "", 2, `["2024-01-01T00:01:30Z", "2024-01-01T00:03:00Z"]`},
+		{"0 9 * * *", `, "America/New_York"`, 1, `["2024-01-01T14:00:00Z"]`},
+		{"CRON_TZ=Asia/Tokyo 0 9 * * *", `, "America/New_York"`, 1, `["2024-01-02T00:00:00Z"]`},
+	} {
+		expect(t, next(tc.expr, tc.tz, tc.n), nil, nil, tc.want)
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	next := func(expr, tz, from string) string {
+		return `
+cron := import("cron")
+times := import("times")
+from := times.parse(times.format_rfc3339, "` + from + `")
+out := []
+for t in cron.parse("` + expr + `", "` + tz + `").next_n(3, from) {
+	out = append(out, times.time_format(times.to_utc(t), times.format_rfc3339))
+}
+out = string(out)`
+	}
+	for _, tc := range []struct {
+		expr, tz, from string
+		want           string
+	}{
+		// 01:30 is repeated on 2026-11-01 and runs only once
+		{"0 30 1 * * *", "America/New_York", "2026-10-31T12:00:00Z",
+			`["2026-11-01T05:30:00Z", "2026-11-02T06:30:00Z", "2026-11-03T06:30:00Z"]`},
+		{"0 30 1 * * *", "America/New_York", "2026-11-01T06:00:00Z",
+			`["2026-11-02T06:30:00Z", "2026-11-03T06:30:00Z", "2026-11-04T06:30:00Z"]`},
+		{"0 * * * *", "America/New_York", "2026-11-01T04:30:00Z",
+			`["2026-11-01T05:00:00Z", "2026-11-01T07:00:00Z", "2026-11-01T08:00:00Z"]`},
+		// 02:30 is skipped on 2026-03-29 and runs at the change to 03:00
+		{"30 2 * * *", "Europe/Berlin", "2026-03-27T12:00:00Z",
+			`["2026-03-28T01:30:00Z", "2026-03-29T01:00:00Z", "2026-03-30T00:30:00Z"]`},
+		{"*/20 2 * * *", "Europe/Berlin", "2026-03-29T00:30:00Z",
+			`["2026-03-29T01:00:00Z", "2026-03-30T00:00:00Z", "2026-03-30T00:20:00Z"]`},
+	} {
+		expect(t, next(tc.expr, tc.tz, tc.from), nil, nil, tc.want)
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`cron := import("cron");` + input))
+		s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`cron.parse("* * *")`, "expected 5 or 6 fields")
+	expectErr(`cron.parse("60 * * * *")`, "invalid minute")
+	expectErr(`cron.parse("* * * foo *")`, "invalid month")
+	expectErr(`cron.parse("5-1 * * * *")`, "invalid range")
+	expectErr(`cron.parse("*/0 * * * *")`, "invalid step")
+	expectErr(`cron.parse("@sometimes")`, "unknown descriptor")
+	expectErr(`cron.parse("@every -1s")`, "non-positive duration")
+	expectErr(`cron.parse("* * * * *", "Nowhere/City")`, "unknown time zone")
+	expectErr(`cron.schedule("* * * * *", 1)`, "callable")
+	expectErr(`cron.schedule("* * * * *", func() {}, {overlap: "never"})`, "invalid overlap policy")
+	expectErr(`cron.schedule("* * * * *", func() {}, {when: 1})`, "unknown job option")
+}
+
+func TestCronSchedule(t *testing.T) {
+	expect(t, `
+cron := import("cron")
+c := chan()
+job := cron.schedule("@every 5ms", func(x) { c.send(x) }, {args: [7]})
+a := c.recv()
+b := c.recv()
+out := string([a, b, job.stop(), job.runs() >= 2, job.stop(), is_undefined(job.next())])
+`, nil, nil, "[7, 7, true, true, false, true]")
+
+	// runs take 25ms and are due every 10ms from 10ms on, until the job is
+	// stopped at 42ms
+	overlap := `
+cron := import("cron")
+times := import("times")
+job := cron.schedule("@every 10ms", func() { times.sleep(25 * times.millisecond) }, {overlap: "%s"})
+times.sleep(42 * times.millisecond)
+job.stop()
+out := string([job.runs(), job.skipped(), job.running()])
+`
+	for _, tc := range []struct{ overlap, want string }{
+		{"skip", "[2, 2, 1]"},
+		{"queue", "[2, 0, 1]"},
+		{"concurrent", "[4, 0, 3]"},
+	} {
+		s := vv.NewScript([]byte(strings.Replace(overlap, "%s", tc.overlap, 1)))
+		s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		s.SetDeterministic(1)
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, tc.want, c.Get("out").Value(), tc.overlap)
+	}
+
+	s := vv.NewScript([]byte(`
+cron := import("cron")
+times := import("times")
+job := cron.schedule("* * * * *", func() {}, {jitter: times.second})
+d := times.sub(job.next(), cron.parse("* * * * *").next())
+out := d >= 0 && d < times.second
+job.stop()
+`))
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	s.SetDeterministic(1)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, true, c.Get("out").Value())
+}
+
+func TestCronAbort(t *testing.T) {
+	script := vv.NewScript([]byte(`
+cron := import("cron")
+cron.schedule("@every 1ms", func() {})
+cron.schedule("@every 1h", func() {})
+`))
+	script.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := script.RunContext(ctx)
+	require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,356 @@
+package stdlib
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/malivvan/vv/vvm"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var cryptoModule = map[string]vvm.Object{
+	"md5":                   &vvm.BuiltinFunction{Name: "md5", Value: cryptoSumFunc("md5")},
+	"sha1":                  &vvm.BuiltinFunction{Name: "sha1", Value: cryptoSumFunc("sha1")},
+	"sha256":                &vvm.BuiltinFunction{Name: "sha256", Value: cryptoSumFunc("sha256")},
+	"sha512":                &vvm.BuiltinFunction{Name: "sha512", Value: cryptoSumFunc("sha512")},
+	"blake2b":               &vvm.BuiltinFunction{Name: "blake2b", Value: cryptoSumFunc("blake2b")},
+	"hasher":                &vvm.BuiltinFunction{Name: "hasher", Value: cryptoHasher},
+	"hmac":                  &vvm.BuiltinFunction{Name: "hmac", Value: cryptoHMAC},
+	"aes_gcm_seal":          &vvm.BuiltinFunction{Name: "aes_gcm_seal", Value: cryptoSealFunc(cryptoAESGCM)},
+	"aes_gcm_open":          &vvm.BuiltinFunction{Name: "aes_gcm_open", Value: cryptoOpenFunc(cryptoAESGCM)},
+	"chacha20poly1305_seal": &vvm.BuiltinFunction{Name: "chacha20poly1305_seal", Value: cryptoSealFunc(chacha20poly1305.New)},
+	"chacha20poly1305_open": &vvm.BuiltinFunction{Name: "chacha20poly1305_open", Value: cryptoOpenFunc(chacha20poly1305.New)},
+	"ed25519_keygen":        &vvm.BuiltinFunction{Name: "ed25519_keygen", Value: cryptoEd25519Keygen},
+	"ed25519_sign":          &vvm.BuiltinFunction{Name: "ed25519_sign", Value: cryptoEd25519Sign},
+	"ed25519_verify":        &vvm.BuiltinFunction{Name: "ed25519_verify", Value: cryptoEd25519Verify},
+	"constant_time_compare": &vvm.BuiltinFunction{Name: "constant_time_compare", Value: cryptoCompare},
+	"rand_bytes":            &vvm.BuiltinFunction{Name: "rand_bytes", Value: cryptoRandBytes},
+}
+
+// cryptoHashes are the hash functions by name.
+var cryptoHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+}
+
+// cryptoHashArg returns the hash function named by the argument at index i.
+func cryptoHashArg(args []vvm.Object, i int) (func() hash.Hash, error) {
+	name, err := netStringArg(args, i)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := cryptoHashes[name]
+	if !ok {
+		return nil, fmt.Errorf("crypto: unknown hash %q", name)
+	}
+	return h, nil
+}
+
+// cryptoSumFunc returns the function returning the digest of its data with
+// the hash function name.
+func cryptoSumFunc(name string) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if len(args) != 1 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		data, err := netDataArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		h := cryptoHashes[name]()
+		h.Write(data)
+		return &vvm.Bytes{Value: h.Sum(nil)}, nil
+	}
+}
+
+func cryptoHasher(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	newHash, err := cryptoHashArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	if len(args) == 2 {
+		key, err := netDataArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		h = hmac.New(newHash, key)
+	} else {
+		h = newHash()
+	}
+	return cryptoHasherObject(h), nil
+}
+
+// cryptoHasherObject returns the script object of the streaming hash h.
+func cryptoHasherObject(h hash.Hash) *vvm.ImmutableMap {
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"size":       &vvm.Int{Value: int64(h.Size())},
+			"block_size": &vvm.Int{Value: int64(h.BlockSize())},
+			"write": &vvm.BuiltinFunction{
+				Name: "write",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 1 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					data, err := netDataArg(args, 0)
+					if err != nil {
+						return nil, err
+					}
+					h.Write(data)
+					return vvm.UndefinedValue, nil
+				},
+			},
+			"sum": &vvm.BuiltinFunction{
+				Name: "sum",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					return &vvm.Bytes{Value: h.Sum(nil)}, nil
+				},
+			},
+			"reset": &vvm.BuiltinFunction{
+				Name: "reset",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					h.Reset()
+					return vvm.UndefinedValue, nil
+				},
+			},
+		},
+	}
+}
+
+func cryptoHMAC(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	newHash, err := cryptoHashArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	key, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := netDataArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(newHash, key)
+	h.Write(data)
+	return &vvm.Bytes{Value: h.Sum(nil)}, nil
+}
+
+func cryptoAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cryptoAEADArgs returns the key, the data and the optional additional data
+// of the arguments of the seal and open functions.
+func cryptoAEADArgs(args []vvm.Object) (key, data, ad []byte, err error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, nil, nil, vvm.ErrWrongNumArguments
+	}
+	if key, err = netDataArg(args, 0); err != nil {
+		return nil, nil, nil, err
+	}
+	if data, err = netDataArg(args, 1); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(args) == 3 {
+		if ad, err = netDataArg(args, 2); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return key, data, ad, nil
+}
+
+// cryptoSealFunc returns the function encrypting and authenticating data
+// with the cipher created by newAEAD. The result is a random nonce followed
+// by the sealed data.
+func cryptoSealFunc(newAEAD func(key []byte) (cipher.AEAD, error)) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		key, data, ad, err := cryptoAEADArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return wrapError(err), nil
+		}
+		if aead.NonceSize()+len(data)+aead.Overhead() > vvm.MaxBytesLen {
+			return nil, vvm.ErrBytesLimit
+		}
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return wrapError(err), nil
+		}
+		return &vvm.Bytes{Value: aead.Seal(nonce, nonce, data, ad)}, nil
+	}
+}
+
+// cryptoOpenFunc returns the function authenticating and decrypting data
+// sealed by the function of cryptoSealFunc.
+func cryptoOpenFunc(newAEAD func(key []byte) (cipher.AEAD, error)) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		key, data, ad, err := cryptoAEADArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return wrapError(err), nil
+		}
+		if len(data) < aead.NonceSize() {
+			return wrapError(errors.New("crypto: ciphertext too short")), nil
+		}
+		nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil {
+			return wrapError(err), nil
+		}
+		return &vvm.Bytes{Value: plain}, nil
+	}
+}
+
+func cryptoEd25519Keygen(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) > 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var priv ed25519.PrivateKey
+	if len(args) == 1 {
+		seed, err := netDataArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return wrapError(fmt.Errorf("crypto: seed must be %d bytes", ed25519.SeedSize)), nil
+		}
+		priv = ed25519.NewKeyFromSeed(seed)
+	} else {
+		var err error
+		if _, priv, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return wrapError(err), nil
+		}
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"public":  &vvm.Bytes{Value: priv.Public().(ed25519.PublicKey)},
+			"private": &vvm.Bytes{Value: priv},
+		},
+	}, nil
+}
+
+func cryptoEd25519Sign(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	key, err := netDataArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return wrapError(fmt.Errorf("crypto: private key must be %d bytes", ed25519.PrivateKeySize)), nil
+	}
+	return &vvm.Bytes{Value: ed25519.Sign(key, msg)}, nil
+}
+
+func cryptoEd25519Verify(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	key, err := netDataArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := netDataArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return wrapError(fmt.Errorf("crypto: public key must be %d bytes", ed25519.PublicKeySize)), nil
+	}
+	if ed25519.Verify(key, msg, sig) {
+		return vvm.TrueValue, nil
+	}
+	return vvm.FalseValue, nil
+}
+
+func cryptoCompare(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	a, err := netDataArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := netDataArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(a, b) == 1 {
+		return vvm.TrueValue, nil
+	}
+	return vvm.FalseValue, nil
+}
+
+func cryptoRandBytes(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	n, ok := vvm.ToInt(args[0])
+	if !ok || n < 0 {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "non-negative int",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if n > vvm.MaxBytesLen {
+		return nil, vvm.ErrBytesLimit
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return wrapError(err), nil
+	}
+	return &vvm.Bytes{Value: b}, nil
+}
@@ -0,0 +1,147 @@
+package stdlib_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+const cryptoImports = `crypto := import("crypto"); hex := import("hex");`
+
+func TestCryptoHash(t *testing.T) {
+	module(t, "crypto").call("md5", "abc").
+		expect(unhex("900150983cd24fb0d6963f7d28e17f72"))
+	module(t, "crypto").call("sha1", "abc").
+		expect(unhex("a9993e364706816aba3e25717850c26c9cd0d89d"))
+	module(t, "crypto").call("sha256", []byte("abc")).
+		expect(unhex("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"))
+	module(t, "crypto").call("sha512", "abc").
+		expect(unhex("ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a" +
+			"2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"))
+	module(t, "crypto").call("blake2b", "abc").
+		expect(unhex("ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+			"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"))
+
+	expect(t, cryptoImports+`
+h := crypto.hasher("sha256")
+h.write("a")
+h.write(bytes("bc"))
+a := hex.encode(h.sum())
+h.reset()
+out := [a, a == hex.encode(crypto.sha256("abc")), hex.encode(h.sum()), h.size, h.block_size]
+`, nil, nil, ARR{"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", true,
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 32, 64})
+}
+
+func TestCryptoHMAC(t *testing.T) {
+	// RFC 4231, test case 2.
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	module(t, "crypto").call("hmac", "sha256", "Jefe", "what do ya want for nothing?").
+		expect(unhex(want))
+	expect(t, cryptoImports+`
+h := crypto.hasher("sha256", "Jefe")
+h.write("what do ya want ")
+h.write("for nothing?")
+out := hex.encode(h.sum())
+`, nil, nil, want)
+}
+
+func TestCryptoAEAD(t *testing.T) {
+	for _, name := range []string{"aes_gcm", "chacha20poly1305"} {
+		expect(t, cryptoImports+`
+key := crypto.rand_bytes(32)
+sealed := crypto.`+name+`_seal(key, "secret", "header")
+again := crypto.`+name+`_seal(key, "secret", "header")
+tampered := sealed[:len(sealed)-1]
+out := [
+	string(crypto.`+name+`_open(key, sealed, "header")),
+	sealed != again,
+	is_error(crypto.`+name+`_open(key, sealed)),
+	is_error(crypto.`+name+`_open(key, tampered, "header")),
+	is_error(crypto.`+name+`_open(crypto.rand_bytes(32), sealed, "header")),
+	is_error(crypto.`+name+`_open(key, "short")),
+	is_error(crypto.`+name+`_seal("short key", "secret")),
+	string(crypto.`+name+`_open(key, crypto.`+name+`_seal(key, bytes(""))))
+]
+`, nil, nil, ARR{"secret", true, true, true, true, true, true, ""})
+	}
+}
+
+func TestCryptoEd25519(t *testing.T) {
+	// RFC 8032, test 1.
+	seed := unhex("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	pub := unhex("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	sig := unhex("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155" +
+		"5fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	module(t, "crypto").call("ed25519_verify", pub, "", sig).expect(true)
+	module(t, "crypto").call("ed25519_verify", pub, "x", sig).expect(false)
+
+	expect(t, cryptoImports+`
+k := crypto.ed25519_keygen(seed)
+r := crypto.ed25519_keygen()
+sig := crypto.ed25519_sign(r.private, "msg")
+out := [
+	k.public,
+	crypto.ed25519_sign(k.private, ""),
+	len(r.public), len(r.private),
+	crypto.ed25519_verify(r.public, "msg", sig),
+	crypto.ed25519_verify(k.public, "msg", sig),
+	is_error(crypto.ed25519_keygen("short")),
+	is_error(crypto.ed25519_sign(r.public, "msg")),
+	is_error(crypto.ed25519_verify(r.private, "msg", sig))
+]
+`, nil, map[string]interface{}{"seed": seed}, ARR{pub, sig, 32, 64, true, false, true, true, true})
+}
+
+func TestCryptoMisc(t *testing.T) {
+	module(t, "crypto").call("constant_time_compare", "abc", []byte("abc")).expect(true)
+	module(t, "crypto").call("constant_time_compare", "abc", "abd").expect(false)
+	module(t, "crypto").call("constant_time_compare", "abc", "ab").expect(false)
+	module(t, "crypto").call("rand_bytes", 0).expect([]byte{})
+
+	expect(t, cryptoImports+`
+a := crypto.rand_bytes(16)
+out := [len(a), a != crypto.rand_bytes(16)]
+`, nil, nil, ARR{16, true})
+
+	// Random bytes stay secret in a deterministic run: they are never drawn
+	// from the run seed.
+	run := func() []byte {
+		s := vv.NewScript([]byte(`crypto := import("crypto"); out := crypto.rand_bytes(8)`))
+		s.SetImports(stdlib.GetModuleMap("crypto"))
+		s.SetDeterministic(7)
+		c, err := s.Run()
+		require.NoError(t, err)
+		return c.Get("out").Bytes()
+	}
+	require.False(t, bytes.Equal(run(), run()))
+}
+
+func TestCryptoErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`crypto := import("crypto");` + input))
+		s.SetImports(stdlib.GetModuleMap("crypto"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`crypto.sha256()`, "wrong number of arguments")
+	expectErr(`crypto.sha256(undefined)`, "invalid type for argument 'first'")
+	expectErr(`crypto.hasher("sha3")`, `unknown hash "sha3"`)
+	expectErr(`crypto.hmac("crc32", "k", "d")`, `unknown hash "crc32"`)
+	expectErr(`crypto.aes_gcm_seal(crypto.rand_bytes(16), undefined)`, "invalid type for argument 'second'")
+	expectErr(`crypto.rand_bytes(-1)`, "invalid type for argument 'first'")
+}
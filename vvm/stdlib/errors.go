@@ -1,12 +1,33 @@
 package stdlib
 
 import (
+	"fmt"
+
 	"github.com/malivvan/vv/vvm"
 )
 
+// ErrPermissionDenied is returned to scripts when the module policy does not
+// grant the capability required by a call.
+type ErrPermissionDenied struct {
+	Capability string
+	Resource   string
+}
+
+func (e ErrPermissionDenied) Error() string {
+	if e.Resource == "" {
+		return fmt.Sprintf("permission denied: %s", e.Capability)
+	}
+	return fmt.Sprintf("permission denied: %s %s", e.Capability, e.Resource)
+}
+
+// ErrorCode returns the code of the script error created for the error.
+func (e ErrPermissionDenied) ErrorCode() string {
+	return "permission_denied"
+}
+
 func wrapError(err error) vvm.Object {
 	if err == nil {
 		return vvm.TrueValue
 	}
-	return &vvm.Error{Value: &vvm.String{Value: err.Error()}}
+	return vvm.NewError(err)
 }
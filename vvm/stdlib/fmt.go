@@ -16,7 +16,7 @@ var fmtModule = map[string]vvm.Object{
 
 func fmtPrint(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
 	vm := ctx.Value(vvm.ContextKey("vm")).(*vvm.VM)
-	printArgs, err := getPrintArgs(args...)
+	printArgs, err := getPrintArgs(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +44,11 @@ func fmtPrintf(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err err
 		return nil, nil
 	}
 
-	s, err := vvm.Format(format.Value, args[1:]...)
+	fmtArgs, err := vvm.StrHooks(ctx, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	s, err := vvm.Format(format.Value, fmtArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +58,7 @@ func fmtPrintf(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err err
 
 func fmtPrintln(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
 	vm := ctx.Value(vvm.ContextKey("vm")).(*vvm.VM)
-	printArgs, err := getPrintArgs(args...)
+	printArgs, err := getPrintArgs(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,14 +85,22 @@ func fmtSprintf(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 		// okay to return 'format' directly as String is immutable
 		return format, nil
 	}
-	s, err := vvm.Format(format.Value, args[1:]...)
+	fmtArgs, err := vvm.StrHooks(ctx, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	s, err := vvm.Format(format.Value, fmtArgs...)
 	if err != nil {
 		return nil, err
 	}
 	return &vvm.String{Value: s}, nil
 }
 
-func getPrintArgs(args ...vvm.Object) ([]interface{}, error) {
+func getPrintArgs(ctx context.Context, args ...vvm.Object) ([]interface{}, error) {
+	args, err := vvm.StrHooks(ctx, args)
+	if err != nil {
+		return nil, err
+	}
 	var printArgs []interface{}
 	l := 0
 	for _, arg := range args {
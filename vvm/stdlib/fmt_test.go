@@ -1,6 +1,15 @@
 package stdlib_test
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/parser"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
 
 func TestFmtSprintf(t *testing.T) {
 	module(t, `fmt`).call("sprintf", "").expect("")
@@ -17,3 +26,35 @@ func TestFmtSprintf(t *testing.T) {
 	module(t, `fmt`).call("sprintf", "%v", IARR{1, IARR{2, IARR{3, 4}}}).
 		expect(`[1, [2, [3, 4]]]`)
 }
+
+// fmtHooked defines a map that is converted to a string by its __str__ hook.
+const fmtHooked = `
+fmt := import("fmt")
+v := {x: 1, __str__: func(a) { return "<" + string(a.x) + ">" }}
+`
+
+func TestFmtStrHook(t *testing.T) {
+	expect(t, fmtHooked+`out := fmt.sprintf("%v %s %q %T %d", v, v, v, v, 2)`,
+		nil, nil, `<1> <1> "<1>" map 2`)
+	expect(t, fmtHooked+`out := format("%v", v)`, nil, nil, "<1>")
+
+	src := fmtHooked + `
+fmt.print(v, " ")
+fmt.printf("%v ", v)
+fmt.println(v)`
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(src))
+	file, err := parser.NewParser(srcFile, []byte(src), nil).ParseFile()
+	require.NoError(t, err)
+	symTable := vvm.NewSymbolTable()
+	for idx, fn := range vvm.GetAllBuiltinFunctions() {
+		symTable.DefineBuiltin(idx, fn.Name)
+	}
+	c := vvm.NewCompiler(srcFile, symTable, nil, stdlib.GetModuleMap("fmt"), nil)
+	require.NoError(t, c.Compile(file))
+	var out bytes.Buffer
+	v := vvm.NewVM(context.Background(), c.Bytecode(), nil, -1)
+	v.Out = &out
+	require.NoError(t, v.Run())
+	require.Equal(t, "<1> <1> <1>\n", out.String())
+}
@@ -0,0 +1,390 @@
+package stdlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/stdlib/json"
+)
+
+// httpDefaultClient makes the requests of the module functions. It has no
+// cookie jar.
+var httpDefaultClient = &httpClient{client: &http.Client{}}
+
+var httpModule = map[string]vvm.Object{
+	"get":       &vvm.BuiltinFunction{Name: "get", Value: httpDefaultClient.get},         // get(url string, opts map) => response/error
+	"post":      &vvm.BuiltinFunction{Name: "post", Value: httpDefaultClient.post},       // post(url string, body object, opts map) => response/error
+	"request":   &vvm.BuiltinFunction{Name: "request", Value: httpDefaultClient.request}, // request(method string, url string, opts map) => response/error
+	"client":    &vvm.BuiltinFunction{Name: "client", Value: httpNewClient},              // client(opts map) => client/error
+	"router":    &vvm.BuiltinFunction{Name: "router", Value: httpNewRouter},              // router() => router
+	"listen":    &vvm.BuiltinFunction{Name: "listen", Value: httpListen},                 // listen(addr string, handler router/func, opts map) => server/error
+	"serve":     &vvm.BuiltinFunction{Name: "serve", Value: httpServe},                   // serve(addr string, handler router/func, opts map) => undefined/error
+	"websocket": &vvm.BuiltinFunction{Name: "websocket", Value: httpWebSocket},           // websocket(url string, opts map) => websocket/error
+}
+
+// httpClient makes requests with the options of a client object.
+type httpClient struct {
+	client  *http.Client
+	headers http.Header   // sent with every request
+	timeout time.Duration // 0 for none
+}
+
+// optionsArg returns the map argument at index i, or nil if there is none.
+func optionsArg(args []vvm.Object, i int, name string) (map[string]vvm.Object, error) {
+	if len(args) <= i {
+		return nil, nil
+	}
+	switch o := args[i].(type) {
+	case *vvm.Map:
+		return o.Value, nil
+	case *vvm.ImmutableMap:
+		return o.Value, nil
+	default:
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "map",
+			Found:    args[i].TypeName(),
+		}
+	}
+}
+
+func httpNewClient(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) > 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	opts, err := optionsArg(args, 0, "first")
+	if err != nil {
+		return nil, err
+	}
+	c := &httpClient{client: &http.Client{}, headers: http.Header{}}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	c.client.Transport = transport
+	redirects, cookies := 10, true
+	for key, value := range opts {
+		ok := true
+		switch key {
+		case "headers":
+			ok = httpHeaders(c.headers, value)
+		case "timeout":
+			var n int64
+			n, ok = vvm.ToInt64(value)
+			c.timeout = time.Duration(n)
+		case "redirects":
+			redirects, ok = vvm.ToInt(value)
+		case "cookies":
+			cookies = !value.IsFalsy()
+		case "tls":
+			transport.TLSClientConfig, err = httpTLSConfig(value)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("http: unknown client option: %s", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("http: invalid client option %s: %s", key, value.TypeName())
+		}
+	}
+	if cookies {
+		c.client.Jar, _ = cookiejar.New(nil)
+	}
+	c.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if redirects <= 0 {
+			// the redirect response is returned as is
+			return http.ErrUseLastResponse
+		}
+		if len(via) > redirects {
+			return fmt.Errorf("stopped after %d redirects", redirects)
+		}
+		return nil
+	}
+	return c.object(), nil
+}
+
+// httpTLSConfig returns the TLS configuration described by the tls client
+// option.
+func httpTLSConfig(value vvm.Object) (*tls.Config, error) {
+	opts, err := optionsArg([]vvm.Object{value}, 0, "tls")
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	var cert, key []byte
+	for name, value := range opts {
+		ok := true
+		switch name {
+		case "insecure_skip_verify":
+			config.InsecureSkipVerify = !value.IsFalsy()
+		case "server_name":
+			config.ServerName, ok = vvm.ToString(value)
+		case "ca":
+			var pem []byte
+			if pem, ok = vvm.ToByteSlice(value); ok {
+				config.RootCAs = x509.NewCertPool()
+				if !config.RootCAs.AppendCertsFromPEM(pem) {
+					return nil, errors.New("http: no certificates in tls option ca")
+				}
+			}
+		case "cert":
+			cert, ok = vvm.ToByteSlice(value)
+		case "key":
+			key, ok = vvm.ToByteSlice(value)
+		default:
+			return nil, fmt.Errorf("http: unknown tls option: %s", name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("http: invalid tls option %s: %s", name, value.TypeName())
+		}
+	}
+	if cert != nil || key != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("http: invalid client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// httpHeaders adds the headers of value, a map of names to a value or an
+// array of values, to h. It reports false if value is invalid.
+func httpHeaders(h http.Header, value vvm.Object) bool {
+	return httpValues(value, func(name, value string) { h.Add(name, value) })
+}
+
+// httpValues calls add for the values of value, a map of names to a value or
+// an array of values. It reports false if value is invalid.
+func httpValues(value vvm.Object, add func(name, value string)) bool {
+	m, err := optionsArg([]vvm.Object{value}, 0, "")
+	if err != nil {
+		return false
+	}
+	for name, v := range m {
+		var values []vvm.Object
+		switch v := v.(type) {
+		case *vvm.Array:
+			values = v.Value
+		case *vvm.ImmutableArray:
+			values = v.Value
+		default:
+			values = []vvm.Object{v}
+		}
+		for _, v := range values {
+			s, ok := vvm.ToString(v)
+			if !ok {
+				return false
+			}
+			add(name, s)
+		}
+	}
+	return true
+}
+
+// object returns the client object of c.
+func (c *httpClient) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"get":     &vvm.BuiltinFunction{Name: "get", Value: c.get},
+		"post":    &vvm.BuiltinFunction{Name: "post", Value: c.post},
+		"request": &vvm.BuiltinFunction{Name: "request", Value: c.request},
+		"cookies": &vvm.BuiltinFunction{Name: "cookies", Value: c.cookies},
+	}}
+}
+
+func (c *httpClient) get(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return c.do(ctx, http.MethodGet, args, 0, nil, 1)
+}
+
+func (c *httpClient) post(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return c.do(ctx, http.MethodPost, args, 0, args[1], 2)
+}
+
+func (c *httpClient) request(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	method, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	return c.do(ctx, strings.ToUpper(method), args, 1, nil, 2)
+}
+
+// cookies returns the cookies the jar of c sends to a URL.
+func (c *httpClient) cookies(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	s, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	res := &vvm.Map{Value: map[string]vvm.Object{}}
+	if c.client.Jar != nil {
+		for _, cookie := range c.client.Jar.Cookies(u) {
+			res.Value[cookie.Name] = &vvm.String{Value: cookie.Value}
+		}
+	}
+	return res, nil
+}
+
+// do makes a request of method to the URL argument at index i with the
+// request options at index j, if any. body, if not nil, is sent as is if it
+// is a string or bytes, or else encoded as JSON.
+func (c *httpClient) do(ctx context.Context, method string, args []vvm.Object, i int, body vvm.Object, j int) (vvm.Object, error) {
+	s, ok := vvm.ToString(args[i])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "string(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	options, err := optionsArg(args, j, ordinal(j))
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return wrapError(err), nil
+	}
+
+	headers := c.headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	var data []byte
+	var contentType string
+	if body != nil {
+		if data, contentType, err = httpBody(body); err != nil {
+			return wrapError(err), nil
+		}
+	}
+	timeout, stream := c.timeout, false
+	for key, value := range options {
+		ok := true
+		switch key {
+		case "headers":
+			ok = httpHeaders(headers, value)
+		case "query":
+			q := u.Query()
+			if ok = httpValues(value, q.Add); ok {
+				u.RawQuery = q.Encode()
+			}
+		case "body":
+			if data, contentType, err = httpBody(value); err != nil {
+				return wrapError(err), nil
+			}
+		case "json":
+			if data, err = json.Encode(value); err != nil {
+				return wrapError(err), nil
+			}
+			contentType = "application/json"
+		case "form":
+			form := url.Values{}
+			if ok = httpValues(value, form.Add); ok {
+				data, contentType = []byte(form.Encode()), "application/x-www-form-urlencoded"
+			}
+		case "timeout":
+			var n int64
+			n, ok = vvm.ToInt64(value)
+			timeout = time.Duration(n)
+		case "stream":
+			stream = !value.IsFalsy()
+		default:
+			return nil, fmt.Errorf("http: unknown request option: %s", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("http: invalid request option %s: %s", key, value.TypeName())
+		}
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, method, u.String(), reader)
+	if err != nil {
+		cancel()
+		return wrapError(err), nil
+	}
+	req.Header = headers
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		return httpError(ctx, err)
+	}
+	if stream {
+		return newHTTPResponse(resp, nil, cancel), nil
+	}
+	defer cancel()
+	data, err = io.ReadAll(io.LimitReader(resp.Body, int64(vvm.MaxBytesLen)+1))
+	_ = resp.Body.Close()
+	if err != nil {
+		return httpError(ctx, err)
+	}
+	if len(data) > vvm.MaxBytesLen {
+		return nil, vvm.ErrBytesLimit
+	}
+	return newHTTPResponse(resp, data, nil), nil
+}
+
+// httpBody returns the data of a request body and its content type.
+func httpBody(body vvm.Object) ([]byte, string, error) {
+	switch body := body.(type) {
+	case *vvm.String:
+		return []byte(body.Value), "text/plain; charset=utf-8", nil
+	case *vvm.Bytes:
+		return body.Value, "application/octet-stream", nil
+	}
+	data, err := json.Encode(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, "application/json", nil
+}
+
+// httpError returns the result of a request that failed with err. The VM
+// is aborted if ctx, the context of the call, is done.
+func httpError(ctx context.Context, err error) (vvm.Object, error) {
+	if ctx.Err() != nil {
+		return nil, vvm.ErrVMAborted
+	}
+	return wrapError(err), nil
+}
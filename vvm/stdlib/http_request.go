@@ -0,0 +1,229 @@
+package stdlib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/stdlib/json"
+)
+
+// httpRequest is the request object passed to the handlers of a server. Its
+// body has been read completely; forms are parsed on first access.
+type httpRequest struct {
+	vvm.ObjectImpl
+	req     *http.Request
+	w       *httpWriter // answers the request
+	body    []byte
+	maxBody int64
+
+	once  sync.Once
+	form  *vvm.ImmutableMap
+	files *vvm.ImmutableMap
+	err   error // of parsing the form
+}
+
+// TypeName returns the name of the type.
+func (r *httpRequest) TypeName() string {
+	return "http-request"
+}
+
+func (r *httpRequest) String() string {
+	return fmt.Sprintf("<http-request %s %s>", r.req.Method, r.req.URL.Path)
+}
+
+// Copy returns r, whose fields cannot be changed.
+func (r *httpRequest) Copy() vvm.Object {
+	return r
+}
+
+// IndexGet returns the field or method of the request named by index.
+func (r *httpRequest) IndexGet(index vvm.Object) (vvm.Object, error) {
+	name, ok := vvm.ToString(index)
+	if !ok {
+		return nil, vvm.ErrInvalidIndexType
+	}
+	switch name {
+	case "method":
+		return &vvm.String{Value: r.req.Method}, nil
+	case "path":
+		return &vvm.String{Value: r.req.URL.Path}, nil
+	case "url":
+		return &vvm.String{Value: r.req.URL.RequestURI()}, nil
+	case "host":
+		return &vvm.String{Value: r.req.Host}, nil
+	case "remote_addr":
+		return &vvm.String{Value: r.req.RemoteAddr}, nil
+	case "proto":
+		return &vvm.String{Value: r.req.Proto}, nil
+	case "pattern":
+		return &vvm.String{Value: r.req.Pattern}, nil
+	case "headers":
+		return httpHeaderMap(r.req.Header), nil
+	case "params":
+		params := map[string]vvm.Object{}
+		for _, name := range httpPatternParams(r.req.Pattern) {
+			params[name] = &vvm.String{Value: r.req.PathValue(name)}
+		}
+		return &vvm.ImmutableMap{Value: params}, nil
+	case "query":
+		return httpFirstValues(r.req.URL.Query()), nil
+	case "cookies":
+		cookies := map[string]vvm.Object{}
+		for _, c := range r.req.Cookies() {
+			if _, ok := cookies[c.Name]; !ok {
+				cookies[c.Name] = &vvm.String{Value: c.Value}
+			}
+		}
+		return &vvm.ImmutableMap{Value: cookies}, nil
+	case "body":
+		return &vvm.Bytes{Value: r.body}, nil
+	case "form", "files":
+		r.once.Do(r.parseForm)
+		if r.err != nil {
+			return wrapError(r.err), nil
+		}
+		if name == "form" {
+			return r.form, nil
+		}
+		return r.files, nil
+	case "header":
+		return &vvm.BuiltinFunction{Name: "header", Value: r.header}, nil
+	case "json":
+		return &vvm.BuiltinFunction{Name: "json", Value: r.json}, nil
+	case "upgrade":
+		return &vvm.BuiltinFunction{Name: "upgrade", Value: wsUpgradeFunc(r)}, nil
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// parseForm parses the URL encoded or multipart form of the body.
+func (r *httpRequest) parseForm() {
+	req := r.req.Clone(context.Background())
+	req.Body = io.NopCloser(bytes.NewReader(r.body))
+	// the body is limited already, files are kept in memory
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		r.err = req.ParseMultipartForm(r.maxBody)
+	} else {
+		r.err = req.ParseForm()
+	}
+	if r.err != nil {
+		return
+	}
+	r.form = httpFirstValues(req.PostForm)
+	files := map[string]vvm.Object{}
+	if req.MultipartForm != nil {
+		for name, headers := range req.MultipartForm.File {
+			arr := &vvm.ImmutableArray{}
+			for _, fh := range headers {
+				file, err := httpFormFile(fh)
+				if err != nil {
+					r.err = err
+					return
+				}
+				arr.Value = append(arr.Value, file)
+			}
+			files[name] = arr
+		}
+	}
+	r.files = &vvm.ImmutableMap{Value: files}
+}
+
+// httpFormFile returns the object of a file uploaded with a multipart form.
+func httpFormFile(fh *multipart.FileHeader) (vvm.Object, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"filename":     &vvm.String{Value: fh.Filename},
+		"content_type": &vvm.String{Value: fh.Header.Get("Content-Type")},
+		"size":         &vvm.Int{Value: fh.Size},
+		"data":         &vvm.Bytes{Value: data},
+	}}, nil
+}
+
+// header returns the values of a request header, joined by commas. Its
+// name is case insensitive.
+func (r *httpRequest) header(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	name, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	values := r.req.Header.Values(name)
+	if len(values) == 0 {
+		return vvm.UndefinedValue, nil
+	}
+	return &vvm.String{Value: strings.Join(values, ", ")}, nil
+}
+
+func (r *httpRequest) json(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	v, err := json.Decode(r.body)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return v, nil
+}
+
+// httpHeaderMap returns a map of the names of h to their values, joined by
+// commas.
+func httpHeaderMap(h http.Header) *vvm.ImmutableMap {
+	headers := make(map[string]vvm.Object, len(h))
+	for name, values := range h {
+		headers[name] = &vvm.String{Value: strings.Join(values, ", ")}
+	}
+	return &vvm.ImmutableMap{Value: headers}
+}
+
+// httpFirstValues returns a map of the names of values to their first value.
+func httpFirstValues(values map[string][]string) *vvm.ImmutableMap {
+	m := make(map[string]vvm.Object, len(values))
+	for name, v := range values {
+		if len(v) > 0 {
+			m[name] = &vvm.String{Value: v[0]}
+		}
+	}
+	return &vvm.ImmutableMap{Value: m}
+}
+
+// httpPatternParams returns the names of the wildcards of a route pattern,
+// such as id for "GET /items/{id}".
+func httpPatternParams(pattern string) []string {
+	var names []string
+	for {
+		i := strings.IndexByte(pattern, '{')
+		if i < 0 {
+			return names
+		}
+		j := strings.IndexByte(pattern[i:], '}')
+		if j < 0 {
+			return names
+		}
+		name := strings.TrimSuffix(pattern[i+1:i+j], "...")
+		if name != "$" {
+			names = append(names, name)
+		}
+		pattern = pattern[i+j+1:]
+	}
+}
@@ -0,0 +1,238 @@
+package stdlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/stdlib/json"
+)
+
+// httpResponse is the response object returned by the requests of the http
+// module. Its body is either read completely or streamed by its reader.
+type httpResponse struct {
+	vvm.ObjectImpl
+	resp    *http.Response
+	body    []byte
+	headers *vvm.ImmutableMap
+	reader  *vvm.ImmutableMap // set if the body is streamed
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // cancels a streamed request
+}
+
+func newHTTPResponse(resp *http.Response, body []byte, cancel context.CancelFunc) *httpResponse {
+	r := &httpResponse{resp: resp, body: body, headers: httpHeaderMap(resp.Header), cancel: cancel}
+	if body == nil {
+		r.reader = &vvm.ImmutableMap{Value: map[string]vvm.Object{
+			// read(bytes) => int/error
+			"read": &vvm.BuiltinFunction{
+				Name:  "read",
+				Value: FuncAYRIE(resp.Body.Read),
+			},
+			// read_all() => bytes/error
+			"read_all": &vvm.BuiltinFunction{
+				Name:  "read_all",
+				Value: r.readAll,
+			},
+			// close() => true/error
+			"close": &vvm.BuiltinFunction{
+				Name:  "close",
+				Value: FuncARE(r.close),
+			},
+		}}
+	}
+	return r
+}
+
+// TypeName returns the name of the type.
+func (r *httpResponse) TypeName() string {
+	return "http-response"
+}
+
+func (r *httpResponse) String() string {
+	return fmt.Sprintf("<http-response %s>", r.resp.Status)
+}
+
+// Copy returns r, whose fields cannot be changed.
+func (r *httpResponse) Copy() vvm.Object {
+	return r
+}
+
+// IndexGet returns the field or method of the response named by index.
+func (r *httpResponse) IndexGet(index vvm.Object) (vvm.Object, error) {
+	name, ok := vvm.ToString(index)
+	if !ok {
+		return nil, vvm.ErrInvalidIndexType
+	}
+	switch name {
+	case "status":
+		return &vvm.String{Value: r.resp.Status}, nil
+	case "status_code":
+		return &vvm.Int{Value: int64(r.resp.StatusCode)}, nil
+	case "ok":
+		return httpBool(r.resp.StatusCode >= 200 && r.resp.StatusCode < 300), nil
+	case "proto":
+		return &vvm.String{Value: r.resp.Proto}, nil
+	case "url":
+		return &vvm.String{Value: r.resp.Request.URL.String()}, nil
+	case "headers":
+		return r.headers, nil
+	case "content_length":
+		return &vvm.Int{Value: r.resp.ContentLength}, nil
+	case "body":
+		if r.reader != nil {
+			return vvm.UndefinedValue, nil
+		}
+		return &vvm.Bytes{Value: r.body}, nil
+	case "reader":
+		if r.reader == nil {
+			return vvm.UndefinedValue, nil
+		}
+		return r.reader, nil
+	case "header":
+		return &vvm.BuiltinFunction{Name: "header", Value: r.header}, nil
+	case "cookies":
+		return &vvm.BuiltinFunction{Name: "cookies", Value: r.cookies}, nil
+	case "text":
+		return &vvm.BuiltinFunction{Name: "text", Value: r.text}, nil
+	case "json":
+		return &vvm.BuiltinFunction{Name: "json", Value: r.json}, nil
+	case "close":
+		return &vvm.BuiltinFunction{Name: "close", Value: FuncARE(r.close)}, nil
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// header returns the values of a header, joined by commas. Its name is case
+// insensitive.
+func (r *httpResponse) header(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	name, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	values := r.resp.Header.Values(name)
+	if len(values) == 0 {
+		return vvm.UndefinedValue, nil
+	}
+	return &vvm.String{Value: strings.Join(values, ", ")}, nil
+}
+
+// cookies returns the cookies set by the response.
+func (r *httpResponse) cookies(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	cookies := r.resp.Cookies()
+	sort.SliceStable(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+	arr := &vvm.Array{}
+	for _, c := range cookies {
+		cookie := map[string]vvm.Object{
+			"name":      &vvm.String{Value: c.Name},
+			"value":     &vvm.String{Value: c.Value},
+			"path":      &vvm.String{Value: c.Path},
+			"domain":    &vvm.String{Value: c.Domain},
+			"secure":    httpBool(c.Secure),
+			"http_only": httpBool(c.HttpOnly),
+		}
+		if !c.Expires.IsZero() {
+			cookie["expires"] = &vvm.Time{Value: c.Expires}
+		}
+		arr.Value = append(arr.Value, &vvm.ImmutableMap{Value: cookie})
+	}
+	return arr, nil
+}
+
+// data returns the body of r, reading the rest of a streamed body.
+func (r *httpResponse) data() ([]byte, error) {
+	if r.reader == nil {
+		return r.body, nil
+	}
+	defer func() { _ = r.close() }()
+	data, err := io.ReadAll(io.LimitReader(r.resp.Body, int64(vvm.MaxBytesLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > vvm.MaxBytesLen {
+		return nil, vvm.ErrBytesLimit
+	}
+	return data, nil
+}
+
+func (r *httpResponse) readAll(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	data, err := r.data()
+	if err == vvm.ErrBytesLimit {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	return &vvm.Bytes{Value: data}, nil
+}
+
+func (r *httpResponse) text(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	data, err := r.data()
+	if err == vvm.ErrBytesLimit {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	if len(data) > vvm.MaxStringLen {
+		return nil, vvm.ErrStringLimit
+	}
+	return &vvm.String{Value: string(data)}, nil
+}
+
+func (r *httpResponse) json(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	data, err := r.data()
+	if err == vvm.ErrBytesLimit {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	v, err := json.Decode(data)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return v, nil
+}
+
+// close closes a streamed body. It does nothing for a body read completely.
+func (r *httpResponse) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel == nil {
+		return nil
+	}
+	err := r.resp.Body.Close()
+	r.cancel()
+	r.cancel = nil
+	return err
+}
+
+func httpBool(b bool) vvm.Object {
+	if b {
+		return vvm.TrueValue
+	}
+	return vvm.FalseValue
+}
@@ -0,0 +1,815 @@
+package stdlib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/stdlib/json"
+)
+
+// httpRouter dispatches the requests of a server to the script functions
+// of its routes, through its middleware.
+type httpRouter struct {
+	vvm.ObjectImpl
+	mux     *http.ServeMux
+	methods map[string]vvm.Object
+
+	mu         sync.Mutex
+	middleware []vvm.Object
+}
+
+// httpExchange is the state of a request handled by a router. It is passed
+// to the route handlers of the mux in the request context.
+type httpExchange struct {
+	ctx     context.Context // of the routine handling the request
+	w       *httpWriter
+	maxBody int64
+	err     error // runtime error of the handler
+}
+
+type httpExchangeKey struct{}
+
+// httpRoute is a route of a router, which calls fn.
+type httpRoute struct {
+	router *httpRouter
+	fn     vvm.Object
+}
+
+func httpNewRouter(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return newHTTPRouter(), nil
+}
+
+func newHTTPRouter() *httpRouter {
+	rt := &httpRouter{mux: http.NewServeMux()}
+	method := func(method string) *vvm.BuiltinFunction {
+		name := strings.ToLower(method)
+		if name == "" {
+			name = "handle"
+		}
+		return &vvm.BuiltinFunction{
+			Name: name,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				return rt.handle(method, args)
+			},
+		}
+	}
+	rt.methods = map[string]vvm.Object{
+		"handle":    method(""),
+		"get":       method(http.MethodGet),
+		"post":      method(http.MethodPost),
+		"put":       method(http.MethodPut),
+		"patch":     method(http.MethodPatch),
+		"delete":    method(http.MethodDelete),
+		"use":       &vvm.BuiltinFunction{Name: "use", Value: rt.use},
+		"static":    &vvm.BuiltinFunction{Name: "static", Value: rt.static},
+		"websocket": &vvm.BuiltinFunction{Name: "websocket", Value: rt.websocket},
+	}
+	return rt
+}
+
+// TypeName returns the name of the type.
+func (rt *httpRouter) TypeName() string {
+	return "http-router"
+}
+
+func (rt *httpRouter) String() string {
+	return "<http-router>"
+}
+
+// Copy returns rt, which is shared by its copies.
+func (rt *httpRouter) Copy() vvm.Object {
+	return rt
+}
+
+// IndexGet returns the method of the router named by index.
+func (rt *httpRouter) IndexGet(index vvm.Object) (vvm.Object, error) {
+	name, ok := vvm.ToString(index)
+	if !ok {
+		return nil, vvm.ErrInvalidIndexType
+	}
+	if fn, ok := rt.methods[name]; ok {
+		return fn, nil
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// use adds a middleware, which is called with the request, the response
+// writer and the function calling the next handler.
+func (rt *httpRouter) use(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	if err := checkCallable("first", args[0]); err != nil {
+		return nil, err
+	}
+	rt.mu.Lock()
+	rt.middleware = append(rt.middleware, args[0])
+	rt.mu.Unlock()
+	return vvm.UndefinedValue, nil
+}
+
+// handle adds a route calling the function args[1] for the pattern args[0],
+// which is prefixed by method if it is not empty.
+func (rt *httpRouter) handle(method string, args []vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	pattern, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	if err := rt.register(pattern, &httpRoute{router: rt, fn: args[1]}); err != nil {
+		return nil, err
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// register adds h to the mux of rt, which panics on invalid or conflicting
+// patterns.
+func (rt *httpRouter) register(pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("http: %v", r)
+		}
+	}()
+	rt.mux.Handle(pattern, h)
+	return nil
+}
+
+// static adds a route serving the files of a directory of the filesystem of
+// the VM below a path prefix.
+func (rt *httpRouter) static(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	prefix, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	dir, ok := vvm.ToString(args[1])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "second",
+			Expected: "string(compatible)",
+			Found:    args[1].TypeName(),
+		}
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	// each file is checked against the policy the route is added with, as
+	// links may lead out of dir
+	policy, _ := ctx.Value(policyKey{}).(*Policy)
+	fn := &vvm.BuiltinFunction{
+		Name: "static",
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			req := args[0].(*httpRequest)
+			w := ctx.Value(httpExchangeKey{}).(*httpExchange).w
+			name := strings.TrimPrefix(req.req.URL.Path, prefix)
+			httpServeFile(ctx, w, req.req, filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), policy)
+			return vvm.UndefinedValue, nil
+		},
+	}
+	if err := rt.register("GET "+prefix, &httpRoute{router: rt, fn: fn}); err != nil {
+		return nil, err
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// httpServeFile serves the file name of the filesystem of the VM running
+// ctx, or the index.html file of a directory, if policy, which may be nil,
+// allows reading it.
+func httpServeFile(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, policy *Policy) {
+	fsys := vmFS(ctx)
+	info, err := fsys.Stat(name)
+	if err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+		info, err = fsys.Stat(name)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	if policy != nil && policy.Check(CapFileRead, name) != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// ServeHTTP handles a request of the route: it reads the body and calls the
+// middleware of the router and the function of the route.
+func (route *httpRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ex := r.Context().Value(httpExchangeKey{}).(*httpExchange)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ex.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		}
+		return
+	}
+	req := &httpRequest{req: r, w: ex.w, body: body, maxBody: ex.maxBody}
+
+	route.router.mu.Lock()
+	chain := append([]vvm.Object(nil), route.router.middleware...)
+	route.router.mu.Unlock()
+	ctx := context.WithValue(ex.ctx, httpExchangeKey{}, ex)
+	res, err := route.call(ctx, chain, req, ex.w.object())
+	if err != nil {
+		ex.err = err
+		if !ex.w.written() {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := ex.w.result(res); err != nil {
+		ex.err = err
+	}
+}
+
+// call calls the first middleware of chain with req, w and the function
+// calling the rest of the chain, or the function of the route once chain is
+// empty.
+func (route *httpRoute) call(ctx context.Context, chain []vvm.Object, req, w vvm.Object) (vvm.Object, error) {
+	if len(chain) == 0 {
+		return vvm.Invoke(ctx, route.fn, req, w)
+	}
+	next := &vvm.BuiltinFunction{
+		Name: "next",
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			if len(args) != 0 {
+				return nil, vvm.ErrWrongNumArguments
+			}
+			return route.call(ctx, chain[1:], req, w)
+		},
+	}
+	return vvm.Invoke(ctx, chain[0], req, w, next)
+}
+
+// httpWriter is the response writer of a request.
+type httpWriter struct {
+	w http.ResponseWriter
+
+	mu     sync.Mutex
+	status int // 0 until the header is written
+}
+
+func (hw *httpWriter) written() bool {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.status != 0
+}
+
+func (hw *httpWriter) Header() http.Header {
+	return hw.w.Header()
+}
+
+func (hw *httpWriter) WriteHeader(status int) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.status == 0 {
+		hw.status = status
+		hw.w.WriteHeader(status)
+	}
+}
+
+func (hw *httpWriter) Write(p []byte) (int, error) {
+	hw.WriteHeader(http.StatusOK)
+	return hw.w.Write(p)
+}
+
+// object returns the response writer object of hw.
+func (hw *httpWriter) object() vvm.Object {
+	header := func(name string, set func(h http.Header, name, value string)) *vvm.BuiltinFunction {
+		return &vvm.BuiltinFunction{
+			Name: name,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 2 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				name, ok := vvm.ToString(args[0])
+				if !ok {
+					return nil, vvm.ErrInvalidArgumentType{
+						Name:     "first",
+						Expected: "string(compatible)",
+						Found:    args[0].TypeName(),
+					}
+				}
+				value, ok := vvm.ToString(args[1])
+				if !ok {
+					return nil, vvm.ErrInvalidArgumentType{
+						Name:     "second",
+						Expected: "string(compatible)",
+						Found:    args[1].TypeName(),
+					}
+				}
+				set(hw.Header(), name, value)
+				return vvm.UndefinedValue, nil
+			},
+		}
+	}
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"set_header": header("set_header", http.Header.Set),
+		"add_header": header("add_header", http.Header.Add),
+		"set_cookie": &vvm.BuiltinFunction{Name: "set_cookie", Value: hw.setCookie},
+		"write_header": &vvm.BuiltinFunction{
+			Name: "write_header",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				status, ok := vvm.ToInt(args[0])
+				if !ok || status < 100 || status > 999 {
+					return nil, vvm.ErrInvalidArgumentType{
+						Name:     "first",
+						Expected: "status code",
+						Found:    args[0].TypeName(),
+					}
+				}
+				hw.WriteHeader(status)
+				return vvm.UndefinedValue, nil
+			},
+		},
+		"write": &vvm.BuiltinFunction{
+			Name: "write",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				var data []byte
+				switch arg := args[0].(type) {
+				case *vvm.Bytes:
+					data = arg.Value
+				default:
+					s, ok := vvm.ToString(arg)
+					if !ok {
+						return nil, vvm.ErrInvalidArgumentType{
+							Name:     "first",
+							Expected: "string/bytes",
+							Found:    arg.TypeName(),
+						}
+					}
+					data = []byte(s)
+				}
+				n, err := hw.Write(data)
+				if err != nil {
+					return wrapError(err), nil
+				}
+				return &vvm.Int{Value: int64(n)}, nil
+			},
+		},
+		"flush": &vvm.BuiltinFunction{
+			Name: "flush",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				hw.WriteHeader(http.StatusOK)
+				if err := http.NewResponseController(hw.w).Flush(); err != nil {
+					return wrapError(err), nil
+				}
+				return vvm.UndefinedValue, nil
+			},
+		},
+	}}
+}
+
+// setCookie sets a cookie described by a map with the keys name, value,
+// path, domain, max_age, secure and http_only.
+func (hw *httpWriter) setCookie(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	opts, err := optionsArg(args, 0, "first")
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Cookie{}
+	for key, value := range opts {
+		ok := true
+		switch key {
+		case "name":
+			c.Name, ok = vvm.ToString(value)
+		case "value":
+			c.Value, ok = vvm.ToString(value)
+		case "path":
+			c.Path, ok = vvm.ToString(value)
+		case "domain":
+			c.Domain, ok = vvm.ToString(value)
+		case "max_age":
+			c.MaxAge, ok = vvm.ToInt(value)
+		case "secure":
+			c.Secure = !value.IsFalsy()
+		case "http_only":
+			c.HttpOnly = !value.IsFalsy()
+		default:
+			return nil, fmt.Errorf("http: unknown cookie field: %s", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("http: invalid cookie field %s: %s", key, value.TypeName())
+		}
+	}
+	if err := c.Valid(); err != nil {
+		return wrapError(err), nil
+	}
+	http.SetCookie(hw.w, c)
+	return vvm.UndefinedValue, nil
+}
+
+// result writes the response described by res, the result of a handler,
+// unless the handler wrote the response itself. A string or bytes is the
+// body, a map has the keys status, headers, body and json, an error value
+// makes an internal server error and other values are encoded as JSON.
+func (hw *httpWriter) result(res vvm.Object) error {
+	if hw.written() {
+		return nil
+	}
+	status, body := http.StatusOK, res
+	switch res := res.(type) {
+	case *vvm.Undefined:
+		return nil
+	case *vvm.Error:
+		http.Error(hw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil
+	case *vvm.Map, *vvm.ImmutableMap:
+		opts, _ := optionsArg([]vvm.Object{res}, 0, "")
+		body = nil
+		for key, value := range opts {
+			ok := true
+			switch key {
+			case "status":
+				status, ok = vvm.ToInt(value)
+				ok = ok && status >= 100 && status <= 999
+			case "headers":
+				ok = httpHeaders(hw.Header(), value)
+			case "body":
+				body = value
+			case "json":
+				data, err := json.Encode(value)
+				if err != nil {
+					return err
+				}
+				body = &vvm.Bytes{Value: data}
+				if hw.Header().Get("Content-Type") == "" {
+					hw.Header().Set("Content-Type", "application/json")
+				}
+			default:
+				return fmt.Errorf("http: unknown response field: %s", key)
+			}
+			if !ok {
+				return fmt.Errorf("http: invalid response field %s: %s", key, value.TypeName())
+			}
+		}
+	}
+
+	var data []byte
+	switch b := body.(type) {
+	case nil:
+	case *vvm.String:
+		data = []byte(b.Value)
+	case *vvm.Bytes:
+		data = b.Value
+	default:
+		var err error
+		if data, err = json.Encode(b); err != nil {
+			return err
+		}
+		if hw.Header().Get("Content-Type") == "" {
+			hw.Header().Set("Content-Type", "application/json")
+		}
+	}
+	hw.WriteHeader(status)
+	_, _ = hw.Write(data)
+	return nil
+}
+
+// httpServer is a server started by http.listen.
+type httpServer struct {
+	srv      *http.Server
+	ln       net.Listener
+	handler  *httpRouter
+	maxBody  int64
+	sem      chan struct{} // limits the requests handled at once, if not nil
+	grace    time.Duration // of the shutdown on abort
+	ctx      context.Context
+	done     chan struct{} // closed once the server stopped
+	shutdown sync.Once
+	stopped  chan struct{} // closed once a shutdown completed
+}
+
+func httpListen(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	s, errObj, err := httpNewServer(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return errObj, nil
+	}
+	return s.object(), nil
+}
+
+func httpServe(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	s, errObj, err := httpNewServer(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return errObj, nil
+	}
+	return s.wait(ctx)
+}
+
+// httpNewServer starts a server listening on the address args[0], which
+// serves the router or function args[1] with the options args[2]. It
+// returns an error object if it cannot listen.
+func httpNewServer(ctx context.Context, args []vvm.Object) (*httpServer, vvm.Object, error) {
+	if vvm.DeterministicOf(ctx) != nil {
+		return nil, nil, errors.New("http: cannot serve in a deterministic run")
+	}
+	addr, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	s := &httpServer{
+		srv:     &http.Server{},
+		maxBody: 10 << 20,
+		grace:   5 * time.Second,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if rt, ok := args[1].(*httpRouter); ok {
+		s.handler = rt
+	} else {
+		// a function handles all requests
+		if err := checkCallable("second", args[1]); err != nil {
+			return nil, nil, err
+		}
+		s.handler = newHTTPRouter()
+		s.handler.mux.Handle("/", &httpRoute{router: s.handler, fn: args[1]})
+	}
+
+	opts, err := optionsArg(args, 2, "third")
+	if err != nil {
+		return nil, nil, err
+	}
+	var timeout time.Duration
+	var tlsConfig *tls.Config
+	for key, value := range opts {
+		ok := true
+		var n int64
+		switch key {
+		case "max_body":
+			s.maxBody, ok = vvm.ToInt64(value)
+		case "max_header_bytes":
+			s.srv.MaxHeaderBytes, ok = vvm.ToInt(value)
+		case "max_concurrent":
+			var max int
+			if max, ok = vvm.ToInt(value); ok && max > 0 {
+				s.sem = make(chan struct{}, max)
+			}
+		case "timeout":
+			n, ok = vvm.ToInt64(value)
+			timeout = time.Duration(n)
+		case "read_timeout":
+			n, ok = vvm.ToInt64(value)
+			s.srv.ReadTimeout = time.Duration(n)
+		case "write_timeout":
+			n, ok = vvm.ToInt64(value)
+			s.srv.WriteTimeout = time.Duration(n)
+		case "idle_timeout":
+			n, ok = vvm.ToInt64(value)
+			s.srv.IdleTimeout = time.Duration(n)
+		case "shutdown_timeout":
+			n, ok = vvm.ToInt64(value)
+			s.grace = time.Duration(n)
+		case "tls":
+			if tlsConfig, err = httpServerTLSConfig(value); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("http: unknown server option: %s", key)
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("http: invalid server option %s: %s", key, value.TypeName())
+		}
+	}
+	s.srv.Handler = s
+	if timeout > 0 {
+		s.srv.Handler = http.TimeoutHandler(s, timeout, http.StatusText(http.StatusServiceUnavailable))
+	}
+
+	if s.ln, err = net.Listen("tcp", addr); err != nil {
+		return nil, wrapError(err), nil
+	}
+	if tlsConfig != nil {
+		s.ln = tls.NewListener(s.ln, tlsConfig)
+	}
+	// the server runs in a routine, which starts the routines of the
+	// requests
+	started := make(chan struct{})
+	_, err = vvm.Start(ctx, &vvm.BuiltinFunction{
+		Name: "http_server",
+		Value: func(ctx context.Context, _ ...vvm.Object) (vvm.Object, error) {
+			s.ctx = ctx
+			close(started)
+			return s.run(ctx)
+		},
+	})
+	if err != nil {
+		_ = s.ln.Close()
+		return nil, nil, err
+	}
+	<-started
+	return s, nil, nil
+}
+
+// httpServerTLSConfig returns the TLS configuration described by the tls
+// server option, a map with the PEM encoded cert and key.
+func httpServerTLSConfig(value vvm.Object) (*tls.Config, error) {
+	opts, err := optionsArg([]vvm.Object{value}, 0, "tls")
+	if err != nil {
+		return nil, err
+	}
+	var cert, key []byte
+	for name, value := range opts {
+		ok := true
+		switch name {
+		case "cert":
+			cert, ok = vvm.ToByteSlice(value)
+		case "key":
+			key, ok = vvm.ToByteSlice(value)
+		default:
+			return nil, fmt.Errorf("http: unknown tls option: %s", name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("http: invalid tls option %s: %s", name, value.TypeName())
+		}
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("http: invalid server certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{pair}}, nil
+}
+
+// run serves until the server is shut down, or gracefully shuts it down
+// once ctx is done.
+func (s *httpServer) run(ctx context.Context) (vvm.Object, error) {
+	defer close(s.done)
+	errc := make(chan error, 1)
+	go func() { errc <- s.srv.Serve(s.ln) }()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return wrapError(err), nil
+		}
+		// Serve returns as soon as the shutdown began
+		<-s.stopped
+		return vvm.UndefinedValue, nil
+	case <-ctx.Done():
+		_ = s.stop(s.grace)
+		<-errc
+		return nil, vvm.ErrVMAborted
+	}
+}
+
+// stop gracefully shuts the server down, closing the connections still
+// active after grace.
+func (s *httpServer) stop(grace time.Duration) error {
+	var err error
+	s.shutdown.Do(func() {
+		defer close(s.stopped)
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		if err = s.srv.Shutdown(ctx); err != nil {
+			err = s.srv.Close()
+		}
+	})
+	return err
+}
+
+// wait blocks until the server has stopped and its requests are handled.
+func (s *httpServer) wait(ctx context.Context) (vvm.Object, error) {
+	select {
+	case <-s.done:
+		return vvm.UndefinedValue, nil
+	case <-ctx.Done():
+		return nil, vvm.ErrVMAborted
+	}
+}
+
+// ServeHTTP handles a request in a new routine.
+func (s *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+			defer func() { <-s.sem }()
+		default:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	ex := &httpExchange{w: &httpWriter{w: w}, maxBody: s.maxBody}
+	done := make(chan struct{})
+	_, err := vvm.Start(s.ctx, &vvm.BuiltinFunction{
+		Name: "http_handler",
+		Value: func(ctx context.Context, _ ...vvm.Object) (vvm.Object, error) {
+			defer close(done)
+			ex.ctx = ctx
+			s.handler.mux.ServeHTTP(ex.w, r.WithContext(context.WithValue(r.Context(), httpExchangeKey{}, ex)))
+			if ex.err != nil {
+				return nil, ex.err
+			}
+			return vvm.UndefinedValue, nil
+		},
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	<-done
+}
+
+// object returns the server object of s.
+func (s *httpServer) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"addr": &vvm.String{Value: s.ln.Addr().String()},
+		"shutdown": &vvm.BuiltinFunction{
+			Name: "shutdown",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) > 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				grace := s.grace
+				if len(args) == 1 {
+					d, err := timesDuration(args, 0, "first")
+					if err != nil {
+						return nil, err
+					}
+					grace = d
+				}
+				if err := s.stop(grace); err != nil {
+					return wrapError(err), nil
+				}
+				return s.wait(ctx)
+			},
+		},
+		"wait": &vvm.BuiltinFunction{
+			Name:        "wait",
+			Restartable: true,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				return s.wait(ctx)
+			},
+		},
+	}}
+}
@@ -0,0 +1,666 @@
+package stdlib_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+func httpEcho(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("X-Multi", "a")
+	w.Header().Add("X-Multi", "b")
+	_, _ = fmt.Fprintf(w, `{"method": %q, "query": %q, "type": %q, "token": %q, "body": %q}`,
+		r.Method, r.URL.RawQuery, r.Header.Get("Content-Type"), r.Header.Get("X-Token"), body)
+}
+
+const httpImports = `http := import("http");`
+
+func TestHTTPRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(httpEcho))
+	defer srv.Close()
+
+	expect(t, httpImports+`
+resp := http.get(url + "/path?a=1", {query: {b: [2, 3]}, headers: {"x-token": "secret"}})
+out := [resp.status_code, resp.ok, resp.header("x-multi"), resp.headers["Content-Type"], resp.json()]
+`, nil, MAP{"url": srv.URL}, ARR{200, true, "a, b", "application/json", MAP{
+		"method": "GET", "query": "a=1&b=2&b=3", "type": "", "token": "secret", "body": "",
+	}})
+	expect(t, httpImports+`
+out := [
+	http.post(url, {a: 1}).json().body,
+	http.post(url, {a: 1}).json().type,
+	http.post(url, bytes("raw")).json().type,
+	http.post(url, "text", {headers: {"Content-Type": "text/csv"}}).json().type,
+	http.request("put", url, {form: {a: "x y"}}).json(),
+	http.request("DELETE", url, {json: [1, 2]}).json().body
+]
+`, nil, MAP{"url": srv.URL}, ARR{`{"a":1}`, "application/json", "application/octet-stream", "text/csv", MAP{
+		"method": "PUT", "query": "", "type": "application/x-www-form-urlencoded", "token": "", "body": "a=x+y",
+	}, "[1,2]"})
+	expect(t, httpImports+`
+resp := http.get(url)
+out := [type_name(resp), string(resp), is_undefined(resp.reader), len(resp.body) > 0, resp.text() == string(resp.body)]
+`, nil, MAP{"url": srv.URL}, ARR{"http-response", "<http-response 200 OK>", true, true, true})
+	expect(t, httpImports+`
+out := [is_error(http.get("http://127.0.0.1:0")), is_error(http.get(":bad"))]
+`, nil, MAP{"url": srv.URL}, ARR{true, true})
+}
+
+func TestHTTPStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "chunk%d;", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	expect(t, httpImports+`
+resp := http.get(url, {stream: true})
+buf := bytes(7)
+n := resp.reader.read(buf)
+first := string(buf[:n])
+rest := string(resp.reader.read_all())
+out := [is_undefined(resp.body), first, rest, is_error(resp.reader.read(buf)), resp.reader.close()]
+`, nil, MAP{"url": srv.URL}, ARR{true, "chunk0;", "chunk1;chunk2;", true, true})
+	expect(t, httpImports+`
+out := http.get(url, {stream: true}).text()
+`, nil, MAP{"url": srv.URL}, "chunk0;chunk1;chunk2;")
+}
+
+func TestHTTPClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		http.Redirect(w, r, "/me", http.StatusFound)
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil {
+			http.Error(w, "no session", http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s %s", c.Value, r.Header.Get("User-Agent"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	expect(t, httpImports+`
+c := http.client({headers: {"User-Agent": "vv"}})
+resp := c.get(url + "/login")
+out := [resp.url == url + "/me", resp.text(), c.cookies(url), http.get(url + "/me").status_code]
+`, nil, MAP{"url": srv.URL}, ARR{true, "s1 vv", MAP{"session": "s1"}, 401})
+	expect(t, httpImports+`
+c := http.client({redirects: 0, cookies: false})
+resp := c.get(url + "/login")
+out := [resp.status_code, resp.header("location"), resp.cookies()[0].value, c.cookies(url)]
+`, nil, MAP{"url": srv.URL}, ARR{302, "/me", "s1", MAP{}})
+}
+
+func TestHTTPTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(httpEcho))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // failed handshakes
+	srv.StartTLS()
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	s := vv.NewScript([]byte(`
+http := import("http")
+out := [
+	is_error(http.get(url)),
+	http.client({tls: {ca: ca}}).get(url).json().method,
+	http.client({tls: {insecure_skip_verify: true}}).get(url).status_code
+]
+`))
+	require.NoError(t, s.Add("url", srv.URL))
+	require.NoError(t, s.Add("ca", string(ca)))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{true, "GET", 200}), c.Get("out").Object())
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	expect(t, httpImports+`
+times := import("times")
+out := [
+	is_error(http.get(url, {timeout: 10 * times.millisecond})),
+	is_error(http.client({timeout: 10 * times.millisecond}).get(url))
+]
+`, nil, MAP{"url": srv.URL}, ARR{true, true})
+
+	s := vv.NewScript([]byte(`http := import("http"); http.get(url)`))
+	require.NoError(t, s.Add("url", srv.URL))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.RunContext(ctx)
+	require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), err)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`http := import("http");` + input))
+		s.SetImports(stdlib.GetModuleMap("http"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`http.get(undefined)`, "invalid type for argument 'first'")
+	expectErr(`http.get("http://x", {when: 1})`, "unknown request option")
+	expectErr(`http.get("http://x", {headers: 1})`, "invalid request option headers")
+	expectErr(`http.client({tls: {ca: "none"}})`, "no certificates")
+	expectErr(`http.client({proxy: 1})`, "unknown client option")
+}
+
+func TestHTTPPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(httpEcho))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		policy *stdlib.Policy
+		want   interface{}
+	}{
+		{&stdlib.Policy{}, ARR{"permission_denied", "permission_denied"}},
+		{&stdlib.Policy{Network: true}, ARR{200, true}},
+	} {
+		s := vv.NewScript([]byte(`
+http := import("http")
+a := http.get(url)
+b := http.client()
+out := [is_error(a) ? a.code : a.status_code, is_error(b) ? b.code : !is_error(b.get(url))]
+`))
+		require.NoError(t, s.Add("url", srv.URL))
+		s.SetImports(stdlib.GetPolicyModuleMap(tc.policy, "http"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(tc.want), c.Get("out").Object())
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	expect(t, `
+http := import("http")
+rt := http.router()
+rt.use(func(req, w, next) {
+	w.set_header("X-Middleware", req.path)
+	return next()
+})
+rt.get("/items/{id}", func(req, w) {
+	return {json: {id: req.params.id, q: req.query.q, method: req.method, pattern: req.pattern}}
+})
+rt.post("/echo", func(req, w) { return req.body })
+rt.post("/form", func(req, w) { return req.form.a + req.header("x-suffix") })
+rt.post("/upload", func(req, w) {
+	f := req.files.f[0]
+	return f.filename + ":" + f.content_type + ":" + string(f.data)
+})
+rt.get("/write", func(req, w) {
+	w.set_header("Content-Type", "text/csv")
+	w.write_header(201)
+	w.write("a,b")
+})
+rt.get("/status", func(req, w) { return {status: 418, headers: {"X-Tea": "pot"}, body: "teapot"} })
+rt.get("/fail", func(req, w) { return error("failed") })
+rt.get("/cookie", func(req, w) {
+	w.set_cookie({name: "b", value: "2"})
+	return req.cookies.a
+})
+srv := http.listen("127.0.0.1:0", rt)
+url := "http://" + srv.addr
+item := http.get(url + "/items/7?q=x")
+multipart := "--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"a.txt\"\r\n" +
+	"Content-Type: text/plain\r\n\r\ndata\r\n--b--\r\n"
+write := http.get(url + "/write")
+status := http.get(url + "/status")
+cookie := http.get(url + "/cookie", {headers: {Cookie: "a=1"}})
+out := [
+	item.json(), item.header("x-middleware"),
+	http.post(url + "/echo", bytes("raw")).text(),
+	http.request("post", url + "/form", {form: {a: "x"}, headers: {"X-Suffix": "y"}}).text(),
+	http.post(url + "/upload", multipart, {headers: {"Content-Type": "multipart/form-data; boundary=b"}}).text(),
+	[write.status_code, write.header("content-type"), write.text()],
+	[status.status_code, status.header("x-tea"), status.text()],
+	http.get(url + "/fail").status_code,
+	http.get(url + "/missing").status_code,
+	http.post(url + "/items/1", "").status_code,
+	[cookie.text(), cookie.cookies()[0].value],
+	srv.shutdown()
+]
+`, nil, nil, ARR{
+		MAP{"id": "7", "q": "x", "method": "GET", "pattern": "GET /items/{id}"}, "/items/7",
+		"raw", "xy", "a.txt:text/plain:data",
+		ARR{201, "text/csv", "a,b"},
+		ARR{418, "pot", "teapot"},
+		500, 404, 405,
+		ARR{"1", "2"},
+		vvm.UndefinedValue,
+	})
+}
+
+func TestHTTPServerHandler(t *testing.T) {
+	// a function handles all requests, and middleware may answer itself
+	expect(t, `
+http := import("http")
+srv := http.listen("127.0.0.1:0", func(req, w) { return [req.method, req.path] })
+a := http.request("put", "http://" + srv.addr + "/x/y").json()
+srv.shutdown()
+
+rt := http.router()
+rt.use(func(req, w, next) {
+	if req.header("x-token") != "secret" {
+		return {status: 401, body: "denied"}
+	}
+	return next()
+})
+rt.handle("/", func(req, w) { return "ok" })
+srv = http.listen("127.0.0.1:0", rt)
+url := "http://" + srv.addr
+b := [http.get(url).status_code, http.get(url, {headers: {"X-Token": "secret"}}).text()]
+srv.shutdown()
+out := [a, b, is_error(http.get(url))]
+`, nil, nil, ARR{ARR{"PUT", "/x/y"}, ARR{401, "ok"}, true})
+}
+
+func TestHTTPServerStatic(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>home</h1>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "css"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "css", "a.css"), []byte("a{}"), 0o644))
+
+	s := vv.NewScript([]byte(`
+http := import("http")
+rt := http.router()
+rt.static("/files", dir)
+srv := http.listen("127.0.0.1:0", rt)
+url := "http://" + srv.addr + "/files"
+css := http.get(url + "/css/a.css")
+out := [
+	http.get(url + "/").text(),
+	[css.text(), css.header("content-type")],
+	http.get(url + "/css").status_code,
+	http.get(url + "/../http_test.go").status_code,
+	http.get(url + "/missing").status_code
+]
+srv.shutdown()
+`))
+	require.NoError(t, s.Add("dir", dir))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{
+		"<h1>home</h1>", ARR{"a{}", "text/css; charset=utf-8"}, 404, 404, 404,
+	}), c.Get("out").Object())
+}
+
+func TestHTTPServerLimits(t *testing.T) {
+	expect(t, `
+http := import("http")
+times := import("times")
+srv := http.listen("127.0.0.1:0", func(req, w) {
+	if req.path == "/slow" {
+		times.sleep(200 * times.millisecond)
+	}
+	return len(req.body)
+}, {max_body: 4, timeout: 50 * times.millisecond})
+url := "http://" + srv.addr
+out := [
+	http.post(url, "1234").text(),
+	http.post(url, "12345").status_code,
+	http.get(url + "/slow").status_code
+]
+srv.shutdown()
+`, nil, nil, ARR{"4", 413, 503})
+}
+
+func TestHTTPServerErrors(t *testing.T) {
+	// a runtime error of a handler fails the request and is reported
+	s := vv.NewScript([]byte(`
+http := import("http")
+srv := http.listen("127.0.0.1:0", func(req, w) { return [] + 1 })
+out := http.get("http://" + srv.addr).status_code
+srv.shutdown()
+`))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	c, err := s.Run()
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "invalid operation"), err.Error())
+	require.Equal(t, object(500), c.Get("out").Object())
+
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`http := import("http");` + input))
+		s.SetImports(stdlib.GetModuleMap("http"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`http.listen(":0", 1)`, "invalid type for argument 'second'")
+	expectErr(`http.listen(":0", func(req, w) {}, {port: 1})`, "unknown server option")
+	expectErr(`http.listen(":0", func(req, w) {}, {tls: {cert: "x", key: "y"}})`, "invalid server certificate")
+	expectErr(`rt := http.router(); rt.get("/a", func(req, w) {}); rt.get("/a", func(req, w) {})`, "conflicts")
+	expectErr(`rt := http.router(); rt.handle("BAD PATTERN HERE", func(req, w) {})`, "http:")
+
+	expect(t, `
+http := import("http")
+srv := http.listen("127.0.0.1:0", func(req, w) {})
+err := http.listen(srv.addr, func(req, w) {})
+srv.shutdown()
+out := is_error(err)
+`, nil, nil, true)
+
+	s = vv.NewScript([]byte(`http := import("http"); http.serve("127.0.0.1:0", func(req, w) {})`))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	s.SetDeterministic(1)
+	_, err = s.Run()
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "deterministic"), err.Error())
+}
+
+func TestHTTPServerAbort(t *testing.T) {
+	// the server is shut down gracefully once the VM is aborted
+	s := vv.NewScript([]byte(`
+http := import("http")
+times := import("times")
+http.serve("127.0.0.1:0", func(req, w) {})
+`))
+	s.SetImports(stdlib.GetModuleMap("http", "times"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.RunContext(ctx)
+	require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), err)
+}
+
+func TestHTTPServerPolicy(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		policy *stdlib.Policy
+		want   interface{}
+	}{
+		{&stdlib.Policy{}, ARR{"permission_denied", "permission_denied"}},
+		{&stdlib.Policy{Network: true}, ARR{"permission_denied", true}},
+		{&stdlib.Policy{Network: true, FileRead: []string{dir}}, ARR{true, true}},
+	} {
+		s := vv.NewScript([]byte(`
+http := import("http")
+rt := http.router()
+a := rt.static("/", dir)
+srv := http.listen("127.0.0.1:0", rt)
+if !is_error(srv) {
+	srv.shutdown()
+}
+out := [is_error(a) ? a.code : true, is_error(srv) ? srv.code : true]
+`))
+		require.NoError(t, s.Add("dir", dir))
+		s.SetImports(stdlib.GetPolicyModuleMap(tc.policy, "http"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(tc.want), c.Get("out").Object())
+	}
+
+	// the files are checked when they are served, after resolving links
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public"), []byte("public"), 0o644))
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	s := vv.NewScript([]byte(`
+http := import("http")
+rt := http.router()
+rt.static("/", dir)
+srv := http.listen("127.0.0.1:0", rt)
+url := "http://" + srv.addr
+out := [http.get(url + "/public").text(), http.get(url + "/link").status_code]
+srv.shutdown()
+`))
+	require.NoError(t, s.Add("dir", dir))
+	s.SetImports(stdlib.GetPolicyModuleMap(&stdlib.Policy{Network: true, FileRead: []string{filepath.Join(dir, "**")}}, "http"))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{"public", 403}), c.Get("out").Object())
+}
+
+func TestHTTPWebSocket(t *testing.T) {
+	expect(t, `
+http := import("http")
+rt := http.router()
+rt.websocket("/ws", func(ws, req) {
+	for {
+		msg := ws.recv()
+		if is_undefined(msg) {
+			break
+		}
+		if msg == "bye" {
+			ws.close(4000, "bye")
+			break
+		}
+		ws.send(msg)
+	}
+}, {subprotocols: ["chat"]})
+srv := http.listen("127.0.0.1:0", rt)
+ws := http.websocket("ws://" + srv.addr + "/ws", {subprotocols: ["x", "chat"]})
+ws.send("hello")
+a := ws.recv()
+ws.send(bytes("bin"))
+b := ws.recv()
+ws.send({n: 1})
+c := ws.recv()
+ws.ping("p")
+ws.send(bytes(70000))
+big := ws.recv()
+ws.send("bye")
+out := [ws.subprotocol, a, b, c, len(big), ws.recv(), ws.close_status(), is_error(ws.send("x"))]
+srv.shutdown()
+`, nil, nil, ARR{"chat", "hello", []byte("bin"), `{"n":1}`, 70000, vvm.UndefinedValue, MAP{"code": 4000, "reason": "bye"}, true})
+}
+
+func TestHTTPWebSocketClose(t *testing.T) {
+	// connections compose with channels and routines
+	expect(t, `
+http := import("http")
+statuses := chan(1)
+srv := http.listen("127.0.0.1:0", func(req, w) {
+	ws := req.upgrade()
+	ticks := start(func() {
+		for i := 0; i < 3; i++ {
+			ws.send(i)
+		}
+	})
+	ticks.wait()
+	for !is_undefined(ws.recv()) {}
+	statuses.send(ws.close_status())
+})
+ws := http.websocket("ws://" + srv.addr)
+got := [ws.recv(), ws.recv(), ws.recv()]
+ws.close()
+out := [got, statuses.recv(), ws.close_status()]
+srv.shutdown()
+`, nil, nil, ARR{ARR{"0", "1", "2"}, MAP{"code": 1000, "reason": ""}, MAP{"code": 1000, "reason": ""}})
+}
+
+// wsRawConn runs a server script with a WebSocket route at "/" on addr and
+// returns a raw connection that completed the opening handshake, its reader
+// and the result of the script.
+func wsRawConn(t *testing.T, input string) (net.Conn, *bufio.Reader, <-chan wsResult) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	s := vv.NewScript([]byte(`http := import("http");` + input))
+	require.NoError(t, s.Add("addr", addr))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	done := make(chan wsResult, 1)
+	go func() {
+		c, err := s.Run()
+		done <- wsResult{c, err}
+	}()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", addr)
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return conn, br, done
+}
+
+type wsResult struct {
+	c   *vv.Program
+	err error
+}
+
+func TestHTTPWebSocketProtocol(t *testing.T) {
+	conn, br, done := wsRawConn(t, `
+statuses := chan(1)
+rt := http.router()
+rt.websocket("/", func(ws, req) {
+	ws.recv()
+	statuses.send(ws.close_status())
+})
+srv := http.listen(addr, rt)
+out := statuses.recv()
+srv.shutdown()
+`)
+
+	// an unmasked client frame is a protocol error
+	_, err := conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	frame := make([]byte, 4)
+	_, err = io.ReadFull(br, frame)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x88, 17, 0x03, 0xea}, frame)
+
+	res := <-done
+	require.NoError(t, res.err)
+	require.Equal(t, object(MAP{"code": 1002, "reason": "invalid masking"}), res.c.Get("out").Object())
+}
+
+func TestHTTPWebSocketTooLarge(t *testing.T) {
+	for name, frames := range map[string][]byte{
+		// a single frame declaring a payload of 1 TiB
+		"length": {0x82, 0xff, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		// two fragments of 6 bytes each
+		"fragments": {
+			0x01, 0x86, 0, 0, 0, 0, 'a', 'b', 'c', 'd', 'e', 'f',
+			0x80, 0x86, 0, 0, 0, 0, 'a', 'b', 'c', 'd', 'e', 'f',
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn, br, done := wsRawConn(t, `
+statuses := chan(1)
+rt := http.router()
+rt.websocket("/", func(ws, req) {
+	ws.recv()
+	statuses.send(ws.close_status())
+}, {max_message: 8})
+srv := http.listen(addr, rt)
+out := statuses.recv()
+srv.shutdown()
+`)
+			_, err := conn.Write(frames)
+			require.NoError(t, err)
+			frame := make([]byte, 4)
+			_, err = io.ReadFull(br, frame)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0x88, 19, 0x03, 0xf1}, frame)
+
+			res := <-done
+			require.NoError(t, res.err)
+			require.Equal(t, object(MAP{"code": 1009, "reason": "message too large"}), res.c.Get("out").Object())
+		})
+	}
+}
+
+func TestHTTPWebSocketErrors(t *testing.T) {
+	expect(t, `
+http := import("http")
+srv := http.listen("127.0.0.1:0", func(req, w) {
+	if req.path == "/plain" {
+		return "plain"
+	}
+	ws := req.upgrade({origins: ["https://example.com"]})
+	if !is_error(ws) {
+		ws.recv()
+		ws.close()
+	}
+})
+url := "ws://" + srv.addr
+allowed := http.websocket(url, {headers: {Origin: "https://example.com"}})
+out := [
+	is_error(http.websocket(url + "/plain")),
+	http.get("http://" + srv.addr + "/ws").status_code,
+	is_error(http.websocket(url, {headers: {Origin: "https://evil.com"}})),
+	is_error(allowed),
+	is_error(http.websocket("http://" + srv.addr))
+]
+allowed.close()
+srv.shutdown()
+`, nil, nil, ARR{true, 400, true, false, true})
+
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`http := import("http");` + input))
+		s.SetImports(stdlib.GetModuleMap("http"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`http.websocket("ws://127.0.0.1:1", {retries: 1})`, "unknown option")
+	expectErr(`http.websocket("ws://127.0.0.1:1", {origins: ["x"]})`, "unknown option")
+	expectErr(`http.router().websocket("/", func(ws, req) {}, {max_message: "x"})`, "invalid option max_message")
+}
+
+func TestHTTPWebSocketAbort(t *testing.T) {
+	s := vv.NewScript([]byte(`
+http := import("http")
+rt := http.router()
+rt.websocket("/", func(ws, req) { ws.recv() })
+srv := http.listen("127.0.0.1:0", rt)
+http.websocket("ws://" + srv.addr).recv()
+`))
+	s.SetImports(stdlib.GetModuleMap("http"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := s.RunContext(ctx)
+	require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), err)
+}
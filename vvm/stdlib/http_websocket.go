@@ -0,0 +1,844 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/stdlib/json"
+)
+
+// WebSocket opcodes and close codes, see RFC 6455.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseNoStatus    = 1005
+	wsCloseAbnormal    = 1006
+	wsCloseInvalidData = 1007
+	wsCloseTooLarge    = 1009
+	wsCloseUnexpected  = 1011
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage is the default maximal size of received messages.
+const wsMaxMessage = 32 << 20
+
+// wsCloseWait is how long close waits for the close frame of the peer.
+const wsCloseWait = time.Second
+
+// errWSClosed is returned by reads once the closing handshake is done.
+var errWSClosed = errors.New("websocket: closed")
+
+// wsOptions are the options of a connection, shared by the client and the
+// server.
+type wsOptions struct {
+	maxMessage   int
+	pingInterval time.Duration
+	subprotocols []string
+	origins      []string // allowed by the server, the host if empty
+}
+
+// option parses the connection option key. It reports false if the key is
+// unknown.
+func (o *wsOptions) option(key string, value vvm.Object) (known, ok bool) {
+	ok = true
+	switch key {
+	case "max_message":
+		o.maxMessage, ok = vvm.ToInt(value)
+	case "ping_interval":
+		var n int64
+		n, ok = vvm.ToInt64(value)
+		o.pingInterval = time.Duration(n)
+	case "subprotocols":
+		o.subprotocols, ok = wsStrings(value)
+	case "origins":
+		o.origins, ok = wsStrings(value)
+	default:
+		return false, false
+	}
+	return true, ok
+}
+
+func wsStrings(value vvm.Object) ([]string, bool) {
+	var values []vvm.Object
+	switch v := value.(type) {
+	case *vvm.Array:
+		values = v.Value
+	case *vvm.ImmutableArray:
+		values = v.Value
+	default:
+		return nil, false
+	}
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := vvm.ToString(v)
+		if !ok {
+			return nil, false
+		}
+		res = append(res, s)
+	}
+	return res, true
+}
+
+// wsConn is a WebSocket connection. Messages are read by one routine at a
+// time; control frames are handled while reading.
+type wsConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	client      bool // frames are masked
+	maxMessage  int
+	subprotocol string
+
+	rmu sync.Mutex // held while reading
+	wmu sync.Mutex // held while writing a frame
+
+	mu        sync.Mutex
+	closeSent bool
+	code      int // close status, 0 while open
+	reason    string
+	done      chan struct{} // closed once the connection is closed
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, client bool, opts *wsOptions, subprotocol string) *wsConn {
+	c := &wsConn{
+		conn:        conn,
+		br:          br,
+		client:      client,
+		maxMessage:  wsMaxMessage,
+		subprotocol: subprotocol,
+		done:        make(chan struct{}),
+	}
+	if opts.maxMessage > 0 {
+		c.maxMessage = min(opts.maxMessage, vvm.MaxBytesLen)
+	}
+	if opts.pingInterval > 0 {
+		go c.keepAlive(opts.pingInterval)
+	}
+	return c
+}
+
+// keepAlive sends a ping every interval until the connection is closed.
+func (c *wsConn) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// finish closes the connection with the close status code and reason,
+// unless it has one already.
+func (c *wsConn) finish(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.code != 0 {
+		return
+	}
+	c.code, c.reason = code, reason
+	_ = c.conn.Close()
+	close(c.done)
+}
+
+// status returns the close status of the connection, and whether it is
+// closed.
+func (c *wsConn) status() (int, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.code, c.reason, c.code != 0
+}
+
+// writeFrame writes a frame with the opcode op and payload data.
+func (c *wsConn) writeFrame(op byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	frame := make([]byte, 2, 14+len(data))
+	frame[0] = 0x80 | op
+	switch n := len(data); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !c.client {
+		_, err := c.conn.Write(append(frame, data...))
+		return err
+	}
+	frame[1] |= 0x80
+	var key [4]byte
+	_, _ = rand.Read(key[:])
+	frame = append(frame, key[:]...)
+	for i, b := range data {
+		frame = append(frame, b^key[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeClose sends a close frame, once.
+func (c *wsConn) writeClose(code int, reason string) error {
+	c.mu.Lock()
+	sent := c.closeSent
+	c.closeSent = true
+	c.mu.Unlock()
+	if sent {
+		return nil
+	}
+	var payload []byte
+	if code != wsCloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(wsClose, payload)
+}
+
+// wsProtocolError is a violation of the protocol by the peer, which closes
+// the connection with its code.
+type wsProtocolError struct {
+	code   int
+	reason string
+}
+
+func (e *wsProtocolError) Error() string {
+	return "websocket: " + e.reason
+}
+
+// readFrame reads a frame and returns its FIN bit, opcode and payload. Data
+// frames with a payload larger than limit fail. The payload is read as it
+// arrives, so a forged length does not allocate more than the peer sends.
+func (c *wsConn) readFrame(limit int) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "reserved bits set"}
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "invalid masking"}
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (n > 125 || !fin) {
+		return false, 0, nil, &wsProtocolError{wsCloseProtocol, "invalid control frame"}
+	}
+	if op < wsClose && n > uint64(limit) {
+		return false, 0, nil, &wsProtocolError{wsCloseTooLarge, "message too large"}
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, c.br, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, 0, nil, err
+	}
+	payload := buf.Bytes()
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage reads the next data message and returns its opcode and data.
+// Pings are answered and a close frame ends the connection.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var op byte
+	var data []byte
+	for {
+		fin, frameOp, payload, err := c.readFrame(c.maxMessage - len(data))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case wsPing:
+			_ = c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code, reason := wsCloseNoStatus, ""
+			if len(payload) >= 2 {
+				code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			}
+			_ = c.writeClose(code, "")
+			c.finish(code, reason)
+			return 0, nil, errWSClosed
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, &wsProtocolError{wsCloseProtocol, "expected continuation frame"}
+			}
+			op, data = frameOp, payload
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, &wsProtocolError{wsCloseProtocol, "unexpected continuation frame"}
+			}
+			data = append(data, payload...)
+		default:
+			return 0, nil, &wsProtocolError{wsCloseProtocol, fmt.Sprintf("unknown opcode %d", frameOp)}
+		}
+		if fin {
+			if op == wsText && !utf8.Valid(data) {
+				return 0, nil, &wsProtocolError{wsCloseInvalidData, "invalid UTF-8 in text message"}
+			}
+			return op, data, nil
+		}
+	}
+}
+
+// fail ends the connection after a failed read.
+func (c *wsConn) fail(err error) {
+	var perr *wsProtocolError
+	if errors.As(err, &perr) {
+		_ = c.writeClose(perr.code, perr.reason)
+		c.finish(perr.code, perr.reason)
+	} else if err != errWSClosed {
+		c.finish(wsCloseAbnormal, err.Error())
+	}
+}
+
+// object returns the connection object of c, which has the methods of a
+// chan object.
+func (c *wsConn) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"remote_addr": &vvm.String{Value: c.conn.RemoteAddr().String()},
+		"subprotocol": &vvm.String{Value: c.subprotocol},
+		// send(msg string/bytes/object) => undefined/error
+		"send": &vvm.BuiltinFunction{Name: "send", Value: c.send},
+		// recv() => string/bytes/undefined
+		"recv": &vvm.BuiltinFunction{Name: "recv", Value: c.recv},
+		// close(code int, reason string) => undefined
+		"close": &vvm.BuiltinFunction{Name: "close", Value: c.close},
+		// ping(data string/bytes) => undefined/error
+		"ping": &vvm.BuiltinFunction{Name: "ping", Value: c.ping},
+		// close_status() => map/undefined
+		"close_status": &vvm.BuiltinFunction{Name: "close_status", Value: c.closeStatus},
+	}}
+}
+
+// send sends a text message for a string, a binary message for bytes, or
+// else the value encoded as JSON in a text message.
+func (c *wsConn) send(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	op, data := byte(wsText), []byte(nil)
+	switch arg := args[0].(type) {
+	case *vvm.String:
+		data = []byte(arg.Value)
+	case *vvm.Bytes:
+		op, data = wsBinary, arg.Value
+	default:
+		var err error
+		if data, err = json.Encode(arg); err != nil {
+			return wrapError(err), nil
+		}
+	}
+	return c.write(ctx, op, data)
+}
+
+func (c *wsConn) ping(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) > 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var data []byte
+	if len(args) == 1 {
+		var err error
+		if data, err = netDataArg(args, 0); err != nil {
+			return nil, err
+		}
+		if len(data) > 125 {
+			return wrapError(errors.New("websocket: ping data too long")), nil
+		}
+	}
+	return c.write(ctx, wsPing, data)
+}
+
+// write writes a frame, unless the connection is closing.
+func (c *wsConn) write(ctx context.Context, op byte, data []byte) (vvm.Object, error) {
+	c.mu.Lock()
+	closing := c.closeSent || c.code != 0
+	c.mu.Unlock()
+	if closing {
+		return wrapError(errWSClosed), nil
+	}
+	res, err := netDo(ctx, c.conn, func() (vvm.Object, error) {
+		return vvm.UndefinedValue, c.writeFrame(op, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := res.(*vvm.Error); ok {
+		c.finish(wsCloseAbnormal, "write failed")
+	}
+	return res, nil
+}
+
+// recv returns the next message, a string for text and bytes for binary
+// messages, or undefined once the connection is closed.
+func (c *wsConn) recv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if _, _, closed := c.status(); closed {
+		return vvm.UndefinedValue, nil
+	}
+	stop := context.AfterFunc(ctx, func() { c.finish(wsCloseAbnormal, "aborted") })
+	op, data, err := c.readMessage()
+	stop()
+	if ctx.Err() != nil {
+		return nil, vvm.ErrVMAborted
+	}
+	if err != nil {
+		c.fail(err)
+		return vvm.UndefinedValue, nil
+	}
+	if op == wsBinary {
+		return &vvm.Bytes{Value: data}, nil
+	}
+	if len(data) > vvm.MaxStringLen {
+		return nil, vvm.ErrStringLimit
+	}
+	return &vvm.String{Value: string(data)}, nil
+}
+
+// close starts the closing handshake with a code, 1000 by default, and a
+// reason, and waits a second at most for the peer to answer.
+func (c *wsConn) close(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) > 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	code, reason := wsCloseNormal, ""
+	if len(args) > 0 {
+		var ok bool
+		if code, ok = vvm.ToInt(args[0]); !ok || code < 1000 || code > 4999 {
+			return nil, vvm.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "close code",
+				Found:    args[0].TypeName(),
+			}
+		}
+	}
+	if len(args) > 1 {
+		var err error
+		if reason, err = netStringArg(args, 1); err != nil {
+			return nil, err
+		}
+		if len(reason) > 123 {
+			return nil, errors.New("websocket: close reason too long")
+		}
+	}
+	if _, _, closed := c.status(); closed {
+		return vvm.UndefinedValue, nil
+	}
+	if err := c.writeClose(code, reason); err != nil {
+		c.finish(wsCloseAbnormal, err.Error())
+		return vvm.UndefinedValue, nil
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsCloseWait))
+	if c.rmu.TryLock() {
+		// nobody reads: wait for the close frame of the peer here
+		defer c.rmu.Unlock()
+		for {
+			if _, _, err := c.readMessage(); err != nil {
+				break
+			}
+		}
+		c.finish(code, reason)
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// closeStatus returns the close code and reason of a closed connection.
+func (c *wsConn) closeStatus(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	code, reason, closed := c.status()
+	if !closed {
+		return vvm.UndefinedValue, nil
+	}
+	return &vvm.Map{Value: map[string]vvm.Object{
+		"code":   &vvm.Int{Value: int64(code)},
+		"reason": &vvm.String{Value: reason},
+	}}, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept header value for key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsHeaderContains reports whether a comma separated header of h contains
+// token, case insensitively.
+func wsHeaderContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsUpgrade upgrades the request r, answered by hw, to a WebSocket
+// connection. A request which is not a valid WebSocket handshake is
+// answered with an error status.
+func wsUpgrade(hw *httpWriter, r *http.Request, opts *wsOptions) (*wsConn, error) {
+	fail := func(status int, reason string) (*wsConn, error) {
+		http.Error(hw, http.StatusText(status), status)
+		return nil, errors.New("websocket: " + reason)
+	}
+	if r.Method != http.MethodGet ||
+		!wsHeaderContains(r.Header, "Connection", "upgrade") ||
+		!wsHeaderContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		hw.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, "missing key")
+	}
+	if !wsOriginAllowed(r, opts.origins) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+	var subprotocol string
+	for _, p := range opts.subprotocols {
+		if wsHeaderContains(r.Header, "Sec-WebSocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+	if hw.written() {
+		return nil, errors.New("websocket: response already written")
+	}
+
+	conn, brw, err := http.NewResponseController(hw.w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+	hw.mu.Lock()
+	hw.status = http.StatusSwitchingProtocols
+	hw.mu.Unlock()
+	_ = conn.SetDeadline(time.Time{})
+
+	h := hw.Header().Clone()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", wsAccept(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = h.Write(brw)
+	_, _ = brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, brw.Reader, false, opts, subprotocol), nil
+}
+
+// wsOriginAllowed reports whether the origin of a browser request is in
+// origins, or else the host of the request.
+func wsOriginAllowed(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsUpgradeFunc returns the upgrade method of the request r.
+func wsUpgradeFunc(r *httpRequest) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if len(args) > 1 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		opts, err := wsServerOptions(args, 0, "first")
+		if err != nil {
+			return nil, err
+		}
+		if r.w == nil {
+			return wrapError(errors.New("websocket: request cannot be upgraded")), nil
+		}
+		c, err := wsUpgrade(r.w, r.req, opts)
+		if err != nil {
+			return wrapError(err), nil
+		}
+		return c.object(), nil
+	}
+}
+
+// wsServerOptions returns the connection options of the server side at
+// index i of args.
+func wsServerOptions(args []vvm.Object, i int, name string) (*wsOptions, error) {
+	m, err := optionsArg(args, i, name)
+	if err != nil {
+		return nil, err
+	}
+	opts := &wsOptions{}
+	for key, value := range m {
+		known, ok := opts.option(key, value)
+		if !known {
+			return nil, fmt.Errorf("websocket: unknown option: %s", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("websocket: invalid option %s: %s", key, value.TypeName())
+		}
+	}
+	return opts, nil
+}
+
+// websocket adds a route upgrading the GET requests of a pattern to
+// WebSocket connections, which are passed to fn with the request. The
+// connection is closed once fn returns.
+func (rt *httpRouter) websocket(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	pattern, err := netStringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	opts, err := wsServerOptions(args, 2, "third")
+	if err != nil {
+		return nil, err
+	}
+	fn := args[1]
+	handler := &vvm.BuiltinFunction{
+		Name: "websocket",
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			req := args[0].(*httpRequest)
+			c, err := wsUpgrade(req.w, req.req, opts)
+			if err != nil {
+				return vvm.UndefinedValue, nil
+			}
+			defer func() { _, _ = c.close(ctx) }()
+			if _, err := vvm.Invoke(ctx, fn, c.object(), req); err != nil {
+				c.finish(wsCloseUnexpected, "handler failed")
+				return nil, err
+			}
+			return vvm.UndefinedValue, nil
+		},
+	}
+	if err := rt.register("GET "+pattern, &httpRoute{router: rt, fn: handler}); err != nil {
+		return nil, err
+	}
+	return vvm.UndefinedValue, nil
+}
+
+// httpWebSocket connects to a WebSocket server.
+func httpWebSocket(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	s, err := netStringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, err := optionsArg(args, 1, "second")
+	if err != nil {
+		return nil, err
+	}
+	opts := &wsOptions{}
+	headers := http.Header{}
+	var timeout time.Duration
+	var tlsConfig *tls.Config
+	for key, value := range m {
+		known, ok := opts.option(key, value)
+		if known && key == "origins" {
+			known = false
+		}
+		if !known {
+			ok = true
+			switch key {
+			case "headers":
+				ok = httpHeaders(headers, value)
+			case "timeout":
+				var n int64
+				n, ok = vvm.ToInt64(value)
+				timeout = time.Duration(n)
+			case "tls":
+				if tlsConfig, err = httpTLSConfig(value); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("websocket: unknown option: %s", key)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("websocket: invalid option %s: %s", key, value.TypeName())
+		}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	var secure bool
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme, secure = "https", true
+	default:
+		return wrapError(fmt.Errorf("websocket: unsupported scheme: %s", u.Scheme)), nil
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return netError(ctx, err)
+	}
+	c, err := wsHandshake(dialCtx, conn, u, secure, tlsConfig, headers, opts)
+	if err != nil {
+		_ = conn.Close()
+		return netError(ctx, err)
+	}
+	return c.object(), nil
+}
+
+// wsHandshake makes the opening handshake of a client connection to u.
+func wsHandshake(ctx context.Context, conn net.Conn, u *url.URL, secure bool, tlsConfig *tls.Config, headers http.Header, opts *wsOptions) (*wsConn, error) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if secure {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tc
+	}
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     headers,
+		Host:       u.Host,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(opts.subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.subprotocols, ", "))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("websocket: invalid accept key")
+	}
+	return newWSConn(conn, br, true, opts, resp.Header.Get("Sec-WebSocket-Protocol")), nil
+}
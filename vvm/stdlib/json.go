@@ -37,17 +37,13 @@ func jsonDecode(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 	case *vvm.Bytes:
 		v, err := json.Decode(o.Value)
 		if err != nil {
-			return &vvm.Error{
-				Value: &vvm.String{Value: err.Error()},
-			}, nil
+			return wrapError(err), nil
 		}
 		return v, nil
 	case *vvm.String:
 		v, err := json.Decode([]byte(o.Value))
 		if err != nil {
-			return &vvm.Error{
-				Value: &vvm.String{Value: err.Error()},
-			}, nil
+			return wrapError(err), nil
 		}
 		return v, nil
 	default:
@@ -66,7 +62,7 @@ func jsonEncode(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 
 	b, err := json.Encode(args[0])
 	if err != nil {
-		return &vvm.Error{Value: &vvm.String{Value: err.Error()}}, nil
+		return wrapError(err), nil
 	}
 
 	return &vvm.Bytes{Value: b}, nil
@@ -100,18 +96,14 @@ func jsonIndent(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 		var dst bytes.Buffer
 		err := gojson.Indent(&dst, o.Value, prefix, indent)
 		if err != nil {
-			return &vvm.Error{
-				Value: &vvm.String{Value: err.Error()},
-			}, nil
+			return wrapError(err), nil
 		}
 		return &vvm.Bytes{Value: dst.Bytes()}, nil
 	case *vvm.String:
 		var dst bytes.Buffer
 		err := gojson.Indent(&dst, []byte(o.Value), prefix, indent)
 		if err != nil {
-			return &vvm.Error{
-				Value: &vvm.String{Value: err.Error()},
-			}, nil
+			return wrapError(err), nil
 		}
 		return &vvm.Bytes{Value: dst.Bytes()}, nil
 	default:
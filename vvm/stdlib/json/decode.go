@@ -7,6 +7,8 @@
 package json
 
 import (
+	"bytes"
+	"math/big"
 	"strconv"
 	"unicode"
 	"unicode/utf16"
@@ -15,6 +17,10 @@ import (
 	"github.com/malivvan/vv/vvm"
 )
 
+// maxExactFloat is 2^53, the largest magnitude up to which every integer has
+// an exact float representation.
+var maxExactFloat = new(big.Int).Lsh(big.NewInt(1), 53)
+
 // Decode parses the JSON-encoded data and returns the result object.
 func Decode(data []byte) (vvm.Object, error) {
 	var d decodeState
@@ -210,6 +216,14 @@ func (d *decodeState) literal() (vvm.Object, error) {
 		if c != '-' && (c < '0' || c > '9') {
 			panic(phasePanicMsg)
 		}
+		// integers too large to be represented exactly by a float are
+		// decoded as bigints
+		if bytes.IndexAny(item, ".eE") < 0 {
+			if v, ok := new(big.Int).SetString(string(item), 10); ok &&
+				v.CmpAbs(maxExactFloat) > 0 {
+				return &vvm.BigInt{Value: v}, nil
+			}
+		}
 		n, _ := strconv.ParseFloat(string(item), 10)
 		return &vvm.Float{Value: n}, nil
 	}
@@ -158,41 +158,24 @@ func Encode(o vvm.Object) ([]byte, error) {
 		}
 		b = append(b, ']')
 	case *vvm.Map:
-		b = append(b, '{')
-		len1 := len(o.Value) - 1
+		return encodeMap(b, o.Value, o.Hashed)
+	case *vvm.ImmutableMap:
+		return encodeMap(b, o.Value, o.Hashed)
+	case *vvm.Set:
+		b = append(b, '[')
 		idx := 0
-		for key, value := range o.Value {
-			b = encodeString(b, key)
-			b = append(b, ':')
-			eb, err := Encode(value)
-			if err != nil {
-				return nil, err
-			}
-			b = append(b, eb...)
-			if idx < len1 {
+		for _, elem := range o.Value {
+			if idx > 0 {
 				b = append(b, ',')
 			}
-			idx++
-		}
-		b = append(b, '}')
-	case *vvm.ImmutableMap:
-		b = append(b, '{')
-		len1 := len(o.Value) - 1
-		idx := 0
-		for key, value := range o.Value {
-			b = encodeString(b, key)
-			b = append(b, ':')
-			eb, err := Encode(value)
+			eb, err := Encode(elem)
 			if err != nil {
 				return nil, err
 			}
 			b = append(b, eb...)
-			if idx < len1 {
-				b = append(b, ',')
-			}
 			idx++
 		}
-		b = append(b, '}')
+		b = append(b, ']')
 	case *vvm.Bool:
 		if o.IsFalsy() {
 			b = strconv.AppendBool(b, false)
@@ -238,6 +221,10 @@ func Encode(o vvm.Object) ([]byte, error) {
 		b = append(b, y...)
 	case *vvm.Int:
 		b = strconv.AppendInt(b, o.Value, 10)
+	case *vvm.BigInt:
+		b = o.Value.Append(b, 10)
+	case *vvm.Decimal:
+		b = append(b, o.String()...)
 	case *vvm.String:
 		// string encoding bug is fixed with newly introduced function
 		// encodeString(). See: https://github.com/malivvan/vv/issues/268
@@ -256,6 +243,49 @@ func Encode(o vvm.Object) ([]byte, error) {
 	return b, nil
 }
 
+// encodeMap encodes a map as JSON object. Non-string keys are encoded as
+// JSON strings containing the JSON encoding of the key, e.g. 1 becomes "1".
+func encodeMap(b []byte, value map[string]vvm.Object, hashed map[vvm.HashKey]vvm.MapEntry) ([]byte, error) {
+	b = append(b, '{')
+	idx := 0
+	for key, value := range value {
+		if idx > 0 {
+			b = append(b, ',')
+		}
+		b = encodeString(b, key)
+		b = append(b, ':')
+		eb, err := Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, eb...)
+		idx++
+	}
+	for _, e := range hashed {
+		if idx > 0 {
+			b = append(b, ',')
+		}
+		kb, err := Encode(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(kb) > 0 && kb[0] == '"' {
+			b = append(b, kb...)
+		} else {
+			b = encodeString(b, string(kb))
+		}
+		b = append(b, ':')
+		eb, err := Encode(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, eb...)
+		idx++
+	}
+	b = append(b, '}')
+	return b, nil
+}
+
 // encodeString encodes given string as JSON string according to
 // https://www.json.org/img/string.png
 // Implementation is inspired by https://github.com/json-iterator/go
@@ -2,6 +2,7 @@ package json_test
 
 import (
 	gojson "encoding/json"
+	"math/big"
 	"testing"
 
 	"github.com/malivvan/vv/vvm"
@@ -59,6 +60,45 @@ func TestJSON(t *testing.T) {
 		"arr": ARR{1, 2, 3, MAP{"a": false, "b": 109.4}}})
 }
 
+func TestEncodeHashed(t *testing.T) {
+	m := &vvm.Map{Value: map[string]vvm.Object{}}
+	require.NoError(t, m.IndexSet(&vvm.Int{Value: 1}, &vvm.String{Value: "one"}))
+	b, err := json.Encode(m)
+	require.NoError(t, err)
+	require.Equal(t, `{"1":"one"}`, string(b))
+
+	m = &vvm.Map{Value: map[string]vvm.Object{}}
+	require.NoError(t, m.IndexSet(&vvm.ImmutableArray{Value: []vvm.Object{
+		&vvm.Int{Value: 1}, &vvm.String{Value: "a"},
+	}}, vvm.TrueValue))
+	b, err = json.Encode(m)
+	require.NoError(t, err)
+	require.Equal(t, `{"[1,\"a\"]":true}`, string(b))
+
+	s, err := vvm.NewSet(&vvm.Int{Value: 1})
+	require.NoError(t, err)
+	b, err = json.Encode(s)
+	require.NoError(t, err)
+	require.Equal(t, `[1]`, string(b))
+}
+
+func TestBigNumbers(t *testing.T) {
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	d, err := vvm.ParseDecimal("-19.840")
+	require.NoError(t, err)
+	b, err := json.Encode(&vvm.Array{Value: []vvm.Object{&vvm.BigInt{Value: n}, d}})
+	require.NoError(t, err)
+	require.Equal(t, `[123456789012345678901234567890,-19.840]`, string(b))
+
+	o, err := json.Decode([]byte(`[123456789012345678901234567890, 9007199254740992, 9007199254740993]`))
+	require.NoError(t, err)
+	arr := o.(*vvm.Array).Value
+	require.Equal(t, &vvm.BigInt{Value: n}, arr[0])
+	require.Equal(t, &vvm.Float{Value: 9007199254740992}, arr[1])
+	require.Equal(t, "bigint", arr[2].TypeName())
+	require.Equal(t, "9007199254740993", arr[2].String())
+}
+
 func TestDecode(t *testing.T) {
 	testDecodeError(t, `{`)
 	testDecodeError(t, `}`)
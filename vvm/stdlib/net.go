@@ -0,0 +1,522 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/malivvan/vv/vvm"
+)
+
+// netMaxLine is the default maximal length of lines read by read_line.
+const netMaxLine = 64 << 10
+
+// errNetLineTooLong is returned by read_line for lines exceeding the maximal
+// line length.
+var errNetLineTooLong = errors.New("net: line too long")
+
+var netModule = map[string]vvm.Object{
+	"dial":        &vvm.BuiltinFunction{Name: "dial", Value: netDialFunc("tcp")},           // dial(addr string, opts map) => conn/error
+	"dial_udp":    &vvm.BuiltinFunction{Name: "dial_udp", Value: netDialFunc("udp")},       // dial_udp(addr string, opts map) => conn/error
+	"dial_unix":   &vvm.BuiltinFunction{Name: "dial_unix", Value: netDialFunc("unix")},     // dial_unix(path string, opts map) => conn/error
+	"listen":      &vvm.BuiltinFunction{Name: "listen", Value: netListenFunc("tcp")},       // listen(addr string, opts map) => listener/error
+	"listen_unix": &vvm.BuiltinFunction{Name: "listen_unix", Value: netListenFunc("unix")}, // listen_unix(path string, opts map) => listener/error
+	"listen_udp":  &vvm.BuiltinFunction{Name: "listen_udp", Value: netListenUDP},           // listen_udp(addr string) => packet conn/error
+	"resolve":     &vvm.BuiltinFunction{Name: "resolve", Value: netResolve},                // resolve(addr string, network string) => string/error
+	"lookup_host": &vvm.BuiltinFunction{Name: "lookup_host", Value: netLookupHost},         // lookup_host(host string) => [string]/error
+}
+
+// netDo runs op, a blocking operation on c, and returns its result. c is
+// closed if ctx is done meanwhile, which aborts the VM. Errors of op other
+// than the limits of the VM are returned as error values.
+func netDo(ctx context.Context, c io.Closer, op func() (vvm.Object, error)) (vvm.Object, error) {
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+	res, err := op()
+	stop()
+	if ctx.Err() != nil {
+		return nil, vvm.ErrVMAborted
+	}
+	if err == vvm.ErrBytesLimit || err == vvm.ErrStringLimit {
+		return nil, err
+	} else if err != nil {
+		return wrapError(err), nil
+	}
+	return res, nil
+}
+
+// netStringArg returns the string argument at index i.
+func netStringArg(args []vvm.Object, i int) (string, error) {
+	s, ok := vvm.ToString(args[i])
+	if !ok {
+		return "", vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "string(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return s, nil
+}
+
+// netDataArg returns the data of the string or bytes argument at index i.
+func netDataArg(args []vvm.Object, i int) ([]byte, error) {
+	if b, ok := args[i].(*vvm.Bytes); ok {
+		return b.Value, nil
+	}
+	s, ok := vvm.ToString(args[i])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "string/bytes",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return []byte(s), nil
+}
+
+// netBufArg returns the bytes argument at index i, which is read into.
+func netBufArg(args []vvm.Object, i int) ([]byte, error) {
+	b, ok := args[i].(*vvm.Bytes)
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     ordinal(i),
+			Expected: "bytes",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return b.Value, nil
+}
+
+// netDialFunc returns the function connecting to an address of network.
+func netDialFunc(network string) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		addr, err := netStringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := optionsArg(args, 1, "second")
+		if err != nil {
+			return nil, err
+		}
+		var d net.Dialer
+		maxLine := netMaxLine
+		for key, value := range opts {
+			ok := true
+			switch key {
+			case "max_line":
+				maxLine, ok = netMaxLineOption(value)
+			case "timeout":
+				var n int64
+				n, ok = vvm.ToInt64(value)
+				d.Timeout = time.Duration(n)
+			case "local_addr":
+				var s string
+				if s, ok = vvm.ToString(value); ok {
+					if d.LocalAddr, err = netLocalAddr(network, s); err != nil {
+						return wrapError(err), nil
+					}
+				}
+			default:
+				return nil, fmt.Errorf("net: unknown dial option: %s", key)
+			}
+			if !ok {
+				return nil, fmt.Errorf("net: invalid dial option %s: %s", key, value.TypeName())
+			}
+		}
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return netError(ctx, err)
+		}
+		return newNetConn(conn, maxLine).object(), nil
+	}
+}
+
+// netMaxLineOption returns the value of the max_line option, limited to the
+// maximal string length.
+func netMaxLineOption(value vvm.Object) (int, bool) {
+	n, ok := vvm.ToInt(value)
+	if !ok || n <= 0 {
+		return 0, false
+	}
+	return min(n, vvm.MaxStringLen), true
+}
+
+// netLocalAddr resolves the local address of a connection of network.
+func netLocalAddr(network, addr string) (net.Addr, error) {
+	switch network {
+	case "tcp":
+		return net.ResolveTCPAddr(network, addr)
+	case "udp":
+		return net.ResolveUDPAddr(network, addr)
+	default:
+		return net.ResolveUnixAddr(network, addr)
+	}
+}
+
+// netListenFunc returns the function listening on an address of network.
+func netListenFunc(network string) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		addr, err := netStringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := optionsArg(args, 1, "second")
+		if err != nil {
+			return nil, err
+		}
+		maxLine := netMaxLine
+		for key, value := range opts {
+			ok := true
+			switch key {
+			case "max_line":
+				maxLine, ok = netMaxLineOption(value)
+			default:
+				return nil, fmt.Errorf("net: unknown listen option: %s", key)
+			}
+			if !ok {
+				return nil, fmt.Errorf("net: invalid listen option %s: %s", key, value.TypeName())
+			}
+		}
+		var lc net.ListenConfig
+		ln, err := lc.Listen(ctx, network, addr)
+		if err != nil {
+			return wrapError(err), nil
+		}
+		return netListenerObject(ln, maxLine), nil
+	}
+}
+
+// netListenerObject returns the listener object of ln, whose connections
+// read lines of up to maxLine bytes.
+func netListenerObject(ln net.Listener, maxLine int) vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"addr": &vvm.String{Value: ln.Addr().String()},
+		// accept() => conn/error
+		"accept": &vvm.BuiltinFunction{
+			Name: "accept",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				return netDo(ctx, ln, func() (vvm.Object, error) {
+					conn, err := ln.Accept()
+					if err != nil {
+						return nil, err
+					}
+					return newNetConn(conn, maxLine).object(), nil
+				})
+			},
+		},
+		// close() => true/error
+		"close": &vvm.BuiltinFunction{
+			Name:  "close",
+			Value: FuncARE(ln.Close),
+		},
+	}}
+}
+
+// netConn is a connection, whose reads are buffered for read_line.
+type netConn struct {
+	conn    net.Conn
+	maxLine int
+
+	rmu sync.Mutex // guards r
+	r   *bufio.Reader
+}
+
+func newNetConn(conn net.Conn, maxLine int) *netConn {
+	return &netConn{conn: conn, maxLine: maxLine, r: bufio.NewReader(conn)}
+}
+
+// object returns the connection object of c.
+func (c *netConn) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"local_addr":  &vvm.String{Value: c.conn.LocalAddr().String()},
+		"remote_addr": &vvm.String{Value: c.conn.RemoteAddr().String()},
+		// read(buf bytes) => int/error
+		"read": &vvm.BuiltinFunction{Name: "read", Value: c.read},
+		// read_line() => string/error
+		"read_line": &vvm.BuiltinFunction{Name: "read_line", Value: c.readLine},
+		// write(data string/bytes) => int/error
+		"write": &vvm.BuiltinFunction{Name: "write", Value: c.write},
+		// close() => true/error
+		"close": &vvm.BuiltinFunction{
+			Name:  "close",
+			Value: FuncARE(c.conn.Close),
+		},
+		// set_deadline(t time/int) => true/error
+		"set_deadline": &vvm.BuiltinFunction{
+			Name:  "set_deadline",
+			Value: netDeadlineFunc(c.conn.SetDeadline),
+		},
+		// set_read_deadline(t time/int) => true/error
+		"set_read_deadline": &vvm.BuiltinFunction{
+			Name:  "set_read_deadline",
+			Value: netDeadlineFunc(c.conn.SetReadDeadline),
+		},
+		// set_write_deadline(t time/int) => true/error
+		"set_write_deadline": &vvm.BuiltinFunction{
+			Name:  "set_write_deadline",
+			Value: netDeadlineFunc(c.conn.SetWriteDeadline),
+		},
+	}}
+}
+
+func (c *netConn) read(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	buf, err := netBufArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return netDo(ctx, c.conn, func() (vvm.Object, error) {
+		c.rmu.Lock()
+		defer c.rmu.Unlock()
+		n, err := c.r.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return &vvm.Int{Value: int64(n)}, nil
+	})
+}
+
+// readLine reads a line without its line ending. The last line may lack a
+// line ending; once all lines are read, an EOF error is returned. Lines
+// longer than the maximal line length fail.
+func (c *netConn) readLine(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return netDo(ctx, c.conn, func() (vvm.Object, error) {
+		c.rmu.Lock()
+		defer c.rmu.Unlock()
+		var line []byte
+		for {
+			chunk, err := c.r.ReadSlice('\n')
+			line = append(line, chunk...)
+			if len(line) > c.maxLine+len("\r\n") {
+				return nil, errNetLineTooLong
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && len(line) > 0 {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			break
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) > c.maxLine {
+			return nil, errNetLineTooLong
+		}
+		return &vvm.String{Value: string(line)}, nil
+	})
+}
+
+func (c *netConn) write(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	data, err := netDataArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return netDo(ctx, c.conn, func() (vvm.Object, error) {
+		n, err := c.conn.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		return &vvm.Int{Value: int64(n)}, nil
+	})
+}
+
+// netDeadlineFunc returns the function setting a deadline with set. The
+// deadline is a time, or a duration from now; a zero duration clears it.
+func netDeadlineFunc(set func(t time.Time) error) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if len(args) != 1 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		var t time.Time
+		switch arg := args[0].(type) {
+		case *vvm.Time:
+			t = arg.Value
+		default:
+			d, ok := vvm.ToInt64(arg)
+			if !ok {
+				return nil, vvm.ErrInvalidArgumentType{
+					Name:     "first",
+					Expected: "time/int",
+					Found:    arg.TypeName(),
+				}
+			}
+			if d != 0 {
+				t = time.Now().Add(time.Duration(d))
+			}
+		}
+		return wrapError(set(t)), nil
+	}
+}
+
+func netListenUDP(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	addr, err := netStringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"local_addr": &vvm.String{Value: conn.LocalAddr().String()},
+		// read_from(buf bytes) => [int, string]/error
+		"read_from": &vvm.BuiltinFunction{
+			Name: "read_from",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				buf, err := netBufArg(args, 0)
+				if err != nil {
+					return nil, err
+				}
+				return netDo(ctx, conn, func() (vvm.Object, error) {
+					n, from, err := conn.ReadFrom(buf)
+					if err != nil {
+						return nil, err
+					}
+					return &vvm.Array{Value: []vvm.Object{
+						&vvm.Int{Value: int64(n)},
+						&vvm.String{Value: from.String()},
+					}}, nil
+				})
+			},
+		},
+		// write_to(data string/bytes, addr string) => int/error
+		"write_to": &vvm.BuiltinFunction{
+			Name: "write_to",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 2 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				data, err := netDataArg(args, 0)
+				if err != nil {
+					return nil, err
+				}
+				addr, err := netStringArg(args, 1)
+				if err != nil {
+					return nil, err
+				}
+				to, err := net.ResolveUDPAddr("udp", addr)
+				if err != nil {
+					return wrapError(err), nil
+				}
+				return netDo(ctx, conn, func() (vvm.Object, error) {
+					n, err := conn.WriteTo(data, to)
+					if err != nil {
+						return nil, err
+					}
+					return &vvm.Int{Value: int64(n)}, nil
+				})
+			},
+		},
+		// close() => true/error
+		"close": &vvm.BuiltinFunction{
+			Name:  "close",
+			Value: FuncARE(conn.Close),
+		},
+		// set_deadline(t time/int) => true/error
+		"set_deadline": &vvm.BuiltinFunction{
+			Name:  "set_deadline",
+			Value: netDeadlineFunc(conn.SetDeadline),
+		},
+	}}, nil
+}
+
+// netResolve resolves the host and port of an address of a network, tcp by
+// default, to an IP address and port number.
+func netResolve(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	addr, err := netStringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp"
+	if len(args) == 2 {
+		if network, err = netStringArg(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	ipNetwork := "ip"
+	switch network {
+	case "tcp", "udp":
+	case "tcp4", "udp4":
+		ipNetwork = "ip4"
+	case "tcp6", "udp6":
+		ipNetwork = "ip6"
+	default:
+		return wrapError(net.UnknownNetworkError(network)), nil
+	}
+	host, portName, err := net.SplitHostPort(addr)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, portName)
+	if err != nil {
+		return netError(ctx, err)
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, ipNetwork, host)
+	if err != nil {
+		return netError(ctx, err)
+	}
+	return &vvm.String{Value: netip.AddrPortFrom(ips[0].Unmap(), uint16(port)).String()}, nil
+}
+
+func netLookupHost(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	host, err := netStringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return netError(ctx, err)
+	}
+	arr := &vvm.Array{}
+	for _, addr := range addrs {
+		arr.Value = append(arr.Value, &vvm.String{Value: addr})
+	}
+	return arr, nil
+}
+
+// netError returns the result of a call that failed with err. The VM is
+// aborted if ctx, the context of the call, is done.
+func netError(ctx context.Context, err error) (vvm.Object, error) {
+	if ctx.Err() != nil {
+		return nil, vvm.ErrVMAborted
+	}
+	return wrapError(err), nil
+}
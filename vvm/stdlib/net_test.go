@@ -0,0 +1,216 @@
+package stdlib_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+const netImports = `net := import("net"); times := import("times");`
+
+func TestNetTCP(t *testing.T) {
+	expect(t, netImports+`
+ln := net.listen("127.0.0.1:0")
+server := start(func() {
+	c := ln.accept()
+	lines := []
+	for {
+		line := c.read_line()
+		if is_error(line) {
+			break
+		}
+		lines = append(lines, line)
+		c.write("echo " + line + "\n")
+	}
+	c.close()
+	return lines
+})
+c := net.dial(ln.addr, {timeout: times.second})
+c.write("hello\r\n")
+a := c.read_line()
+c.write("world\n")
+buf := bytes(5)
+n := c.read(buf)
+b := c.read_line()
+c.write(bytes("last"))
+c.close()
+ln.close()
+out := [a, string(buf[:n]), b, server.result(), c.remote_addr == ln.addr, is_error(ln.accept())]
+`, nil, nil, ARR{"echo hello", "echo ", "world", ARR{"hello", "world", "last"}, true, true})
+}
+
+func TestNetMaxLine(t *testing.T) {
+	expect(t, netImports+`
+ln := net.listen("127.0.0.1:0", {max_line: 4})
+server := start(func() {
+	c := ln.accept()
+	c.write("abc\n")
+	res := [c.read_line(), string(c.read_line()), c.read_line()]
+	c.close()
+	return res
+})
+c := net.dial(ln.addr, {max_line: 2})
+c.write("abcd\r\nabcde\nok\n")
+a := c.read_line()
+c.close()
+ln.close()
+out := [server.result(), string(a)]
+`, nil, nil, ARR{ARR{"abcd", `error: "net: line too long"`, "ok"}, `error: "net: line too long"`})
+}
+
+func TestNetUDP(t *testing.T) {
+	expect(t, netImports+`
+pc := net.listen_udp("127.0.0.1:0")
+c := net.dial_udp(pc.local_addr)
+c.write("ping")
+buf := bytes(16)
+from := pc.read_from(buf)
+pc.write_to("pong", from[1])
+n := c.read(buf)
+pc.close()
+c.close()
+out := [from[0], from[1] == c.local_addr, string(buf[:n])]
+`, nil, nil, ARR{4, true, "pong"})
+}
+
+func TestNetUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vv.sock")
+	expect(t, netImports+`
+ln := net.listen_unix(path)
+server := start(func() {
+	c := ln.accept()
+	c.write(c.read_line() + "!")
+	c.close()
+})
+c := net.dial_unix(path)
+c.write("hi\n")
+out := [c.read_line(), is_error(c.read_line())]
+server.wait()
+ln.close()
+`, nil, map[string]interface{}{"path": path}, ARR{"hi!", true})
+}
+
+func TestNetDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = ln.Close() }()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			defer func() { _ = c.Close() }()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	expect(t, netImports+`
+c := net.dial(addr)
+c.set_read_deadline(10 * times.millisecond)
+a := c.read_line()
+c.set_deadline(times.now())
+b := c.write("x")
+out := [is_error(a), is_error(b), c.set_deadline(0)]
+c.close()
+`, nil, map[string]interface{}{"addr": ln.Addr().String()}, ARR{true, true, true})
+}
+
+func TestNetAbort(t *testing.T) {
+	s := vv.NewScript([]byte(`
+net := import("net")
+ln := net.listen("127.0.0.1:0")
+ln.accept()
+`))
+	s.SetImports(stdlib.GetModuleMap("net"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.RunContext(ctx)
+	require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), err)
+}
+
+func TestNetResolve(t *testing.T) {
+	expect(t, netImports+`
+out := [
+	net.resolve("127.0.0.1:http"),
+	net.resolve("[::1]:53", "udp"),
+	is_error(net.resolve("127.0.0.1:80", "ip")),
+	is_error(net.resolve("localhost")),
+	net.lookup_host("127.0.0.1")
+]
+`, nil, nil, ARR{"127.0.0.1:80", "[::1]:53", true, true, ARR{"127.0.0.1"}})
+}
+
+func TestNetErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(`net := import("net");` + input))
+		s.SetImports(stdlib.GetModuleMap("net"))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	expectErr(`net.dial(undefined)`, "invalid type for argument 'first'")
+	expectErr(`net.dial("127.0.0.1:1", {retries: 1})`, "unknown dial option")
+	expectErr(`net.dial("127.0.0.1:1", {timeout: "x"})`, "invalid dial option timeout")
+	expectErr(`net.dial("127.0.0.1:1", {max_line: 0})`, "invalid dial option max_line")
+	expectErr(`net.listen("127.0.0.1:0", {backlog: 1})`, "unknown listen option")
+	expectErr(`ln := net.listen("127.0.0.1:0"); c := net.dial(ln.addr); c.read("x")`, "invalid type for argument 'first'")
+
+	expect(t, netImports+`
+ln := net.listen("127.0.0.1:0")
+addr := ln.addr
+ln.close()
+out := [is_error(net.dial(addr)), is_error(net.listen("256.0.0.1:0")), is_error(net.dial_unix("/nonexistent/vv.sock"))]
+`, nil, nil, ARR{true, true, true})
+}
+
+func TestNetPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy *stdlib.Policy
+		want   interface{}
+	}{
+		{&stdlib.Policy{}, "permission_denied"},
+		{&stdlib.Policy{Network: true}, true},
+	} {
+		s := vv.NewScript([]byte(`
+net := import("net")
+ln := net.listen("127.0.0.1:0")
+out := is_error(ln) ? ln.code : ln.close()
+`))
+		s.SetImports(stdlib.GetPolicyModuleMap(tc.policy, "net"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(tc.want), c.Get("out").Object())
+	}
+
+	// Unix sockets are files as well
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "**")}
+	for _, tc := range []struct {
+		policy *stdlib.Policy
+		want   interface{}
+	}{
+		{&stdlib.Policy{Network: true}, ARR{"permission_denied", "permission_denied"}},
+		{&stdlib.Policy{Network: true, FileRead: files}, ARR{"permission_denied", "permission_denied"}},
+		{&stdlib.Policy{FileRead: files, FileWrite: files}, ARR{"permission_denied", "permission_denied"}},
+		{&stdlib.Policy{Network: true, FileRead: files, FileWrite: files}, ARR{true, true}},
+	} {
+		s := vv.NewScript([]byte(`
+net := import("net")
+ln := net.listen_unix(path)
+c := net.dial_unix(path)
+out := [is_error(ln) ? ln.code : ln.close(), is_error(c) ? c.code : c.close()]
+`))
+		require.NoError(t, s.Add("path", filepath.Join(dir, "vv.sock")))
+		s.SetImports(stdlib.GetPolicyModuleMap(tc.policy, "net"))
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, object(tc.want), c.Get("out").Object())
+	}
+}
@@ -2,14 +2,21 @@ package stdlib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 
 	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/vfs"
 )
 
+// errHostOnly is returned by os functions that operate on the host directly
+// when the VM is configured with a virtual filesystem.
+var errHostOnly = errors.New("operation not supported by virtual filesystem")
+
 var osModule = map[string]vvm.Object{
 	"o_rdonly":            &vvm.Int{Value: int64(os.O_RDONLY)},
 	"o_wronly":            &vvm.Int{Value: int64(os.O_WRONLY)},
@@ -45,12 +52,15 @@ var osModule = map[string]vvm.Object{
 	}, // args() => array(string)
 	"chdir": &vvm.BuiltinFunction{
 		Name:  "chdir",
-		Value: FuncASRE(os.Chdir),
+		Value: osHostFunc(FuncASRE(os.Chdir)),
 	}, // chdir(dir string) => error
-	"chmod": osFuncASFmRE("chmod", os.Chmod), // chmod(name string, mode int) => error
+	"chmod": &vvm.BuiltinFunction{
+		Name:  "chmod",
+		Value: osHostFunc(osFuncASFmRE("chmod", os.Chmod).Value),
+	}, // chmod(name string, mode int) => error
 	"chown": &vvm.BuiltinFunction{
 		Name:  "chown",
-		Value: FuncASIIRE(os.Chown),
+		Value: osHostFunc(FuncASIIRE(os.Chown)),
 	}, // chown(name string, uid int, gid int) => error
 	"clearenv": &vvm.BuiltinFunction{
 		Name:  "clearenv",
@@ -58,7 +68,7 @@ var osModule = map[string]vvm.Object{
 	}, // clearenv()
 	"environ": &vvm.BuiltinFunction{
 		Name:  "environ",
-		Value: FuncARSs(os.Environ),
+		Value: osEnviron,
 	}, // environ() => array(string)
 	"exit": &vvm.BuiltinFunction{
 		Name:  "exit",
@@ -74,7 +84,7 @@ var osModule = map[string]vvm.Object{
 	}, // getegid() => int
 	"getenv": &vvm.BuiltinFunction{
 		Name:  "getenv",
-		Value: FuncASRS(os.Getenv),
+		Value: osGetenv,
 	}, // getenv(s string) => string
 	"geteuid": &vvm.BuiltinFunction{
 		Name:  "geteuid",
@@ -114,33 +124,49 @@ var osModule = map[string]vvm.Object{
 	}, // hostname() => string/error
 	"lchown": &vvm.BuiltinFunction{
 		Name:  "lchown",
-		Value: FuncASIIRE(os.Lchown),
+		Value: osHostFunc(FuncASIIRE(os.Lchown)),
 	}, // lchown(name string, uid int, gid int) => error
 	"link": &vvm.BuiltinFunction{
 		Name:  "link",
-		Value: FuncASSRE(os.Link),
+		Value: osHostFunc(FuncASSRE(os.Link)),
 	}, // link(oldname string, newname string) => error
 	"lookup_env": &vvm.BuiltinFunction{
 		Name:  "lookup_env",
 		Value: osLookupEnv,
 	}, // lookup_env(key string) => string/false
-	"mkdir":     osFuncASFmRE("mkdir", os.Mkdir),        // mkdir(name string, perm int) => error
-	"mkdir_all": osFuncASFmRE("mkdir_all", os.MkdirAll), // mkdir_all(name string, perm int) => error
+	"mkdir": &vvm.BuiltinFunction{
+		Name: "mkdir",
+		Value: osFSFunc(func(fsys vfs.FS) vvm.CallableFunc {
+			return osFuncASFmRE("mkdir", fsys.Mkdir).Value
+		}),
+	}, // mkdir(name string, perm int) => error
+	"mkdir_all": &vvm.BuiltinFunction{
+		Name: "mkdir_all",
+		Value: osFSFunc(func(fsys vfs.FS) vvm.CallableFunc {
+			return osFuncASFmRE("mkdir_all", fsys.MkdirAll).Value
+		}),
+	}, // mkdir_all(name string, perm int) => error
 	"readlink": &vvm.BuiltinFunction{
 		Name:  "readlink",
-		Value: FuncASRSE(os.Readlink),
+		Value: osHostFunc(FuncASRSE(os.Readlink)),
 	}, // readlink(name string) => string/error
 	"remove": &vvm.BuiltinFunction{
-		Name:  "remove",
-		Value: FuncASRE(os.Remove),
+		Name: "remove",
+		Value: osFSFunc(func(fsys vfs.FS) vvm.CallableFunc {
+			return FuncASRE(fsys.Remove)
+		}),
 	}, // remove(name string) => error
 	"remove_all": &vvm.BuiltinFunction{
-		Name:  "remove_all",
-		Value: FuncASRE(os.RemoveAll),
+		Name: "remove_all",
+		Value: osFSFunc(func(fsys vfs.FS) vvm.CallableFunc {
+			return FuncASRE(fsys.RemoveAll)
+		}),
 	}, // remove_all(name string) => error
 	"rename": &vvm.BuiltinFunction{
-		Name:  "rename",
-		Value: FuncASSRE(os.Rename),
+		Name: "rename",
+		Value: osFSFunc(func(fsys vfs.FS) vvm.CallableFunc {
+			return FuncASSRE(fsys.Rename)
+		}),
 	}, // rename(oldpath string, newpath string) => error
 	"setenv": &vvm.BuiltinFunction{
 		Name:  "setenv",
@@ -148,7 +174,7 @@ var osModule = map[string]vvm.Object{
 	}, // setenv(key string, value string) => error
 	"symlink": &vvm.BuiltinFunction{
 		Name:  "symlink",
-		Value: FuncASSRE(os.Symlink),
+		Value: osHostFunc(FuncASSRE(os.Symlink)),
 	}, // symlink(oldname string newname string) => error
 	"temp_dir": &vvm.BuiltinFunction{
 		Name:  "temp_dir",
@@ -156,7 +182,7 @@ var osModule = map[string]vvm.Object{
 	}, // temp_dir() => string
 	"truncate": &vvm.BuiltinFunction{
 		Name:  "truncate",
-		Value: FuncASI64RE(os.Truncate),
+		Value: osHostFunc(FuncASI64RE(os.Truncate)),
 	}, // truncate(name string, size int) => error
 	"unsetenv": &vvm.BuiltinFunction{
 		Name:  "unsetenv",
@@ -198,6 +224,64 @@ var osModule = map[string]vvm.Object{
 		Name:  "read_file",
 		Value: osReadFile,
 	}, // readfile(name) => array(byte)/error
+	"read_dir": &vvm.BuiltinFunction{
+		Name:  "read_dir",
+		Value: osReadDir,
+	}, // read_dir(name) => array(imap(fileinfo))/error
+}
+
+// vmFS returns the filesystem of the VM running the builtin, or the host
+// filesystem if the builtin is called outside a VM.
+func vmFS(ctx context.Context) vfs.FS {
+	if vm, ok := ctx.Value(vvm.ContextKey("vm")).(*vvm.VM); ok && vm.FS != nil {
+		return vm.FS
+	}
+	return vfs.OS()
+}
+
+// osFSFunc binds fn to the filesystem of the calling VM.
+func osFSFunc(fn func(fsys vfs.FS) vvm.CallableFunc) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		return fn(vmFS(ctx))(ctx, args...)
+	}
+}
+
+// osHostFunc guards fn, which operates on the host filesystem directly, so
+// that it fails when the calling VM uses a virtual filesystem.
+func osHostFunc(fn vvm.CallableFunc) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if !vfs.IsOS(vmFS(ctx)) {
+			return wrapError(errHostOnly), nil
+		}
+		return fn(ctx, args...)
+	}
+}
+
+func osReadDir(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	dname, ok := vvm.ToString(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "string(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	entries, err := vmFS(ctx).ReadDir(dname)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	arr := &vvm.Array{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return wrapError(err), nil
+		}
+		arr.Value = append(arr.Value, makeOSFileInfo(info))
+	}
+	return arr, nil
 }
 
 func osReadFile(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
@@ -212,7 +296,7 @@ func osReadFile(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 			Found:    args[0].TypeName(),
 		}
 	}
-	bytes, err := os.ReadFile(fname)
+	bytes, err := vmFS(ctx).ReadFile(fname)
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -234,10 +318,14 @@ func osStat(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error)
 			Found:    args[0].TypeName(),
 		}
 	}
-	stat, err := os.Stat(fname)
+	stat, err := vmFS(ctx).Stat(fname)
 	if err != nil {
 		return wrapError(err), nil
 	}
+	return makeOSFileInfo(stat), nil
+}
+
+func makeOSFileInfo(stat fs.FileInfo) *vvm.ImmutableMap {
 	fstat := &vvm.ImmutableMap{
 		Value: map[string]vvm.Object{
 			"name":  &vvm.String{Value: stat.Name()},
@@ -251,7 +339,7 @@ func osStat(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error)
 	} else {
 		fstat.Value["directory"] = vvm.FalseValue
 	}
-	return fstat, nil
+	return fstat
 }
 
 func osCreate(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
@@ -266,7 +354,7 @@ func osCreate(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	res, err := os.Create(s1)
+	res, err := vmFS(ctx).Create(s1)
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -285,7 +373,7 @@ func osOpen(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	res, err := os.Open(s1)
+	res, err := vmFS(ctx).Open(s1)
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -320,7 +408,7 @@ func osOpenFile(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 			Found:    args[2].TypeName(),
 		}
 	}
-	res, err := os.OpenFile(s1, i2, os.FileMode(i3))
+	res, err := vmFS(ctx).OpenFile(s1, i2, os.FileMode(i3))
 	if err != nil {
 		return wrapError(err), nil
 	}
@@ -373,6 +461,41 @@ func osFuncASFmRE(
 	}
 }
 
+// lookupEnv is like os.LookupEnv, but records the result in a
+// deterministic run.
+func lookupEnv(ctx context.Context, key string) (string, bool, error) {
+	if d := vvm.DeterministicOf(ctx); d != nil {
+		return d.LookupEnv(ctx, key)
+	}
+	v, ok := os.LookupEnv(key)
+	return v, ok, nil
+}
+
+func osGetenv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	var err error
+	res, ferr := FuncASRS(func(key string) string {
+		var v string
+		v, _, err = lookupEnv(ctx, key)
+		return v
+	})(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return res, ferr
+}
+
+func osEnviron(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	d := vvm.DeterministicOf(ctx)
+	if d == nil {
+		return FuncARSs(os.Environ)(ctx, args...)
+	}
+	env, err := d.Environ(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FuncARSs(func() []string { return env })(ctx, args...)
+}
+
 func osLookupEnv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 	if len(args) != 1 {
 		return nil, vvm.ErrWrongNumArguments
@@ -385,7 +508,10 @@ func osLookupEnv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	res, ok := os.LookupEnv(s1)
+	res, ok, err := lookupEnv(ctx, s1)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
 		return vvm.FalseValue, nil
 	}
@@ -409,11 +535,13 @@ func osExpandEnv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 	}
 	var vlen int
 	var failed bool
+	var lerr error
 	s := os.Expand(s1, func(k string) string {
-		if failed {
+		if failed || lerr != nil {
 			return ""
 		}
-		v := os.Getenv(k)
+		var v string
+		v, _, lerr = lookupEnv(ctx, k)
 
 		// this does not count the other texts that are not being replaced
 		// but the code checks the final length at the end
@@ -424,6 +552,9 @@ func osExpandEnv(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
 		}
 		return v
 	})
+	if lerr != nil {
+		return nil, lerr
+	}
 	if failed || len(s) > vvm.MaxStringLen {
 		return nil, vvm.ErrStringLimit
 	}
@@ -5,21 +5,12 @@ import (
 	"os"
 
 	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/vfs"
 )
 
-func makeOSFile(file *os.File) *vvm.ImmutableMap {
-	return &vvm.ImmutableMap{
+func makeOSFile(file vfs.File) *vvm.ImmutableMap {
+	fileMap := &vvm.ImmutableMap{
 		Value: map[string]vvm.Object{
-			// chdir() => true/error
-			"chdir": &vvm.BuiltinFunction{
-				Name:  "chdir",
-				Value: FuncARE(file.Chdir),
-			}, //
-			// chown(uid int, gid int) => true/error
-			"chown": &vvm.BuiltinFunction{
-				Name:  "chown",
-				Value: FuncAIIRE(file.Chown),
-			}, //
 			// close() => error
 			"close": &vvm.BuiltinFunction{
 				Name:  "close",
@@ -55,24 +46,6 @@ func makeOSFile(file *os.File) *vvm.ImmutableMap {
 				Name:  "read",
 				Value: FuncAYRIE(file.Read),
 			}, //
-			// chmod(mode int) => error
-			"chmod": &vvm.BuiltinFunction{
-				Name: "chmod",
-				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
-					if len(args) != 1 {
-						return nil, vvm.ErrWrongNumArguments
-					}
-					i1, ok := vvm.ToInt64(args[0])
-					if !ok {
-						return nil, vvm.ErrInvalidArgumentType{
-							Name:     "first",
-							Expected: "int(compatible)",
-							Found:    args[0].TypeName(),
-						}
-					}
-					return wrapError(file.Chmod(os.FileMode(i1))), nil
-				},
-			},
 			// seek(offset int, whence int) => int/error
 			"seek": &vvm.BuiltinFunction{
 				Name: "seek",
@@ -110,9 +83,46 @@ func makeOSFile(file *os.File) *vvm.ImmutableMap {
 					if len(args) != 0 {
 						return nil, vvm.ErrWrongNumArguments
 					}
-					return osStat(ctx, &vvm.String{Value: file.Name()})
+					stat, err := file.Stat()
+					if err != nil {
+						return wrapError(err), nil
+					}
+					return makeOSFileInfo(stat), nil
 				},
 			},
 		},
 	}
+
+	// host files additionally support changing their attributes
+	if file, ok := file.(*os.File); ok {
+		// chdir() => true/error
+		fileMap.Value["chdir"] = &vvm.BuiltinFunction{
+			Name:  "chdir",
+			Value: FuncARE(file.Chdir),
+		}
+		// chown(uid int, gid int) => true/error
+		fileMap.Value["chown"] = &vvm.BuiltinFunction{
+			Name:  "chown",
+			Value: FuncAIIRE(file.Chown),
+		}
+		// chmod(mode int) => error
+		fileMap.Value["chmod"] = &vvm.BuiltinFunction{
+			Name: "chmod",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 1 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				i1, ok := vvm.ToInt64(args[0])
+				if !ok {
+					return nil, vvm.ErrInvalidArgumentType{
+						Name:     "first",
+						Expected: "int(compatible)",
+						Found:    args[0].TypeName(),
+					}
+				}
+				return wrapError(file.Chmod(os.FileMode(i1))), nil
+			},
+		}
+	}
+	return fileMap
 }
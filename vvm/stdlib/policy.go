@@ -0,0 +1,582 @@
+package stdlib
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/vfs"
+)
+
+// Capability names reported by ErrPermissionDenied.
+const (
+	CapEnvRead   = "env_read"
+	CapEnvWrite  = "env_write"
+	CapFileRead  = "file_read"
+	CapFileWrite = "file_write"
+	CapExec      = "exec"
+	CapProcess   = "process"
+	CapExit      = "exit"
+	CapNetwork   = "network"
+)
+
+// Policy grants capabilities to the builtin modules of a module map created
+// with GetPolicyModuleMap. The zero value grants nothing; functions that do
+// not need any capability (e.g. os.getpid or os.path_separator) are always
+// available.
+type Policy struct {
+	// EnvRead allows reading environment variables.
+	EnvRead bool
+
+	// EnvWrite allows setting and clearing environment variables, also those
+	// of executed programs.
+	EnvWrite bool
+
+	// FileRead lists glob patterns of paths that may be opened for reading,
+	// listed or inspected. Patterns use path.Match syntax per path element,
+	// additionally "**" matches any number of elements. Symbolic links of
+	// paths and patterns are resolved before matching.
+	FileRead []string
+
+	// FileWrite lists glob patterns of paths that may be created, written,
+	// renamed or removed. Write access does not imply read access.
+	FileWrite []string
+
+	// Exec lists the programs that may be executed. Plain names match
+	// programs looked up in PATH, absolute paths match exactly and "*"
+	// allows every program.
+	Exec []string
+
+	// Process allows looking up arbitrary processes and signalling
+	// processes, also those started by the script.
+	Process bool
+
+	// Exit allows terminating the host process.
+	Exit bool
+
+	// Network allows network access.
+	Network bool
+
+	// fsys resolves the checked paths, the host filesystem if nil.
+	fsys vfs.FS
+}
+
+// permission describes what a builtin function requires from a Policy.
+type permission struct {
+	// check returns an error if the call is not permitted.
+	check func(p *Policy, args []vvm.Object) error
+
+	// wrap optionally restricts the object returned by a permitted call.
+	wrap func(p *Policy, args []vvm.Object, ret vvm.Object) vvm.Object
+}
+
+// modulePermissions lists the permissions of all policy aware modules.
+// Functions of these modules which are not listed are denied.
+var modulePermissions = map[string]map[string]permission{
+	"os":      osPermissions,
+	"http":    httpPermissions,
+	"net":     netPermissions,
+	"archive": archivePermissions,
+}
+
+var osPermissions = map[string]permission{
+	"args":           {check: allowAll},
+	"getegid":        {check: allowAll},
+	"geteuid":        {check: allowAll},
+	"getgid":         {check: allowAll},
+	"getgroups":      {check: allowAll},
+	"getpagesize":    {check: allowAll},
+	"getpid":         {check: allowAll},
+	"getppid":        {check: allowAll},
+	"getuid":         {check: allowAll},
+	"getwd":          {check: allowAll},
+	"hostname":       {check: allowAll},
+	"temp_dir":       {check: allowAll},
+	"environ":        {check: requireFlag(CapEnvRead)},
+	"expand_env":     {check: requireFlag(CapEnvRead)},
+	"getenv":         {check: requireFlag(CapEnvRead)},
+	"lookup_env":     {check: requireFlag(CapEnvRead)},
+	"clearenv":       {check: requireFlag(CapEnvWrite)},
+	"setenv":         {check: requireFlag(CapEnvWrite)},
+	"unsetenv":       {check: requireFlag(CapEnvWrite)},
+	"exit":           {check: requireFlag(CapExit)},
+	"find_process":   {check: requireFlag(CapProcess)},
+	"chdir":          {check: requirePath(CapFileRead, 0)},
+	"stat":           {check: requirePath(CapFileRead, 0)},
+	"read_file":      {check: requirePath(CapFileRead, 0)},
+	"read_dir":       {check: requirePath(CapFileRead, 0)},
+	"readlink":       {check: requireLinkPath(CapFileRead, 0)},
+	"chmod":          {check: requirePath(CapFileWrite, 0)},
+	"chown":          {check: requirePath(CapFileWrite, 0)},
+	"lchown":         {check: requireLinkPath(CapFileWrite, 0)},
+	"truncate":       {check: requirePath(CapFileWrite, 0)},
+	"mkdir":          {check: requirePath(CapFileWrite, 0)},
+	"mkdir_all":      {check: requirePath(CapFileWrite, 0)},
+	"remove":         {check: requireLinkPath(CapFileWrite, 0)},
+	"remove_all":     {check: requireLinkPath(CapFileWrite, 0)},
+	"rename":         {check: requireAll(requireLinkPath(CapFileWrite, 0), requireLinkPath(CapFileWrite, 1))},
+	"link":           {check: requireAll(requirePath(CapFileRead, 0), requireLinkPath(CapFileWrite, 1))},
+	"symlink":        {check: requireAll(requirePath(CapFileRead, 0), requireLinkPath(CapFileWrite, 1))},
+	"open":           {check: requirePath(CapFileRead, 0), wrap: policyOSFile},
+	"create":         {check: requirePath(CapFileWrite, 0), wrap: policyOSFile},
+	"open_file":      {check: requireOpenFile, wrap: policyOSFile},
+	"exec":           {check: requireExec(0), wrap: policyOSExecCommand},
+	"exec_look_path": {check: requireExec(0)},
+	"start_process":  {check: requireAll(requireExec(0), requireDir(2), requireEnv(3)), wrap: policyOSProcess},
+}
+
+var httpPermissions = map[string]permission{
+	"get":       {check: requireFlag(CapNetwork)},
+	"post":      {check: requireFlag(CapNetwork)},
+	"request":   {check: requireFlag(CapNetwork)},
+	"client":    {check: requireFlag(CapNetwork)},
+	"router":    {check: allowAll, wrap: policyHTTPRouter},
+	"listen":    {check: requireFlag(CapNetwork)},
+	"serve":     {check: requireFlag(CapNetwork)},
+	"websocket": {check: requireFlag(CapNetwork)},
+}
+
+var netPermissions = map[string]permission{
+	"dial":        {check: requireFlag(CapNetwork)},
+	"dial_udp":    {check: requireFlag(CapNetwork)},
+	"dial_unix":   {check: requireSocket},
+	"listen":      {check: requireFlag(CapNetwork)},
+	"listen_unix": {check: requireSocket},
+	"listen_udp":  {check: requireFlag(CapNetwork)},
+	"resolve":     {check: requireFlag(CapNetwork)},
+	"lookup_host": {check: requireFlag(CapNetwork)},
+}
+
+// The archive functions check every file they open with checkPath.
+var archivePermissions = map[string]permission{
+	"list":    {check: allowAll},
+	"extract": {check: allowAll},
+	"create":  {check: allowAll},
+}
+
+// GetPolicyModuleMap returns the module map that includes all modules for
+// the given module names, restricted to the capabilities granted by policy.
+// A nil policy grants every capability.
+func GetPolicyModuleMap(policy *Policy, names ...string) *vvm.ModuleMap {
+	modules := vvm.NewModuleMap()
+	for _, name := range names {
+		if mod := BuiltinModules[name]; mod != nil {
+			if policy != nil {
+				mod = policy.restrict(name, mod)
+			}
+			modules.AddBuiltinModule(name, mod)
+		}
+		if mod := SourceModules[name]; mod != "" {
+			modules.AddSourceModule(name, []byte(mod))
+		}
+	}
+	return modules
+}
+
+// Check returns an ErrPermissionDenied error if the policy does not grant
+// capability for resource. For boolean capabilities resource is ignored.
+// Check can be used by embedders to extend the policy to their own modules.
+func (p *Policy) Check(capability, resource string) error {
+	return p.check(capability, resource, true)
+}
+
+// check checks capability for resource. For file capabilities, follow
+// reports whether a symbolic link named by resource is resolved, or the
+// link itself is checked.
+func (p *Policy) check(capability, resource string, follow bool) error {
+	var granted bool
+	switch capability {
+	case CapEnvRead:
+		granted = p.EnvRead
+	case CapEnvWrite:
+		granted = p.EnvWrite
+	case CapProcess:
+		granted = p.Process
+	case CapExit:
+		granted = p.Exit
+	case CapNetwork:
+		granted = p.Network
+	case CapFileRead:
+		granted = p.matchAnyPath(p.FileRead, resource, follow)
+	case CapFileWrite:
+		granted = p.matchAnyPath(p.FileWrite, resource, follow)
+	case CapExec:
+		granted = matchAnyProgram(p.Exec, resource)
+	}
+	if !granted {
+		return ErrPermissionDenied{Capability: capability, Resource: resource}
+	}
+	return nil
+}
+
+// restrict guards all functions of a builtin module with their permissions.
+func (p *Policy) restrict(name string, mod map[string]vvm.Object) map[string]vvm.Object {
+	perms, ok := modulePermissions[name]
+	if !ok {
+		return mod
+	}
+	res := make(map[string]vvm.Object, len(mod))
+	for key, attr := range mod {
+		fn, ok := attr.(*vvm.BuiltinFunction)
+		if !ok {
+			res[key] = attr
+			continue
+		}
+		perm, ok := perms[key]
+		if !ok {
+			perm = permission{check: denyAll(name + "." + key)}
+		}
+		res[key] = p.guard(fn, perm)
+	}
+	return res
+}
+
+// policyKey is the context key of the policy a guarded function is called
+// with.
+type policyKey struct{}
+
+// checkPath checks capability for the file name, which a builtin function
+// called with ctx is about to open. Functions called without a policy may
+// open every file.
+func checkPath(ctx context.Context, capability, name string) error {
+	if p, ok := ctx.Value(policyKey{}).(*Policy); ok {
+		return p.Check(capability, name)
+	}
+	return nil
+}
+
+// within returns a copy of p which resolves paths with fsys.
+func (p *Policy) within(fsys vfs.FS) *Policy {
+	q := *p
+	q.fsys = fsys
+	return &q
+}
+
+// guard returns a builtin function which checks perm before calling fn.
+// Paths are checked as resolved by the filesystem of the calling VM.
+func (p *Policy) guard(fn *vvm.BuiltinFunction, perm permission) *vvm.BuiltinFunction {
+	return &vvm.BuiltinFunction{
+		Name: fn.Name,
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			p := p.within(vmFS(ctx))
+			if err := perm.check(p, args); err != nil {
+				return wrapError(err), nil
+			}
+			ctx = context.WithValue(ctx, policyKey{}, p)
+			ret, err := fn.Value(ctx, args...)
+			if err == nil && perm.wrap != nil {
+				ret = perm.wrap(p, args, ret)
+			}
+			return ret, err
+		},
+	}
+}
+
+func allowAll(_ *Policy, _ []vvm.Object) error {
+	return nil
+}
+
+func denyAll(name string) func(*Policy, []vvm.Object) error {
+	return func(_ *Policy, _ []vvm.Object) error {
+		return ErrPermissionDenied{Capability: name}
+	}
+}
+
+func requireAll(checks ...func(*Policy, []vvm.Object) error) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		for _, check := range checks {
+			if err := check(p, args); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func requireFlag(capability string) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, _ []vvm.Object) error {
+		return p.Check(capability, "")
+	}
+}
+
+// requirePath checks the path given as argument idx. Arguments of invalid
+// type are left to the guarded function to report.
+func requirePath(capability string, idx int) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		if idx >= len(args) {
+			return nil
+		}
+		name, ok := vvm.ToString(args[idx])
+		if !ok {
+			return nil
+		}
+		return p.Check(capability, name)
+	}
+}
+
+// requireLinkPath checks the path given as argument idx like requirePath, but
+// does not resolve a symbolic link named by it, for functions operating on
+// the link itself.
+func requireLinkPath(capability string, idx int) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		if idx >= len(args) {
+			return nil
+		}
+		name, ok := vvm.ToString(args[idx])
+		if !ok {
+			return nil
+		}
+		return p.check(capability, name, false)
+	}
+}
+
+// requireSocket checks a Unix socket, whose path given as first argument is
+// read and written like a file.
+var requireSocket = requireAll(
+	requireFlag(CapNetwork),
+	requirePath(CapFileRead, 0),
+	requirePath(CapFileWrite, 0),
+)
+
+// requireDir checks the working directory of a program given as argument
+// idx. An empty directory is the working directory of the host process.
+func requireDir(idx int) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		if idx >= len(args) {
+			return nil
+		}
+		if name, ok := vvm.ToString(args[idx]); !ok || name == "" {
+			return nil
+		}
+		return requirePath(CapFileRead, idx)(p, args)
+	}
+}
+
+// requireEnv checks the environment of a program given as argument idx. An
+// empty environment needs no capability.
+func requireEnv(idx int) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		if idx >= len(args) {
+			return nil
+		}
+		switch arr := args[idx].(type) {
+		case *vvm.Array:
+			if len(arr.Value) == 0 {
+				return nil
+			}
+		case *vvm.ImmutableArray:
+			if len(arr.Value) == 0 {
+				return nil
+			}
+		default:
+			return nil
+		}
+		return p.Check(CapEnvWrite, "")
+	}
+}
+
+func requireExec(idx int) func(*Policy, []vvm.Object) error {
+	return func(p *Policy, args []vvm.Object) error {
+		if idx >= len(args) {
+			return nil
+		}
+		name, ok := vvm.ToString(args[idx])
+		if !ok {
+			return nil
+		}
+		return p.Check(CapExec, name)
+	}
+}
+
+// requireOpenFile checks open_file(name, flag, perm) depending on the
+// access requested by flag.
+func requireOpenFile(p *Policy, args []vvm.Object) error {
+	if len(args) != 3 {
+		return nil
+	}
+	flag, ok := vvm.ToInt(args[1])
+	if !ok {
+		return nil
+	}
+	if flag&os.O_WRONLY == 0 {
+		if err := requirePath(CapFileRead, 0)(p, args); err != nil {
+			return err
+		}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return requirePath(CapFileWrite, 0)(p, args)
+	}
+	return nil
+}
+
+// policyOSFile guards the attribute changing methods of file objects.
+func policyOSFile(p *Policy, args []vvm.Object, ret vvm.Object) vvm.Object {
+	file, ok := ret.(*vvm.ImmutableMap)
+	if !ok {
+		return ret
+	}
+	name := args[:1]
+	guarded := map[string]permission{
+		"chdir": {check: func(p *Policy, _ []vvm.Object) error { return requirePath(CapFileRead, 0)(p, name) }},
+		"chmod": {check: func(p *Policy, _ []vvm.Object) error { return requirePath(CapFileWrite, 0)(p, name) }},
+		"chown": {check: func(p *Policy, _ []vvm.Object) error { return requirePath(CapFileWrite, 0)(p, name) }},
+	}
+	for key, perm := range guarded {
+		if fn, ok := file.Value[key].(*vvm.BuiltinFunction); ok {
+			file.Value[key] = p.guard(fn, perm)
+		}
+	}
+	return file
+}
+
+// policyOSExecCommand guards changing the program, working directory and
+// environment of a command object and signalling its process.
+func policyOSExecCommand(p *Policy, _ []vvm.Object, ret vvm.Object) vvm.Object {
+	cmd, ok := ret.(*vvm.ImmutableMap)
+	if !ok {
+		return ret
+	}
+	guarded := map[string]permission{
+		"set_path": {check: requireExec(0)},
+		"set_dir":  {check: requireDir(0)},
+		"set_env":  {check: requireEnv(0)},
+		"process":  {check: allowAll, wrap: policyOSProcess},
+	}
+	for key, perm := range guarded {
+		if fn, ok := cmd.Value[key].(*vvm.BuiltinFunction); ok {
+			cmd.Value[key] = p.guard(fn, perm)
+		}
+	}
+	return cmd
+}
+
+// policyOSProcess guards signalling a process object.
+func policyOSProcess(p *Policy, _ []vvm.Object, ret vvm.Object) vvm.Object {
+	proc, ok := ret.(*vvm.ImmutableMap)
+	if !ok {
+		return ret
+	}
+	for _, key := range []string{"kill", "signal"} {
+		if fn, ok := proc.Value[key].(*vvm.BuiltinFunction); ok {
+			proc.Value[key] = p.guard(fn, permission{check: requireFlag(CapProcess)})
+		}
+	}
+	return proc
+}
+
+// policyHTTPRouter guards serving the files of a directory.
+func policyHTTPRouter(p *Policy, _ []vvm.Object, ret vvm.Object) vvm.Object {
+	rt, ok := ret.(*httpRouter)
+	if !ok {
+		return ret
+	}
+	if fn, ok := rt.methods["static"].(*vvm.BuiltinFunction); ok {
+		rt.methods["static"] = p.guard(fn, permission{check: requirePath(CapFileRead, 1)})
+	}
+	return rt
+}
+
+func (p *Policy) matchAnyPath(patterns []string, name string, follow bool) bool {
+	if name == "" {
+		return false
+	}
+	abs, err := p.resolvePath(name, follow)
+	if err != nil {
+		return false
+	}
+	elems := strings.Split(filepath.ToSlash(abs), "/")
+	for _, pattern := range patterns {
+		if matchPathElems(p.resolvePattern(pattern), elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute form of name with its symbolic links
+// resolved by the filesystem of p. Unless follow is set, a link named by the
+// last element of name is not resolved.
+func (p *Policy) resolvePath(name string, follow bool) (string, error) {
+	if !follow {
+		dir, file := filepath.Split(strings.TrimRight(name, string(filepath.Separator)))
+		if file != "" && file != "." && file != ".." {
+			if dir == "" {
+				dir = "."
+			}
+			dir, err := p.resolvePath(dir, true)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(dir, file), nil
+		}
+	}
+	fsys := p.fsys
+	if fsys == nil {
+		fsys = vfs.OS()
+	}
+	if r, ok := fsys.(vfs.Resolver); ok {
+		return r.Resolve(name)
+	}
+	return filepath.Abs(name)
+}
+
+// resolvePattern splits pattern into its elements, resolving the symbolic
+// links of the elements before the first one containing a wildcard.
+func (p *Policy) resolvePattern(pattern string) []string {
+	elems := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	i := 0
+	for i < len(elems) && !strings.ContainsAny(elems[i], "*?[\\") {
+		i++
+	}
+	if i == 0 {
+		return elems
+	}
+	prefix, err := p.resolvePath(filepath.FromSlash(strings.Join(elems[:i], "/")), true)
+	if err != nil {
+		return elems
+	}
+	return append(strings.Split(filepath.ToSlash(prefix), "/"), elems[i:]...)
+}
+
+func matchPathElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchPathElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+func matchAnyProgram(allowed []string, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, a := range allowed {
+		switch {
+		case a == "*":
+			return true
+		case !strings.ContainsRune(a, '/') && !strings.ContainsRune(a, filepath.Separator):
+			if a == name {
+				return true
+			}
+		case filepath.IsAbs(name) && filepath.Clean(a) == filepath.Clean(name):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package stdlib_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+	"github.com/malivvan/vv/vvm/vfs"
+)
+
+func TestPolicyHarmless(t *testing.T) {
+	policy := &stdlib.Policy{}
+	expect(t, `
+os := import("os")
+out := [os.path_separator, os.getpid() > 0, is_error(os.getenv("HOME"))]
+`, stdlib.GetPolicyModuleMap(policy, stdlib.AllModuleNames()...), nil, ARR{os.PathSeparator, true, true})
+}
+
+func TestPolicyEnv(t *testing.T) {
+	_ = os.Setenv("VV_POLICY", "foo")
+	defer func() { _ = os.Unsetenv("VV_POLICY") }()
+
+	expect(t, `
+os := import("os")
+out := string(os.getenv("VV_POLICY"))
+`, stdlib.GetPolicyModuleMap(&stdlib.Policy{}, stdlib.AllModuleNames()...), nil, "error: \"permission denied: env_read\"")
+	expect(t, `
+os := import("os")
+out := os.getenv("VV_POLICY").code
+`, stdlib.GetPolicyModuleMap(&stdlib.Policy{}, stdlib.AllModuleNames()...), nil, "permission_denied")
+	expect(t, `
+os := import("os")
+out := [os.getenv("VV_POLICY"), is_error(os.setenv("VV_POLICY", "bar"))]
+`, stdlib.GetPolicyModuleMap(&stdlib.Policy{EnvRead: true}, stdlib.AllModuleNames()...), nil, ARR{"foo", true})
+	require.Equal(t, "foo", os.Getenv("VV_POLICY"))
+	expect(t, `
+os := import("os")
+os.setenv("VV_POLICY", "bar")
+out := os.getenv("VV_POLICY")
+`, stdlib.GetPolicyModuleMap(&stdlib.Policy{EnvRead: true, EnvWrite: true}, stdlib.AllModuleNames()...), nil, "bar")
+}
+
+func TestPolicyFiles(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "in.txt"), []byte("in"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "out.txt"), []byte("out"), 0644))
+
+	policy := &stdlib.Policy{
+		FileRead:  []string{filepath.Join(dir, "**")},
+		FileWrite: []string{filepath.Join(dir, "*.log")},
+	}
+	src := `
+os := import("os")
+out := [
+	string(os.read_file(dir + "/in.txt")),
+	string(os.read_file(outside + "/out.txt")),
+	is_error(os.create(dir + "/new.txt")),
+	is_error(os.create(dir + "/new.log")),
+	is_error(os.open_file(dir + "/in.txt", os.o_rdonly, 0)),
+	is_error(os.open_file(dir + "/in.txt", os.o_rdwr, 0)),
+	is_error(os.rename(dir + "/new.log", outside + "/new.log")),
+	is_error(os.open(dir + "/in.txt").chmod(0777))
+]
+`
+	s := vv.NewScript([]byte(src))
+	require.NoError(t, s.Add("dir", dir))
+	require.NoError(t, s.Add("outside", outside))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "os"))
+	p, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{
+		"in",
+		"error: \"permission denied: file_read " + outside + "/out.txt\"",
+		true, false, false, true, true, true,
+	}), p.Get("out").Object())
+
+	_, err = os.Stat(filepath.Join(dir, "new.log"))
+	require.NoError(t, err)
+}
+
+func TestPolicySymlinks(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "out.txt"), []byte("out"), 0644))
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	policy := &stdlib.Policy{
+		FileRead:  []string{filepath.Join(dir, "**")},
+		FileWrite: []string{filepath.Join(dir, "**")},
+	}
+	s := vv.NewScript([]byte(`
+os := import("os")
+out := [
+	is_error(os.read_file(dir + "/link/out.txt")),
+	is_error(os.create(dir + "/link/new.txt")),
+	is_error(os.stat(dir + "/link")),
+	is_error(os.readlink(dir + "/link")),
+	is_error(os.remove(dir + "/link"))
+]
+`))
+	require.NoError(t, s.Add("dir", dir))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "os"))
+	p, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{true, true, true, false, false}), p.Get("out").Object())
+	_, err = os.Stat(filepath.Join(outside, "out.txt"))
+	require.NoError(t, err)
+
+	// paths are resolved by the filesystem of the VM
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "data"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "secret"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data", "in.txt"), []byte("in"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret", "s.txt"), []byte("s"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret"), filepath.Join(root, "data", "link")))
+	s = vv.NewScript([]byte(`
+os := import("os")
+out := [string(os.read_file("/data/in.txt")), is_error(os.read_file("/data/link/s.txt"))]
+`))
+	s.SetFS(vfs.Chroot(vfs.OS(), root))
+	s.SetImports(stdlib.GetPolicyModuleMap(&stdlib.Policy{FileRead: []string{"/data/**"}}, "os"))
+	p, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{"in", true}), p.Get("out").Object())
+}
+
+func TestPolicyExec(t *testing.T) {
+	policy := &stdlib.Policy{Exec: []string{"echo"}}
+	expect(t, `
+os := import("os")
+cmd := os.exec("echo", "foo")
+out := [
+	string(cmd.output()),
+	is_error(os.exec("sh", "-c", "echo foo")),
+	is_error(os.exec("/tmp/echo")),
+	is_error(os.exec("echo").set_path("/bin/sh")),
+	is_error(os.find_process(1))
+]
+`, stdlib.GetPolicyModuleMap(policy, stdlib.AllModuleNames()...), nil, ARR{"foo\n", true, true, true, true})
+}
+
+func TestPolicyExecProcess(t *testing.T) {
+	dir := t.TempDir()
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("sleep not found: %v", err)
+	}
+	policy := &stdlib.Policy{Exec: []string{"sleep", sleep}}
+	src := `
+os := import("os")
+cmd := os.exec("sleep", "1")
+denied := [
+	is_error(cmd.set_dir(dir)),
+	is_error(cmd.set_env(["A=b"])),
+	is_error(os.start_process(sleep, ["sleep", "1"], dir, [])),
+	is_error(os.start_process(sleep, ["sleep", "1"], "", ["A=b"]))
+]
+proc := os.start_process(sleep, ["sleep", "1"], "", [])
+cmd.start()
+out := [denied, is_error(proc.signal(15)), is_error(proc.kill()), is_error(cmd.process().kill())]
+proc.wait()
+cmd.wait()
+`
+	s := vv.NewScript([]byte(src))
+	require.NoError(t, s.Add("dir", dir))
+	require.NoError(t, s.Add("sleep", sleep))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "os"))
+	p, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{ARR{true, true, true, true}, true, true, true}), p.Get("out").Object())
+
+	policy = &stdlib.Policy{Exec: []string{"sleep", sleep}, FileRead: []string{dir}, EnvWrite: true, Process: true}
+	s = vv.NewScript([]byte(src))
+	require.NoError(t, s.Add("dir", dir))
+	require.NoError(t, s.Add("sleep", sleep))
+	s.SetImports(stdlib.GetPolicyModuleMap(policy, "os"))
+	p, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, object(ARR{ARR{false, false, false, false}, false, false, false}), p.Get("out").Object())
+}
+
+func TestPolicyErrorType(t *testing.T) {
+	mod := stdlib.GetPolicyModuleMap(&stdlib.Policy{}, "os").GetBuiltinModule("os")
+	require.NotNil(t, mod)
+	for name, attr := range mod.Attrs {
+		if name == "args" {
+			continue // requires a VM
+		}
+		fn, ok := attr.(*vvm.BuiltinFunction)
+		if !ok {
+			continue
+		}
+		// no function of the os module may be left unlisted
+		ret, _ := fn.Value(context.Background())
+		if e, ok := ret.(*vvm.Error); ok {
+			require.False(t, strings.Contains(e.String(), "os."), name)
+		}
+	}
+
+	err := (&stdlib.Policy{}).Check(stdlib.CapNetwork, "")
+	var denied stdlib.ErrPermissionDenied
+	require.True(t, errors.As(err, &denied))
+	require.Equal(t, stdlib.CapNetwork, denied.Capability)
+	require.NoError(t, (&stdlib.Policy{Network: true}).Check(stdlib.CapNetwork, ""))
+	require.NoError(t, (&stdlib.Policy{FileRead: []string{"/a/**/c"}}).Check(stdlib.CapFileRead, "/a/b/b/c"))
+	require.Error(t, (&stdlib.Policy{FileRead: []string{"/a/*"}}).Check(stdlib.CapFileRead, "/a/b/c"))
+}
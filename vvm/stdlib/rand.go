@@ -9,54 +9,50 @@ import (
 
 var randModule = map[string]vvm.Object{
 	"int": &vvm.BuiltinFunction{
-		Name:  "int",
-		Value: FuncARI64(rand.Int63),
+		Name: "int",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncARI64(r.Int63)
+		}),
 	},
 	"float": &vvm.BuiltinFunction{
-		Name:  "float",
-		Value: FuncARF(rand.Float64),
+		Name: "float",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncARF(r.Float64)
+		}),
 	},
 	"intn": &vvm.BuiltinFunction{
-		Name:  "intn",
-		Value: FuncAI64RI64(rand.Int63n),
+		Name: "intn",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncAI64RI64(r.Int63n)
+		}),
 	},
 	"exp_float": &vvm.BuiltinFunction{
-		Name:  "exp_float",
-		Value: FuncARF(rand.ExpFloat64),
+		Name: "exp_float",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncARF(r.ExpFloat64)
+		}),
 	},
 	"norm_float": &vvm.BuiltinFunction{
-		Name:  "norm_float",
-		Value: FuncARF(rand.NormFloat64),
+		Name: "norm_float",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncARF(r.NormFloat64)
+		}),
 	},
 	"perm": &vvm.BuiltinFunction{
-		Name:  "perm",
-		Value: FuncAIRIs(rand.Perm),
+		Name: "perm",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncAIRIs(r.Perm)
+		}),
 	},
 	"seed": &vvm.BuiltinFunction{
-		Name:  "seed",
-		Value: FuncAI64R(rand.Seed),
+		Name: "seed",
+		Value: randFunc(func(r randSource) vvm.CallableFunc {
+			return FuncAI64R(r.Seed)
+		}),
 	},
 	"read": &vvm.BuiltinFunction{
-		Name: "read",
-		Value: func(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
-			if len(args) != 1 {
-				return nil, vvm.ErrWrongNumArguments
-			}
-			y1, ok := args[0].(*vvm.Bytes)
-			if !ok {
-				return nil, vvm.ErrInvalidArgumentType{
-					Name:     "first",
-					Expected: "bytes",
-					Found:    args[0].TypeName(),
-				}
-			}
-			res, err := rand.Read(y1.Value)
-			if err != nil {
-				ret = wrapError(err)
-				return
-			}
-			return &vvm.Int{Value: int64(res)}, nil
-		},
+		Name:  "read",
+		Value: randFunc(randRead),
 	},
 	"rand": &vvm.BuiltinFunction{
 		Name: "rand",
@@ -110,27 +106,67 @@ func randRand(r *rand.Rand) *vvm.ImmutableMap {
 				Value: FuncAI64R(r.Seed),
 			},
 			"read": &vvm.BuiltinFunction{
-				Name: "read",
-				Value: func(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
-					if len(args) != 1 {
-						return nil, vvm.ErrWrongNumArguments
-					}
-					y1, ok := args[0].(*vvm.Bytes)
-					if !ok {
-						return nil, vvm.ErrInvalidArgumentType{
-							Name:     "first",
-							Expected: "bytes",
-							Found:    args[0].TypeName(),
-						}
-					}
-					res, err := r.Read(y1.Value)
-					if err != nil {
-						ret = wrapError(err)
-						return
-					}
-					return &vvm.Int{Value: int64(res)}, nil
-				},
+				Name:  "read",
+				Value: randRead(r),
 			},
 		},
 	}
 }
+
+// randSource is the random number generator of the module functions.
+type randSource interface {
+	Int63() int64
+	Int63n(n int64) int64
+	Float64() float64
+	ExpFloat64() float64
+	NormFloat64() float64
+	Perm(n int) []int
+	Seed(seed int64)
+	Read(p []byte) (int, error)
+}
+
+// globalRand is the randSource of the top-level functions of math/rand.
+type globalRand struct{}
+
+func (globalRand) Int63() int64               { return rand.Int63() }
+func (globalRand) Int63n(n int64) int64       { return rand.Int63n(n) }
+func (globalRand) Float64() float64           { return rand.Float64() }
+func (globalRand) ExpFloat64() float64        { return rand.ExpFloat64() }
+func (globalRand) NormFloat64() float64       { return rand.NormFloat64() }
+func (globalRand) Perm(n int) []int           { return rand.Perm(n) }
+func (globalRand) Seed(seed int64)            { rand.Seed(seed) }
+func (globalRand) Read(p []byte) (int, error) { return rand.Read(p) }
+
+// randFunc returns a function that calls the function created by fn for the
+// random number generator of the run: the one seeded from the run seed in a
+// deterministic run, otherwise the global one.
+func randFunc(fn func(r randSource) vvm.CallableFunc) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+		if d := vvm.DeterministicOf(ctx); d != nil {
+			return fn(d.Rand())(ctx, args...)
+		}
+		return fn(globalRand{})(ctx, args...)
+	}
+}
+
+func randRead(r randSource) vvm.CallableFunc {
+	return func(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
+		if len(args) != 1 {
+			return nil, vvm.ErrWrongNumArguments
+		}
+		y1, ok := args[0].(*vvm.Bytes)
+		if !ok {
+			return nil, vvm.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "bytes",
+				Found:    args[0].TypeName(),
+			}
+		}
+		res, err := r.Read(y1.Value)
+		if err != nil {
+			ret = wrapError(err)
+			return
+		}
+		return &vvm.Int{Value: int64(res)}, nil
+	}
+}
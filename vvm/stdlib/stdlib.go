@@ -23,16 +23,7 @@ func AllModuleNames() []string {
 // GetModuleMap returns the module map that includes all modules
 // for the given module names.
 func GetModuleMap(names ...string) *vvm.ModuleMap {
-	modules := vvm.NewModuleMap()
-	for _, name := range names {
-		if mod := BuiltinModules[name]; mod != nil {
-			modules.AddBuiltinModule(name, mod)
-		}
-		if mod := SourceModules[name]; mod != "" {
-			modules.AddSourceModule(name, []byte(mod))
-		}
-	}
-	return modules
+	return GetPolicyModuleMap(nil, names...)
 }
 
 // Func returns a BuiltinFunction from the given function value.
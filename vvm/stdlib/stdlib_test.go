@@ -60,7 +60,7 @@ if write_file("./temp", "foobar") {
 }
 
 os.remove("./temp")
-`, "foobar")
+`, nil, nil, "foobar")
 
 	// exec.command
 	expect(t, `
@@ -70,7 +70,7 @@ cmd := os.exec("echo", "foo", "bar")
 if !is_error(cmd) {
 	out = cmd.output()
 }
-`, []byte("foo bar\n"))
+`, nil, nil, []byte("foo bar\n"))
 
 }
 
@@ -232,13 +232,21 @@ func object(v interface{}) vvm.Object {
 	panic(fmt.Errorf("unknown type: %T", v))
 }
 
-func expect(t *testing.T, input string, expected interface{}) {
+// expect runs input with modules, or all modules if it is nil, and the
+// variables vars, and compares its out variable with expected.
+func expect(t *testing.T, input string, modules *vvm.ModuleMap, vars MAP, expected interface{}) {
 	s := vv.NewScript([]byte(input))
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	for name, v := range vars {
+		require.NoError(t, s.Add(name, v))
+	}
+	if modules == nil {
+		modules = stdlib.GetModuleMap(stdlib.AllModuleNames()...)
+	}
+	s.SetImports(modules)
 	c, err := s.Run()
 	require.NoError(t, err)
 	require.NotNil(t, c)
 	v := c.Get("out")
 	require.NotNil(t, v)
-	require.Equal(t, expected, v.Value())
+	require.Equal(t, object(expected), v.Object())
 }
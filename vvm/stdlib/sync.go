@@ -0,0 +1,604 @@
+package stdlib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/malivvan/vv/vvm"
+)
+
+var syncModule = map[string]vvm.Object{
+	"mutex":        &vvm.BuiltinFunction{Name: "mutex", Value: syncMutex},
+	"rwmutex":      &vvm.BuiltinFunction{Name: "rwmutex", Value: syncRWMutex},
+	"waitgroup":    &vvm.BuiltinFunction{Name: "waitgroup", Value: syncWaitGroup},
+	"once":         &vvm.BuiltinFunction{Name: "once", Value: syncOnce},
+	"semaphore":    &vvm.BuiltinFunction{Name: "semaphore", Value: syncSemaphore},
+	"atomic_int":   &vvm.BuiltinFunction{Name: "atomic_int", Value: syncAtomicInt},
+	"atomic_value": &vvm.BuiltinFunction{Name: "atomic_value", Value: syncAtomicValue},
+}
+
+// syncState guards the state of a sync object. Blocking calls wait for a
+// change of the state instead of blocking on Go synchronization, so that
+// they can be aborted and scheduled by a deterministic run.
+type syncState struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed on the next change
+}
+
+// wait blocks until ready returns true and then calls take. Both are called
+// with the state locked. It returns vvm.ErrVMAborted if ctx is done first.
+func (s *syncState) wait(ctx context.Context, ready func() bool, take func()) error {
+	if d := vvm.DeterministicOf(ctx); d != nil {
+		// only the running routine changes state, so ready still holds
+		// when Wait returns
+		err := d.Wait(ctx, func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return ready()
+		})
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		take()
+		s.mu.Unlock()
+		return nil
+	}
+	for {
+		s.mu.Lock()
+		if ready() {
+			take()
+			s.mu.Unlock()
+			return nil
+		}
+		if s.changed == nil {
+			s.changed = make(chan struct{})
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return vvm.ErrVMAborted
+		}
+	}
+}
+
+// try calls take and returns true if ready returns true, without blocking.
+func (s *syncState) try(ready func() bool, take func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ready() {
+		return false
+	}
+	take()
+	return true
+}
+
+// update calls fn with the state locked and wakes up the waiting calls.
+func (s *syncState) update(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := fn(); err != nil {
+		return err
+	}
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+	return nil
+}
+
+// syncNoArgs wraps fn into a builtin function without arguments that returns
+// undefined.
+func syncNoArgs(name string, fn func(ctx context.Context) error) *vvm.BuiltinFunction {
+	return &vvm.BuiltinFunction{
+		Name: name,
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			if len(args) != 0 {
+				return nil, vvm.ErrWrongNumArguments
+			}
+			if err := fn(ctx); err != nil {
+				return nil, err
+			}
+			return vvm.UndefinedValue, nil
+		},
+	}
+}
+
+// syncBlocking is syncNoArgs for fn that only waits for the state and takes
+// it, so that its calls can be interrupted by a snapshot.
+func syncBlocking(name string, fn func(ctx context.Context) error) *vvm.BuiltinFunction {
+	f := syncNoArgs(name, fn)
+	f.Restartable = true
+	return f
+}
+
+// syncWith wraps lock and unlock into a builtin function that calls its
+// first argument with the remaining arguments while holding the lock.
+func syncWith(name string, lock, unlock func(ctx context.Context) error) *vvm.BuiltinFunction {
+	return &vvm.BuiltinFunction{
+		Name: name,
+		Value: func(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
+			if len(args) < 1 {
+				return nil, vvm.ErrWrongNumArguments
+			}
+			if err := checkCallable("first", args[0]); err != nil {
+				return nil, err
+			}
+			if err := lock(ctx); err != nil {
+				return nil, err
+			}
+			defer func() {
+				if uerr := unlock(ctx); err == nil {
+					err = uerr
+				}
+			}()
+			return vvm.Invoke(ctx, args[0], args[1:]...)
+		},
+	}
+}
+
+// syncCount returns the optional count argument, which defaults to 1.
+func syncCount(args []vvm.Object) (int64, error) {
+	switch len(args) {
+	case 0:
+		return 1, nil
+	case 1:
+		n, ok := vvm.ToInt64(args[0])
+		if !ok {
+			return 0, vvm.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "int(compatible)",
+				Found:    args[0].TypeName(),
+			}
+		}
+		return n, nil
+	}
+	return 0, vvm.ErrWrongNumArguments
+}
+
+// rwMutex is a reader/writer mutual exclusion lock. A mutex is a rwMutex
+// without the reader methods.
+type rwMutex struct {
+	syncState
+	writer  bool
+	readers int
+	pending int // writers waiting for the lock
+}
+
+func (m *rwMutex) lock(ctx context.Context) error {
+	m.mu.Lock()
+	m.pending++
+	m.mu.Unlock()
+	err := m.wait(ctx, m.canLock, m.take)
+	if err != nil {
+		_ = m.update(func() error {
+			m.pending--
+			return nil
+		})
+	}
+	return err
+}
+
+func (m *rwMutex) canLock() bool {
+	return !m.writer && m.readers == 0
+}
+
+func (m *rwMutex) take() {
+	m.writer = true
+	m.pending--
+}
+
+func (m *rwMutex) tryLock() bool {
+	return m.try(m.canLock, func() { m.writer = true })
+}
+
+func (m *rwMutex) unlock(context.Context) error {
+	return m.update(func() error {
+		if !m.writer {
+			return errors.New("sync: unlock of unlocked mutex")
+		}
+		m.writer = false
+		return nil
+	})
+}
+
+// canRLock reports whether a reader can take the lock. A blocked writer
+// excludes new readers, so that a writer cannot starve.
+func (m *rwMutex) canRLock() bool {
+	return !m.writer && m.pending == 0
+}
+
+func (m *rwMutex) rtake() {
+	m.readers++
+}
+
+func (m *rwMutex) rlock(ctx context.Context) error {
+	return m.wait(ctx, m.canRLock, m.rtake)
+}
+
+func (m *rwMutex) runlock(context.Context) error {
+	return m.update(func() error {
+		if m.readers == 0 {
+			return errors.New("sync: runlock of unlocked rwmutex")
+		}
+		m.readers--
+		return nil
+	})
+}
+
+func (m *rwMutex) object(rw bool) *vvm.ImmutableMap {
+	methods := map[string]vvm.Object{
+		"lock":   syncBlocking("lock", m.lock),
+		"unlock": syncNoArgs("unlock", m.unlock),
+		"try_lock": &vvm.BuiltinFunction{
+			Name:  "try_lock",
+			Value: FuncARB(m.tryLock),
+		},
+		"with": syncWith("with", m.lock, m.unlock),
+	}
+	if rw {
+		methods["rlock"] = syncBlocking("rlock", m.rlock)
+		methods["runlock"] = syncNoArgs("runlock", m.runlock)
+		methods["try_rlock"] = &vvm.BuiltinFunction{
+			Name: "try_rlock",
+			Value: FuncARB(func() bool {
+				return m.try(m.canRLock, m.rtake)
+			}),
+		}
+		methods["rwith"] = syncWith("rwith", m.rlock, m.runlock)
+	}
+	return &vvm.ImmutableMap{Value: methods}
+}
+
+// mutex() => mutex
+func syncMutex(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return (&rwMutex{}).object(false), nil
+}
+
+// rwmutex() => rwmutex
+func syncRWMutex(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return (&rwMutex{}).object(true), nil
+}
+
+// waitgroup() => waitgroup
+func syncWaitGroup(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var (
+		wg    syncState
+		count int64
+	)
+	add := func(n int64) error {
+		return wg.update(func() error {
+			if count+n < 0 {
+				return errors.New("sync: negative waitgroup counter")
+			}
+			count += n
+			return nil
+		})
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"add": &vvm.BuiltinFunction{
+				Name: "add",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					n, err := syncCount(args)
+					if err != nil {
+						return nil, err
+					}
+					if err := add(n); err != nil {
+						return nil, err
+					}
+					return vvm.UndefinedValue, nil
+				},
+			},
+			"done": syncNoArgs("done", func(context.Context) error {
+				return add(-1)
+			}),
+			"wait": syncBlocking("wait", func(ctx context.Context) error {
+				return wg.wait(ctx, func() bool { return count == 0 }, func() {})
+			}),
+		},
+	}, nil
+}
+
+// once() => once
+func syncOnce(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 0 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	var (
+		once    syncState
+		done    bool
+		running bool
+		result  vvm.Object = vvm.UndefinedValue
+	)
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			// do(fn, args...) calls fn only the first time and returns
+			// its result to every caller.
+			"do": &vvm.BuiltinFunction{
+				Name: "do",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) < 1 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					if err := checkCallable("first", args[0]); err != nil {
+						return nil, err
+					}
+					var first bool
+					err := once.wait(ctx, func() bool { return !running }, func() {
+						if !done {
+							running, first = true, true
+						}
+					})
+					if err != nil {
+						return nil, err
+					}
+					if !first {
+						once.mu.Lock()
+						defer once.mu.Unlock()
+						return result, nil
+					}
+					res, err := vvm.Invoke(ctx, args[0], args[1:]...)
+					_ = once.update(func() error {
+						running, done = false, true
+						if err == nil {
+							result = res
+						}
+						return nil
+					})
+					return res, err
+				},
+			},
+			"done": &vvm.BuiltinFunction{
+				Name: "done",
+				Value: FuncARB(func() bool {
+					once.mu.Lock()
+					defer once.mu.Unlock()
+					return done
+				}),
+			},
+		},
+	}, nil
+}
+
+// semaphore(n) => semaphore
+func syncSemaphore(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	size, ok := vvm.ToInt64(args[0])
+	if !ok {
+		return nil, vvm.ErrInvalidArgumentType{
+			Name:     "first",
+			Expected: "int(compatible)",
+			Found:    args[0].TypeName(),
+		}
+	}
+	if size < 1 {
+		return nil, errors.New("sync: semaphore size must be positive")
+	}
+	var (
+		sem  syncState
+		used int64
+	)
+	count := func(args []vvm.Object) (int64, error) {
+		n, err := syncCount(args)
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 || n > size {
+			return 0, errors.New("sync: semaphore count out of range")
+		}
+		return n, nil
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"acquire": &vvm.BuiltinFunction{
+				Name:        "acquire",
+				Restartable: true,
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					n, err := count(args)
+					if err != nil {
+						return nil, err
+					}
+					err = sem.wait(ctx, func() bool { return used+n <= size }, func() { used += n })
+					if err != nil {
+						return nil, err
+					}
+					return vvm.UndefinedValue, nil
+				},
+			},
+			"try_acquire": &vvm.BuiltinFunction{
+				Name: "try_acquire",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					n, err := count(args)
+					if err != nil {
+						return nil, err
+					}
+					if sem.try(func() bool { return used+n <= size }, func() { used += n }) {
+						return vvm.TrueValue, nil
+					}
+					return vvm.FalseValue, nil
+				},
+			},
+			"release": &vvm.BuiltinFunction{
+				Name: "release",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					n, err := count(args)
+					if err != nil {
+						return nil, err
+					}
+					err = sem.update(func() error {
+						if n > used {
+							return errors.New("sync: release of unacquired semaphore")
+						}
+						used -= n
+						return nil
+					})
+					if err != nil {
+						return nil, err
+					}
+					return vvm.UndefinedValue, nil
+				},
+			},
+		},
+	}, nil
+}
+
+// atomic_int(v=0) => atomic_int
+func syncAtomicInt(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	var v int64
+	switch len(args) {
+	case 0:
+	case 1:
+		i, ok := vvm.ToInt64(args[0])
+		if !ok {
+			return nil, vvm.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "int(compatible)",
+				Found:    args[0].TypeName(),
+			}
+		}
+		v = i
+	default:
+		return nil, vvm.ErrWrongNumArguments
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"get": &vvm.BuiltinFunction{
+				Name:  "get",
+				Value: FuncARI64(func() int64 { return atomic.LoadInt64(&v) }),
+			},
+			"set": &vvm.BuiltinFunction{
+				Name:  "set",
+				Value: FuncAI64R(func(i int64) { atomic.StoreInt64(&v, i) }),
+			},
+			// add(delta=1) => new value
+			"add": &vvm.BuiltinFunction{
+				Name: "add",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					n, err := syncCount(args)
+					if err != nil {
+						return nil, err
+					}
+					return &vvm.Int{Value: atomic.AddInt64(&v, n)}, nil
+				},
+			},
+			// swap(new) => old value
+			"swap": &vvm.BuiltinFunction{
+				Name:  "swap",
+				Value: FuncAI64RI64(func(i int64) int64 { return atomic.SwapInt64(&v, i) }),
+			},
+			// cas(old, new) => bool
+			"cas": &vvm.BuiltinFunction{
+				Name: "cas",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 2 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					var old [2]int64
+					for i, name := range []string{"first", "second"} {
+						n, ok := vvm.ToInt64(args[i])
+						if !ok {
+							return nil, vvm.ErrInvalidArgumentType{
+								Name:     name,
+								Expected: "int(compatible)",
+								Found:    args[i].TypeName(),
+							}
+						}
+						old[i] = n
+					}
+					if atomic.CompareAndSwapInt64(&v, old[0], old[1]) {
+						return vvm.TrueValue, nil
+					}
+					return vvm.FalseValue, nil
+				},
+			},
+		},
+	}, nil
+}
+
+// atomic_value(v=undefined) => atomic_value
+func syncAtomicValue(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	var (
+		mu sync.Mutex
+		v  vvm.Object = vvm.UndefinedValue
+	)
+	switch len(args) {
+	case 0:
+	case 1:
+		v = args[0]
+	default:
+		return nil, vvm.ErrWrongNumArguments
+	}
+	one := func(fn func(x vvm.Object) vvm.Object) vvm.CallableFunc {
+		return func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			if len(args) != 1 {
+				return nil, vvm.ErrWrongNumArguments
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(args[0]), nil
+		}
+	}
+	return &vvm.ImmutableMap{
+		Value: map[string]vvm.Object{
+			"load": &vvm.BuiltinFunction{
+				Name: "load",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 0 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					return v, nil
+				},
+			},
+			"store": &vvm.BuiltinFunction{
+				Name: "store",
+				Value: one(func(x vvm.Object) vvm.Object {
+					v = x
+					return vvm.UndefinedValue
+				}),
+			},
+			// swap(new) => old value
+			"swap": &vvm.BuiltinFunction{
+				Name: "swap",
+				Value: one(func(x vvm.Object) vvm.Object {
+					old := v
+					v = x
+					return old
+				}),
+			},
+			// cas(old, new) => bool
+			"cas": &vvm.BuiltinFunction{
+				Name: "cas",
+				Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+					if len(args) != 2 {
+						return nil, vvm.ErrWrongNumArguments
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if !v.Equals(args[0]) {
+						return vvm.FalseValue, nil
+					}
+					v = args[1]
+					return vvm.TrueValue, nil
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,134 @@
+package stdlib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
+)
+
+// syncCounter increments a shared map from several routines. Run with -race
+// to verify that each protect function orders the increments.
+const syncCounter = `
+s := import("sync")
+wg := s.waitgroup()
+c := {n: 0}
+%s
+gate := s.waitgroup()
+gate.add()
+wg.add(8)
+for i := 0; i < 8; i++ {
+	start(func() {
+		gate.wait()
+		for j := 0; j < 2000; j++ {
+			protect(func() { c.n += 1 })
+		}
+		wg.done()
+	})
+}
+gate.done()
+wg.wait()
+out := c.n
+`
+
+func TestSync(t *testing.T) {
+	for _, protect := range []string{
+		`m := s.mutex(); protect := func(fn) { m.with(fn) }`,
+		`m := s.mutex(); protect := func(fn) { m.lock(); fn(); m.unlock() }`,
+		`m := s.rwmutex(); protect := func(fn) { m.with(fn) }`,
+		`m := s.semaphore(1); protect := func(fn) { m.acquire(); fn(); m.release() }`,
+	} {
+		script := strings.Replace(syncCounter, "%s", protect, 1)
+		expect(t, script, nil, nil, int64(16000))
+
+		s := vv.NewScript([]byte(script))
+		s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		s.SetDeterministic(1)
+		c, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, int64(16000), c.Get("out").Value())
+	}
+
+	s := `s := import("sync");`
+	expect(t, s+`
+n := s.atomic_int()
+wg := s.waitgroup()
+for i := 0; i < 8; i++ {
+	wg.add()
+	start(func() { for j := 0; j < 50; j++ { n.add() }; wg.done() })
+}
+wg.wait()
+out := n.get()`, nil, nil, int64(400))
+	expect(t, s+`n := s.atomic_int(5); out := string([n.add(2), n.swap(1), n.cas(2, 3), n.cas(1, 4), n.get()])`, nil, nil,
+		"[7, 7, false, true, 4]")
+	expect(t, s+`v := s.atomic_value(); out := string([v.load(), v.swap([1]), v.cas([1], "x"), v.cas(1, 2), v.load()])`, nil, nil,
+		`[<undefined>, <undefined>, true, false, "x"]`)
+
+	expect(t, s+`
+o := s.once()
+calls := 0
+f := func(x) { calls++; return x }
+r := [start(o.do, f, 1), start(o.do, f, 2)]
+first := r[0].result()
+out := string([first == r[1].result(), calls, o.done(), o.do(f, 3) == first])`, nil, nil,
+		"[true, 1, true, true]")
+
+	expect(t, s+`m := s.mutex(); out := string([m.try_lock(), m.try_lock()])`, nil, nil, "[true, false]")
+	expect(t, s+`m := s.rwmutex(); m.rlock(); out := string([m.try_rlock(), m.try_lock()])`, nil, nil, "[true, false]")
+	expect(t, s+`m := s.semaphore(3); m.acquire(2); out := string([m.try_acquire(2), m.try_acquire()])`, nil, nil, "[false, true]")
+	expect(t, s+`m := s.rwmutex(); out := m.rwith(func(a, b) { return a + b }, 1, 2)`, nil, nil, int64(3))
+
+	// a waiting writer excludes new readers
+	expect(t, s+`
+m := s.rwmutex()
+m.rlock()
+w := start(func() { m.lock(); m.unlock() })
+for m.try_rlock() { m.runlock() }
+m.runlock()
+w.wait()
+out := m.try_rlock()`, nil, nil, true)
+}
+
+func TestSyncErrors(t *testing.T) {
+	expectErr := func(input, contains string) {
+		s := vv.NewScript([]byte(input))
+		s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		_, err := s.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(), contains), err.Error())
+	}
+	s := `s := import("sync");`
+
+	expectErr(s+`s.mutex().unlock()`, "unlock of unlocked mutex")
+	expectErr(s+`s.rwmutex().runlock()`, "runlock of unlocked rwmutex")
+	expectErr(s+`s.waitgroup().done()`, "negative waitgroup counter")
+	expectErr(s+`s.semaphore(1).release()`, "release of unacquired semaphore")
+	expectErr(s+`s.semaphore(1).acquire(2)`, "count out of range")
+	expectErr(s+`s.semaphore(0)`, "size must be positive")
+	expectErr(s+`s.mutex().with(1)`, "callable")
+	expectErr(s+`m := s.mutex(); m.with(func() { m.unlock() })`, "unlock of unlocked mutex")
+}
+
+func TestSyncAbort(t *testing.T) {
+	s := `s := import("sync");`
+	for _, block := range []string{
+		`m := s.mutex(); m.lock(); m.lock()`,
+		`m := s.rwmutex(); m.rlock(); m.lock()`,
+		`wg := s.waitgroup(); wg.add(); wg.wait()`,
+		`m := s.semaphore(1); m.acquire(); m.acquire()`,
+		`o := s.once(); o.do(func() { o.do(func() {}) })`,
+	} {
+		script := vv.NewScript([]byte(s + block))
+		script.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := script.RunContext(ctx)
+		cancel()
+		require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), block)
+	}
+}
@@ -42,8 +42,9 @@ var timesModule = map[string]vvm.Object{
 	"november":            &vvm.Int{Value: int64(time.November)},
 	"december":            &vvm.Int{Value: int64(time.December)},
 	"sleep": &vvm.BuiltinFunction{
-		Name:  "sleep",
-		Value: timesSleep,
+		Name:        "sleep",
+		Value:       timesSleep,
+		Restartable: true,
 	}, // sleep(int)
 	"parse_duration": &vvm.BuiltinFunction{
 		Name:  "parse_duration",
@@ -113,6 +114,18 @@ var timesModule = map[string]vvm.Object{
 		Name:  "after",
 		Value: timesAfter,
 	}, // after(t time, u time) => bool
+	"timer": &vvm.BuiltinFunction{
+		Name:  "timer",
+		Value: timesTimerChan,
+	}, // timer(d int) => chan
+	"tick": &vvm.BuiltinFunction{
+		Name:  "tick",
+		Value: timesTick,
+	}, // tick(d int) => chan
+	"after_func": &vvm.BuiltinFunction{
+		Name:  "after_func",
+		Value: timesAfterFunc,
+	}, // after_func(d int, fn func, args...) => timer
 	"before": &vvm.BuiltinFunction{
 		Name:  "before",
 		Value: timesBefore,
@@ -199,24 +212,18 @@ func timesSleep(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 		return
 	}
 	ret = vvm.UndefinedValue
-	if time.Duration(i1) <= time.Second {
-		time.Sleep(time.Duration(i1))
+	if d := vvm.DeterministicOf(ctx); d != nil {
+		if err = d.Sleep(ctx, time.Duration(i1)); err != nil {
+			ret = nil
+		}
 		return
 	}
-
-	done := make(chan struct{})
-	go func() {
-		time.Sleep(time.Duration(i1))
-		select {
-		case <-ctx.Done():
-		case done <- struct{}{}:
-		}
-	}()
-
+	timer := time.NewTimer(time.Duration(i1))
+	defer timer.Stop()
 	select {
 	case <-ctx.Done():
 		return nil, vvm.ErrVMAborted
-	case <-done:
+	case <-timer.C:
 	}
 	return
 }
@@ -264,7 +271,7 @@ func timesSince(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 		return
 	}
 
-	ret = &vvm.Int{Value: int64(time.Since(t1))}
+	ret = &vvm.Int{Value: int64(timesNowOf(ctx).Sub(t1))}
 
 	return
 }
@@ -285,7 +292,7 @@ func timesUntil(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err er
 		return
 	}
 
-	ret = &vvm.Int{Value: int64(time.Until(t1))}
+	ret = &vvm.Int{Value: int64(t1.Sub(timesNowOf(ctx)))}
 
 	return
 }
@@ -500,11 +507,20 @@ func timesNow(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err erro
 		return
 	}
 
-	ret = &vvm.Time{Value: time.Now()}
+	ret = &vvm.Time{Value: timesNowOf(ctx)}
 
 	return
 }
 
+// timesNowOf returns the current time, which is the time of the virtual
+// clock in a deterministic run.
+func timesNowOf(ctx context.Context) time.Time {
+	if d := vvm.DeterministicOf(ctx); d != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
 func timesParse(ctx context.Context, args ...vvm.Object) (ret vvm.Object, err error) {
 	if len(args) != 2 {
 		err = vvm.ErrWrongNumArguments
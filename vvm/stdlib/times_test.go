@@ -1,11 +1,16 @@
 package stdlib_test
 
 import (
-	"github.com/malivvan/vv/vvm"
-	"github.com/malivvan/vv/vvm/require"
+	"context"
+	"errors"
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/malivvan/vv"
+	"github.com/malivvan/vv/vvm"
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/stdlib"
 )
 
 func TestTimes(t *testing.T) {
@@ -62,6 +67,12 @@ func TestTimes(t *testing.T) {
 		expect(false)
 	module(t, "times").call("after", time2, time2.Add(-time.Hour)).
 		expect(true)
+	module(t, "times").call("after", time2).expectError()
+	module(t, "times").call("after", time2, 1, 2).expectError()
+	module(t, "times").call("after", "x", time2).expectError()
+	module(t, "times").call("timer").expectError()
+	module(t, "times").call("timer", 1, 2).expectError()
+	module(t, "times").call("timer", "x").expectError()
 	module(t, "times").call("before", time2, time2.Add(time.Hour)).
 		expect(true)
 	module(t, "times").call("before", time2, time2.Add(-time.Hour)).
@@ -87,3 +98,67 @@ func TestTimes(t *testing.T) {
 		expect(time1.Location().String())
 	module(t, "times").call("time_string", time1).expect(time1.String())
 }
+
+// timesTimers exercises the timer channels of the times module.
+const timesTimers = `
+times := import("times")
+ms := times.millisecond
+res := []
+
+c := times.timer(5 * ms)
+res = append(res, is_time(c.recv()), is_undefined(c.recv()), c.stop())
+
+c = times.timer(times.hour)
+res = append(res, c.stop(), c.stop(), is_undefined(c.recv()))
+
+tk := times.tick(2 * ms)
+n := 0
+for n < 3 { if is_time(tk.recv()) { n++ } }
+tk.close()
+res = append(res, n, is_undefined(tk.recv()))
+
+done := chan()
+f := times.after_func(5 * ms, func(x) { done.send(x) }, 42)
+res = append(res, done.recv(), f.stop())
+
+f = times.after_func(times.hour, func() { done.send(1) })
+res = append(res, f.stop(), wait_all([f.routine], 1))
+
+begin := times.now()
+times.sleep(3 * ms)
+res = append(res, times.since(begin) >= 3 * ms)
+out := string(res)
+`
+
+func TestTimesTimers(t *testing.T) {
+	const want = "[true, true, false, true, false, true, 3, true, 42, false, true, true, true]"
+	expect(t, timesTimers, nil, nil, want)
+
+	s := vv.NewScript([]byte(timesTimers))
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	s.SetDeterministic(1)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, want, c.Get("out").Value())
+
+	expect(t, `times := import("times"); out := times.after(times.now(), times.now())`, nil, nil, false)
+}
+
+func TestTimesAbort(t *testing.T) {
+	s := `times := import("times");`
+	for _, block := range []string{
+		`times.sleep(times.hour)`,
+		`times.sleep(times.second)`,
+		`times.timer(times.hour).recv()`,
+		`times.tick(times.hour).recv()`,
+	} {
+		script := vv.NewScript([]byte(s + block))
+		script.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		begin := time.Now()
+		_, err := script.RunContext(ctx)
+		cancel()
+		require.True(t, errors.Is(err, vvm.ErrVMAborted) || errors.Is(err, context.DeadlineExceeded), block)
+		require.True(t, time.Since(begin) < 500*time.Millisecond, block)
+	}
+}
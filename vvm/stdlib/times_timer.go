@@ -0,0 +1,242 @@
+package stdlib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/malivvan/vv/vvm"
+)
+
+// timesTimer is the state of a timer channel returned by times.after and
+// times.tick. It has no goroutine of its own: recv waits for the next
+// deadline itself, so an unused timer costs nothing.
+type timesTimer struct {
+	mu      sync.Mutex
+	next    time.Time     // next deadline
+	period  time.Duration // 0 for a one-shot timer
+	fired   bool          // one-shot timer delivered
+	stopped bool
+	stop    chan struct{} // closed when stopped
+}
+
+func newTimesTimer(ctx context.Context, d, period time.Duration) *timesTimer {
+	return &timesTimer{
+		next:   timesNowOf(ctx).Add(d),
+		period: period,
+		stop:   make(chan struct{}),
+	}
+}
+
+// object returns the channel object of t, which has the send, recv and
+// close methods of chan objects and a stop method.
+func (t *timesTimer) object() vvm.Object {
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"send": &vvm.BuiltinFunction{
+			Name: "send",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				return nil, errors.New("times: send on timer channel")
+			},
+		},
+		"recv": &vvm.BuiltinFunction{
+			Name:        "recv",
+			Restartable: true,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				if len(args) != 0 {
+					return nil, vvm.ErrWrongNumArguments
+				}
+				return t.recv(ctx)
+			},
+		},
+		"close": syncNoArgs("close", func(ctx context.Context) error {
+			t.cancel()
+			return nil
+		}),
+		"stop": t.stopMethod(),
+	}}
+}
+
+// stopMethod returns the stop method of t, which stops t and returns whether
+// it was active.
+func (t *timesTimer) stopMethod() *vvm.BuiltinFunction {
+	return &vvm.BuiltinFunction{
+		Name: "stop",
+		Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+			if len(args) != 0 {
+				return nil, vvm.ErrWrongNumArguments
+			}
+			if t.cancel() {
+				return vvm.TrueValue, nil
+			}
+			return vvm.FalseValue, nil
+		},
+	}
+}
+
+// done reports whether t delivers no more values. t must be locked.
+func (t *timesTimer) done() bool {
+	return t.stopped || t.period == 0 && t.fired
+}
+
+// recv waits for the next deadline of t and returns the current time, or
+// undefined once t is stopped or a one-shot timer has fired, like a closed
+// channel. It returns vvm.ErrVMAborted if ctx is done first.
+func (t *timesTimer) recv(ctx context.Context) (vvm.Object, error) {
+	d := vvm.DeterministicOf(ctx)
+	for {
+		t.mu.Lock()
+		if t.done() {
+			t.mu.Unlock()
+			return vvm.UndefinedValue, nil
+		}
+		now := timesNowOf(ctx)
+		if !t.next.After(now) {
+			if t.period == 0 {
+				t.fired = true
+			} else {
+				// missed ticks are dropped
+				t.next = t.next.Add(t.period * (now.Sub(t.next)/t.period + 1))
+			}
+			t.mu.Unlock()
+			return &vvm.Time{Value: now}, nil
+		}
+		next := t.next
+		t.mu.Unlock()
+
+		if d != nil {
+			_, err := d.WaitUntil(ctx, func() bool {
+				t.mu.Lock()
+				defer t.mu.Unlock()
+				return t.stopped
+			}, next)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-t.stop:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, vvm.ErrVMAborted
+		}
+	}
+}
+
+// cancel stops t and reports whether it was active.
+func (t *timesTimer) cancel() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done() {
+		return false
+	}
+	t.stopped = true
+	close(t.stop)
+	return true
+}
+
+// reset makes t, a one-shot timer, fire again at the time at. It reports
+// false if t was stopped.
+func (t *timesTimer) reset(at time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.next, t.fired = at, false
+	return true
+}
+
+// shutdown stops t like cancel, but also once a one-shot timer has fired
+// and may be reset. It reports whether t was not stopped before.
+func (t *timesTimer) shutdown() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	close(t.stop)
+	return true
+}
+
+// cancelled reports whether t was stopped.
+func (t *timesTimer) cancelled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+// timesDuration returns the duration argument at index i.
+func timesDuration(args []vvm.Object, i int, name string) (time.Duration, error) {
+	n, ok := vvm.ToInt64(args[i])
+	if !ok {
+		return 0, vvm.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "int(compatible)",
+			Found:    args[i].TypeName(),
+		}
+	}
+	return time.Duration(n), nil
+}
+
+func timesTimerChan(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	d, err := timesDuration(args, 0, "first")
+	if err != nil {
+		return nil, err
+	}
+	return newTimesTimer(ctx, d, 0).object(), nil
+}
+
+func timesTick(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) != 1 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	d, err := timesDuration(args, 0, "first")
+	if err != nil {
+		return nil, err
+	}
+	if d <= 0 {
+		return nil, errors.New("times: non-positive tick interval")
+	}
+	return newTimesTimer(ctx, d, d).object(), nil
+}
+
+func timesAfterFunc(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+	if len(args) < 2 {
+		return nil, vvm.ErrWrongNumArguments
+	}
+	d, err := timesDuration(args, 0, "first")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCallable("second", args[1]); err != nil {
+		return nil, err
+	}
+	fn, fnArgs := args[1], append([]vvm.Object(nil), args[2:]...)
+	t := newTimesTimer(ctx, d, 0)
+	routine, err := vvm.Start(ctx, &vvm.BuiltinFunction{
+		Name: "after_func",
+		Value: func(ctx context.Context, _ ...vvm.Object) (vvm.Object, error) {
+			v, err := t.recv(ctx)
+			if err != nil || v == vvm.UndefinedValue {
+				return v, err
+			}
+			return vvm.Invoke(ctx, fn, fnArgs...)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &vvm.ImmutableMap{Value: map[string]vvm.Object{
+		"stop":    t.stopMethod(),
+		"routine": routine,
+	}}, nil
+}
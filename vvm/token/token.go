@@ -85,79 +85,103 @@ const (
 	Undefined
 	Import
 	_keywordEnd
+	TemplateHead   // `...${
+	TemplateMiddle // }...${
+	TemplateTail   // }...`
+	_extOperatorBeg
+	QuestionPeriod // ?.
+	QuestionLBrack // ?[
+	Coalesce       // ??
+	_extOperatorEnd
+	_extKeywordBeg
+	Yield
+	_extKeywordEnd
+	_extLiteralBeg
+	BigInt  // 123n
+	Decimal // 1.23d
+	_extLiteralEnd
 )
 
 var tokens = [...]string{
-	Illegal:      "ILLEGAL",
-	EOF:          "EOF",
-	Comment:      "COMMENT",
-	Ident:        "IDENT",
-	Int:          "INT",
-	Float:        "FLOAT",
-	Char:         "CHAR",
-	String:       "STRING",
-	Add:          "+",
-	Sub:          "-",
-	Mul:          "*",
-	Quo:          "/",
-	Rem:          "%",
-	And:          "&",
-	Or:           "|",
-	Xor:          "^",
-	Shl:          "<<",
-	Shr:          ">>",
-	AndNot:       "&^",
-	AddAssign:    "+=",
-	SubAssign:    "-=",
-	MulAssign:    "*=",
-	QuoAssign:    "/=",
-	RemAssign:    "%=",
-	AndAssign:    "&=",
-	OrAssign:     "|=",
-	XorAssign:    "^=",
-	ShlAssign:    "<<=",
-	ShrAssign:    ">>=",
-	AndNotAssign: "&^=",
-	LAnd:         "&&",
-	LOr:          "||",
-	Inc:          "++",
-	Dec:          "--",
-	Equal:        "==",
-	Less:         "<",
-	Greater:      ">",
-	Assign:       "=",
-	Not:          "!",
-	NotEqual:     "!=",
-	LessEq:       "<=",
-	GreaterEq:    ">=",
-	Define:       ":=",
-	Ellipsis:     "...",
-	LParen:       "(",
-	LBrack:       "[",
-	LBrace:       "{",
-	Comma:        ",",
-	Period:       ".",
-	RParen:       ")",
-	RBrack:       "]",
-	RBrace:       "}",
-	Semicolon:    ";",
-	Colon:        ":",
-	Question:     "?",
-	Break:        "break",
-	Continue:     "continue",
-	Else:         "else",
-	For:          "for",
-	Func:         "func",
-	Error:        "error",
-	Immutable:    "immutable",
-	If:           "if",
-	Return:       "return",
-	Export:       "export",
-	True:         "true",
-	False:        "false",
-	In:           "in",
-	Undefined:    "undefined",
-	Import:       "import",
+	Illegal:        "ILLEGAL",
+	EOF:            "EOF",
+	Comment:        "COMMENT",
+	Ident:          "IDENT",
+	Int:            "INT",
+	Float:          "FLOAT",
+	Char:           "CHAR",
+	String:         "STRING",
+	Add:            "+",
+	Sub:            "-",
+	Mul:            "*",
+	Quo:            "/",
+	Rem:            "%",
+	And:            "&",
+	Or:             "|",
+	Xor:            "^",
+	Shl:            "<<",
+	Shr:            ">>",
+	AndNot:         "&^",
+	AddAssign:      "+=",
+	SubAssign:      "-=",
+	MulAssign:      "*=",
+	QuoAssign:      "/=",
+	RemAssign:      "%=",
+	AndAssign:      "&=",
+	OrAssign:       "|=",
+	XorAssign:      "^=",
+	ShlAssign:      "<<=",
+	ShrAssign:      ">>=",
+	AndNotAssign:   "&^=",
+	LAnd:           "&&",
+	LOr:            "||",
+	Inc:            "++",
+	Dec:            "--",
+	Equal:          "==",
+	Less:           "<",
+	Greater:        ">",
+	Assign:         "=",
+	Not:            "!",
+	NotEqual:       "!=",
+	LessEq:         "<=",
+	GreaterEq:      ">=",
+	Define:         ":=",
+	Ellipsis:       "...",
+	LParen:         "(",
+	LBrack:         "[",
+	LBrace:         "{",
+	Comma:          ",",
+	Period:         ".",
+	RParen:         ")",
+	RBrack:         "]",
+	RBrace:         "}",
+	Semicolon:      ";",
+	Colon:          ":",
+	Question:       "?",
+	Break:          "break",
+	Continue:       "continue",
+	Else:           "else",
+	For:            "for",
+	Func:           "func",
+	Error:          "error",
+	Immutable:      "immutable",
+	If:             "if",
+	Return:         "return",
+	Export:         "export",
+	True:           "true",
+	False:          "false",
+	In:             "in",
+	Undefined:      "undefined",
+	Import:         "import",
+	TemplateHead:   "TEMPLATE_HEAD",
+	TemplateMiddle: "TEMPLATE_MIDDLE",
+	TemplateTail:   "TEMPLATE_TAIL",
+	QuestionPeriod: "?.",
+	QuestionLBrack: "?[",
+	Coalesce:       "??",
+	Yield:          "yield",
+	BigInt:         "BIGINT",
+	Decimal:        "DECIMAL",
 }
 
 func (tok Token) String() string {
@@ -180,33 +204,38 @@ const LowestPrec = 0
 // Precedence returns the precedence for the operator token.
 func (tok Token) Precedence() int {
 	switch tok {
-	case LOr:
+	case Coalesce:
 		return 1
-	case LAnd:
+	case LOr:
 		return 2
-	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq:
+	case LAnd:
 		return 3
-	case Add, Sub, Or, Xor:
+	case Equal, NotEqual, Less, LessEq, Greater, GreaterEq, In:
 		return 4
-	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+	case Add, Sub, Or, Xor:
 		return 5
+	case Mul, Quo, Rem, Shl, Shr, And, AndNot:
+		return 6
 	}
 	return LowestPrec
 }
 
 // IsLiteral returns true if the token is a literal.
 func (tok Token) IsLiteral() bool {
-	return _literalBeg < tok && tok < _literalEnd
+	return (_literalBeg < tok && tok < _literalEnd) ||
+		(_extLiteralBeg < tok && tok < _extLiteralEnd)
 }
 
 // IsOperator returns true if the token is an operator.
 func (tok Token) IsOperator() bool {
-	return _operatorBeg < tok && tok < _operatorEnd
+	return (_operatorBeg < tok && tok < _operatorEnd) ||
+		(_extOperatorBeg < tok && tok < _extOperatorEnd)
 }
 
 // IsKeyword returns true if the token is a keyword.
 func (tok Token) IsKeyword() bool {
-	return _keywordBeg < tok && tok < _keywordEnd
+	return (_keywordBeg < tok && tok < _keywordEnd) ||
+		(_extKeywordBeg < tok && tok < _extKeywordEnd)
 }
 
 // Lookup returns corresponding keyword if ident is a keyword.
@@ -222,4 +251,7 @@ func init() {
 	for i := _keywordBeg + 1; i < _keywordEnd; i++ {
 		keywords[tokens[i]] = i
 	}
+	for i := _extKeywordBeg + 1; i < _extKeywordEnd; i++ {
+		keywords[tokens[i]] = i
+	}
 }
@@ -0,0 +1,226 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ErrOutsideRoot is returned by a chroot filesystem when a path resolves to
+// a location outside its root directory.
+var ErrOutsideRoot = errors.New("path escapes from root")
+
+// Chroot wraps fsys so that all paths are resolved relative to dir. Paths
+// passed to the returned FS are treated as rooted at dir: "/etc/passwd" and
+// "../../etc/passwd" both map to dir/etc/passwd. If fsys has symbolic links,
+// which it reports by implementing Resolver, links pointing outside dir are
+// rejected as well.
+func Chroot(fsys FS, dir string) FS {
+	if IsOS(fsys) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	return &chrootFS{fsys: fsys, root: filepath.Clean(dir)}
+}
+
+type chrootFS struct {
+	fsys FS
+	root string
+}
+
+// resolve maps the script visible name to a path of the wrapped filesystem.
+func (c *chrootFS) resolve(op, name string) (string, error) {
+	p := filepath.Join(c.root, filepath.FromSlash(path.Clean("/"+filepath.ToSlash(name))))
+	if r, ok := c.fsys.(Resolver); ok {
+		if _, err := c.checkLinks(r, p); err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return p, nil
+}
+
+// checkLinks resolves the symbolic links of p with r and makes sure the
+// result is still located inside the root. It returns the resolved path
+// relative to the resolved root.
+func (c *chrootFS) checkLinks(r Resolver, p string) (string, error) {
+	root, err := r.Resolve(c.root)
+	if err != nil {
+		return "", unwrapPathError(err)
+	}
+	real, err := r.Resolve(p)
+	if err != nil {
+		return "", unwrapPathError(err)
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", ErrOutsideRoot
+	}
+	return rel, nil
+}
+
+func (c *chrootFS) Open(name string) (File, error) {
+	p, err := c.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.fsys.Open(p)
+	if err != nil {
+		return nil, chrootError(err, name)
+	}
+	return &chrootFile{File: f, name: name}, nil
+}
+
+func (c *chrootFS) Create(name string) (File, error) {
+	p, err := c.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.fsys.Create(p)
+	if err != nil {
+		return nil, chrootError(err, name)
+	}
+	return &chrootFile{File: f, name: name}, nil
+}
+
+func (c *chrootFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	p, err := c.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.fsys.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, chrootError(err, name)
+	}
+	return &chrootFile{File: f, name: name}, nil
+}
+
+func (c *chrootFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := c.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := c.fsys.Stat(p)
+	return fi, chrootError(err, name)
+}
+
+func (c *chrootFS) Mkdir(name string, perm fs.FileMode) error {
+	p, err := c.resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return chrootError(c.fsys.Mkdir(p, perm), name)
+}
+
+func (c *chrootFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := c.resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return chrootError(c.fsys.MkdirAll(p, perm), name)
+}
+
+func (c *chrootFS) Remove(name string) error {
+	p, err := c.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	if p == c.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return chrootError(c.fsys.Remove(p), name)
+}
+
+func (c *chrootFS) RemoveAll(name string) error {
+	p, err := c.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	if p == c.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return chrootError(c.fsys.RemoveAll(p), name)
+}
+
+func (c *chrootFS) Rename(oldpath, newpath string) error {
+	op, err := c.resolve("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	np, err := c.resolve("rename", newpath)
+	if err != nil {
+		return err
+	}
+	err = c.fsys.Rename(op, np)
+	var le *os.LinkError
+	if errors.As(err, &le) {
+		return &os.LinkError{Op: le.Op, Old: oldpath, New: newpath, Err: le.Err}
+	}
+	return chrootError(err, oldpath)
+}
+
+func (c *chrootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := c.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.fsys.ReadDir(p)
+	return entries, chrootError(err, name)
+}
+
+func (c *chrootFS) ReadFile(name string) ([]byte, error) {
+	p, err := c.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.fsys.ReadFile(p)
+	return data, chrootError(err, name)
+}
+
+// Resolve resolves name with the wrapped filesystem and maps the result back
+// to a path rooted at the root directory.
+func (c *chrootFS) Resolve(name string) (string, error) {
+	p, err := c.resolve("resolve", name)
+	if err != nil {
+		return "", err
+	}
+	r, ok := c.fsys.(Resolver)
+	if !ok {
+		return path.Clean("/" + filepath.ToSlash(name)), nil
+	}
+	rel, err := c.checkLinks(r, p)
+	if err != nil {
+		return "", &fs.PathError{Op: "resolve", Path: name, Err: err}
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), nil
+}
+
+// unwrapPathError hides the host path of a path error of the wrapped
+// filesystem, which is reported for the script visible name instead.
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+// chrootError hides the host path in path errors of the wrapped filesystem.
+func chrootError(err error, name string) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+type chrootFile struct {
+	File
+	name string
+}
+
+func (f *chrootFile) Name() string {
+	return f.name
+}
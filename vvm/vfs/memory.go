@@ -0,0 +1,423 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Memory is an in-memory filesystem. It is safe for concurrent use. Paths
+// are slash separated; relative paths are resolved against "/".
+type Memory struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemory creates an empty in-memory filesystem containing only the root
+// directory.
+func NewMemory() *Memory {
+	return &Memory{
+		nodes: map[string]*memNode{
+			"/": {name: "/", mode: fs.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+func memPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// parent returns the parent directory node of p. The caller must hold the
+// lock.
+func (m *Memory) parent(op, name, p string) (*memNode, error) {
+	dir, ok := m.nodes[path.Dir(p)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !dir.mode.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return dir, nil
+}
+
+// Open opens the named file for reading.
+func (m *Memory) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file.
+func (m *Memory) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file with the specified flag and perm.
+func (m *Memory) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	p := memPath(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, ok := m.nodes[p]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		if _, err := m.parent("open", name, p); err != nil {
+			return nil, err
+		}
+		node = &memNode{name: path.Base(p), mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[p] = node
+	case node.mode.IsDir() && isWriteFlag(flag):
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if flag&os.O_TRUNC != 0 && !node.mode.IsDir() {
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	f := &memFile{fs: m, node: node, path: p, name: name, flag: flag}
+	if flag&os.O_APPEND != 0 {
+		f.offset = int64(len(node.data))
+	}
+	return f, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[memPath(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return node.info(), nil
+}
+
+// Mkdir creates a new directory with the specified name and permission bits.
+func (m *Memory) Mkdir(name string, perm fs.FileMode) error {
+	p := memPath(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[p]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if _, err := m.parent("mkdir", name, p); err != nil {
+		return err
+	}
+	m.nodes[p] = &memNode{name: path.Base(p), mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (m *Memory) MkdirAll(name string, perm fs.FileMode) error {
+	p := memPath(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur := "/"
+	for _, elem := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		cur = path.Join(cur, elem)
+		node, ok := m.nodes[cur]
+		if !ok {
+			m.nodes[cur] = &memNode{name: elem, mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+			continue
+		}
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (m *Memory) Remove(name string) error {
+	p := memPath(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, ok := m.nodes[p]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if p == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if node.mode.IsDir() && len(m.children(p)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+// RemoveAll removes path and any children it contains.
+func (m *Memory) RemoveAll(name string) error {
+	p := memPath(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	for k := range m.nodes {
+		if k == p || strings.HasPrefix(k, p+"/") {
+			delete(m.nodes, k)
+		}
+	}
+	return nil
+}
+
+// Rename renames (moves) oldpath to newpath.
+func (m *Memory) Rename(oldpath, newpath string) error {
+	op, np := memPath(oldpath), memPath(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, ok := m.nodes[op]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	if op == "/" || strings.HasPrefix(np, op+"/") {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrInvalid}
+	}
+	if _, err := m.parent("rename", newpath, np); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	if dst, ok := m.nodes[np]; ok && dst.mode.IsDir() {
+		if !node.mode.IsDir() || len(m.children(np)) > 0 {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+		}
+	}
+	for k, v := range m.nodes {
+		if strings.HasPrefix(k, op+"/") {
+			delete(m.nodes, k)
+			m.nodes[np+strings.TrimPrefix(k, op)] = v
+		}
+	}
+	delete(m.nodes, op)
+	node.name = path.Base(np)
+	m.nodes[np] = node
+	return nil
+}
+
+// ReadDir reads the named directory, returning all its directory entries
+// sorted by filename.
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	p := memPath(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+	var entries []fs.DirEntry
+	for _, child := range m.children(p) {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	return entries, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[memPath(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return append([]byte{}, node.data...), nil
+}
+
+// children returns the direct children of the directory p sorted by name.
+// The caller must hold the lock.
+func (m *Memory) children(p string) []*memNode {
+	prefix := p
+	if prefix != "/" {
+		prefix += "/"
+	}
+	var res []*memNode
+	for k, v := range m.nodes {
+		if k != p && strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			res = append(res, v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+func (n *memNode) info() fs.FileInfo {
+	return &memInfo{name: n.name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() any           { return nil }
+
+type memFile struct {
+	fs     *Memory
+	node   *memNode
+	path   string
+	name   string
+	flag   int
+	offset int64
+	dirPos int
+	closed bool
+}
+
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if write && !isWriteFlag(f.flag&^(os.O_CREATE|os.O_TRUNC)) {
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	if f.node.mode.IsDir() && op != "readdirent" && op != "stat" && op != "close" && op != "sync" {
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	end := f.offset + int64(len(b))
+	if end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	copy(f.node.data[f.offset:], b)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if err := f.check("seek", false); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+	return f.node.info(), nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync", false)
+}
+
+func (f *memFile) Readdirnames(n int) ([]string, error) {
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "readdirent", Path: f.name, Err: fs.ErrClosed}
+	}
+	if !f.node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	children := f.fs.children(f.path)
+	if f.dirPos >= len(children) {
+		if n > 0 {
+			return nil, io.EOF
+		}
+		return []string{}, nil
+	}
+	children = children[f.dirPos:]
+	if n > 0 && n < len(children) {
+		children = children[:n]
+	}
+	names := make([]string, 0, len(children))
+	for _, c := range children {
+		names = append(names, c.name)
+	}
+	f.dirPos += len(names)
+	return names, nil
+}
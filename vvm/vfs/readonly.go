@@ -0,0 +1,94 @@
+package vfs
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// ReadOnly wraps fsys so that every modifying operation fails with
+// ErrReadOnly. Files opened through it cannot be written to.
+func ReadOnly(fsys FS) FS {
+	return &readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys FS
+}
+
+func (r *readOnlyFS) Open(name string) (File, error) {
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f}, nil
+}
+
+func (r *readOnlyFS) Create(name string) (File, error) {
+	return nil, readOnlyError("create", name)
+}
+
+func (r *readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if isWriteFlag(flag) {
+		return nil, readOnlyError("open", name)
+	}
+	f, err := r.fsys.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f}, nil
+}
+
+func (r *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return r.fsys.Stat(name)
+}
+
+func (r *readOnlyFS) Mkdir(name string, _ fs.FileMode) error {
+	return readOnlyError("mkdir", name)
+}
+
+func (r *readOnlyFS) MkdirAll(name string, _ fs.FileMode) error {
+	return readOnlyError("mkdir", name)
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	return readOnlyError("remove", name)
+}
+
+func (r *readOnlyFS) RemoveAll(name string) error {
+	return readOnlyError("remove", name)
+}
+
+func (r *readOnlyFS) Rename(oldpath, _ string) error {
+	return readOnlyError("rename", oldpath)
+}
+
+func (r *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return r.fsys.ReadDir(name)
+}
+
+func (r *readOnlyFS) ReadFile(name string) ([]byte, error) {
+	return r.fsys.ReadFile(name)
+}
+
+func (r *readOnlyFS) Resolve(name string) (string, error) {
+	if res, ok := r.fsys.(Resolver); ok {
+		return res.Resolve(name)
+	}
+	return filepath.Abs(name)
+}
+
+type readOnlyFile struct {
+	File
+}
+
+func (f *readOnlyFile) Write(_ []byte) (int, error) {
+	return 0, readOnlyError("write", f.Name())
+}
+
+func (f *readOnlyFile) WriteString(_ string) (int, error) {
+	return 0, readOnlyError("write", f.Name())
+}
+
+func readOnlyError(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+}
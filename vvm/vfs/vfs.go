@@ -0,0 +1,191 @@
+// Package vfs provides the filesystem abstraction used by the os module.
+//
+// An FS decides what a script can see and touch on disk. The package ships
+// a host backed implementation (OS), a read-only wrapper (ReadOnly), a path
+// jailed wrapper (Chroot) and a purely in-memory implementation (NewMemory).
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrReadOnly is returned by a read-only filesystem for every operation that
+// would modify it.
+var ErrReadOnly = errors.New("read-only file system")
+
+// FS is a filesystem the os module operates on. Paths are always given in
+// the form the script passed them in; it is up to the implementation to
+// interpret them.
+type FS interface {
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+
+	// Create creates or truncates the named file.
+	Create(name string) (File, error)
+
+	// OpenFile is the generalized open call. It opens the named file with
+	// the specified flag (os.O_RDONLY etc.) and perm.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Stat returns a FileInfo describing the named file.
+	Stat(name string) (fs.FileInfo, error)
+
+	// Mkdir creates a new directory with the specified name and permission
+	// bits.
+	Mkdir(name string, perm fs.FileMode) error
+
+	// MkdirAll creates a directory named path, along with any necessary
+	// parents.
+	MkdirAll(name string, perm fs.FileMode) error
+
+	// Remove removes the named file or (empty) directory.
+	Remove(name string) error
+
+	// RemoveAll removes path and any children it contains.
+	RemoveAll(name string) error
+
+	// Rename renames (moves) oldpath to newpath.
+	Rename(oldpath, newpath string) error
+
+	// ReadDir reads the named directory, returning all its directory
+	// entries sorted by filename.
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// ReadFile reads the named file and returns its contents.
+	ReadFile(name string) ([]byte, error)
+}
+
+// Resolver is implemented by filesystems whose paths may contain symbolic
+// links. Policies match the resolved paths, so that links cannot lead out
+// of the files they grant.
+type Resolver interface {
+	// Resolve returns the absolute form of name, in the form names are
+	// passed to the filesystem, with the symbolic links of its longest
+	// existing prefix evaluated.
+	Resolve(name string) (string, error)
+}
+
+// File is an open file of an FS.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	io.StringWriter
+
+	// Name returns the name of the file as presented to Open.
+	Name() string
+
+	// Stat returns a FileInfo describing the file.
+	Stat() (fs.FileInfo, error)
+
+	// Sync commits the current contents of the file to stable storage.
+	Sync() error
+
+	// Readdirnames reads up to n names from the directory. If n <= 0 all
+	// remaining names are returned.
+	Readdirnames(n int) ([]string, error)
+}
+
+// OS returns the filesystem of the host operating system.
+func OS() FS {
+	return osFS{}
+}
+
+// IsOS reports whether fsys is the unrestricted host filesystem.
+func IsOS(fsys FS) bool {
+	_, ok := fsys.(osFS)
+	return ok
+}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return openOSFile(os.Open(name))
+}
+
+func (osFS) Create(name string) (File, error) {
+	return openOSFile(os.Create(name))
+}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return openOSFile(os.OpenFile(name, flag, perm))
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Resolve fails for dangling links, as a file created through one would be
+// located elsewhere.
+func (osFS) Resolve(name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		name = wd + string(filepath.Separator) + name
+	}
+	var rest string
+	for cur := name; ; {
+		if _, err := os.Lstat(cur); err == nil {
+			real, err := filepath.EvalSymlinks(cur)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(real, rest), nil
+		}
+		dir, file := filepath.Split(strings.TrimRight(cur, string(filepath.Separator)))
+		if file == "" {
+			return filepath.Clean(name), nil
+		}
+		cur, rest = dir, filepath.Join(file, rest)
+	}
+}
+
+// openOSFile avoids returning a non-nil File interface holding a nil
+// *os.File.
+func openOSFile(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// isWriteFlag reports whether the open flag requests write access.
+func isWriteFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+}
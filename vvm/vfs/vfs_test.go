@@ -0,0 +1,199 @@
+package vfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/malivvan/vv/vvm/require"
+	"github.com/malivvan/vv/vvm/vfs"
+)
+
+func testFS(t *testing.T, fsys vfs.FS) {
+	require.NoError(t, fsys.MkdirAll("/a/b", 0755))
+	require.NoError(t, fsys.Mkdir("/a/c", 0755))
+	require.True(t, errors.Is(fsys.Mkdir("/a/c", 0755), fs.ErrExist))
+
+	f, err := fsys.Create("/a/b/file.txt")
+	require.NoError(t, err)
+	n, err := f.WriteString("hello world")
+	require.NoError(t, err)
+	require.Equal(t, 11, n)
+	off, err := f.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), off)
+	buf := make([]byte, 5)
+	n, err = f.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(buf[:n]))
+	require.Equal(t, "/a/b/file.txt", f.Name())
+	require.NoError(t, f.Close())
+
+	data, err := fsys.ReadFile("/a/b/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	f, err = fsys.OpenFile("/a/b/file.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("!"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	fi, err := fsys.Stat("/a/b/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, "file.txt", fi.Name())
+	require.Equal(t, int64(12), fi.Size())
+	require.False(t, fi.IsDir())
+
+	require.NoError(t, fsys.Rename("/a/b/file.txt", "/a/c/moved.txt"))
+	_, err = fsys.Stat("/a/b/file.txt")
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+
+	entries, err := fsys.ReadDir("/a")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "b", entries[0].Name())
+	require.Equal(t, "c", entries[1].Name())
+	require.True(t, entries[0].IsDir())
+
+	d, err := fsys.Open("/a/c")
+	require.NoError(t, err)
+	names, err := d.Readdirnames(-1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"moved.txt"}, names)
+	require.NoError(t, d.Close())
+
+	require.Error(t, fsys.Remove("/a/c"))
+	require.NoError(t, fsys.Remove("/a/c/moved.txt"))
+	require.NoError(t, fsys.Remove("/a/c"))
+	require.NoError(t, fsys.RemoveAll("/a"))
+	_, err = fsys.Stat("/a/b")
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestMemory(t *testing.T) {
+	testFS(t, vfs.NewMemory())
+}
+
+func TestMemoryErrors(t *testing.T) {
+	fsys := vfs.NewMemory()
+	_, err := fsys.Open("/missing")
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Create("/missing/file")
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+
+	f, err := fsys.Create("/file")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("x"))
+	require.True(t, errors.Is(err, fs.ErrClosed))
+
+	_, err = fsys.OpenFile("/file", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	require.True(t, errors.Is(err, fs.ErrExist))
+
+	f, err = fsys.Open("/file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.Error(t, err)
+	require.Error(t, fsys.Remove("/"))
+}
+
+func TestChroot(t *testing.T) {
+	dir := t.TempDir()
+	testFS(t, vfs.Chroot(vfs.OS(), dir))
+	testFS(t, vfs.Chroot(vfs.NewMemory(), "/jail"))
+
+	fsys := vfs.Chroot(vfs.OS(), dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inside"), []byte("in"), 0644))
+	data, err := fsys.ReadFile("../../../inside")
+	require.NoError(t, err)
+	require.Equal(t, "in", string(data))
+
+	// error messages do not leak host paths
+	_, err = fsys.Stat("/missing")
+	require.Equal(t, "stat /missing: no such file or directory", err.Error())
+
+	// symbolic links out of the root are rejected
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644))
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	_, err = fsys.ReadFile("/link/secret")
+	require.True(t, errors.Is(err, vfs.ErrOutsideRoot))
+	_, err = fsys.Create("/link/new")
+	require.True(t, errors.Is(err, vfs.ErrOutsideRoot))
+
+	// also when the host filesystem is wrapped
+	fsys = vfs.Chroot(vfs.ReadOnly(vfs.OS()), dir)
+	_, err = fsys.ReadFile("/link/secret")
+	require.True(t, errors.Is(err, vfs.ErrOutsideRoot))
+	_, err = fsys.Stat("/link")
+	require.True(t, errors.Is(err, vfs.ErrOutsideRoot))
+	data, err = fsys.ReadFile("/inside")
+	require.NoError(t, err)
+	require.Equal(t, "in", string(data))
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "data"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "secret"), 0755))
+	if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(dir, "data", "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	require.NoError(t, os.Symlink(filepath.Join(dir, "nowhere"), filepath.Join(dir, "data", "dangling")))
+
+	fsys := vfs.OS().(vfs.Resolver)
+	p, err := fsys.Resolve(filepath.Join(dir, "data", "link", "new", "file"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "secret", "new", "file"), p)
+	p, err = fsys.Resolve(filepath.Join(dir, "data", "file"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "data", "file"), p)
+	_, err = fsys.Resolve(filepath.Join(dir, "data", "dangling"))
+	require.Error(t, err)
+
+	fsys = vfs.Chroot(vfs.OS(), dir).(vfs.Resolver)
+	p, err = fsys.Resolve("/data/link/file")
+	require.NoError(t, err)
+	require.Equal(t, "/secret/file", p)
+	p, err = fsys.Resolve("../data/missing")
+	require.NoError(t, err)
+	require.Equal(t, "/data/missing", p)
+
+	fsys = vfs.ReadOnly(vfs.Chroot(vfs.OS(), dir)).(vfs.Resolver)
+	p, err = fsys.Resolve("/data/link")
+	require.NoError(t, err)
+	require.Equal(t, "/secret", p)
+}
+
+func TestReadOnly(t *testing.T) {
+	mem := vfs.NewMemory()
+	f, err := mem.Create("/file")
+	require.NoError(t, err)
+	_, _ = f.WriteString("data")
+	require.NoError(t, f.Close())
+
+	fsys := vfs.ReadOnly(mem)
+	data, err := fsys.ReadFile("/file")
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+
+	_, err = fsys.Create("/other")
+	require.True(t, errors.Is(err, vfs.ErrReadOnly))
+	_, err = fsys.OpenFile("/file", os.O_RDWR, 0)
+	require.True(t, errors.Is(err, vfs.ErrReadOnly))
+	require.True(t, errors.Is(fsys.Mkdir("/dir", 0755), vfs.ErrReadOnly))
+	require.True(t, errors.Is(fsys.Remove("/file"), vfs.ErrReadOnly))
+	require.True(t, errors.Is(fsys.Rename("/file", "/x"), vfs.ErrReadOnly))
+
+	f, err = fsys.Open("/file")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.True(t, errors.Is(err, vfs.ErrReadOnly))
+}
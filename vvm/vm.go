@@ -5,14 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"os"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/malivvan/vv/vvm/parser"
 	"github.com/malivvan/vv/vvm/token"
+	"github.com/malivvan/vv/vvm/vfs"
 )
 
 // ContextKey is a type for context keys used in the VM.
@@ -28,6 +31,24 @@ type frame struct {
 	freeVars    []*ObjectPtr
 	ip          int
 	basePointer int
+	gen         *Generator // set while the frame runs a resumed generator
+	tailCalls   []frame    // call sites of the functions the frame replaced
+}
+
+// maxTailCalls is the number of tail call sites kept per frame for stack
+// traces. Older sites of longer tail call chains are dropped.
+const maxTailCalls = 16
+
+// tailCall records the call site ip of the function f runs before the frame
+// is replaced by the called function.
+func (f *frame) tailCall(ip int) {
+	site := frame{fn: f.fn, ip: ip}
+	if len(f.tailCalls) < maxTailCalls {
+		f.tailCalls = append(f.tailCalls, site)
+		return
+	}
+	copy(f.tailCalls, f.tailCalls[1:])
+	f.tailCalls[len(f.tailCalls)-1] = site
 }
 
 type vmChildCtl struct {
@@ -59,6 +80,21 @@ type VM struct {
 	In          io.Reader
 	Out         io.Writer
 	Args        []string
+	FS          vfs.FS
+
+	deterministic *Deterministic
+	task          *task      // routine of a deterministic run run by this VM
+	routine       *routineVM // routine run by this VM, or by the VM that invoked it
+
+	pause       *pauseCtl               // shared with all clones
+	callCtx     context.Context         // passed to the functions the VM calls
+	interrupt   context.CancelCauseFunc // cancels callCtx
+	pendingCall int                     // 1 + number of arguments of an interrupted call
+	invoked     bool                    // set for the clones run by Invoke
+	result      bool                    // the run returns the value left on the stack
+	finished    bool                    // restored from a snapshot taken after the main function returned
+	restored    bool                    // state restored from a snapshot, not yet run
+	resumes     []*routineVM            // restored routines to start with the next run
 }
 
 const (
@@ -84,7 +120,9 @@ func NewVM(ctx context.Context, bytecode *Bytecode, globals []Object, maxAllocs
 		In:          os.Stdin,
 		Out:         os.Stdout,
 		Args:        os.Args,
+		FS:          vfs.OS(),
 	}
+	v.pause = newPauseCtl(v)
 	v.ctx, v.cancel = context.WithCancel(context.WithValue(ctx, ContextKey("vm"), v))
 	frame := &frame{
 		fn: bytecode.MainFunction,
@@ -112,6 +150,13 @@ func concatInsts(instructions ...[]byte) []byte {
 	return concat
 }
 
+// SetDeterministic makes the runs of the VM deterministic. It must be called
+// before Run.
+func (v *VM) SetDeterministic(d *Deterministic) {
+	v.deterministic = d
+	v.task = d.main
+}
+
 var emptyEntry = &CompiledFunction{
 	Instructions: MakeInstruction(parser.OpSuspend),
 }
@@ -133,8 +178,13 @@ func (v *VM) ShallowClone() *VM {
 		In:          v.In,
 		Out:         v.Out,
 		Args:        v.Args,
+		FS:          v.FS,
+
+		deterministic: v.deterministic,
+		pause:         v.pause,
+		routine:       v.routine,
 	}
-	vClone.ctx, vClone.cancel = context.WithCancel(context.WithValue(v.ctx, ContextKey("vm"), v))
+	vClone.ctx, vClone.cancel = context.WithCancel(context.WithValue(v.ctx, ContextKey("vm"), vClone))
 	frame := &frame{
 		fn: emptyEntry,
 		ip: -1,
@@ -143,6 +193,68 @@ func (v *VM) ShallowClone() *VM {
 	return vClone
 }
 
+// Invoke calls fn with args from Go code and returns its result. It allows
+// builtin functions and modules to call back into the script, for example to
+// run a comparator or a callback passed as argument. Compiled functions run in
+// a shallow clone of the VM, which is aborted together with v; maps with a
+// __call__ hook are called through the hook.
+func (v *VM) Invoke(fn Object, args ...Object) (Object, error) {
+	return v.invoke(v.ctx, fn, args...)
+}
+
+// invoke is Invoke on behalf of the routine running ctx, which differs from
+// the routine of v for a routine running a Go function.
+func (v *VM) invoke(ctx context.Context, fn Object, args ...Object) (Object, error) {
+	if !fn.CanCall() {
+		hook := hookOf(fn, HookCall)
+		if hook == nil {
+			return nil, fmt.Errorf("not callable: %s", fn.TypeName())
+		}
+		args = append([]Object{fn}, args...)
+		fn = hook
+	}
+	if cfn, ok := fn.(*CompiledFunction); ok {
+		clone := v.ShallowClone()
+		clone.invoked = true
+		if t := ctx.Value(ContextKey("task")); t != v.ctx.Value(ContextKey("task")) {
+			clone.ctx = context.WithValue(clone.ctx, ContextKey("task"), t)
+		}
+		if err := v.addChild(clone); err != nil {
+			return nil, err
+		}
+		defer v.delChild(clone)
+		return clone.RunCompiled(cfn, args...)
+	}
+	res, err := fn.Call(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = UndefinedValue
+	}
+	return res, nil
+}
+
+// Invoke calls fn with args on behalf of the VM running ctx, as passed to
+// builtin functions. See VM.Invoke. Outside a VM only functions implemented
+// in Go can be called.
+func Invoke(ctx context.Context, fn Object, args ...Object) (Object, error) {
+	if v, ok := ctx.Value(ContextKey("vm")).(*VM); ok {
+		return v.invoke(ctx, fn, args...)
+	}
+	if _, ok := fn.(*CompiledFunction); ok || !fn.CanCall() {
+		return nil, fmt.Errorf("not callable outside a VM: %s", fn.TypeName())
+	}
+	res, err := fn.Call(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = UndefinedValue
+	}
+	return res, nil
+}
+
 // constract wrapper function func(fn, ...args){ return fn(args...) }
 var funcWrapper = &CompiledFunction{
 	Instructions: concatInsts(
@@ -163,47 +275,73 @@ func (v *VM) releaseSpace() {
 
 // RunCompiled run the VM with user supplied function fn.
 func (v *VM) RunCompiled(fn *CompiledFunction, args ...Object) (val Object, err error) {
-	v.stack = make([]Object, initialStackSize)
-	if fn == nil { // normal Run
-		// reset VM states
-		v.sp = 0
-	} else { // run user supplied function
-		entry := &CompiledFunction{
-			Instructions: concatInsts(
-				MakeInstruction(parser.OpCall, 1+len(args), 0),
-				MakeInstruction(parser.OpSuspend),
-			),
-		}
-		v.stack[0] = funcWrapper
-		v.stack[1] = fn
-		for i, arg := range args {
-			v.stack[i+2] = arg
+	if v.restored { // continue the state restored from a snapshot
+		v.restored = false
+	} else {
+		v.stack = make([]Object, initialStackSize)
+		if fn == nil { // normal Run
+			// reset VM states
+			v.sp = 0
+			v.result = false
+			v.finished = false
+		} else { // run user supplied function
+			entry := &CompiledFunction{
+				Instructions: concatInsts(
+					MakeInstruction(parser.OpCall, 1+len(args), 0),
+					MakeInstruction(parser.OpSuspend),
+				),
+			}
+			v.stack[0] = funcWrapper
+			v.stack[1] = fn
+			for i, arg := range args {
+				v.stack[i+2] = arg
+			}
+			v.sp = 2 + len(args)
+			v.frames[0].fn = entry
+			v.result = true
 		}
-		v.sp = 2 + len(args)
-		v.frames[0].fn = entry
-	}
 
-	v.curFrame = v.frames[0]
-	v.curInsts = v.curFrame.fn.Instructions
-	v.framesIndex = 1
-	v.ip = -1
+		v.curFrame = v.frames[0]
+		v.curFrame.tailCalls = v.curFrame.tailCalls[:0]
+		v.curInsts = v.curFrame.fn.Instructions
+		v.framesIndex = 1
+		v.ip = -1
+	}
 	v.allocs = v.maxAllocs + 1
+	v.callCtx, v.interrupt = context.WithCancelCause(v.ctx)
+	v.pause.enter(v)
 
 	defer func() {
 		if perr := recover(); perr != nil {
 			v.err = ErrPanic{perr, debug.Stack()}
 			v.Abort() // run time panic should trigger abort chain
 		}
+		v.pause.leave(v)
+		if v.task != nil {
+			if r := v.routine; r != nil && r.VM == v && r.canRestart(v.err) {
+				r.startNext(v)
+			}
+			v.task.exit() // let the routines run
+		}
 		v.childCtl.Wait() // waits for all child VMs to exit
+		v.pause.exit(v)
+		v.interrupt(nil)
 		err = v.postRun()
-		if fn != nil && atomic.LoadInt64(&v.aborting) == 0 {
+		if v.result && atomic.LoadInt64(&v.aborting) == 0 {
 			val = v.stack[v.sp-1]
 		}
 		v.releaseSpace()
 	}()
 
+	for _, gvm := range v.resumes {
+		v.resume(gvm)
+	}
+	v.resumes = nil
+
 	val = UndefinedValue
-	v.run()
+	if !v.finished {
+		v.run()
+	}
 	return
 }
 
@@ -259,28 +397,50 @@ func (v *VM) delChild(cvm *VM) {
 	v.childCtl.Done()
 }
 
+// callers returns copies of the frames of the call stack, the current frame
+// first.
 func (v *VM) callers() (frames []frame) {
 	curFrame := *v.curFrame
 	curFrame.ip = v.ip - 1
+	curFrame.tailCalls = slices.Clone(curFrame.tailCalls)
 	frames = append(frames, curFrame)
 	for i := v.framesIndex - 1; i >= 1; i-- {
 		curFrame = *v.frames[i-1]
+		curFrame.tailCalls = slices.Clone(curFrame.tailCalls)
 		frames = append(frames, curFrame)
 	}
 	return frames
 }
 
-func (v *VM) callStack(frames []frame) string {
+// pos returns the source position of the instruction v runs.
+func (v *VM) pos() parser.Pos {
+	if v.curFrame == nil {
+		return parser.NoPos
+	}
+	return v.curFrame.fn.SourcePos(v.ip)
+}
+
+// stackTrace returns the source positions of frames, or of the current call
+// stack if frames is nil. Frames without a position, such as the entry frames
+// of functions called from Go, are left out. The call sites of tail calls
+// follow the position of the frame they replaced.
+func (v *VM) stackTrace(frames []frame) []parser.SourceFilePos {
 	if frames == nil {
 		frames = v.callers()
 	}
-
-	var sb strings.Builder
+	stack := make([]parser.SourceFilePos, 0, len(frames))
 	for _, f := range frames {
-		filePos := v.fileSet.Position(f.fn.SourcePos(f.ip))
-		fmt.Fprintf(&sb, "\n\tat %s", filePos)
+		if pos := v.fileSet.Position(f.fn.SourcePos(f.ip)); pos.IsValid() {
+			stack = append(stack, pos)
+		}
+		for i := len(f.tailCalls) - 1; i >= 0; i-- {
+			t := f.tailCalls[i]
+			if pos := v.fileSet.Position(t.fn.SourcePos(t.ip)); pos.IsValid() {
+				stack = append(stack, pos)
+			}
+		}
 	}
-	return sb.String()
+	return stack
 }
 
 func (v *VM) postRun() (err error) {
@@ -290,32 +450,42 @@ func (v *VM) postRun() (err error) {
 		err = nil
 	}
 	if err != nil {
-		var e ErrPanic
-		if errors.As(err, &e) {
-			err = fmt.Errorf("\nRuntime Panic: %v%s\n%s", e.perr, v.callStack(nil), e.stack)
-		} else {
-			err = fmt.Errorf("\nRuntime Error: %w%s", err, v.callStack(nil))
+		stack := v.stackTrace(nil)
+		var inner *RuntimeError
+		if errors.As(err, &inner) {
+			// error of a function invoked from Go: continue its stack trace
+			err = inner.Err
+			stack = append(inner.Stack, stack...)
 		}
+		rerr := newRuntimeError(err, stack)
+		rerr.Routines = v.childCtl.errors
+		return rerr
 	}
 
 	var sb strings.Builder
 	for _, cerr := range v.childCtl.errors {
 		fmt.Fprintf(&sb, "%v\n", cerr)
 	}
-	cerrs := sb.String()
-
-	if err != nil && len(cerrs) != 0 {
-		err = fmt.Errorf("%w\n%s", err, cerrs)
-		return
-	}
-	if len(cerrs) != 0 {
-		err = fmt.Errorf("%s", cerrs)
+	if sb.Len() != 0 {
+		err = fmt.Errorf("%s", sb.String())
 	}
 	return
 }
 
 func (v *VM) run() {
+	if v.pendingCall != 0 { // restored in the middle of a call
+		numArgs := v.pendingCall - 1
+		v.pendingCall = 0
+		if v.callNative(v.stack[v.sp-1-numArgs], numArgs); v.err != nil {
+			return
+		}
+	}
+
 	for atomic.LoadInt64(&v.aborting) == 0 {
+		if atomic.LoadInt32(&v.pause.req) != 0 {
+			v.park()
+			continue
+		}
 		v.ip++
 
 		switch v.curInsts[v.ip] {
@@ -337,8 +507,20 @@ func (v *VM) run() {
 			if e != nil {
 				v.sp -= 2
 				if e == ErrInvalidOperator {
-					v.err = fmt.Errorf("invalid operation: %s %s %s",
-						left.TypeName(), tok.String(), right.TypeName())
+					hook, self, other := binaryHook(tok, left, right)
+					if hook != nil {
+						v.callHook(hookCall, v.sp, hook, self, other)
+						if v.err != nil {
+							return
+						}
+						break
+					}
+					if tok == token.In {
+						// operands of 'in' are swapped by the compiler
+						left, right = right, left
+					}
+					v.err = invalidOperation(fmt.Sprintf("%s %s %s",
+						left.TypeName(), tok.String(), right.TypeName()))
 					return
 				}
 				v.err = e
@@ -357,6 +539,13 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			v.sp -= 2
+			if hook, self, other := equalHook(left, right); hook != nil {
+				v.callHook(hookEqual, v.sp, hook, self, other)
+				if v.err != nil {
+					return
+				}
+				break
+			}
 			if left.Equals(right) {
 				v.stack[v.sp] = TrueValue
 			} else {
@@ -367,6 +556,13 @@ func (v *VM) run() {
 			right := v.stack[v.sp-1]
 			left := v.stack[v.sp-2]
 			v.sp -= 2
+			if hook, self, other := equalHook(left, right); hook != nil {
+				v.callHook(hookNotEqual, v.sp, hook, self, other)
+				if v.err != nil {
+					return
+				}
+				break
+			}
 			if left.Equals(right) {
 				v.stack[v.sp] = FalseValue
 			} else {
@@ -404,9 +600,17 @@ func (v *VM) run() {
 				}
 				v.stack[v.sp] = res
 				v.sp++
+			case *BigInt:
+				var res Object = &BigInt{Value: new(big.Int).Not(x.Value)}
+				v.allocs--
+				if v.allocs == 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = res
+				v.sp++
 			default:
-				v.err = fmt.Errorf("invalid operation: ^%s",
-					operand.TypeName())
+				v.err = invalidOperation("^" + operand.TypeName())
 				return
 			}
 		case parser.OpMinus:
@@ -432,9 +636,29 @@ func (v *VM) run() {
 				}
 				v.stack[v.sp] = res
 				v.sp++
+			case *BigInt:
+				var res Object = &BigInt{Value: new(big.Int).Neg(x.Value)}
+				v.allocs--
+				if v.allocs == 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = res
+				v.sp++
+			case *Decimal:
+				var res Object = &Decimal{
+					Value: new(big.Int).Neg(x.Value),
+					Scale: x.Scale,
+				}
+				v.allocs--
+				if v.allocs == 0 {
+					v.err = ErrObjectAllocLimit
+					return
+				}
+				v.stack[v.sp] = res
+				v.sp++
 			default:
-				v.err = fmt.Errorf("invalid operation: -%s",
-					operand.TypeName())
+				v.err = invalidOperation("-" + operand.TypeName())
 				return
 			}
 		case parser.OpJumpFalsy:
@@ -460,6 +684,20 @@ func (v *VM) run() {
 				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
 				v.ip = pos - 1
 			}
+		case parser.OpOptionalJump:
+			v.ip += 2
+			if v.stack[v.sp-1] == UndefinedValue {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
+		case parser.OpCoalesceJump:
+			v.ip += 2
+			if v.stack[v.sp-1] == UndefinedValue {
+				v.sp--
+			} else {
+				pos := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+				v.ip = pos - 1
+			}
 		case parser.OpJump:
 			pos := int(v.curInsts[v.ip+2]) | int(v.curInsts[v.ip+1])<<8
 			v.ip = pos - 1
@@ -510,6 +748,96 @@ func (v *VM) run() {
 
 			v.stack[v.sp] = arr
 			v.sp++
+		case parser.OpConcat:
+			v.ip += 2
+			numParts := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
+
+			var sb strings.Builder
+			for i := v.sp - numParts; i < v.sp; i++ {
+				str, err := v.toString(v.stack[i])
+				if err != nil {
+					v.err = err
+					return
+				}
+				if sb.Len()+len(str) > MaxStringLen {
+					v.err = ErrStringLimit
+					return
+				}
+				sb.WriteString(str)
+			}
+			v.sp -= numParts
+
+			var str Object = &String{Value: sb.String()}
+			v.allocs--
+			if v.allocs == 0 {
+				v.err = ErrObjectAllocLimit
+				return
+			}
+
+			v.stack[v.sp] = str
+			v.sp++
+		case parser.OpDestructure:
+			numValues := int(v.curInsts[v.ip+1])
+			strict := int(v.curInsts[v.ip+2]) == 1
+			v.ip += 2
+
+			var elements []Object
+			switch val := v.stack[v.sp-1].(type) {
+			case *Array:
+				elements = val.Value
+			case *ImmutableArray:
+				elements = val.Value
+			case *Range:
+				elements = val.values()
+			default:
+				v.err = fmt.Errorf("not destructurable: %s", val.TypeName())
+				return
+			}
+			if strict && len(elements) != numValues {
+				v.err = fmt.Errorf("wrong number of values: want=%d, got=%d",
+					numValues, len(elements))
+				return
+			}
+
+			v.sp--
+			v.checkGrowStack(numValues)
+			if v.err != nil {
+				return
+			}
+			for i := 0; i < numValues; i++ {
+				if i < len(elements) {
+					v.stack[v.sp] = elements[i]
+				} else {
+					v.stack[v.sp] = UndefinedValue
+				}
+				v.sp++
+			}
+		case parser.OpDestructureMap:
+			v.ip++
+			numKeys := int(v.curInsts[v.ip])
+			base := v.sp - numKeys - 1
+			src := v.stack[base]
+			for i := 0; i < numKeys; i++ {
+				val, err := src.IndexGet(v.stack[base+1+i])
+				if err != nil {
+					if err == ErrNotIndexable {
+						v.err = fmt.Errorf("not indexable: %s", src.TypeName())
+						return
+					}
+					if err == ErrInvalidIndexType {
+						v.err = fmt.Errorf("invalid index type: %s",
+							v.stack[base+1+i].TypeName())
+						return
+					}
+					v.err = err
+					return
+				}
+				if val == nil {
+					val = UndefinedValue
+				}
+				v.stack[base+i] = val
+			}
+			v.sp = base + numKeys
 		case parser.OpMap:
 			v.ip += 2
 			numElements := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
@@ -533,6 +861,7 @@ func (v *VM) run() {
 			value := v.stack[v.sp-1]
 			var e Object = &Error{
 				Value: value,
+				Stack: v.stackTrace(nil),
 			}
 			v.allocs--
 			if v.allocs == 0 {
@@ -555,7 +884,8 @@ func (v *VM) run() {
 				v.stack[v.sp-1] = immutableArray
 			case *Map:
 				var immutableMap Object = &ImmutableMap{
-					Value: value.Value,
+					Value:  value.Value,
+					Hashed: value.Hashed,
 				}
 				v.allocs--
 				if v.allocs == 0 {
@@ -583,187 +913,119 @@ func (v *VM) run() {
 				v.err = err
 				return
 			}
-			if val == nil {
+			if val == nil || val == UndefinedValue {
+				if hook := hookOf(left, HookIndex); hook != nil {
+					v.callHook(hookCall, v.sp, hook, left, index)
+					if v.err != nil {
+						return
+					}
+					break
+				}
 				val = UndefinedValue
 			}
 			v.stack[v.sp] = val
 			v.sp++
 		case parser.OpSliceIndex:
+			numIndices := int(v.curInsts[v.ip+1])
+			v.ip++
+
+			step := UndefinedValue
+			if numIndices == 3 {
+				step = v.stack[v.sp-1]
+				v.sp--
+			}
 			high := v.stack[v.sp-1]
 			low := v.stack[v.sp-2]
 			left := v.stack[v.sp-3]
 			v.sp -= 3
 
-			var lowIdx int64
-			if low != UndefinedValue {
-				if low, ok := low.(*Int); ok {
-					lowIdx = low.Value
-				} else {
-					v.err = fmt.Errorf("invalid slice index type: %s",
-						low.TypeName())
-					return
-				}
-			}
-
+			var val Object
 			switch left := left.(type) {
 			case *Array:
-				numElements := int64(len(left.Value))
-				var highIdx int64
-				if high == UndefinedValue {
-					highIdx = numElements
-				} else if high, ok := high.(*Int); ok {
-					highIdx = high.Value
-				} else {
-					v.err = fmt.Errorf("invalid slice index type: %s",
-						high.TypeName())
+				start, count, stride, err := sliceIndices(len(left.Value),
+					low, high, step)
+				if err != nil {
+					v.err = err
 					return
 				}
-				if lowIdx > highIdx {
-					v.err = fmt.Errorf("invalid slice index: %d > %d",
-						lowIdx, highIdx)
-					return
-				}
-				if lowIdx < 0 {
-					lowIdx = 0
-				} else if lowIdx > numElements {
-					lowIdx = numElements
-				}
-				if highIdx < 0 {
-					highIdx = 0
-				} else if highIdx > numElements {
-					highIdx = numElements
-				}
-				var val Object = &Array{
-					Value: left.Value[lowIdx:highIdx],
-				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
-					return
-				}
-				v.stack[v.sp] = val
-				v.sp++
+				val = &Array{Value: sliceOf(left.Value, start, count, stride)}
 			case *ImmutableArray:
-				numElements := int64(len(left.Value))
-				var highIdx int64
-				if high == UndefinedValue {
-					highIdx = numElements
-				} else if high, ok := high.(*Int); ok {
-					highIdx = high.Value
-				} else {
-					v.err = fmt.Errorf("invalid slice index type: %s",
-						high.TypeName())
+				start, count, stride, err := sliceIndices(len(left.Value),
+					low, high, step)
+				if err != nil {
+					v.err = err
 					return
 				}
-				if lowIdx > highIdx {
-					v.err = fmt.Errorf("invalid slice index: %d > %d",
-						lowIdx, highIdx)
+				val = &Array{Value: sliceOf(left.Value, start, count, stride)}
+			case *Range:
+				start, count, stride, err := sliceIndices(int(left.Len()),
+					low, high, step)
+				if err != nil {
+					v.err = err
 					return
 				}
-				if lowIdx < 0 {
-					lowIdx = 0
-				} else if lowIdx > numElements {
-					lowIdx = numElements
-				}
-				if highIdx < 0 {
-					highIdx = 0
-				} else if highIdx > numElements {
-					highIdx = numElements
+				elements := make([]Object, count)
+				for i := range elements {
+					elements[i] = &Int{Value: left.At(int64(start + i*stride))}
 				}
-				var val Object = &Array{
-					Value: left.Value[lowIdx:highIdx],
-				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
-					return
-				}
-				v.stack[v.sp] = val
-				v.sp++
+				val = &Array{Value: elements}
 			case *String:
-				numElements := int64(len(left.Value))
-				var highIdx int64
-				if high == UndefinedValue {
-					highIdx = numElements
-				} else if high, ok := high.(*Int); ok {
-					highIdx = high.Value
-				} else {
-					v.err = fmt.Errorf("invalid slice index type: %s",
-						high.TypeName())
+				start, count, stride, err := sliceIndices(len(left.Value),
+					low, high, step)
+				if err != nil {
+					v.err = err
 					return
 				}
-				if lowIdx > highIdx {
-					v.err = fmt.Errorf("invalid slice index: %d > %d",
-						lowIdx, highIdx)
-					return
-				}
-				if lowIdx < 0 {
-					lowIdx = 0
-				} else if lowIdx > numElements {
-					lowIdx = numElements
-				}
-				if highIdx < 0 {
-					highIdx = 0
-				} else if highIdx > numElements {
-					highIdx = numElements
-				}
-				var val Object = &String{
-					Value: left.Value[lowIdx:highIdx],
-				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
-					return
-				}
-				v.stack[v.sp] = val
-				v.sp++
-			case *Bytes:
-				numElements := int64(len(left.Value))
-				var highIdx int64
-				if high == UndefinedValue {
-					highIdx = numElements
-				} else if high, ok := high.(*Int); ok {
-					highIdx = high.Value
+				if stride == 1 {
+					val = &String{Value: left.Value[start : start+count]}
 				} else {
-					v.err = fmt.Errorf("invalid slice index type: %s",
-						high.TypeName())
-					return
-				}
-				if lowIdx > highIdx {
-					v.err = fmt.Errorf("invalid slice index: %d > %d",
-						lowIdx, highIdx)
-					return
-				}
-				if lowIdx < 0 {
-					lowIdx = 0
-				} else if lowIdx > numElements {
-					lowIdx = numElements
-				}
-				if highIdx < 0 {
-					highIdx = 0
-				} else if highIdx > numElements {
-					highIdx = numElements
-				}
-				var val Object = &Bytes{
-					Value: left.Value[lowIdx:highIdx],
+					val = &String{Value: string(sliceOf([]byte(left.Value),
+						start, count, stride))}
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+			case *Bytes:
+				start, count, stride, err := sliceIndices(len(left.Value),
+					low, high, step)
+				if err != nil {
+					v.err = err
 					return
 				}
-				v.stack[v.sp] = val
-				v.sp++
+				val = &Bytes{Value: sliceOf(left.Value, start, count, stride)}
+			default:
+				v.err = fmt.Errorf("not sliceable: %s", left.TypeName())
+				return
 			}
-		case parser.OpCall:
+			v.allocs--
+			if v.allocs == 0 {
+				v.err = ErrObjectAllocLimit
+				return
+			}
+			v.stack[v.sp] = val
+			v.sp++
+		case parser.OpCall, parser.OpTailCall:
+			tailCall := v.curInsts[v.ip] == parser.OpTailCall
 			numArgs := int(v.curInsts[v.ip+1])
 			spread := int(v.curInsts[v.ip+2])
 			v.ip += 2
 
 			value := v.stack[v.sp-1-numArgs]
 			if !value.CanCall() {
-				v.err = fmt.Errorf("not callable: %s", value.TypeName())
-				return
+				hook := hookOf(value, HookCall)
+				if hook == nil {
+					v.err = fmt.Errorf("not callable: %s", value.TypeName())
+					return
+				}
+
+				// call hook(value, args...) instead
+				v.checkGrowStack(1)
+				if v.err != nil {
+					return
+				}
+				copy(v.stack[v.sp-numArgs+1:], v.stack[v.sp-numArgs:v.sp])
+				v.stack[v.sp-numArgs] = value
+				v.stack[v.sp-numArgs-1] = hook
+				v.sp++
+				numArgs++
+				value = hook
 			}
 
 			if spread == 1 {
@@ -819,10 +1081,33 @@ func (v *VM) run() {
 					return
 				}
 
-				// test if it's tail-call
-				if callee == v.curFrame.fn { // recursion
+				if tailCall && v.curFrame.gen == nil {
+					// replace the current frame: move the callee and its
+					// arguments down to where the current function was called
+					base := v.curFrame.basePointer
+					copy(v.stack[base-1:], v.stack[v.sp-numArgs-1:v.sp])
+					v.sp = base + numArgs
+					v.checkGrowStack(callee.NumLocals - numArgs)
+					if v.err != nil {
+						return
+					}
+					for i := v.sp; i < base+callee.NumLocals; i++ {
+						v.stack[i] = UndefinedValue
+					}
+					v.curFrame.tailCall(v.ip)
+					v.curFrame.fn = callee
+					v.curFrame.freeVars = callee.Free
+					v.curInsts = callee.Instructions
+					v.ip = -1
+					v.sp = base + callee.NumLocals
+					break
+				}
+
+				// test if it's a self-recursive call followed by a return
+				if callee == v.curFrame.fn && v.curFrame.gen == nil { // recursion
 					nextOp := v.curInsts[v.ip+1]
-					if nextOp == parser.OpReturn ||
+					if (nextOp == parser.OpReturn &&
+						v.curInsts[v.ip+2] <= 1) ||
 						(nextOp == parser.OpPop &&
 							parser.OpReturn == v.curInsts[v.ip+2]) {
 						for p := 0; p < numArgs; p++ {
@@ -848,49 +1133,30 @@ func (v *VM) run() {
 				v.curFrame.fn = callee
 				v.curFrame.freeVars = callee.Free
 				v.curFrame.basePointer = v.sp - numArgs
+				v.curFrame.tailCalls = v.curFrame.tailCalls[:0]
 				v.curInsts = callee.Instructions
 				v.ip = -1
 				v.framesIndex++
 				v.sp = v.sp - numArgs + callee.NumLocals
-			} else {
-				ret, e := value.Call(v.ctx, v.stack[v.sp-numArgs:v.sp]...)
-				v.sp -= numArgs + 1
-
-				// runtime error
-				if e != nil {
-					if e == ErrWrongNumArguments {
-						v.err = fmt.Errorf(
-							"wrong number of arguments in call to '%s'",
-							value.TypeName())
-						return
-					}
-					if e, ok := e.(ErrInvalidArgumentType); ok {
-						v.err = fmt.Errorf(
-							"invalid type for argument '%s' in call to '%s': "+
-								"expected %s, found %s",
-							e.Name, value.TypeName(), e.Expected, e.Found)
-						return
-					}
-					v.err = e
-					return
-				}
-
-				// nil return -> undefined
-				if ret == nil {
-					ret = UndefinedValue
-				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
-					return
-				}
-				v.stack[v.sp] = ret
-				v.sp++
+			} else if v.callNative(value, numArgs); v.err != nil {
+				return
 			}
 		case parser.OpReturn:
 			v.ip++
+			numRets := int(v.curInsts[v.ip])
+			if v.curFrame.gen != nil {
+				v.returnGenerator()
+				continue
+			}
+			if numRets > 1 {
+				v.returnMulti(numRets)
+				if v.err != nil {
+					return
+				}
+				continue
+			}
 			var retVal Object
-			if int(v.curInsts[v.ip]) == 1 {
+			if numRets == 1 {
 				retVal = v.stack[v.sp-1]
 			} else {
 				retVal = UndefinedValue
@@ -1053,6 +1319,13 @@ func (v *VM) run() {
 			var iterator Object
 			dst := v.stack[v.sp-1]
 			v.sp--
+			if hook := hookOf(dst, HookIter); hook != nil {
+				v.callHook(hookIter, v.sp, hook, dst)
+				if v.err != nil {
+					return
+				}
+				break
+			}
 			if !dst.CanIterate() {
 				v.err = fmt.Errorf("not iterable: %s", dst.TypeName())
 				return
@@ -1067,6 +1340,13 @@ func (v *VM) run() {
 			v.sp++
 		case parser.OpIteratorNext:
 			iterator := v.stack[v.sp-1]
+			if g, ok := iterator.(*Generator); ok && !g.done {
+				v.resumeGenerator(g)
+				if v.err != nil {
+					return
+				}
+				break
+			}
 			v.sp--
 			hasMore := iterator.(Iterator).Next()
 			if hasMore {
@@ -1087,6 +1367,16 @@ func (v *VM) run() {
 			val := iterator.(Iterator).Value()
 			v.stack[v.sp] = val
 			v.sp++
+		case parser.OpGenerator:
+			v.newGenerator()
+			if v.err != nil {
+				return
+			}
+		case parser.OpYield:
+			v.yieldGenerator()
+			if v.err != nil {
+				return
+			}
 		case parser.OpSuspend:
 			return
 		default:
@@ -1097,6 +1387,35 @@ func (v *VM) run() {
 	}
 }
 
+// returnMulti returns numRets values from the current frame. The values are
+// left on the caller's stack if the caller unpacks them right away,
+// otherwise they are returned as an array.
+func (v *VM) returnMulti(numRets int) {
+	values := v.stack[v.sp-numRets : v.sp]
+	v.framesIndex--
+	v.curFrame = v.frames[v.framesIndex-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = v.curFrame.ip
+	base := v.frames[v.framesIndex].basePointer - 1
+
+	if v.ip+2 < len(v.curInsts) &&
+		v.curInsts[v.ip+1] == parser.OpDestructure &&
+		int(v.curInsts[v.ip+2]) == numRets {
+		copy(v.stack[base:], values)
+		v.sp = base + numRets
+		v.ip += 3 // skip OpDestructure
+		return
+	}
+
+	v.allocs--
+	if v.allocs == 0 {
+		v.err = ErrObjectAllocLimit
+		return
+	}
+	v.stack[base] = &Array{Value: append([]Object(nil), values...)}
+	v.sp = base + 1
+}
+
 func (v *VM) checkGrowStack(added int) {
 	should := v.sp + added
 	if should < len(v.stack) {
@@ -1116,6 +1435,70 @@ func (v *VM) checkGrowStack(added int) {
 	v.stack = new
 }
 
+// callNative calls value, a callable that is not a compiled function, with
+// the numArgs arguments on top of the stack and replaces them with the
+// result. Only the calls of restartable builtin functions can be interrupted
+// by a snapshot; they are made again once it is taken.
+func (v *VM) callNative(value Object, numArgs int) {
+	if r := v.routine; r != nil {
+		atomic.StoreInt64(&r.site, int64(v.pos()))
+		atomic.AddInt32(&r.calls, 1)
+		defer atomic.AddInt32(&r.calls, -1)
+	}
+	ctx := v.ctx
+	fn, restartable := value.(*BuiltinFunction)
+	if restartable = restartable && fn.Restartable; restartable {
+		ctx = v.callCtx
+	}
+	ret, e := value.Call(ctx, v.stack[v.sp-numArgs:v.sp]...)
+	for e != nil && restartable && v.interrupted() {
+		v.pendingCall = numArgs + 1
+		v.park()
+		v.pendingCall = 0
+		if atomic.LoadInt64(&v.aborting) != 0 {
+			e = ErrVMAborted
+			break
+		}
+		ret, e = value.Call(v.callCtx, v.stack[v.sp-numArgs:v.sp]...)
+	}
+	v.sp -= numArgs + 1
+
+	// runtime error
+	if e != nil {
+		if e == ErrWrongNumArguments {
+			v.err = fmt.Errorf(
+				"%w in call to '%s'",
+				ErrWrongNumArguments, value.TypeName())
+			return
+		}
+		if e, ok := e.(ErrInvalidArgumentType); ok {
+			v.err = fmt.Errorf(
+				"invalid type for argument '%s' in call to '%s': "+
+					"expected %s, found %s",
+				e.Name, value.TypeName(), e.Expected, e.Found)
+			return
+		}
+		v.err = e
+		return
+	}
+
+	// nil return -> undefined
+	if ret == nil {
+		ret = UndefinedValue
+	}
+	// errors created by Go code get the stack of the call
+	if e, ok := ret.(*Error); ok && e.Stack == nil {
+		e.Stack = v.stackTrace(nil)
+	}
+	v.allocs--
+	if v.allocs == 0 {
+		v.err = ErrObjectAllocLimit
+		return
+	}
+	v.stack[v.sp] = ret
+	v.sp++
+}
+
 // IsStackEmpty tests if the stack is empty or not.
 func (v *VM) IsStackEmpty() bool {
 	return v.sp == 0
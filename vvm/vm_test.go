@@ -10,6 +10,7 @@ import (
 	_runtime "runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/malivvan/vv/vvm"
 	"github.com/malivvan/vv/vvm/parser"
@@ -133,11 +134,21 @@ func TestArray(t *testing.T) {
 			nil, arr[idx])
 	}
 
-	expectRun(t, fmt.Sprintf("%s[%d]", arrStr, -1),
+	for idx := 1; idx <= arrLen; idx++ {
+		expectRun(t, fmt.Sprintf("out = %s[%d]", arrStr, -idx),
+			nil, arr[arrLen-idx])
+	}
+	expectRun(t, fmt.Sprintf("%s[%d]", arrStr, -arrLen-1),
 		nil, vvm.UndefinedValue)
 	expectRun(t, fmt.Sprintf("%s[%d]", arrStr, arrLen),
 		nil, vvm.UndefinedValue)
 
+	// negative index set
+	expectRun(t, `a1 := [1, 2, 3]; a1[-1] = 5; out = a1`,
+		nil, ARR{1, 2, 5})
+	expectError(t, `a1 := [1, 2, 3]; a1[-4] = 5`,
+		nil, "index out of bounds")
+
 	// slice operator
 	for low := 0; low < arrLen; low++ {
 		expectRun(t, fmt.Sprintf("out = %s[%d:%d]", arrStr, low, low),
@@ -159,20 +170,56 @@ func TestArray(t *testing.T) {
 	expectRun(t, fmt.Sprintf("out = %s[:]", arrStr),
 		nil, arr)
 	expectRun(t, fmt.Sprintf("out = %s[%d:]", arrStr, -1),
+		nil, arr[arrLen-1:])
+	expectRun(t, fmt.Sprintf("out = %s[%d:]", arrStr, -arrLen-1),
 		nil, arr)
+	expectRun(t, fmt.Sprintf("out = %s[:%d]", arrStr, -1),
+		nil, arr[:arrLen-1])
+	expectRun(t, fmt.Sprintf("out = %s[%d:%d]", arrStr, -3, -1),
+		nil, arr[arrLen-3:arrLen-1])
 	expectRun(t, fmt.Sprintf("out = %s[:%d]", arrStr, arrLen+1),
 		nil, arr)
 	expectRun(t, fmt.Sprintf("out = %s[%d:%d]", arrStr, 2, 2),
 		nil, ARR{})
 
-	expectError(t, fmt.Sprintf("%s[:%d]", arrStr, -1),
-		nil, "invalid slice index")
 	expectError(t, fmt.Sprintf("%s[%d:]", arrStr, arrLen+1),
 		nil, "invalid slice index")
-	expectError(t, fmt.Sprintf("%s[%d:%d]", arrStr, 0, -1),
+	expectError(t, fmt.Sprintf("%s[%d:%d]", arrStr, 0, -arrLen-1),
 		nil, "invalid slice index")
 	expectError(t, fmt.Sprintf("%s[%d:%d]", arrStr, 2, 1),
 		nil, "invalid slice index")
+	expectError(t, fmt.Sprintf("%s[%d:%d]", arrStr, -1, -2),
+		nil, "invalid slice index")
+
+	// stepped slice operator
+	expectRun(t, fmt.Sprintf("out = %s[::]", arrStr), nil, arr)
+	expectRun(t, fmt.Sprintf("out = %s[::1]", arrStr), nil, arr)
+	expectRun(t, fmt.Sprintf("out = %s[::2]", arrStr), nil, ARR{1, 3, 5})
+	expectRun(t, fmt.Sprintf("out = %s[1::2]", arrStr), nil, ARR{2, 4, 6})
+	expectRun(t, fmt.Sprintf("out = %s[1:4:2]", arrStr), nil, ARR{2, 4})
+	expectRun(t, fmt.Sprintf("out = %s[:5:4]", arrStr), nil, ARR{1, 5})
+	expectRun(t, fmt.Sprintf("out = %s[::10]", arrStr), nil, ARR{1})
+	expectRun(t, fmt.Sprintf("out = %s[::-1]", arrStr),
+		nil, ARR{6, 5, 4, 3, 2, 1})
+	expectRun(t, fmt.Sprintf("out = %s[::-2]", arrStr), nil, ARR{6, 4, 2})
+	expectRun(t, fmt.Sprintf("out = %s[4:1:-1]", arrStr), nil, ARR{5, 4, 3})
+	expectRun(t, fmt.Sprintf("out = %s[-2::-3]", arrStr), nil, ARR{5, 2})
+	expectRun(t, fmt.Sprintf("out = %s[10:-10:-1]", arrStr),
+		nil, ARR{6, 5, 4, 3, 2, 1})
+	expectRun(t, fmt.Sprintf("out = %s[2:2:-1]", arrStr), nil, ARR{})
+	expectRun(t, `out = [][::-1]`, nil, ARR{})
+	expectRun(t, `a := [1, 2, 3]; b := a[::-1]; b[0] = 9; out = a`,
+		nil, ARR{1, 2, 3})
+	expectRun(t, `out = immutable([1, 2, 3])[::-1]`, nil, ARR{3, 2, 1})
+	expectRun(t, `out = bytes("abc")[::-1]`, nil, []byte("cba"))
+	expectRun(t, `out = bytes("abcdef")[-4:-1:2]`, nil, []byte("ce"))
+
+	expectError(t, fmt.Sprintf("%s[::0]", arrStr),
+		nil, "invalid slice step")
+	expectError(t, fmt.Sprintf("%s[::\"a\"]", arrStr),
+		nil, "invalid slice step type")
+	expectError(t, fmt.Sprintf("%s[1:4:-1]", arrStr),
+		nil, "invalid slice index")
 }
 
 func TestAssignment(t *testing.T) {
@@ -449,6 +496,52 @@ a := {
 a.x.e = "bar"`, nil, "not index-assignable")
 }
 
+func TestBigInt(t *testing.T) {
+	num := func(src, expected string) {
+		expectRun(t, `x := `+src+`; out = format("%T %v", x, x)`, nil, expected)
+	}
+	num(`123456789012345678901234567890n`, "bigint 123456789012345678901234567890")
+	num(`9223372036854775807n + 1`, "bigint 9223372036854775808")
+	num(`1 + 9223372036854775807n`, "bigint 9223372036854775808")
+	num(`-9223372036854775807n - 10`, "bigint -9223372036854775817")
+	num(`4294967296n * 4294967296n`, "bigint 18446744073709551616")
+	num(`-7n / 2`, "bigint -3")
+	num(`-7n % 2`, "bigint -1")
+	num(`0xffn & 15`, "bigint 15")
+	num(`1n << 70`, "bigint 1180591620717411303424")
+	num(`(1n << 70) >> 69`, "bigint 2")
+	num(`^0n`, "bigint -1")
+	num(`-5n`, "bigint -5")
+	num(`1n + 0.5`, "float 1.5")
+	num(`0.5 + 1n`, "float 1.5")
+	num(`1n + 0.5d`, "decimal 1.5")
+	num(`bigint("18446744073709551616")`, "bigint 18446744073709551616")
+	num(`bigint(12.9)`, "bigint 12")
+	num(`bigint(-1.99d)`, "bigint -1")
+	num(`bigint("x", 0)`, "int 0")
+	num(`int(42n)`, "int 42")
+	num(`float(12345n)`, "float 12345")
+
+	expectRun(t, `out = 1n == 1`, nil, true)
+	expectRun(t, `out = 1 == 1n`, nil, true)
+	expectRun(t, `out = 1n != 2`, nil, true)
+	expectRun(t, `out = 1n == 1.0d`, nil, true)
+	expectRun(t, `out = 1n == 1.0`, nil, false)
+	expectRun(t, `out = 2 < 10n`, nil, true)
+	expectRun(t, `out = 10n >= 10`, nil, true)
+	expectRun(t, `out = 2n > 1.5`, nil, true)
+	expectRun(t, `out = !0n`, nil, true)
+	expectRun(t, `out = is_bigint(1n)`, nil, true)
+	expectRun(t, `out = is_bigint(1)`, nil, false)
+	expectRun(t, `m := {}; m[1] = "a"; out = m[1n]`, nil, "a")
+	expectRun(t, `out = len(set(1, 1n, 1.0d))`, nil, 1)
+
+	expectError(t, `1n / 0`, nil, "division by zero")
+	expectError(t, `1n % 0n`, nil, "division by zero")
+	expectError(t, `1n << -1`, nil, "invalid shift count")
+	expectError(t, `1n + "a"`, nil, "invalid operation")
+}
+
 func TestBitwise(t *testing.T) {
 	expectRun(t, `out = 1 & 1`, nil, 1)
 	expectRun(t, `out = 1 & 0`, nil, 0)
@@ -752,24 +845,24 @@ func TestBuiltinFunction(t *testing.T) {
 	expectError(t, `delete(immutable([]), "")`, nil,
 		`invalid type for argument 'first'`)
 	expectError(t, `delete([], "")`, nil, `invalid type for argument 'first'`)
-	expectError(t, `delete({}, 1)`, nil, `invalid type for argument 'second'`)
-	expectError(t, `delete({}, 1.0)`, nil, `invalid type for argument 'second'`)
+	expectRun(t, `m := {}; m[1] = 2; delete(m, 1); out = len(m)`, nil, 0)
+	expectRun(t, `m := {}; m[1.0] = 2; delete(m, 1); out = len(m)`, nil, 1)
 	expectError(t, `delete({}, undefined)`, nil,
 		`invalid type for argument 'second'`)
 	expectError(t, `delete({}, [])`, nil, `invalid type for argument 'second'`)
 	expectError(t, `delete({}, {})`, nil, `invalid type for argument 'second'`)
 	expectError(t, `delete({}, error("err"))`, nil,
 		`invalid type for argument 'second'`)
-	expectError(t, `delete({}, bytes("str"))`, nil,
-		`invalid type for argument 'second'`)
-	expectError(t, `delete({}, char(35))`, nil,
-		`invalid type for argument 'second'`)
-	expectError(t, `delete({}, time(1257894000))`, nil,
-		`invalid type for argument 'second'`)
 	expectError(t, `delete({}, immutable({}))`, nil,
 		`invalid type for argument 'second'`)
-	expectError(t, `delete({}, immutable([]))`, nil,
+	expectError(t, `delete({}, immutable([{}]))`, nil,
 		`invalid type for argument 'second'`)
+	expectRun(t, `
+m := {}
+keys := [bytes("str"), char(35), time(1257894000), immutable([1, "a"])]
+for k in keys { m[k] = k }
+for k in keys { delete(m, k) }
+out = len(m)`, nil, 0)
 
 	expectRun(t, `out = delete({}, "")`, nil, vvm.UndefinedValue)
 	expectRun(t, `out = {key1: 1}; delete(out, "key1")`, nil, MAP{})
@@ -1003,6 +1096,170 @@ out = 1 > 2 ?
 	10 - 5`, nil, 5)
 }
 
+func TestDecimal(t *testing.T) {
+	num := func(src, expected string) {
+		expectRun(t, `x := `+src+`; out = format("%T %v", x, x)`, nil, expected)
+	}
+	num(`1.50d`, "decimal 1.50")
+	num(`0.1d + 0.2d`, "decimal 0.3")
+	num(`1.10d + 2.205d`, "decimal 3.305")
+	num(`1.50d - 2`, "decimal -0.50")
+	num(`1.5d * 2.25d`, "decimal 3.375")
+	num(`1d / 3d`, "decimal 0.3333333333333333")
+	num(`2d / 3`, "decimal 0.6666666666666667")
+	num(`10.00d / 4`, "decimal 2.50")
+	num(`1 / 8d`, "decimal 0.125")
+	num(`-7.5d % 2`, "decimal -1.5")
+	num(`-0.05d`, "decimal -0.05")
+	num(`1e3d`, "decimal 1000")
+	num(`1.5e-3d`, "decimal 0.0015")
+	num(`0.1d + 0.2`, "decimal 0.3")
+	num(`0.2 + 0.1d`, "decimal 0.3")
+	num(`5 - 0.5d`, "decimal 4.5")
+	num(`123456789012345678901234567890n * 1.5d`, "decimal 185185183518518518351851851835.0")
+	num(`decimal("19.990")`, "decimal 19.990")
+	num(`decimal(0.1)`, "decimal 0.1")
+	num(`decimal(3)`, "decimal 3")
+	num(`decimal("x", 0)`, "int 0")
+	num(`float(2.5d)`, "float 2.5")
+	num(`int(-2.9d)`, "int -2")
+
+	expectRun(t, `out = 1.50d == 1.5d`, nil, true)
+	expectRun(t, `out = 2.00d == 2`, nil, true)
+	expectRun(t, `out = 0.1d + 0.2d == 0.3d`, nil, true)
+	expectRun(t, `out = 0.5d == 0.5`, nil, false)
+	expectRun(t, `out = 1.25d < 1.3d`, nil, true)
+	expectRun(t, `out = 2 > 1.99d`, nil, true)
+	expectRun(t, `out = 0.1d <= 0.1`, nil, true)
+	expectRun(t, `out = !0.00d`, nil, true)
+	expectRun(t, `out = is_decimal(1.0d)`, nil, true)
+	expectRun(t, `out = is_decimal(1.0)`, nil, false)
+	expectRun(t, `m := {}; m[1.50d] = "a"; m[2d] = "b"; out = m[1.5d] + m[2]`, nil, "ab")
+
+	expectRun(t, `out = format("%.2f|%.1f|%.1f|%8.3f|%f", 1.005d, 1.25d, 1.35d, -2.5d, 1.50d)`,
+		nil, "1.00|1.2|1.4|  -2.500|1.50")
+	expectRun(t, `out = format("%d|%x|%+08d", 12345678901234567890n, 255n, 42n)`,
+		nil, "12345678901234567890|ff|+0000042")
+
+	expectError(t, `1.5d / 0`, nil, "division by zero")
+	expectError(t, `1.5d % 0.0d`, nil, "division by zero")
+	expectError(t, `1.5d & 1`, nil, "invalid operation")
+}
+
+func TestDestructuring(t *testing.T) {
+	// multiple values
+	expectRun(t, `a, b := 1, 2; out = [a, b]`, nil, ARR{1, 2})
+	expectRun(t, `a, b := 1, 2; a, b = b, a; out = [a, b]`, nil, ARR{2, 1})
+	expectRun(t, `a := 1; a, b := a + 1, a + 2; out = [a, b]`, nil, ARR{2, 3})
+	expectRun(t, `a, _ := 1, 2; _, b := 3, 4; out = [a, b]`, nil, ARR{1, 4})
+	expectRun(t, `m := {}; m.a, m.b = 1, 2; out = m`, nil, MAP{"a": 1, "b": 2})
+	expectRun(t, `a, b := [1, 2]; out = [a, b]`, nil, ARR{1, 2})
+	expectRun(t, `a, b := immutable([1, 2]); out = [a, b]`, nil, ARR{1, 2})
+
+	// multiple return values
+	expectRun(t, `f := func() { return 1, 2 }; a, b := f(); out = [a, b]`,
+		nil, ARR{1, 2})
+	expectRun(t, `f := func() { return 1, 2 }; out = f()`, nil, ARR{1, 2})
+	expectRun(t, `f := func() { return 1, 2 }; [a] := f(); out = a`, nil, 1)
+	expectRun(t, `f := func() { return 1, 2 }; out = len(f())`, nil, 2)
+	expectRun(t, `
+f := func(x) { return x, x * 2 }
+g := func(x) { return f(x + 1) }
+a, b := g(1)
+out = [a, b, g(2)]`, nil, ARR{2, 4, ARR{3, 6}})
+	expectRun(t, `
+f := func(n, acc) {
+	if n == 0 { return acc, n }
+	return f(n - 1, acc + n)
+}
+a, b := f(4, 0)
+out = [a, b]`, nil, ARR{10, 0})
+	expectRun(t, `
+f := func(n) {
+	if n == 0 { return 0 }
+	return n, f(n - 1)
+}
+out = f(2)`, nil, ARR{2, ARR{1, 0}})
+	expectRun(t, `
+f := func() { return 1, 2, 3 }
+a, b, c := f()
+d := func() {
+	x, y, z := f()
+	return z, y, x
+}
+e, g, h := d()
+out = [a, b, c, e, g, h]`, nil, ARR{1, 2, 3, 3, 2, 1})
+
+	// patterns
+	expectRun(t, `[a, b] := [1, 2, 3]; out = [a, b]`, nil, ARR{1, 2})
+	expectRun(t, `[a, b, c] := [1, 2]; out = [a, b, c]`,
+		nil, ARR{1, 2, vvm.UndefinedValue})
+	expectRun(t, `[a, [b, c]] := [1, [2, 3]]; out = [a, b, c]`,
+		nil, ARR{1, 2, 3})
+	expectRun(t, `{a, b} := {a: 1, b: 2, c: 3}; out = [a, b]`, nil, ARR{1, 2})
+	expectRun(t, `{a: x, "b c": y} := {a: 1, "b c": 2}; out = [x, y]`,
+		nil, ARR{1, 2})
+	expectRun(t, `{a, b: [c, d]} := {a: 1, b: [2, 3]}; out = [a, c, d]`,
+		nil, ARR{1, 2, 3})
+	expectRun(t, `[{a}, {a: b}] := [{a: 1}, {a: 2}]; out = [a, b]`,
+		nil, ARR{1, 2})
+	expectRun(t, `a := 0; b := [0, 0]; [a, b[1]] = [1, 2]; out = [a, b]`,
+		nil, ARR{1, ARR{0, 2}})
+	expectRun(t, `x := 1; {x} = {}; out = x`, nil, vvm.UndefinedValue)
+
+	// closures
+	expectRun(t, `
+f := func(x) { return x, x * 2 }
+g := func() {
+	fns := []
+	for i := 0; i < 3; i++ {
+		a, b := f(i)
+		fns = append(fns, func() { return a + b })
+	}
+	return fns
+}
+fns := g()
+out = [fns[0](), fns[1](), fns[2]()]`, nil, ARR{0, 3, 6})
+	expectRun(t, `
+g := func() {
+	a, b := 1, 2
+	h := func() { return a + b }
+	a, c := 10, 20
+	return h(), c
+}
+out = g()`, nil, ARR{12, 20})
+	expectRun(t, `
+g := func() {
+	a := 1
+	h := func() {
+		a, b := 2, 3
+		return a + b
+	}
+	return h(), a
+}
+out = g()`, nil, ARR{5, 1})
+	expectRun(t, `
+g := func() {
+	a := 1
+	h := func() {
+		b := 0
+		a, b = 2, 3
+		return b
+	}
+	return h(), a
+}
+out = g()`, nil, ARR{3, 2})
+
+	expectError(t, `a, b := 1`, nil, "not destructurable: int")
+	expectError(t, `a, b := [1]`, nil,
+		"wrong number of values: want=2, got=1")
+	expectError(t, `f := func() { return 1, 2, 3 }; a, b := f()`, nil,
+		"wrong number of values: want=2, got=3")
+	expectError(t, `[a] := 1`, nil, "not destructurable: int")
+	expectError(t, `{a} := 1`, nil, "not indexable: int")
+	expectError(t, `{a} := [1]`, nil, "invalid index type: string")
+}
+
 func TestEquality(t *testing.T) {
 	testEquality(t, `1`, `1`, true)
 	testEquality(t, `1`, `2`, false)
@@ -1183,6 +1440,57 @@ func TestError(t *testing.T) {
 	expectError(t, `error("error").err`, nil, "invalid index on error")
 	expectError(t, `error("error").value_`, nil, "invalid index on error")
 	expectError(t, `error([1,2,3])[1]`, nil, "invalid index on error")
+
+	expectRun(t, `out = error("foo").code`, nil, vvm.UndefinedValue)
+	expectRun(t, `out = error("foo").cause`, nil, vvm.UndefinedValue)
+	expectRun(t, `out = new_error("foo", "not_found").code`, nil, "not_found")
+	expectRun(t, `out = new_error("foo").value`, nil, "foo")
+	expectRun(t, `
+e := wrap(error("io"), "read failed", "read")
+out = [e.value, e.code, e.cause.value, unwrap(e).value, unwrap(unwrap(e))]`,
+		nil, ARR{"read failed", "read", "io", "io", vvm.UndefinedValue})
+	expectRun(t, `
+e := wrap(new_error("io", "eof"), "read failed")
+out = [is_error(e, "eof"), is_error(e, "read"), is_error(e, e), is_error(e, e.cause), is_error(e, error("io"))]`,
+		nil, ARR{true, false, true, true, false})
+	expectRun(t, `out = is_error(1, "eof")`, nil, false)
+	expectRun(t, `out = unwrap(1)`, nil, vvm.UndefinedValue)
+	expectError(t, `wrap(1, "foo")`, nil, "invalid type for argument 'first'")
+	expectError(t, `new_error("foo", 1)`, nil, "invalid type for argument 'second'")
+	expectError(t, `is_error(error(1), 1)`, nil, "invalid type for argument 'second'")
+}
+
+func TestRuntimeError(t *testing.T) {
+	program := parse(t, `f := func() {
+	return 1n / 0n
+}
+f()`)
+	_, _, err := traceCompileRun(program, nil, nil, -1)
+	var rerr *vvm.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, "test", rerr.File)
+	require.Equal(t, 2, rerr.Line)
+	require.Equal(t, 9, rerr.Column)
+	require.Equal(t, 2, len(rerr.Stack))
+	require.Equal(t, 4, rerr.Stack[1].Line)
+	require.True(t, errors.Is(err, vvm.ErrDivisionByZero))
+	require.Equal(t, vvm.ErrDivisionByZero, rerr.Err)
+	require.Equal(t, "division_by_zero", vvm.ErrorCode(err))
+}
+
+func TestErrorStack(t *testing.T) {
+	for src, expected := range map[string]IARR{
+		`out = error("foo").stack`: {"test:1:13"},
+		`f := func() { return error("foo") }
+out = f().stack`: {"test:1:28", "test:2:7"},
+		`out = int("foo", error("bad")).stack`: {"test:1:24"},
+		`out = new_error("foo").stack`:         {"test:1:7"},
+	} {
+		res, _, err := traceCompileRun(parse(t, src),
+			map[string]vvm.Object{testOut: vvm.UndefinedValue}, nil, -1)
+		require.NoError(t, err)
+		require.Equal(t, toObject(expected), res[testOut], src)
+	}
 }
 
 func TestFloat(t *testing.T) {
@@ -1805,6 +2113,120 @@ out = (func() {
 	`, nil, 2)
 }
 
+func TestGenerator(t *testing.T) {
+	expectRun(t, `
+gen := func(n) {
+	for i := 0; i < n; i++ {
+		yield i * i
+	}
+}
+out = []
+for x in gen(4) { out = append(out, x) }`, nil, ARR{0, 1, 4, 9})
+
+	// key is the index of the yielded value
+	expectRun(t, `
+gen := func() { yield "a"; yield "b" }
+out = ""
+for i, x in gen() { out += string(i) + x }`, nil, "0a1b")
+
+	// infinite generators are lazy
+	expectRun(t, `
+fib := func() {
+	a, b := 0, 1
+	for {
+		yield a
+		a, b = b, a + b
+	}
+}
+out = []
+for x in fib() {
+	if x > 20 { break }
+	out = append(out, x)
+}`, nil, ARR{0, 1, 1, 2, 3, 5, 8, 13})
+
+	// return ends the generator; returned values are discarded
+	expectRun(t, `
+gen := func() { yield 1; return 5; yield 2 }
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{1})
+	expectRun(t, `
+out = 0
+for x in func() { if false { yield 1 } }() { out++ }`, nil, 0)
+	expectRun(t, `out = func() { yield }(); out = type_name(out)`,
+		nil, "generator")
+
+	// the body does not run until the first iteration
+	expectRun(t, `
+out = 0
+gen := func() { out = 1; yield 2 }
+g := gen()`, nil, 0)
+
+	// a generator is consumed by iterating it
+	expectRun(t, `
+g := func() { yield 1; yield 2 }()
+out = 0
+for x in g { out += x }
+for x in g { out += 10 }`, nil, 3)
+	expectRun(t, `
+g := func() { yield 1; yield 2; yield 3 }()
+out = 0
+for x in g { out += x; break }
+for x in g { out += x * 10 }`, nil, 51)
+
+	// locals, arguments and free variables survive suspension
+	expectRun(t, `
+counter := func(start, ...steps) {
+	n := start
+	for s in steps {
+		n += s
+		yield n
+	}
+}
+out = []
+for x in counter(10, 1, 2, 3) { out = append(out, x) }`, nil, ARR{11, 13, 16})
+	expectRun(t, `
+base := 100
+gen := func() {
+	add := func(x) { return base + x }
+	yield add(1)
+	base = 200
+	yield add(2)
+}
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{101, 202})
+
+	// generators can consume other generators and recurse
+	expectRun(t, `
+nums := func(n) { for i := 0; i < n; i++ { yield i } }
+double := func(g) { for x in g { yield x * 2 } }
+out = []
+for x in double(nums(3)) { out = append(out, x) }`, nil, ARR{0, 2, 4})
+	expectRun(t, `
+countdown := func(n) {
+	if n == 0 { return }
+	yield n
+	for x in countdown(n - 1) { yield x }
+}
+out = []
+for x in countdown(4) { out = append(out, x) }`, nil, ARR{4, 3, 2, 1})
+	expectRun(t, `
+gen := func() {
+	for k, v in {a: 1} { yield k }
+	for x in [1, 2] { yield x }
+}
+out = []
+for x in gen() { out = append(out, x) }`, nil, ARR{"a", 1, 2})
+
+	expectError(t, `yield 1`, nil, "yield not allowed outside function")
+	expectError(t, `
+g := undefined
+g = func() { for x in g { yield x } }()
+for x in g {}`, nil, "generator already running")
+	expectError(t, `
+gen := func() { yield 1; yield [] + 1 }
+for x in gen() {}`, nil, "invalid operation")
+}
+
 func TestBlocksInGlobalScope(t *testing.T) {
 	expectRun(t, `
 f := undefined
@@ -1890,6 +2312,98 @@ for x in [1, 2, 3] {
 		nil, 6)
 }
 
+func TestHooks(t *testing.T) {
+	vec := `
+vec := func(x, y) {
+	return {
+		x: x,
+		y: y,
+		__add__: func(a, b) { return vec(a.x + b.x, a.y + b.y) },
+		__mul__: func(a, k) { return vec(a.x * k, a.y * k) },
+		__eq__: func(a, b) { return is_map(b) && a.x == b.x && a.y == b.y },
+		__lt__: func(a, b) { return a.x * a.x + a.y * a.y < b.x * b.x + b.y * b.y },
+		__str__: func(a) { return "(" + string(a.x) + ", " + string(a.y) + ")" },
+		__iter__: func(a) { yield a.x; yield a.y },
+		__call__: func(a, ...args) { return [a.x, a.y] + args }
+	}
+}
+`
+	// binary operators
+	expectRun(t, vec+`out = (vec(1, 2) + vec(3, 4)).y`, nil, 6)
+	expectRun(t, vec+`out = (vec(1, 2) * 3).x`, nil, 3)
+	expectRun(t, vec+`a := vec(1, 1); a += vec(1, 2); out = [a.x, a.y]`,
+		nil, ARR{2, 3})
+	expectError(t, vec+`vec(1, 2) - vec(1, 1)`, nil,
+		"invalid operation: map - map")
+	expectError(t, vec+`2 * vec(1, 2)`, nil, "invalid operation: int * map")
+
+	// comparisons are mirrored: a > b is b.__lt__(b, a)
+	expectRun(t, vec+`out = vec(1, 1) < vec(2, 2)`, nil, true)
+	expectRun(t, vec+`out = vec(3, 1) < vec(2, 2)`, nil, false)
+	expectRun(t, vec+`out = vec(3, 3) > vec(2, 2)`, nil, true)
+	expectError(t, vec+`vec(1, 1) <= vec(2, 2)`, nil,
+		"invalid operation: map >= map")
+
+	// equality
+	expectRun(t, vec+`out = vec(1, 2) == vec(1, 2)`, nil, true)
+	expectRun(t, vec+`out = vec(1, 2) != vec(1, 2)`, nil, false)
+	expectRun(t, vec+`out = vec(1, 2) == vec(2, 1)`, nil, false)
+	expectRun(t, vec+`out = 5 == vec(1, 2)`, nil, false)
+	expectRun(t, vec+`out = {x: 1, y: 2} == vec(1, 2)`, nil, true)
+	expectRun(t, `out = {__eq__: func(a, b) { return 1 }} == 2`, nil, true)
+	expectRun(t, `out = {__eq__: func(a, b) { return 0 }} != 2`, nil, true)
+	expectRun(t, `out = immutable({__eq__: func(a, b) { return 1 }}) == 2`, nil, true)
+	expectRun(t, `a := {}; a[1] = 2; b := {}; b[1] = 2; out = [a == b, a != {}, {} == {}]`, nil, ARR{true, true, true})
+
+	// string conversion
+	expectRun(t, vec+`out = string(vec(1, 2))`, nil, "(1, 2)")
+	expectRun(t, vec+"out = `v=${vec(1, 2)}`", nil, "v=(1, 2)")
+
+	// iteration
+	expectRun(t, vec+`out = []; for i, x in vec(5, 6) { out += [i, x] }`,
+		nil, ARR{0, 5, 1, 6})
+	expectRun(t, `out = 0; for x in {a: 1, __iter__: func(m) { return [m.a, 2] }} { out += x }`,
+		nil, 3)
+	expectRun(t, `
+inner := {__iter__: func(m) { return [1, 2, 3] }}
+outer := {__iter__: func(m) { return inner }}
+out = 0; for x in outer { out += x }`, nil, 6)
+	expectError(t, `for x in {__iter__: func(m) { return 1 }} {}`, nil,
+		"not iterable: int")
+
+	// calls
+	expectRun(t, vec+`out = vec(1, 2)(3, 4)`, nil, ARR{1, 2, 3, 4})
+	expectRun(t, vec+`out = vec(1, 2)([5, 6]...)`, nil, ARR{1, 2, 5, 6})
+	expectRun(t, `out = {__call__: len}()`, nil, 1)
+	expectError(t, `{}()`, nil, "not callable: map")
+	expectError(t, `{__call__: 1}()`, nil, "not callable: map")
+
+	// __index__ is consulted for missing keys only; writes are not affected
+	expectRun(t, `
+m := {a: 1, __index__: func(m, k) { return k + "?" }}
+out = [m.a, m.b, m["c"]]`, nil, ARR{1, "b?", "c?"})
+	expectRun(t, `
+m := {__index__: func(m, k) { return 0 }}
+m.a += 2
+m.b = 5
+out = [m.a, m.b, m.c]`, nil, ARR{2, 5, 0})
+
+	// hooks of immutable maps work, but cannot be replaced
+	expectRun(t, vec+`out = (immutable(vec(1, 2)) + vec(1, 1)).x`, nil, 2)
+	expectRun(t, `out = immutable({__index__: func(m, k) { return 7 }}).a`,
+		nil, 7)
+	expectError(t, `m := immutable({__index__: func(m, k) { return 7 }}); m.a = 1`,
+		nil, "not index-assignable")
+
+	// errors inside hooks
+	expectError(t, `{__add__: func(a, b) { return a.x.y.z + 1 }} + 1`, nil,
+		"invalid operation: undefined + int")
+	expectError(t, `{__add__: func(a) { return a }} + 1`, nil,
+		"wrong number of arguments: want=1, got=2")
+	expectError(t, `m := {}; m = {__eq__: func(a, b) { return m == b }}; m == 1`,
+		nil, "stack overflow")
+}
+
 func TestIf(t *testing.T) {
 
 	expectRun(t, `if (true) { out = 10 }`, nil, 10)
@@ -2565,6 +3079,33 @@ out = m["foo"](2) + m["foo"](3)
 		nil, 5)
 	expectRun(t, `func() { m1 := {k1: 1, k2: "foo"}; m2 := m1; m2.k1 = 3; out = m1.k1 }()`,
 		nil, 3)
+
+	// non-string keys
+	expectRun(t, `m := {}; m[1] = "int"; m["1"] = "string"; out = [m[1], m["1"], len(m)]`,
+		nil, ARR{"int", "string", 2})
+	expectRun(t, `m := {}; m[1.5] = 1; m['a'] = 2; m[true] = 3; out = m[1.5] + m['a'] + m[true]`,
+		nil, 6)
+	expectRun(t, `m := {}; m[immutable([1, "a"])] = 1; out = m[immutable([1, "a"])]`,
+		nil, 1)
+	expectRun(t, `m := {}; m[time(1257894000)] = 1; out = m[time(1257894000)]`,
+		nil, 1)
+	expectRun(t, `m := {}; m[bytes("a")] = 1; out = [m[bytes("a")], m["a"]]`,
+		nil, ARR{1, vvm.UndefinedValue})
+	expectRun(t, `m := {}; m[1] = 1; out = m[2]`, nil, vvm.UndefinedValue)
+	expectRun(t, `m := {a: 1}; m[2] = 2; out = 0; for k, v in m { out += v }`,
+		nil, 3)
+	expectRun(t, `m := {a: 1}; m[2] = 2; m2 := copy(m); m2[2] = 3; out = m[2]`,
+		nil, 2)
+	expectRun(t, `m := {}; m[2] = 2; out = m == {}`, nil, false)
+	expectRun(t, `m1 := {}; m1[2] = 2; m2 := {}; m2[2] = 2; out = m1 == m2`,
+		nil, true)
+	expectRun(t, `m := {}; m[2] = 2; m = immutable(m); out = m[2]`, nil, 2)
+	expectRun(t, `m := {a: 1}; m[2] = 2; out = [2 in m, "a" in m, 3 in m, [] in m]`,
+		nil, ARR{true, true, false, false})
+	expectError(t, `m := {}; m[[1]] = 1`, nil, "invalid index type")
+	expectError(t, `m := {}; m[{}] = 1`, nil, "invalid index type")
+	expectError(t, `m := {}; m[immutable([[1]])] = 1`, nil, "invalid index type")
+	expectError(t, `m := {}; m[undefined]`, nil, "invalid index type")
 }
 
 func TestBuiltin(t *testing.T) {
@@ -2879,6 +3420,116 @@ func testAllocsLimit(t *testing.T, src string, limit int64) {
 	}
 }
 
+func TestOptionalChaining(t *testing.T) {
+	expectRun(t, `m := {a: {b: [1, {c: 2}]}}; out = m?.a?.b?[1]?.c`, nil, 2)
+	expectRun(t, `m := {a: {b: [1, 2, 3]}}; out = m.a?.b?[1:]`, nil, ARR{2, 3})
+	expectRun(t, `m := {}; out = m.a?.b`, nil, vvm.UndefinedValue)
+	expectRun(t, `m := {}; out = m.a?[0]`, nil, vvm.UndefinedValue)
+	expectRun(t, `m := {}; out = m.a?[0:1]`, nil, vvm.UndefinedValue)
+
+	// the whole chain is skipped
+	expectRun(t, `m := {}; out = m.a?.b.c.d()`, nil, vvm.UndefinedValue)
+	expectRun(t, `m := {}; out = m.a?[0](1)[2]`, nil, vvm.UndefinedValue)
+	expectRun(t, `
+n := 0
+f := func() { n++; return "x" }
+m := {}
+v := m.a?[f()].b(f())
+out = [v, n]`, nil, ARR{vvm.UndefinedValue, 0})
+	expectRun(t, `
+m := {f: func(x) { return {v: x} }}
+out = [m?.f(1).v, m.g?.f(1).v]`, nil, ARR{1, vvm.UndefinedValue})
+
+	// arguments and indexes are separate chains
+	expectRun(t, `
+m := {f: func(x) { return x }}
+out = m?.f(m.a?.b)`, nil, vvm.UndefinedValue)
+	expectRun(t, `m := {a: {b: 5}}; out = [1, 2][m?.x?.y ?? 1]`, nil, 2)
+
+	// only undefined short-circuits
+	expectRun(t, `out = [false, 0]?[1]`, nil, 0)
+	expectError(t, `a := 1; b := a?.b.c`, nil, "not indexable")
+	expectRun(t, `a := [1]; out = a?[2]?.b.c`, nil, vvm.UndefinedValue)
+	expectError(t, `m := {}; m.a?.b = 1`, nil,
+		"Compile Error: optional chaining not allowed in assignment")
+	expectError(t, `m := {}; m?[1]++`, nil,
+		"Compile Error: optional chaining not allowed in assignment")
+	expectError(t, `m := {}; [m?.a] = [1]`, nil,
+		"Compile Error: optional chaining not allowed in assignment")
+
+	// conditional expression with a float and an array
+	expectRun(t, `out = true ?.5 : 1`, nil, 0.5)
+	expectRun(t, `out = false ? [1] : [2]`, nil, ARR{2})
+}
+
+func TestCoalesce(t *testing.T) {
+	expectRun(t, `out = undefined ?? 1`, nil, 1)
+	expectRun(t, `out = 0 ?? 1`, nil, 0)
+	expectRun(t, `out = false ?? 1`, nil, false)
+	expectRun(t, `out = "" ?? 1`, nil, "")
+	expectRun(t, `out = undefined ?? undefined ?? 3`, nil, 3)
+	expectRun(t, `out = undefined ?? 1 + 2`, nil, 3)
+	expectRun(t, `out = undefined || false ?? 1`, nil, false)
+	expectRun(t, `m := {a: {}}; out = m.a.b?.c ?? "none"`, nil, "none")
+	expectRun(t, `
+n := 0
+f := func() { n++; return n }
+a := 5 ?? f()
+b := undefined ?? f()
+out = [a, b, n]`, nil, ARR{5, 1, 1})
+	expectRun(t, `
+f := func(x) {
+	return x ?? "default"
+}
+out = [f(undefined), f(1)]`, nil, ARR{"default", 1})
+}
+
+func TestRange(t *testing.T) {
+	expectRun(t, `out = type_name(range(0, 3))`, nil, "range")
+	expectRun(t, `out = string(range(0, 3))`, nil, "range(0, 3)")
+	expectRun(t, `out = string(range(5, 0, 2))`, nil, "range(5, 0, 2)")
+	expectRun(t, `out = []; for x in range(0, 5) { out = append(out, x) }`,
+		nil, ARR{0, 1, 2, 3, 4})
+	expectRun(t, `out = []; for x in range(5, -5, 3) { out = append(out, x) }`,
+		nil, ARR{5, 2, -1, -4})
+	expectRun(t, `out = 0; for i, x in range(10, 13) { out += i * x }`,
+		nil, 11+2*12)
+
+	// elements are produced on demand
+	expectRun(t, `
+out = 0
+for x in range(0, 1000000000000) {
+	if x == 5 { break }
+	out += x
+}`, nil, 10)
+	expectRun(t, `out = len(range(0, 1000000000000, 3))`,
+		nil, 333333333334)
+
+	expectRun(t, `out = len(range(0, 0))`, nil, 0)
+	expectRun(t, `out = len(range(3, -3, 2))`, nil, 3)
+	expectRun(t, `out = range(0, 10, 3)[2]`, nil, 6)
+	expectRun(t, `out = range(10, 0, 3)[3]`, nil, 1)
+	expectRun(t, `out = range(0, 10, 3)[4]`, nil, vvm.UndefinedValue)
+	expectRun(t, `out = 6 in range(0, 10, 3)`, nil, true)
+	expectRun(t, `out = 7 in range(0, 10, 3)`, nil, false)
+	expectRun(t, `out = -2 in range(4, -5, 3)`, nil, true)
+	expectRun(t, `out = 4 in range(0, 4)`, nil, false)
+	expectRun(t, `out = "a" in range(0, 4)`, nil, false)
+	expectRun(t, `out = range(0, 5, 2) == range(0, 6, 2)`, nil, true)
+	expectRun(t, `out = range(0, 5) == range(0, 5, 2)`, nil, false)
+	expectRun(t, `out = range(0, 0) == range(3, 3)`, nil, true)
+	expectRun(t, `out = range(1, 1) ? 1 : 2`, nil, 2)
+	expectRun(t, `out = range(0, 10, 3)[-1]`, nil, 9)
+	expectRun(t, `out = range(10, 0, 3)[-4]`, nil, 10)
+	expectRun(t, `out = range(0, 3)[-4]`, nil, vvm.UndefinedValue)
+	expectRun(t, `out = range(0, 10, 3)[1:]`, nil, ARR{3, 6, 9})
+	expectRun(t, `out = range(5, 0)[::-2]`, nil, ARR{1, 3, 5})
+	expectRun(t, `out = append(range(0, 3), 4)`, nil, ARR{0, 1, 2, 4})
+	expectRun(t, `out = is_array(range(0, 3))`, nil, true)
+	expectRun(t, `a, b := range(3, 5); out = a * b`, nil, 12)
+	expectError(t, `range(0, 5)["a"]`, nil, "invalid index type")
+}
+
 func TestReturn(t *testing.T) {
 	expectRun(t, `out = func() { return 10; }()`, nil, 10)
 	expectRun(t, `out = func() { return 10; return 9; }()`, nil, 10)
@@ -3105,6 +3756,36 @@ func() {
 		nil, "not index-assignable")
 }
 
+func TestSet(t *testing.T) {
+	expectRun(t, `out = len(set(1, 2, 2, "a"))`, nil, 3)
+	expectRun(t, `out = len(set([1, 2, 2]...))`, nil, 2)
+	expectRun(t, `out = len(set())`, nil, 0)
+	expectRun(t, `out = is_set(set())`, nil, true)
+	expectRun(t, `out = type_name(set())`, nil, "set")
+	expectRun(t, `out = [1 in set(1, 2), 3 in set(1, 2), [] in set(1)]`,
+		nil, ARR{true, false, false})
+	expectRun(t, `out = set(1, 2) | set(2, 3) == set(1, 2, 3)`, nil, true)
+	expectRun(t, `out = set(1, 2) & set(2, 3) == set(2)`, nil, true)
+	expectRun(t, `out = set(1, 2) - set(2, 3) == set(1)`, nil, true)
+	expectRun(t, `out = set(1, 2) ^ set(2, 3) == set(1, 3)`, nil, true)
+	expectRun(t, `out = [set(1) < set(1, 2), set(1) <= set(1), set(1) < set(1), set(1, 2) > set(2), set(1) >= set(2)]`,
+		nil, ARR{true, true, false, true, false})
+	expectRun(t, `out = set(1) == set(1.0)`, nil, false)
+	expectRun(t, `s := set(1); s[2] = true; s[1] = false; out = [s[1], s[2], len(s)]`,
+		nil, ARR{false, true, 1})
+	expectRun(t, `s := set(1, 2); delete(s, 1); out = s == set(2)`, nil, true)
+	expectRun(t, `s := set(1, 2); out = 0; for x in s { out += x }`, nil, 3)
+	expectRun(t, `s1 := set(1); s2 := copy(s1); s2[2] = true; out = len(s1)`,
+		nil, 1)
+	expectRun(t, `out = bool(set())`, nil, false)
+	expectRun(t, `out = set(immutable([1, 2])) == set(immutable([1, 2]))`,
+		nil, true)
+	expectError(t, `set([1])`, nil, "unhashable type: array")
+	expectError(t, `set(1) + set(2)`, nil, "invalid operation: set + set")
+	expectError(t, `1 in 2`, nil, "invalid operation: int in int")
+	expectError(t, `s := set(); s[[]] = true`, nil, "invalid index type")
+}
+
 func TestSourceModules(t *testing.T) {
 	testEnumModule(t, `out = enum.key(0, 20)`, 0)
 	testEnumModule(t, `out = enum.key(10, 20)`, 10)
@@ -3189,6 +3870,8 @@ func TestSourceModules(t *testing.T) {
 	testEnumModule(t, `out = enum.at(["one"], 1)`,
 		vvm.UndefinedValue)
 	testEnumModule(t, `out = enum.at(["one"], -1)`,
+		"one")
+	testEnumModule(t, `out = enum.at(["one"], -2)`,
 		vvm.UndefinedValue)
 	testEnumModule(t, `out = enum.at(["one","two","three"], 0)`,
 		"one")
@@ -3197,7 +3880,7 @@ func TestSourceModules(t *testing.T) {
 	testEnumModule(t, `out = enum.at(["one","two","three"], 2)`,
 		"three")
 	testEnumModule(t, `out = enum.at(["one","two","three"], -1)`,
-		vvm.UndefinedValue)
+		"three")
 	testEnumModule(t, `out = enum.at(["one","two","three"], 3)`,
 		vvm.UndefinedValue)
 	testEnumModule(t, `out = enum.at(["one","two","three"], "1")`,
@@ -3395,8 +4078,13 @@ func TestString(t *testing.T) {
 			nil, str[idx])
 	}
 
-	expectRun(t, fmt.Sprintf("%s[%d]", strStr, -1),
+	expectRun(t, fmt.Sprintf("out = %s[%d]", strStr, -1),
+		nil, 'f')
+	expectRun(t, fmt.Sprintf("out = %s[%d]", strStr, -strLen),
+		nil, 'a')
+	expectRun(t, fmt.Sprintf("%s[%d]", strStr, -strLen-1),
 		nil, vvm.UndefinedValue)
+	expectRun(t, `out = "héllo"[-4]`, nil, 'é')
 	expectRun(t, fmt.Sprintf("%s[%d]", strStr, strLen),
 		nil, vvm.UndefinedValue)
 
@@ -3428,17 +4116,21 @@ func TestString(t *testing.T) {
 	expectRun(t, fmt.Sprintf("out = %s[:]", strStr),
 		nil, str)
 	expectRun(t, fmt.Sprintf("out = %s[%d:]", strStr, -1),
-		nil, str)
+		nil, str[strLen-1:])
+	expectRun(t, fmt.Sprintf("out = %s[:%d]", strStr, -1),
+		nil, str[:strLen-1])
 	expectRun(t, fmt.Sprintf("out = %s[:%d]", strStr, strLen+1),
 		nil, str)
 	expectRun(t, fmt.Sprintf("out = %s[%d:%d]", strStr, 2, 2),
 		nil, "")
+	expectRun(t, fmt.Sprintf("out = %s[::-1]", strStr),
+		nil, "fedcba")
+	expectRun(t, fmt.Sprintf("out = %s[1::2]", strStr),
+		nil, "bdf")
 
-	expectError(t, fmt.Sprintf("%s[:%d]", strStr, -1),
-		nil, "invalid slice index")
 	expectError(t, fmt.Sprintf("%s[%d:]", strStr, strLen+1),
 		nil, "invalid slice index")
-	expectError(t, fmt.Sprintf("%s[%d:%d]", strStr, 0, -1),
+	expectError(t, fmt.Sprintf("%s[%d:%d]", strStr, 0, -strLen-1),
 		nil, "invalid slice index")
 	expectError(t, fmt.Sprintf("%s[%d:%d]", strStr, 2, 1),
 		nil, "invalid slice index")
@@ -3462,6 +4154,35 @@ func TestString(t *testing.T) {
 	expectError(t, `"foo" - "bar"`, nil, "invalid operation")
 }
 
+func TestTemplate(t *testing.T) {
+	expectRun(t, "out = `plain`", nil, "plain")
+	expectRun(t, "a := 1; out = `a=${a}`", nil, "a=1")
+	expectRun(t, "a := 1; out = `${a}${a + 1}${a * 3}`", nil, "123")
+	expectRun(t, "a := [1, 2]; out = `${a} ${a[1]}`", nil, "[1, 2] 2")
+	expectRun(t, "out = `${\"x\"}${'y'}${1.5}${true}${undefined}`",
+		nil, "xy1.5true<undefined>")
+	expectRun(t, "out = `${ {a: 5}.a }`", nil, "5")
+	expectRun(t, "f := func(x) { return `<${x}>` }; out = `${f(`${f(1)}`)}`",
+		nil, "<<1>>")
+	expectRun(t, "out = `\\${a $a $ {a}`", nil, "\\${a $a $ {a}")
+	expectRun(t, "out = len(`C:\\new\\table ${`)", nil, 15)
+	expectRun(t, "a := 1; out = `\\${a}`", nil, "\\1")
+	expectRun(t, "out = `${\"${\"}a}`", nil, "${a}")
+	expectRun(t, "a := 2; out = `x\\n${a}`", nil, "x\\n2")
+	expectRun(t, "a := 2\nout = `${\n\ta +\n\ta\n}\nz`", nil, "4\nz")
+	expectRun(t, "out = \"\"; for x in [1, 2] { out += `${x},` }", nil, "1,2,")
+
+	expectError(t, "a := 1\nb := `x${a.b.c}`", nil,
+		"Runtime Error: not indexable: string\n\tat test:2:12")
+	expectError(t, "b := `x${c}`", nil,
+		"Compile Error: unresolved reference 'c'\n\tat test:1:10")
+
+	vvm.MaxStringLen = 9
+	expectError(t, "a := \"12345\"; b := `${a}${a}`", nil,
+		"exceeding string size limit")
+	vvm.MaxStringLen = 2147483647
+}
+
 func TestTailCall(t *testing.T) {
 	expectRun(t, `
 	fac := func(n, a) {
@@ -3554,6 +4275,133 @@ out = c
 `, nil, 9999)
 }
 
+func TestTailCallMutual(t *testing.T) {
+	// mutual recursion deeper than MaxFrames
+	expectRun(t, `
+is_even := undefined
+is_odd := func(n) { return n == 0 ? false : is_even(n - 1) }
+is_even = func(n) { return n == 0 ? true : is_odd(n - 1) }
+out = is_even(5000)`, nil, true)
+
+	expectRun(t, `
+walk := undefined
+step := func(node, depth) {
+	if node == undefined { return depth }
+	return walk(node.next, depth + 1)
+}
+walk = func(node, depth) { return step(node, depth) }
+list := undefined
+for i := 0; i < 3000; i++ { list = {next: list} }
+out = walk(list, 0)`, nil, 3000)
+
+	// closures called in tail position keep their own free variables
+	expectRun(t, `
+make := func(k) {
+	return func(n, next) {
+		if n == 0 { return k }
+		return next(n - 1)
+	}
+}
+a := make("a")
+b := make("b")
+out = a(1, func(n) { return b(n, undefined) })`, nil, "b")
+
+	// variadic and native functions in tail position
+	expectRun(t, `
+sum := func(acc, ...rest) {
+	if len(rest) == 0 { return acc }
+	return sum(acc + rest[0], rest[1:]...)
+}
+f := func(x) { return string(x) }
+out = f(sum(0, 1, 2, 3, 4))`, nil, "10")
+	expectRun(t, `f := func() { return [1, 2] }; g := func() { return f() }
+a, b := g(); out = a + b`, nil, 3)
+
+	// generators do not reuse their frame
+	expectRun(t, `
+id := func(x) { return x }
+gen := func() { yield 1; return id(2) }
+out = 0; for x in gen() { out += x }`, nil, 1)
+
+	expectError(t, `f := func(a) { return a }; g := func() { return f() }; g()`,
+		nil, "wrong number of arguments")
+}
+
+func TestTailCallStack(t *testing.T) {
+	program := parse(t, `f := func() {
+	return 1 / 0
+}
+g := func() {
+	return f()
+}
+g()`)
+	_, _, err := traceCompileRun(program, nil, nil, -1)
+	var rerr *vvm.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, 2, rerr.Line)
+	require.Equal(t, 9, rerr.Column)
+	// the frame of g was replaced by f, but keeps the call site
+	require.Equal(t, 3, len(rerr.Stack))
+	require.Equal(t, 5, rerr.Stack[1].Line)
+	require.Equal(t, 7, rerr.Stack[2].Line)
+
+	// several tail calls deep
+	program = parse(t, `f := func(x) {
+	return x / 0
+}
+g := func(x) {
+	return f(x + 1)
+}
+h := func(x) {
+	return g(x + 1)
+}
+k := func() {
+	h(1)
+	return
+}
+k()`)
+	_, _, err = traceCompileRun(program, nil, nil, -1)
+	require.True(t, errors.As(err, &rerr))
+	var lines []int
+	for _, pos := range rerr.Stack {
+		lines = append(lines, pos.Line)
+	}
+	require.Equal(t, []int{2, 5, 8, 11, 14}, lines)
+
+	// error values created after tail calls, with the positions of f and g
+	// on top of those of the caller
+	expectRun(t, `
+f := func() { return error("x") }
+g := func() { return f() }
+out = len(g().stack) - len(error("y").stack)`, nil, 2)
+
+	// long tail call chains keep the most recent call sites
+	program = parse(t, `f := func(n) {
+	if n == 0 {
+		return 1 / n
+	}
+	return f(n - 1)
+}
+f(100)`)
+	_, _, err = traceCompileRun(program, nil, nil, -1)
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, 18, len(rerr.Stack))
+	require.Equal(t, 3, rerr.Stack[0].Line)
+	require.Equal(t, 5, rerr.Stack[1].Line)
+	require.Equal(t, 7, rerr.Stack[17].Line)
+}
+
+func TestTailCallLocals(t *testing.T) {
+	// the stack grows for the locals of a function called in tail position
+	var sb strings.Builder
+	sb.WriteString("f := func() {\n")
+	for i := 0; i < 120; i++ {
+		fmt.Fprintf(&sb, "\ta%d := %d\n", i, i)
+	}
+	sb.WriteString("\treturn a0 + a119 + 2\n}\ng := func() { return f() }\nout = g()")
+	expectRun(t, sb.String(), nil, 121)
+}
+
 // tail call with free vars
 func TestTailCallFreeVars(t *testing.T) {
 	expectRun(t, `
@@ -3627,6 +4475,328 @@ func TestSpread(t *testing.T) {
 		"Runtime Error: wrong number of arguments: want=3, got=2")
 }
 
+func TestSnapshot(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var result vvm.Object
+	var calls int
+	modules := vvm.NewModuleMap()
+	modules.AddBuiltinModule("gate", map[string]vvm.Object{
+		// wait blocks until the test releases the gate
+		"wait": &vvm.BuiltinFunction{
+			Name:        "wait",
+			Restartable: true,
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				select {
+				case entered <- struct{}{}:
+				default:
+				}
+				select {
+				case <-release:
+					return nil, nil
+				case <-ctx.Done():
+					return nil, vvm.ErrVMAborted
+				}
+			},
+		},
+		"done": &vvm.BuiltinFunction{
+			Name: "done",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				result = args[0]
+				return nil, nil
+			},
+		},
+		"handle": &vvm.BuiltinFunction{
+			Name: "handle",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				return &vvm.BuiltinFunction{Name: "file"}, nil
+			},
+		},
+		// call blocks like wait, but cannot be made again
+		"call": &vvm.BuiltinFunction{
+			Name: "call",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				calls++
+				entered <- struct{}{}
+				<-release
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return &vvm.Int{Value: int64(calls)}, nil
+			},
+		},
+	})
+	compile := func(src string) *vvm.Bytecode {
+		file := parse(t, src)
+		symTable := vvm.NewSymbolTable()
+		for idx, fn := range vvm.GetAllBuiltinFunctions() {
+			symTable.DefineBuiltin(idx, fn.Name)
+		}
+		c := vvm.NewCompiler(file.InputFile, symTable, nil, modules, nil)
+		require.NoError(t, c.Compile(file))
+		return c.Bytecode()
+	}
+	start := func(v *vvm.VM) chan error {
+		done := make(chan error, 1)
+		go func() { done <- v.Run() }()
+		<-entered
+		return done
+	}
+
+	bytecode := compile(`
+gate := import("gate")
+counter := func() { n := 0; return func() { n++; return n } }()
+c := chan()
+r := start(func(x) { v := c.recv(); return x + v + counter() }, 10)
+m := {a: [1, 2, 3], s: "x"}
+m.self = m
+sum := 0
+for x in [1, 2, 3] {
+	if x == 2 {
+		counter()
+		gate.wait()
+	}
+	sum += x
+}
+c.send(counter())
+gate.done([r.result(), m.self.a, m.s, sum, counter()])`)
+
+	v := vvm.NewVM(context.Background(), bytecode, nil, -1)
+	done := start(v)
+	snapshot, err := v.Snapshot()
+	require.NoError(t, err)
+	v.Abort()
+	require.True(t, errors.Is(<-done, vvm.ErrVMAborted))
+	require.Nil(t, result)
+
+	// the restored run continues in the loop, with the routine waiting
+	restored, err := vvm.Restore(bytecode, snapshot)
+	require.NoError(t, err)
+	done = start(restored)
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, `[15, [1, 2, 3], "x", 6, 4]`, result.String())
+
+	_, err = vvm.Restore(compile(`a := 1`), snapshot)
+	require.True(t, errors.Is(err, vvm.ErrInvalidSnapshot))
+	_, err = v.Snapshot()
+	require.True(t, errors.Is(err, vvm.ErrNotRunning))
+
+	// values implemented in Go cannot be saved
+	release = make(chan struct{})
+	v = vvm.NewVM(context.Background(), compile(`
+gate := import("gate")
+h := gate.handle()
+gate.wait()`), nil, -1)
+	done = start(v)
+	_, err = v.Snapshot()
+	var nserr vvm.ErrNotSnapshotable
+	require.True(t, errors.As(err, &nserr), err)
+	require.Equal(t, "builtin-function:file", nserr.Type)
+	require.Equal(t, "globals[1]", nserr.Path)
+	close(release)
+	require.NoError(t, <-done)
+
+	// other calls in progress are waited for, and made only once
+	select {
+	case <-entered: // left by the wait made again
+	default:
+	}
+	snapshotAfter := func(v *vvm.VM) chan error {
+		errc := make(chan error, 1)
+		go func() {
+			var err error
+			snapshot, err = v.Snapshot()
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			t.Fatalf("snapshot taken during a call: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		return errc
+	}
+	release = make(chan struct{})
+	bytecode = compile(`
+gate := import("gate")
+gate.done(gate.call())`)
+	v = vvm.NewVM(context.Background(), bytecode, nil, -1)
+	done = start(v)
+	errc := snapshotAfter(v)
+	close(release)
+	require.NoError(t, <-errc)
+	v.Abort()
+	require.True(t, errors.Is(<-done, vvm.ErrVMAborted))
+	restored, err = vvm.Restore(bytecode, snapshot)
+	require.NoError(t, err)
+	require.NoError(t, restored.Run())
+	require.Equal(t, "1", result.String())
+	require.Equal(t, 1, calls)
+
+	// a failed snapshot leaves them alone too
+	release = make(chan struct{})
+	v = vvm.NewVM(context.Background(), compile(`
+gate := import("gate")
+h := gate.handle()
+gate.done(gate.call())`), nil, -1)
+	done = start(v)
+	errc = snapshotAfter(v)
+	close(release)
+	require.True(t, errors.As(<-errc, &nserr))
+	require.NoError(t, <-done)
+	require.Equal(t, "2", result.String())
+	require.Equal(t, 2, calls)
+}
+
+func FuzzRestore(f *testing.F) {
+	src := []byte(`
+c := chan()
+m := {a: [1, 2.5, "x", [1, 2]], b: bytes("y")}
+m[1] = immutable([1, "z"])
+m.self = m
+s := set(1, "x", 'c')
+g := func() { n := 0; return func() { n++; return n } }()
+r := start(func(x) { return x + g() }, 10)
+c.recv()`)
+	fileSet := parser.NewFileSet()
+	file, err := parser.NewParser(fileSet.AddFile("test", -1, len(src)), src, nil).ParseFile()
+	if err != nil {
+		f.Fatal(err)
+	}
+	symTable := vvm.NewSymbolTable()
+	for idx, fn := range vvm.GetAllBuiltinFunctions() {
+		symTable.DefineBuiltin(idx, fn.Name)
+	}
+	c := vvm.NewCompiler(file.InputFile, symTable, nil, nil, nil)
+	if err := c.Compile(file); err != nil {
+		f.Fatal(err)
+	}
+	bytecode := c.Bytecode()
+
+	v := vvm.NewVM(context.Background(), bytecode, nil, -1)
+	done := make(chan error, 1)
+	go func() { done <- v.Run() }()
+	var snapshot []byte
+	for {
+		if snapshot, err = v.Snapshot(); !errors.Is(err, vvm.ErrNotRunning) {
+			break
+		}
+		_runtime.Gosched() // not started yet
+	}
+	if err != nil {
+		f.Fatal(err)
+	}
+	v.Abort()
+	<-done
+	f.Add(snapshot)
+	f.Add(snapshot[:len(snapshot)/2])
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		restored, err := vvm.Restore(bytecode, b)
+		if err != nil {
+			require.True(t, errors.Is(err, vvm.ErrInvalidSnapshot), err)
+			require.Nil(t, restored)
+		}
+	})
+}
+
+func TestRoutines(t *testing.T) {
+	expectRun(t, `r := start_with({name: "w"}, func(x) { return x }, 5); out = [r.name, r.id, r.result(), r.info().state]`,
+		nil, ARR{"w", 1, 5, "done"})
+	expectRun(t, `r := start(func() { return routines()[0].parent }); out = [r.result(), len(routines())]`,
+		nil, ARR{0, 0})
+	expectRun(t, `
+a := start(func() {
+	b := start(func() { return routines() })
+	return [b.id, b.result()[1].parent]
+})
+out = a.result()`, nil, ARR{2, 1})
+
+	// abort stops the routine calling it only
+	expectRun(t, `r := start(func() { abort(); return 1 }); r.wait(); out = 2`, nil, 2)
+
+	// failure policies
+	expectError(t, `start(func() { return 1 + "a" })`, nil, "invalid operation")
+	expectRun(t, `r := start_with({on_failure: "ignore"}, func() { return 1 + "a" }); out = is_error(r.result())`,
+		nil, true)
+	expectError(t, `c := chan(); start_with({on_failure: "fail"}, func() { return 1 + "a" }); c.recv()`,
+		nil, "invalid operation")
+	expectRun(t, `
+n := 0
+r := start_with({on_failure: "restart", max_restarts: 5}, func(x) {
+	n++
+	if n < 3 { return 1 + "a" }
+	return x + n
+}, 10)
+out = [r.result(), r.info().restarts]`, nil, ARR{13, 2})
+	expectError(t, `start_with({on_failure: "restart"}, func() { return 1 + "a" })`, nil, "invalid operation")
+	expectError(t, `start_with({x: 1}, func() {})`, nil, "unknown routine option: x")
+	expectError(t, `start_with({on_failure: "x"}, func() {})`, nil, "invalid failure policy: x")
+	expectError(t, `start_with({name: 1}, 1)`, nil, "invalid type for argument 'second'")
+
+	expectRun(t, `
+c := chan()
+a := start(func() { c.recv(); return "a" })
+b := start(func() { return "b" })
+w := wait_any([a, b])
+r := [w.result(), wait_all([a, b], 0), is_undefined(wait_any([a], 0))]
+c.send(1)
+out = r + [wait_all([a, b]), wait_any([b, a]).result()]`, nil, ARR{"b", false, true, true, "b"})
+	expectRun(t, `out = wait_all([])`, nil, true)
+	expectError(t, `wait_all(1)`, nil, "expected array")
+	expectError(t, `wait_all([1])`, nil, "expected array of routines")
+	expectError(t, `wait_any([])`, nil, "wait_any of no routines")
+
+	// the routines of a run can be monitored from Go
+	entered := make(chan struct{}, 3)
+	release := make(chan struct{})
+	modules := vvm.NewModuleMap()
+	modules.AddBuiltinModule("gate", map[string]vvm.Object{
+		"wait": &vvm.BuiltinFunction{
+			Name: "wait",
+			Value: func(ctx context.Context, args ...vvm.Object) (vvm.Object, error) {
+				entered <- struct{}{}
+				select {
+				case <-release:
+					return nil, nil
+				case <-ctx.Done():
+					return nil, vvm.ErrVMAborted
+				}
+			},
+		},
+	})
+	file := parse(t, `gate := import("gate")
+a := start_with({name: "a", on_failure: "restart"}, func() {
+	b := start(func() { gate.wait() })
+	gate.wait()
+	b.wait()
+})
+gate.wait()
+a.wait()
+out := routines()`)
+	symTable := vvm.NewSymbolTable()
+	for idx, fn := range vvm.GetAllBuiltinFunctions() {
+		symTable.DefineBuiltin(idx, fn.Name)
+	}
+	c := vvm.NewCompiler(file.InputFile, symTable, nil, modules, nil)
+	require.NoError(t, c.Compile(file))
+	v := vvm.NewVM(context.Background(), c.Bytecode(), nil, -1)
+	done := make(chan error, 1)
+	go func() { done <- v.Run() }()
+	for i := 0; i < 3; i++ {
+		<-entered
+	}
+	var infos []string
+	for _, r := range v.Routines() {
+		infos = append(infos, fmt.Sprintf("%d %q %d %s %s %d",
+			r.ID, r.Name, r.Parent, r.State, r.Pos, r.Restarts))
+	}
+	require.Equal(t, `1 "a" 0 waiting test:4:2 0,2 "" 1 waiting test:3:22 0`, strings.Join(infos, ","))
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, 0, len(v.Routines()))
+}
+
 func expectRun(
 	t *testing.T,
 	input string,
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"time"
 )
@@ -50,10 +52,20 @@ func CountObjects(o Object) (c int) {
 		for _, v := range o.Value {
 			c += CountObjects(v)
 		}
+		for _, e := range o.Hashed {
+			c += CountObjects(e.Key) + CountObjects(e.Value)
+		}
 	case *ImmutableMap:
 		for _, v := range o.Value {
 			c += CountObjects(v)
 		}
+		for _, e := range o.Hashed {
+			c += CountObjects(e.Key) + CountObjects(e.Value)
+		}
+	case *Set:
+		for _, v := range o.Value {
+			c += CountObjects(v)
+		}
 	case *Error:
 		c += CountObjects(o.Value)
 	}
@@ -74,6 +86,15 @@ func ToString(o Object) (v string, ok bool) {
 	return
 }
 
+// ToHashKey will try to compute the hash key of object o. It returns false
+// if o is not hashable.
+func ToHashKey(o Object) (HashKey, bool) {
+	if h, ok := o.(Hashable); ok {
+		return h.HashKey()
+	}
+	return HashKey{}, false
+}
+
 // ToInt will try to convert object o to int value.
 func ToInt(o Object) (v int, ok bool) {
 	switch o := o.(type) {
@@ -113,6 +134,16 @@ func ToInt64(o Object) (v int64, ok bool) {
 	case *Char:
 		v = int64(o.Value)
 		ok = true
+	case *BigInt:
+		if o.Value.IsInt64() {
+			v = o.Value.Int64()
+			ok = true
+		}
+	case *Decimal:
+		if i := o.Int(); i.IsInt64() {
+			v = i.Int64()
+			ok = true
+		}
 	case *Bool:
 		if o == TrueValue {
 			v = 1
@@ -137,6 +168,12 @@ func ToFloat64(o Object) (v float64, ok bool) {
 	case *Float:
 		v = o.Value
 		ok = true
+	case *BigInt:
+		v = o.Float64()
+		ok = true
+	case *Decimal:
+		v = o.Float64()
+		ok = true
 	case *String:
 		c, err := strconv.ParseFloat(o.Value, 64)
 		if err == nil {
@@ -147,6 +184,57 @@ func ToFloat64(o Object) (v float64, ok bool) {
 	return
 }
 
+// ToBigInt will try to convert object o to a big.Int value. Floats and
+// decimals are truncated towards zero.
+func ToBigInt(o Object) (v *big.Int, ok bool) {
+	switch o := o.(type) {
+	case *BigInt:
+		v = o.Value
+		ok = true
+	case *Decimal:
+		v = o.Int()
+		ok = true
+	case *Float:
+		if !math.IsNaN(o.Value) && !math.IsInf(o.Value, 0) {
+			v, _ = big.NewFloat(o.Value).Int(nil)
+			ok = true
+		}
+	case *String:
+		v, ok = new(big.Int).SetString(o.Value, 10)
+	default:
+		var i int64
+		if i, ok = ToInt64(o); ok {
+			v = big.NewInt(i)
+		}
+	}
+	return
+}
+
+// ToDecimal will try to convert object o to a decimal value. Floats are
+// converted using their shortest decimal representation.
+func ToDecimal(o Object) (v *Decimal, ok bool) {
+	switch o := o.(type) {
+	case *Decimal, *Int, *BigInt, *Float:
+		d, err := toDecimal(o)
+		if err == nil {
+			v = d
+			ok = true
+		}
+	case *String:
+		d, err := ParseDecimal(o.Value)
+		if err == nil {
+			v = d
+			ok = true
+		}
+	case *Char, *Bool:
+		var i int64
+		if i, ok = ToInt64(o); ok {
+			v = decimalFromInt(i)
+		}
+	}
+	return
+}
+
 // ToBool will try to convert object o to bool value.
 func ToBool(o Object) (v bool, ok bool) {
 	ok = true
@@ -202,6 +290,8 @@ func ToInterface(o Object) (res interface{}) {
 		res = o.Value
 	case *Float:
 		res = o.Value
+	case *BigInt:
+		res = o.Value
 	case *Bool:
 		res = o == TrueValue
 	case *Char:
@@ -219,14 +309,13 @@ func ToInterface(o Object) (res interface{}) {
 			res.([]interface{})[i] = ToInterface(val)
 		}
 	case *Map:
-		res = make(map[string]interface{})
-		for key, v := range o.Value {
-			res.(map[string]interface{})[key] = ToInterface(v)
-		}
+		res = mapToInterface(o.Value, o.Hashed)
 	case *ImmutableMap:
-		res = make(map[string]interface{})
-		for key, v := range o.Value {
-			res.(map[string]interface{})[key] = ToInterface(v)
+		res = mapToInterface(o.Value, o.Hashed)
+	case *Set:
+		res = make([]interface{}, 0, len(o.Value))
+		for _, v := range o.Value {
+			res = append(res.([]interface{}), ToInterface(v))
 		}
 	case *Time:
 		res = o.Value
@@ -265,13 +354,15 @@ func FromInterface(v interface{}) (Object, error) {
 		return &Char{Value: rune(v)}, nil
 	case float64:
 		return &Float{Value: v}, nil
+	case *big.Int:
+		return &BigInt{Value: v}, nil
 	case []byte:
 		if len(v) > MaxBytesLen {
 			return nil, ErrBytesLimit
 		}
 		return &Bytes{Value: v}, nil
 	case error:
-		return &Error{Value: &String{Value: v.Error()}}, nil
+		return NewError(v), nil
 	case map[string]Object:
 		return &Map{Value: v}, nil
 	case map[string]interface{}:
@@ -284,6 +375,22 @@ func FromInterface(v interface{}) (Object, error) {
 			kv[vk] = vo
 		}
 		return &Map{Value: kv}, nil
+	case map[interface{}]interface{}:
+		m := &Map{Value: make(map[string]Object)}
+		for vk, vv := range v {
+			ko, err := FromInterface(vk)
+			if err != nil {
+				return nil, err
+			}
+			vo, err := FromInterface(vv)
+			if err != nil {
+				return nil, err
+			}
+			if err := m.IndexSet(ko, vo); err != nil {
+				return nil, ErrNotHashable{Type: ko.TypeName()}
+			}
+		}
+		return m, nil
 	case []Object:
 		return &Array{Value: v}, nil
 	case []interface{}:
@@ -305,3 +412,31 @@ func FromInterface(v interface{}) (Object, error) {
 	}
 	return nil, fmt.Errorf("cannot convert to object: %T", v)
 }
+
+// mapToInterface converts the entries of a map. A map with non-string keys
+// is converted to map[interface{}]interface{}, otherwise
+// map[string]interface{} is returned.
+func mapToInterface(value map[string]Object, hashed map[HashKey]MapEntry) interface{} {
+	if len(hashed) == 0 {
+		res := make(map[string]interface{}, len(value))
+		for key, v := range value {
+			res[key] = ToInterface(v)
+		}
+		return res
+	}
+	res := make(map[interface{}]interface{}, len(value)+len(hashed))
+	for key, v := range value {
+		res[key] = ToInterface(v)
+	}
+	for _, e := range hashed {
+		key := ToInterface(e.Key)
+		switch k := key.(type) {
+		case []byte: // slices are not comparable
+			key = string(k)
+		case []interface{}:
+			key = e.Key.String()
+		}
+		res[key] = ToInterface(e.Value)
+	}
+	return res
+}